@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+// linesNotCLF counts lines -clf dropped for not being in combined log format
+var linesNotCLF uint64
+
+// clfEntry is the parts of a common/combined log format line that -clf keeps
+type clfEntry struct {
+	method  []byte
+	path    []byte
+	status  []byte
+	latency []byte
+}
+
+// parseCLF parses an Apache or nginx access log line:
+//
+//	host ident user [time] "METHOD path proto" status size ["referer" "user agent"] [latency]
+//
+// latency is a last number after the standard fields, like nginx $request_time or Apache %D.
+func parseCLF(line []byte) (clfEntry, bool) {
+	var e clfEntry
+	open := bytes.IndexByte(line, '[')
+	if open < 0 {
+		return e, false
+	}
+	end := bytes.IndexByte(line[open:], ']')
+	if end < 0 {
+		return e, false
+	}
+	rest := bytes.TrimLeft(line[open+end+1:], " ")
+	request, rest, ok := cutQuoted(rest)
+	if !ok {
+		return e, false
+	}
+	method, reqRest, _ := bytes.Cut(request, []byte(" "))
+	path, _, _ := bytes.Cut(reqRest, []byte(" "))
+	e.method = method
+	e.path = path
+	fields := splitFields(nil, rest, nil)
+	if len(fields) < 2 || len(fields[0]) != 3 || !isDigits(fields[0]) {
+		return e, false
+	}
+	e.status = fields[0]
+	rest = bytes.TrimLeft(rest, " ")
+	// status and size
+	for i := 0; i < 2; i++ {
+		_, rest, _ = bytes.Cut(rest, []byte(" "))
+		rest = bytes.TrimLeft(rest, " ")
+	}
+	// referer and user agent, if there
+	for i := 0; i < 2 && len(rest) != 0 && rest[0] == '"'; i++ {
+		_, rest, ok = cutQuoted(rest)
+		if !ok {
+			return e, true
+		}
+		rest = bytes.TrimLeft(rest, " ")
+	}
+	extra := splitFields(nil, rest, nil)
+	if len(extra) != 0 && isNumber(extra[len(extra)-1]) {
+		e.latency = extra[len(extra)-1]
+	}
+	return e, true
+}
+
+// cutQuoted splits `"quoted" rest` after the closing quote, skipping \" escapes
+func cutQuoted(b []byte) (quoted, rest []byte, ok bool) {
+	if len(b) == 0 || b[0] != '"' {
+		return nil, b, false
+	}
+	for i := 1; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			i++
+		case '"':
+			return b[1:i], bytes.TrimLeft(b[i+1:], " "), true
+		}
+	}
+	return nil, b, false
+}
+
+func isDigits(b []byte) bool {
+	for _, ch := range b {
+		if ch < '0' || ch > '9' {
+			return false
+		}
+	}
+	return len(b) != 0
+}
+
+// isNumber is digits with at most one '.'
+func isNumber(b []byte) bool {
+	whole, frac, _ := bytes.Cut(b, []byte("."))
+	return isDigits(whole) && (len(frac) == 0 || isDigits(frac))
+}
+
+// clfStage replaces an access log line with its status, method, path, and latency, tab separated.
+// Lines that don't parse are dropped.
+type clfStage struct {
+	out []byte
+}
+
+func (cs *clfStage) apply(line []byte) ([]byte, bool) {
+	e, ok := parseCLF(line)
+	if !ok {
+		atomic.AddUint64(&linesNotCLF, 1)
+		return nil, false
+	}
+	cs.out = append(cs.out[:0], e.status...)
+	for _, part := range [][]byte{e.method, e.path, e.latency} {
+		cs.out = append(cs.out, '\t')
+		cs.out = append(cs.out, part...)
+	}
+	return cs.out, true
+}
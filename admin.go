@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodPost {
+		return true
+	}
+	w.Header().Set("Allow", "POST")
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	fmt.Fprintf(w, "POST only\n")
+	return false
+}
+
+// isAdminRequest is true for requests that change the sampler rather than look at it:
+// anything but GET, HEAD, OPTIONS, and POST /graphql (reset, snapshot, creating collectors, ingest), and /debug/pprof/
+func isAdminRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return strings.HasPrefix(r.URL.Path, "/debug/pprof/")
+	}
+	if r.Method == http.MethodPost && r.URL.Path == "/graphql" {
+		// queries only read
+		return false
+	}
+	return true
+}
+
+type adminConnKey struct{}
+
+// adminConn is the -admin-http server's ConnContext, marking its requests as allowed to administer
+func adminConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, adminConnKey{}, true)
+}
+
+// readOnly refuses admin requests that didn't come in on -admin-http with a 403 pointing there
+type readOnly struct {
+	next http.Handler
+}
+
+func (ro *readOnly) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isAdminRequest(r) && r.Context().Value(adminConnKey{}) == nil {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "%s %s is only on the -admin-http listener\n", r.Method, r.URL.Path)
+		return
+	}
+	ro.next.ServeHTTP(w, r)
+}
+
+// resetHeader must be on POST /reset when there's no auth or -admin-http to keep it from other sites.
+// A page can only send it cross-site after a preflight, which -cors-origin doesn't allow.
+const resetHeader = "X-Ssample-Reset"
+
+// reset handles POST /reset, clearing the sample to start a new measurement window.
+// With ?final=1 the pre-reset sample is returned.
+func (s *ssampleServer) reset(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	if s.guardReset && r.Header.Get(resetHeader) == "" {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "without -auth-token, -auth-htpasswd, or -admin-http POST /reset needs an %s header\n", resetHeader)
+		return
+	}
+	var out LineNoResponse
+	var st CollectorStats
+	out.Lines, out.LineNumbers, st = s.c.Reset()
+	out.LinesSeen = st.LinesSeen
+	out.BytesSeen = st.BytesSeen
+	if boolish(r.FormValue("final")) {
+		writeSample(w, negotiateFormat(r), &out)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "reset after %d lines, %d bytes\n", st.LinesSeen, st.BytesSeen)
+}
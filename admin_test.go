@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestReset(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		guard  bool
+		method string
+		header bool
+		status int
+	}{
+		{"GET", false, "GET", false, http.StatusMethodNotAllowed},
+		{"with auth", false, "POST", false, http.StatusOK},
+		{"unguarded with the header", false, "POST", true, http.StatusOK},
+		{"cross-site form", true, "POST", false, http.StatusForbidden},
+		{"with the header", true, "POST", true, http.StatusOK},
+	} {
+		c := NewCollector(10, "")
+		c.AddLine("a")
+		c.AddLine("b")
+		s := &ssampleServer{c: c, guardReset: tc.guard}
+		// a form post, as another site's page can send without a preflight
+		r := httptest.NewRequest(tc.method, "/reset", strings.NewReader(url.Values{"final": {"1"}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tc.header {
+			r.Header.Set(resetHeader, "1")
+		}
+		w := httptest.NewRecorder()
+		s.routes().ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%s: %d %q, want %d", tc.name, w.Code, w.Body.String(), tc.status)
+		}
+		want := 2
+		if tc.status == http.StatusOK {
+			want = 0
+			if body := w.Body.String(); !strings.Contains(body, "\"a\"") {
+				t.Errorf("%s: final sample %q", tc.name, body)
+			}
+		}
+		if seen := c.Seen(); seen != want {
+			t.Errorf("%s: %d lines seen after, want %d", tc.name, seen, want)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	s := &ssampleServer{c: NewCollector(10, "")}
+	ro := &readOnly{next: s.routes()}
+	for _, tc := range []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/", http.StatusOK},
+		{"POST", "/reset", http.StatusForbidden},
+		{"POST", "/snapshot", http.StatusForbidden},
+		{"GET", "/debug/pprof/", http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		ro.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.status {
+			t.Errorf("%s %s: %d, want %d", tc.method, tc.path, w.Code, tc.status)
+		}
+	}
+}
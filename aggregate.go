@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TargetStatus is an entry in the aggregator's GET /targets
+type TargetStatus struct {
+	Target    string  `json:"target"`
+	LinesSeen int     `json:"seen"`
+	BytesSeen int64   `json:"bytesSeen"`
+	Host      *V1Host `json:"host,omitempty"`
+	// the same process's sample came from another target too and only one is merged
+	DuplicateOf string     `json:"duplicateOf,omitempty"`
+	LastOK      *time.Time `json:"lastOk,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+type aggTarget struct {
+	rc     *remoteClient
+	sample *loadedSample
+	status TargetStatus
+	// a pushed sample's change token, what the next push's changes follow on from
+	token string
+}
+
+// aggregator keeps the last sample fetched from (or pushed by) each target and merges them into one collector
+type aggregator struct {
+	targets []*aggTarget
+	keep    int
+	c       *Collector
+	rng     *rand.Rand
+
+	// pushed by -push instances, by push id
+	pushed map[string]*aggTarget
+
+	l sync.Mutex
+
+	// held across merge and restore so an older merge can't replace a newer one
+	mergem sync.Mutex
+}
+
+func readTargets(path string) ([]string, error) {
+	fin, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fin.Close()
+	var out []string
+	in := bufio.NewScanner(fin)
+	for in.Scan() {
+		line := strings.TrimSpace(in.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		out = append(out, line)
+	}
+	return out, in.Err()
+}
+
+// poll fetches every target and rebuilds the merged sample.
+// A target that fails keeps contributing its last good sample.
+func (ag *aggregator) poll() {
+	var wg sync.WaitGroup
+	for _, t := range ag.targets {
+		wg.Add(1)
+		go func(t *aggTarget) {
+			defer wg.Done()
+			body, err := t.rc.get("/v1/sample")
+			var ls *loadedSample
+			if err == nil {
+				ls, err = parseSample(body)
+			}
+			if err == nil {
+				err = ls.mergeable()
+			}
+			ag.l.Lock()
+			defer ag.l.Unlock()
+			if err != nil {
+				t.status.Error = err.Error()
+				return
+			}
+			ls.Name = t.status.Target
+			t.sample = ls
+			t.status.Error = ""
+			now := time.Now()
+			t.status.LastOK = &now
+			t.status.LinesSeen = ls.LinesSeen
+			t.status.BytesSeen = ls.BytesSeen
+			t.status.Host = ls.Host
+		}(t)
+	}
+	wg.Wait()
+	ag.remerge()
+}
+
+// remerge replaces the served sample with a fresh merge of the latest sample from every target
+func (ag *aggregator) remerge() {
+	ag.mergem.Lock()
+	defer ag.mergem.Unlock()
+	ag.l.Lock()
+	var samples []*loadedSample
+	// a process both polled and pushing, or listed twice, is merged once
+	type process struct {
+		hostname string
+		pid      int
+		started  int64
+	}
+	seen := make(map[process]string)
+	add := func(t *aggTarget) {
+		t.status.DuplicateOf = ""
+		if h := t.sample.Host; h != nil && !h.Started.IsZero() {
+			p := process{h.Hostname, h.Pid, h.Started.UnixNano()}
+			if first, ok := seen[p]; ok {
+				t.status.DuplicateOf = first
+				return
+			}
+			seen[p] = t.status.Target
+		}
+		samples = append(samples, t.sample)
+	}
+	for _, t := range ag.targets {
+		if t.sample != nil {
+			add(t)
+		}
+	}
+	ids := make([]string, 0, len(ag.pushed))
+	for id := range ag.pushed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		add(ag.pushed[id])
+	}
+	merged := mergeSamples(samples, ag.keep, ag.rng)
+	ag.l.Unlock()
+	blob, err := json.Marshal(stateFromSample(merged))
+	if err == nil {
+		err = ag.c.RestoreState(blob)
+	}
+	if err != nil {
+		errorf("aggregate: %v", err)
+	}
+}
+
+// stateFromSample makes a -state style collectorState of a merged sample
+func stateFromSample(v *V1Sample) collectorState {
+	st := collectorState{
+		sampleHeader: v.sampleHeader,
+		LinesToKeep:  v.Capacity,
+		LinesSeen:    v.LinesSeen,
+		BytesSeen:    v.BytesSeen,
+		Start:        v.Window.Start,
+		Lines:        make([]string, len(v.Lines)),
+		LineNumbers:  make([]int, len(v.Lines)),
+		LineTimes:    make([]time.Time, len(v.Lines)),
+		LineSources:  make([]string, len(v.Lines)),
+	}
+	for i, rec := range v.Lines {
+		st.Lines[i] = rec.Line
+		st.LineNumbers[i] = rec.LineNumber
+		st.LineTimes[i] = rec.Time
+		st.LineSources[i] = rec.Source
+	}
+	return st
+}
+
+// receivePush serves POST /push?id=ID&token=TOKEN from `ssample -push`, the sender's whole current sample,
+// replacing the last one with the same id so lines and seen counts are never counted twice;
+// and POST /push?id=ID&base=TOKEN, a pushDelta of the changes since the push with that token.
+// A delta that doesn't follow on from the last push is refused with 409 Conflict, for the whole sample to be sent.
+func (ag *aggregator) receivePush(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		badRequest(w, fmt.Errorf("missing id"))
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPushBytes))
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	token := r.URL.Query().Get("token")
+	base := r.URL.Query().Get("base")
+	var ls *loadedSample
+	if base != "" {
+		var delta pushDelta
+		if err := json.Unmarshal(body, &delta); err != nil {
+			badRequest(w, err)
+			return
+		}
+		// the delta is applied without holding ag.l, to a sample that is never changed once pushed
+		var old *loadedSample
+		ag.l.Lock()
+		if t := ag.pushed[id]; t != nil && t.token == base {
+			old = t.sample
+		}
+		ag.l.Unlock()
+		if old == nil {
+			http.Error(w, "no push with that base token, push the whole sample", http.StatusConflict)
+			return
+		}
+		ls = applyPushDelta(old, &delta)
+		token = delta.Token
+	} else {
+		ls, err = parseSample(body)
+		if err == nil {
+			err = ls.mergeable()
+		}
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+	}
+	ls.Name = id
+	now := time.Now()
+	ag.l.Lock()
+	if t := ag.pushed[id]; base != "" && (t == nil || t.token != base) {
+		// another push got in while the delta was applied
+		ag.l.Unlock()
+		http.Error(w, "no push with that base token, push the whole sample", http.StatusConflict)
+		return
+	}
+	ag.pushed[id] = &aggTarget{
+		sample: ls,
+		status: TargetStatus{Target: "push:" + id, LinesSeen: ls.LinesSeen, BytesSeen: ls.BytesSeen, Host: ls.Host, LastOK: &now},
+		token:  token,
+	}
+	ag.l.Unlock()
+	ag.remerge()
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "ok\n")
+}
+
+const maxPushBytes = 64 << 20
+
+// applyPushDelta returns the pushed sample old with delta's changes made to it.
+// Changes are applied by line number, so ones already in old (pushed while it was being made) change nothing.
+func applyPushDelta(old *loadedSample, delta *pushDelta) *loadedSample {
+	ls := *old
+	ls.Capacity = delta.Capacity
+	ls.LinesSeen = delta.LinesSeen
+	ls.BytesSeen = delta.BytesSeen
+	ls.Window = delta.Window
+	ls.Sources = delta.Sources
+	byNumber := make(map[int]SampleRecord, len(old.Records))
+	for _, rec := range old.Records {
+		byNumber[rec.LineNumber] = rec
+	}
+	for _, ch := range delta.Changes {
+		if ch.Reset {
+			clear(byNumber)
+			continue
+		}
+		if ch.Evicted >= 0 {
+			delete(byNumber, ch.Evicted)
+		}
+		if _, ok := byNumber[ch.LineNumber]; ch.LineNumber >= 0 && !ok {
+			rec := SampleRecord{LineNumber: ch.LineNumber, Line: ch.Line, Bytes: len(ch.Line), Time: ch.Time, Source: ch.Source}
+			rec.fixUTF8()
+			byNumber[ch.LineNumber] = rec
+		}
+	}
+	ls.Records = make([]SampleRecord, 0, len(byNumber))
+	for _, rec := range byNumber {
+		ls.Records = append(ls.Records, rec)
+	}
+	sort.Slice(ls.Records, func(i, j int) bool { return ls.Records[i].LineNumber < ls.Records[j].LineNumber })
+	return &ls
+}
+
+func (ag *aggregator) serveTargets(w http.ResponseWriter, r *http.Request) {
+	ag.l.Lock()
+	out := make([]TargetStatus, 0, len(ag.targets)+len(ag.pushed))
+	for _, t := range ag.targets {
+		out = append(out, t.status)
+	}
+	for _, t := range ag.pushed {
+		out = append(out, t.status)
+	}
+	ag.l.Unlock()
+	pushed := out[len(ag.targets):]
+	sort.Slice(pushed, func(i, j int) bool { return pushed[i].Target < pushed[j].Target })
+	blob, _ := json.Marshal(out)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(blob)
+}
+
+// aggregateMain is `ssample aggregate -targets hosts.txt -http :4422`
+func aggregateMain(args []string) {
+	fs := flag.NewFlagSet("aggregate", flag.ContinueOnError)
+	targetsPath := fs.String("targets", "", "file of ssample servers to pull from, one http://host:port (or unix:/path.sock) per line; without it only accept -push")
+	every := fs.Duration("every", 30*time.Second, "how often to pull")
+	keep := countFlag(fs, "l", 1000, "lines in the merged sample")
+	haddr := fs.String("http", ":4422", "host:port (or unix:/path.sock) to serve the merged sample on")
+	token := fs.String("token", os.Getenv("SSAMPLE_TOKEN"), "bearer token for targets (default $SSAMPLE_TOKEN)")
+	userpass := fs.String("user", "", "user:password for basic auth to targets")
+	insecure := fs.Bool("insecure", false, "don't verify target https certificates")
+	authToken := fs.String("auth-token", "", "require \"Authorization: Bearer TOKEN\" on requests to this server")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s aggregate -targets hosts.txt [flags]\n\nPeriodically pull samples from many ssample servers and serve their weighted merge.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	var targets []string
+	var err error
+	if *targetsPath != "" {
+		targets, err = readTargets(*targetsPath)
+		maybefail(err, "%s: %v\n", *targetsPath, err)
+	}
+	ag := &aggregator{
+		keep:   *keep,
+		c:      NewCollector(*keep, "aggregate"),
+		rng:    rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), uint64(os.Getpid()))),
+		pushed: make(map[string]*aggTarget),
+	}
+	for _, target := range targets {
+		ag.targets = append(ag.targets, &aggTarget{
+			rc:     newRemoteClient(target, *token, *userpass, *insecure),
+			status: TargetStatus{Target: target},
+		})
+	}
+	server := &ssampleServer{c: ag.c, guardReset: *authToken == ""}
+	mux := server.routes()
+	mux.HandleFunc("/targets", ag.serveTargets)
+	mux.HandleFunc("/push", ag.receivePush)
+	mux.HandleFunc("/healthz", healthz)
+	var handler http.Handler = mux
+	if *authToken != "" {
+		handler = (&authHandler{token: *authToken}).wrap(mux)
+	}
+	ln, err := listen(*haddr, 0660)
+	maybefail(err, "%s: %v\n", *haddr, err)
+	if len(ag.targets) != 0 {
+		go func() {
+			ag.poll()
+			for range time.Tick(*every) {
+				ag.poll()
+			}
+		}()
+	}
+	err = http.Serve(ln, handler)
+	maybefail(err, "%s: %v\n", *haddr, err)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestApplyPushDelta(t *testing.T) {
+	old := &loadedSample{
+		Name:      "push",
+		Capacity:  3,
+		LinesSeen: 3,
+		Records: []SampleRecord{
+			{LineNumber: 0, Line: "a"},
+			{LineNumber: 1, Line: "b"},
+			{LineNumber: 2, Line: "c"},
+		},
+	}
+	lines := func(ls *loadedSample) []string {
+		var out []string
+		for _, rec := range ls.Records {
+			out = append(out, rec.Line)
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		name    string
+		changes []ReservoirChange
+		want    []string
+	}{
+		{"nothing", nil, []string{"a", "b", "c"}},
+		{"evicted", []ReservoirChange{
+			{LineNumber: 5, Line: "f", Evicted: 1},
+			{LineNumber: 7, Line: "h", Evicted: 0},
+		}, []string{"c", "f", "h"}},
+		{"already applied", []ReservoirChange{
+			{LineNumber: 2, Line: "c", Evicted: -1},
+		}, []string{"a", "b", "c"}},
+		{"reset", []ReservoirChange{
+			{LineNumber: 5, Line: "f", Evicted: 1},
+			{LineNumber: -1, Evicted: -1, Reset: true},
+			{LineNumber: 0, Line: "x", Evicted: -1},
+			{LineNumber: 1, Line: "y", Evicted: -1},
+		}, []string{"x", "y"}},
+		{"reset last", []ReservoirChange{
+			{LineNumber: 5, Line: "f", Evicted: 1},
+			{LineNumber: -1, Evicted: -1, Reset: true},
+		}, nil},
+	} {
+		got := applyPushDelta(old, &pushDelta{Capacity: 3, LinesSeen: 10, BytesSeen: 40, Changes: tc.changes})
+		if !slices.Equal(lines(got), tc.want) {
+			t.Errorf("%s: lines %q, want %q", tc.name, lines(got), tc.want)
+		}
+		if got.Name != "push" || got.LinesSeen != 10 || got.BytesSeen != 40 {
+			t.Errorf("%s: %s seen %d lines %d bytes", tc.name, got.Name, got.LinesSeen, got.BytesSeen)
+		}
+	}
+	if !slices.Equal(lines(old), []string{"a", "b", "c"}) {
+		t.Errorf("old sample changed to %q", lines(old))
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import "bytes"
+
+// ansiStage removes ANSI escape sequences (colors, cursor movement, terminal titles) from a line
+type ansiStage struct {
+	out []byte
+}
+
+func (as *ansiStage) apply(line []byte) ([]byte, bool) {
+	if bytes.IndexByte(line, 0x1b) < 0 {
+		return line, true
+	}
+	as.out = stripANSI(as.out[:0], line)
+	return as.out, true
+}
+
+// stripANSI appends line without its escape sequences to out
+func stripANSI(out, line []byte) []byte {
+	for i := 0; i < len(line); i++ {
+		if line[i] != 0x1b {
+			out = append(out, line[i])
+			continue
+		}
+		i++
+		if i >= len(line) {
+			break
+		}
+		switch line[i] {
+		case '[':
+			// CSI: parameter and intermediate bytes up to a final byte in @ to ~
+			i++
+			for i < len(line) && (line[i] < 0x40 || line[i] > 0x7e) {
+				i++
+			}
+		case ']', 'P', 'X', '^', '_':
+			// OSC and other strings, ended by BEL or ESC \
+			i++
+			for i < len(line) {
+				if line[i] == 0x07 {
+					break
+				}
+				if line[i] == 0x1b && i+1 < len(line) && line[i+1] == '\\' {
+					i++
+					break
+				}
+				i++
+			}
+		default:
+			// two byte sequences like ESC ( B charset selection take one more byte
+			if line[i] >= 0x20 && line[i] <= 0x2f && i+1 < len(line) {
+				i++
+			}
+		}
+	}
+	return out
+}
+
+// utf8BOM is U+FEFF as UTF-8
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// windowsStage strips what files from Windows tools carry: every trailing \r with cr
+// (the line reader only drops one before \n), and a leading UTF-8 byte order mark with bom.
+// It only reslices, so it never copies.
+type windowsStage struct {
+	cr  bool
+	bom bool
+}
+
+func (ws windowsStage) apply(line []byte) ([]byte, bool) {
+	if ws.bom {
+		line = bytes.TrimPrefix(line, utf8BOM)
+	}
+	if ws.cr {
+		line = bytes.TrimRight(line, "\r")
+	}
+	return line, true
+}
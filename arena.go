@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"hash/maphash"
+)
+
+// lineArena holds the kept lines' bytes in a few large chunks instead of a string per line,
+// so a big reservoir is a handful of pointer-free allocations for the GC rather than one per line.
+// A replaced line leaves its bytes behind as garbage; when there is more garbage than
+// live bytes the live lines are compacted into fresh chunks.
+//
+// With interning on, identical lines share one copy of their bytes and live and garbage count
+// distinct bytes only. Shared copies are found by a hash of the line and compared in the arena,
+// so interning costs a few dozen bytes a distinct line and adds nothing for the GC to scan.
+type lineArena struct {
+	chunks [][]byte
+	// chunk being filled, chunks after it are empty (kept from before a Reset)
+	cur   int
+	spans []lineSpan
+
+	live    int
+	garbage int
+
+	// hash of a line -> index in refs of a shared copy, nil unless interning.
+	// Copies of different lines with the same hash are chained by next.
+	interned map[uint64]int32
+	refs     []internRef
+	// unused refs
+	free     []int32
+	distinct int
+	seed     maphash.Seed
+}
+
+// internRef is a shared copy of a line and how many kept lines use it, refs 0 if unused
+type internRef struct {
+	span lineSpan
+	refs int32
+	// another copy with the same hash, -1 if none
+	next int32
+	hash uint64
+}
+
+type lineSpan struct {
+	chunk, off, n uint32
+}
+
+const arenaChunkSize = 256 << 10
+
+// Intern turns on sharing of identical lines; call it before adding any
+func (a *lineArena) Intern() {
+	a.interned = make(map[uint64]int32)
+	a.seed = maphash.MakeSeed()
+}
+
+// Distinct is the number of different lines kept, or Len() if not interning
+func (a *lineArena) Distinct() int {
+	if a.interned == nil {
+		return len(a.spans)
+	}
+	return a.distinct
+}
+
+func (a *lineArena) Len() int {
+	return len(a.spans)
+}
+
+// Get copies out line i
+func (a *lineArena) Get(i int) string {
+	s := a.spans[i]
+	return string(a.chunks[s.chunk][s.off : s.off+s.n])
+}
+
+// Strings copies out all the lines
+func (a *lineArena) Strings() []string {
+	out := make([]string, len(a.spans))
+	for i := range a.spans {
+		out[i] = a.Get(i)
+	}
+	return out
+}
+
+// Reset empties the arena, keeping its chunks for reuse
+func (a *lineArena) Reset() {
+	for i := range a.chunks {
+		a.chunks[i] = a.chunks[i][:0]
+	}
+	a.cur = 0
+	a.spans = a.spans[:0]
+	a.live = 0
+	a.garbage = 0
+	if a.interned != nil {
+		clear(a.interned)
+		a.refs = a.refs[:0]
+		a.free = a.free[:0]
+		a.distinct = 0
+	}
+}
+
+// bytes is the arena's copy of a span
+func (a *lineArena) bytes(s lineSpan) []byte {
+	return a.chunks[s.chunk][s.off : s.off+s.n]
+}
+
+// findInterned returns the index in refs of the shared copy of line, -1 if there's none
+func (a *lineArena) findInterned(h uint64, line []byte) int32 {
+	i, ok := a.interned[h]
+	if !ok {
+		return -1
+	}
+	for ; i >= 0; i = a.refs[i].next {
+		if bytes.Equal(a.bytes(a.refs[i].span), line) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (a *lineArena) alloc(n int) (lineSpan, []byte) {
+	for a.cur < len(a.chunks) && len(a.chunks[a.cur])+n > cap(a.chunks[a.cur]) {
+		if len(a.chunks[a.cur]) == 0 {
+			// a reused chunk too small for this line, replace it
+			break
+		}
+		a.cur++
+	}
+	if a.cur == len(a.chunks) {
+		a.chunks = append(a.chunks, nil)
+	}
+	if len(a.chunks[a.cur])+n > cap(a.chunks[a.cur]) {
+		a.chunks[a.cur] = make([]byte, 0, max(n, arenaChunkSize))
+	}
+	ch := a.chunks[a.cur]
+	off := len(ch)
+	a.chunks[a.cur] = ch[:off+n]
+	return lineSpan{chunk: uint32(a.cur), off: uint32(off), n: uint32(n)}, a.chunks[a.cur][off : off+n]
+}
+
+func (a *lineArena) compact() {
+	old := a.chunks
+	a.chunks = nil
+	a.cur = 0
+	a.garbage = 0
+	if a.interned != nil {
+		moved := make(map[lineSpan]lineSpan, a.distinct)
+		for i := range a.refs {
+			ir := &a.refs[i]
+			if ir.refs == 0 {
+				continue
+			}
+			s := ir.span
+			span, dst := a.alloc(int(s.n))
+			copy(dst, old[s.chunk][s.off:s.off+s.n])
+			moved[s] = span
+			ir.span = span
+		}
+		for i, s := range a.spans {
+			a.spans[i] = moved[s]
+		}
+		return
+	}
+	for i, s := range a.spans {
+		span, dst := a.alloc(int(s.n))
+		copy(dst, old[s.chunk][s.off:s.off+s.n])
+		a.spans[i] = span
+	}
+}
+
+// release drops one use of span's bytes, making them garbage if it was the last
+func (a *lineArena) release(s lineSpan) {
+	if a.interned != nil {
+		b := a.bytes(s)
+		h := maphash.Bytes(a.seed, b)
+		i := a.findInterned(h, b)
+		ir := &a.refs[i]
+		ir.refs--
+		if ir.refs > 0 {
+			return
+		}
+		a.unlinkInterned(i)
+	}
+	a.live -= int(s.n)
+	a.garbage += int(s.n)
+}
+
+// unlinkInterned takes unused refs[i] out of its hash's chain
+func (a *lineArena) unlinkInterned(i int32) {
+	ir := &a.refs[i]
+	if a.interned[ir.hash] == i {
+		if ir.next < 0 {
+			delete(a.interned, ir.hash)
+		} else {
+			a.interned[ir.hash] = ir.next
+		}
+	} else {
+		for j := a.interned[ir.hash]; j >= 0; j = a.refs[j].next {
+			if a.refs[j].next == i {
+				a.refs[j].next = ir.next
+				break
+			}
+		}
+	}
+	a.free = append(a.free, i)
+	a.distinct--
+}
+
+// store returns a span holding line, shared with an identical kept line if interning
+func store[T string | []byte](a *lineArena, line T) lineSpan {
+	span, dst := a.alloc(len(line))
+	copy(dst, line)
+	if a.interned != nil {
+		// the copy just made is compared and hashed, so neither a string nor a []byte line is converted
+		h := maphash.Bytes(a.seed, dst)
+		if i := a.findInterned(h, dst); i >= 0 {
+			// give back the copy, it's the last thing allocated
+			a.chunks[span.chunk] = a.chunks[span.chunk][:span.off]
+			a.refs[i].refs++
+			return a.refs[i].span
+		}
+		ir := internRef{span: span, refs: 1, next: -1, hash: h}
+		if head, ok := a.interned[h]; ok {
+			ir.next = head
+		}
+		var i int32
+		if n := len(a.free); n != 0 {
+			i = a.free[n-1]
+			a.free = a.free[:n-1]
+			a.refs[i] = ir
+		} else {
+			i = int32(len(a.refs))
+			a.refs = append(a.refs, ir)
+		}
+		a.interned[h] = i
+		a.distinct++
+	}
+	a.live += len(line)
+	return span
+}
+
+// Remove drops line i, moving the last line into its place
+func (a *lineArena) Remove(i int) {
+	last := len(a.spans) - 1
+	a.release(a.spans[i])
+	a.spans[i] = a.spans[last]
+	a.spans = a.spans[:last]
+	if a.garbage > a.live && a.garbage > arenaChunkSize {
+		a.compact()
+	}
+}
+
+// arenaSet replaces line i, or appends it if i is Len()
+func arenaSet[T string | []byte](a *lineArena, i int, line T) {
+	if i == len(a.spans) {
+		a.spans = append(a.spans, store(a, line))
+		return
+	}
+	// store before release so a line replaced by an identical one keeps its bytes
+	old := a.spans[i]
+	a.spans[i] = store(a, line)
+	a.release(old)
+	if a.garbage > a.live && a.garbage > arenaChunkSize {
+		a.compact()
+	}
+}
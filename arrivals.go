@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// noGap is a kept line's gap when it's unknown: the first line, or restored from a state without gaps
+const noGap = time.Duration(-1)
+
+// arrivalStats is -arrivals: the gaps between input lines arriving, over all input, and the busiest second,
+// to show how bursty input is and how long it goes quiet.
+// With -time-regex the gaps are between line times; a line older than the one before it has a gap of 0.
+// Its methods are called with the Collector's lock held.
+type arrivalStats struct {
+	// -quantiles to report
+	quantiles []float64
+
+	// previous line's time, zero before the first
+	last time.Time
+
+	count    int64
+	min, max float64
+	mean, m2 float64
+	digest   tdigest
+	// the second gaps ended in and lines in it, and the most lines in any second
+	second      int64
+	secondLines int
+	peak        int
+	// when the longest gap ended
+	maxEnd time.Time
+}
+
+func newArrivalStats(quantiles []float64) *arrivalStats {
+	return &arrivalStats{quantiles: quantiles}
+}
+
+// add counts a line at t, returning its gap after the line before it, noGap for the first
+func (as *arrivalStats) add(t time.Time) time.Duration {
+	if sec := t.Unix(); sec != as.second {
+		as.second = sec
+		as.secondLines = 0
+	}
+	as.secondLines++
+	as.peak = max(as.peak, as.secondLines)
+	if as.last.IsZero() {
+		as.last = t
+		return noGap
+	}
+	gap := max(0, t.Sub(as.last))
+	if t.After(as.last) {
+		as.last = t
+	}
+	v := gap.Seconds()
+	as.count++
+	if as.count == 1 || v < as.min {
+		as.min = v
+	}
+	if as.count == 1 || v > as.max {
+		as.max = v
+		as.maxEnd = t
+	}
+	delta := v - as.mean
+	as.mean += delta / float64(as.count)
+	as.m2 += delta * (v - as.mean)
+	as.digest.add(v)
+	return gap
+}
+
+func (as *arrivalStats) reset() {
+	q := as.quantiles
+	*as = arrivalStats{quantiles: q}
+}
+
+// ArrivalStats is -arrivals in /v1/sample, gaps in seconds
+type ArrivalStats struct {
+	// gaps between consecutive input lines, one less than the lines
+	Gaps   int64   `json:"gaps"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	Stddev float64 `json:"stddev"`
+	// estimated from a t-digest, by name like "p99"
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+	// lines per second, 1/mean
+	Rate float64 `json:"rate"`
+	// most lines in any one second
+	PeakRate int `json:"peakRate"`
+	// stddev/mean, about 1 for lines arriving independently at random, more the burstier they are
+	Burstiness float64 `json:"burstiness"`
+	// when the longest gap, Max, ended
+	MaxEnd time.Time `json:"maxEnd"`
+}
+
+func (as *arrivalStats) summary() *ArrivalStats {
+	out := &ArrivalStats{Gaps: as.count, Min: as.min, Max: as.max, Mean: as.mean, PeakRate: as.peak, MaxEnd: as.maxEnd}
+	if as.count > 1 {
+		out.Stddev = math.Sqrt(as.m2 / float64(as.count-1))
+	}
+	if as.mean > 0 {
+		out.Rate = 1 / as.mean
+		out.Burstiness = out.Stddev / as.mean
+	}
+	if as.count > 0 && len(as.quantiles) != 0 {
+		out.Quantiles = make(map[string]float64, len(as.quantiles))
+		for _, q := range as.quantiles {
+			out.Quantiles[quantileName(q)] = as.digest.quantile(q, as.min, as.max)
+		}
+	}
+	return out
+}
+
+func (ast *ArrivalStats) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "arrivals: %d gaps, mean %s (%.3g lines/s), stddev %s, burstiness %.3g, peak %d lines/s",
+		ast.Gaps, secondsDuration(ast.Mean), ast.Rate, secondsDuration(ast.Stddev), ast.Burstiness, ast.PeakRate)
+	for _, q := range sortedQuantileNames(ast.Quantiles) {
+		fmt.Fprintf(&sb, ", %s %s", q, secondsDuration(ast.Quantiles[q]))
+	}
+	if ast.Gaps > 0 {
+		fmt.Fprintf(&sb, ", longest quiet %s ending %s", secondsDuration(ast.Max), ast.MaxEnd.Format(time.RFC3339))
+	}
+	return sb.String()
+}
+
+// secondsDuration shows seconds as a duration like 1.5s or 250µs
+func secondsDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Microsecond)
+}
+
+// SetArrivals has c time the gaps between lines, call it before adding lines or restoring a state
+func (c *Collector) SetArrivals(as *arrivalStats) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.lineGaps = make([]time.Duration, c.lines.Len())
+	for i := range c.lineGaps {
+		c.lineGaps[i] = noGap
+	}
+	c.arrivals = as
+}
+
+// Arrivals returns the -arrivals gaps so far, nil if not timing them
+func (c *Collector) Arrivals() *ArrivalStats {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.arrivals == nil {
+		return nil
+	}
+	return c.arrivals.summary()
+}
+
+// printSampleGaps writes the sample like printSample with each line's gap after the line before it in the input,
+// "{lineNumber}\t+{gap}\t{line}", and "-" for the gap of the first line
+func printSampleGaps(c *Collector) {
+	records, _, _ := c.Records()
+	out := bufio.NewWriter(os.Stdout)
+	for _, rec := range records {
+		gap := "-"
+		if rec.Gap != nil {
+			gap = "+" + secondsDuration(*rec.Gap).String()
+		}
+		line := rec.Line
+		if rec.LineBase64 != nil {
+			line = string(rec.LineBase64)
+		}
+		fmt.Fprintf(out, "%d\t%s\t%s%c", rec.LineNumber, gap, line, recordSep)
+	}
+	out.Flush()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// authHandler requires a bearer token or basic auth credentials before passing a request on.
+type authHandler struct {
+	next http.Handler
+
+	token string
+
+	// user -> htpasswd style password field, "{SHA}base64" or plain text
+	users map[string]string
+}
+
+// wrap returns next behind the same credentials as a, a nil a means no auth
+func (a *authHandler) wrap(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return &authHandler{next: next, token: a.token, users: a.users}
+}
+
+func (a *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.ok(r) {
+		a.next.ServeHTTP(w, r)
+		return
+	}
+	if len(a.users) != 0 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="ssample"`)
+	} else {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusUnauthorized)
+	fmt.Fprintf(w, "unauthorized\n")
+}
+
+func (a *authHandler) ok(r *http.Request) bool {
+	if a.token != "" {
+		ah := r.Header.Get("Authorization")
+		if strings.HasPrefix(ah, "Bearer ") && ctEqual(strings.TrimPrefix(ah, "Bearer "), a.token) {
+			return true
+		}
+	}
+	if len(a.users) != 0 {
+		user, pass, ok := r.BasicAuth()
+		if !ok {
+			return false
+		}
+		stored, found := a.users[user]
+		if !found {
+			return false
+		}
+		return checkHtpasswd(stored, pass)
+	}
+	return false
+}
+
+func ctEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func checkHtpasswd(stored, pass string) bool {
+	if strings.HasPrefix(stored, "{SHA}") {
+		sum := sha1.Sum([]byte(pass))
+		return ctEqual(stored[5:], base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	return ctEqual(stored, pass)
+}
+
+// readHtpasswd reads "user:password" lines.
+// Passwords may be "{SHA}" hashed (htpasswd -s) or plain text; blank lines and # comments are skipped.
+func readHtpasswd(path string) (map[string]string, error) {
+	fin, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fin.Close()
+	users := make(map[string]string)
+	in := bufio.NewScanner(fin)
+	lineno := 0
+	for in.Scan() {
+		lineno++
+		line := strings.TrimSpace(in.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected user:password", path, lineno)
+		}
+		stored := line[colon+1:]
+		if strings.HasPrefix(stored, "$") {
+			return nil, fmt.Errorf("%s:%d: unsupported password hash, use htpasswd -s or plain text", path, lineno)
+		}
+		users[line[:colon]] = stored
+	}
+	return users, in.Err()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"time"
+)
+
+// message is a payload from a message broker input, and the topic or subject it was published to
+type message struct {
+	subject string
+	payload []byte
+}
+
+// brokerConn is a subscription on a broker, made by messageSource's dial
+type brokerConn interface {
+	// next waits for a message
+	next() (message, error)
+	// buffered is more than 0 if messages have arrived that next hasn't returned
+	buffered() int
+	close()
+}
+
+// brokerRetryMax is the longest messageSource waits between tries to reconnect
+const brokerRetryMax = 30 * time.Second
+
+// messageSource is a lineSource of the lines of a broker's messages, most being one line.
+// Each line's source is the subject it came from. When the connection is lost it reconnects,
+// waiting longer after each failure, so like -f it ends only when ssample does.
+type messageSource struct {
+	// for messages, without any password
+	name string
+	dial func() (brokerConn, error)
+	conn brokerConn
+	// when conn was made, and how long to wait before the next try after it fails
+	connected time.Time
+	wait      time.Duration
+	// see lineReader
+	max int
+
+	// the lines of the current message not yet returned
+	rest    []byte
+	subject string
+	line    []byte
+}
+
+// newMessageSource connects once, so that a bad broker or subscription fails at startup
+func newMessageSource(name string, max int, dial func() (brokerConn, error)) (*messageSource, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	return &messageSource{name: name, dial: dial, conn: conn, connected: time.Now(), max: max}, nil
+}
+
+func (ms *messageSource) Scan() bool {
+	for {
+		for len(ms.rest) == 0 {
+			ms.reconnect()
+			m, err := ms.conn.next()
+			if err != nil {
+				warnf("%s: %v, reconnecting", ms.name, err)
+				ms.conn.close()
+				ms.conn = nil
+				continue
+			}
+			ms.subject = m.subject
+			ms.rest = m.payload
+		}
+		// a newline at the end of a message doesn't make an empty line after it
+		line, rest, found := bytes.Cut(ms.rest, []byte{recordSep})
+		ms.rest = rest
+		total := int64(len(line))
+		// trimLine can append to it
+		ms.line = append(ms.line[:0], line...)
+		if found {
+			ms.line = append(ms.line, recordSep)
+		}
+		var ok bool
+		ms.line, ok = trimLine(ms.line, ms.max, total)
+		if ok {
+			return true
+		}
+	}
+}
+
+// reconnect dials until there's a connection. One that lasted a while is tried again at once,
+// otherwise each try waits longer, so that a broker that drops connections as they're made isn't hammered.
+func (ms *messageSource) reconnect() {
+	if ms.conn != nil {
+		return
+	}
+	if time.Since(ms.connected) > brokerRetryMax {
+		ms.wait = 0
+	}
+	for {
+		if ms.wait > 0 {
+			time.Sleep(ms.wait)
+		}
+		ms.wait = min(max(2*ms.wait, time.Second), brokerRetryMax)
+		conn, err := ms.dial()
+		if err == nil {
+			infof("%s: reconnected", ms.name)
+			ms.conn = conn
+			ms.connected = time.Now()
+			return
+		}
+		warnf("%s: %v, trying again in %s", ms.name, err, ms.wait)
+	}
+}
+
+func (ms *messageSource) Bytes() []byte {
+	return ms.line
+}
+
+func (ms *messageSource) Err() error {
+	return nil
+}
+
+func (ms *messageSource) Buffered() int {
+	n := len(ms.rest)
+	if ms.conn != nil {
+		n += ms.conn.buffered()
+	}
+	return n
+}
+
+// Tag is the subject of the line, its source in the sample
+func (ms *messageSource) Tag() string {
+	return ms.subject
+}
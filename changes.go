@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// changeLogSize is how many reservoir changes /v1/changes keeps, a poller further behind gets the whole sample again
+const changeLogSize = 10000
+
+// changeLog is the recent reservoir changes, kept once /v1/changes is first asked for
+type changeLog struct {
+	// identifies this run of changes, a token from another epoch can't be followed on from
+	epoch uint64
+	// ring of the last n changes, changes[v%len] made the version v+1, for v from first
+	changes []ReservoirChange
+	first   uint64
+	n       int
+}
+
+func (cl *changeLog) add(change ReservoirChange) {
+	if cl.n < len(cl.changes) {
+		cl.changes[int((cl.first+uint64(cl.n))%uint64(len(cl.changes)))] = change
+		cl.n++
+		return
+	}
+	cl.changes[int(cl.first%uint64(len(cl.changes)))] = change
+	cl.first++
+}
+
+// since returns the changes after version, false if they're no longer all here
+func (cl *changeLog) since(version uint64) ([]ReservoirChange, bool) {
+	end := cl.first + uint64(cl.n)
+	if version < cl.first || version > end {
+		return nil, false
+	}
+	out := make([]ReservoirChange, 0, end-version)
+	for v := version; v < end; v++ {
+		out = append(out, cl.changes[int(v%uint64(len(cl.changes)))])
+	}
+	return out, true
+}
+
+// V1Changes is /v1/changes: the reservoir changes since a token, and the token to ask with next.
+// If Resync, the changes didn't follow on from the token: clear the mirror, Changes inserts the whole current sample.
+type V1Changes struct {
+	Token     string            `json:"token"`
+	Resync    bool              `json:"resync,omitempty"`
+	LinesSeen int               `json:"linesSeen"`
+	Changes   []ReservoirChange `json:"changes"`
+}
+
+func parseChangeToken(token string) (epoch, version uint64, err error) {
+	es, vs, ok := strings.Cut(token, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("bad token %q", token)
+	}
+	epoch, err = strconv.ParseUint(es, 16, 64)
+	if err == nil {
+		version, err = strconv.ParseUint(vs, 10, 64)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad token %q", token)
+	}
+	return epoch, version, nil
+}
+
+// Changes returns the reservoir changes since token, or with a resync the whole sample if they can't be
+func (c *Collector) Changes(token string) (V1Changes, error) {
+	var epoch, version uint64
+	if token != "" {
+		var err error
+		epoch, version, err = parseChangeToken(token)
+		if err != nil {
+			return V1Changes{}, err
+		}
+	}
+	c.l.Lock()
+	defer c.l.Unlock()
+	cl := c.changeLog()
+	out := V1Changes{Token: fmt.Sprintf("%x-%d", cl.epoch, c.version), LinesSeen: c.linesSeen}
+	if token != "" && epoch == cl.epoch {
+		changes, ok := cl.since(version)
+		if ok {
+			out.Changes = changes
+			return out, nil
+		}
+	}
+	out.Resync = true
+	out.Changes = make([]ReservoirChange, c.lines.Len())
+	for i := range out.Changes {
+		out.Changes[i] = ReservoirChange{LineNumber: c.lineNumbers[i], Line: c.lines.Get(i), Evicted: -1, Time: c.lineTimes[i], Source: c.Source}
+		if c.lineSources != nil {
+			out.Changes[i].Source = c.lineSources[i]
+		}
+	}
+	return out, nil
+}
+
+// changeLog starts keeping changes if they aren't yet. Holds c.l.
+func (c *Collector) changeLog() *changeLog {
+	if c.changes == nil {
+		c.changes = &changeLog{epoch: rand.Uint64(), changes: make([]ReservoirChange, changeLogSize), first: c.version}
+	}
+	return c.changes
+}
+
+// ChangeToken is the /v1/changes token of the sample as it is now
+func (c *Collector) ChangeToken() string {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return fmt.Sprintf("%x-%d", c.changeLog().epoch, c.version)
+}
+
+// v1Changes serves /v1/changes?token=..., insertions and evictions since the token
+func (s *ssampleServer) v1Changes(w http.ResponseWriter, r *http.Request) {
+	out, err := s.c.Changes(r.FormValue("token"))
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
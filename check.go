@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configCheck is -check: each thing validated, reported at the end.
+// Any failure exits through maybefail like it would have on a real run.
+type configCheck struct {
+	checked []string
+}
+
+func (cc *configCheck) ok(format string, args ...interface{}) {
+	cc.checked = append(cc.checked, fmt.Sprintf(format, args...))
+}
+
+// writable checks that path could be written without writing it:
+// an existing file is opened for append, a new one created and removed
+func (cc *configCheck) writable(flagName, path string) {
+	if path == "" {
+		return
+	}
+	err := checkWritable(path)
+	maybefail(err, "-%s %s: %v\n", flagName, path, err)
+	cc.ok("-%s %s: writable", flagName, path)
+}
+
+func checkWritable(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err == nil {
+		return f.Close()
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(path)
+}
+
+// dirWritable checks a file could be made in dir
+func (cc *configCheck) dirWritable(flagName, dir string) {
+	if dir == "" {
+		return
+	}
+	f, err := os.CreateTemp(dir, ".ssample-check-")
+	maybefail(err, "-%s %s: %v\n", flagName, dir, err)
+	f.Close()
+	os.Remove(f.Name())
+	cc.ok("-%s %s: writable directory", flagName, dir)
+}
+
+// listenAddr resolves a listen() address without listening, which a running instance might be
+func (cc *configCheck) listenAddr(flagName, addr string) {
+	if addr == "" {
+		return
+	}
+	if addr == "systemd" || strings.HasPrefix(addr, "systemd:") {
+		if os.Getenv("LISTEN_FDS") == "" {
+			maybefail(errors.New("no LISTEN_FDS"), "-%s %s: no sockets from systemd, run from a .socket unit\n", flagName, addr)
+		}
+		cc.ok("-%s %s: %s sockets from systemd", flagName, addr, os.Getenv("LISTEN_FDS"))
+		return
+	}
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		f, err := os.CreateTemp(filepath.Dir(path), ".ssample-check-")
+		maybefail(err, "-%s %s: %v\n", flagName, addr, err)
+		f.Close()
+		os.Remove(f.Name())
+		cc.ok("-%s %s: socket directory writable", flagName, addr)
+		return
+	}
+	ta, err := net.ResolveTCPAddr("tcp", addr)
+	maybefail(err, "-%s %s: %v\n", flagName, addr, err)
+	cc.ok("-%s %s: resolves to %s", flagName, addr, ta)
+}
+
+// udpAddr resolves an address to send to
+func (cc *configCheck) udpAddr(flagName, addr string) {
+	if addr == "" {
+		return
+	}
+	ua, err := net.ResolveUDPAddr("udp", addr)
+	maybefail(err, "-%s %s: %v\n", flagName, addr, err)
+	cc.ok("-%s %s: resolves to %s", flagName, addr, ua)
+}
+
+// pushURL checks a -push target parses
+func (cc *configCheck) pushURL(target string) {
+	if target == "" {
+		return
+	}
+	u, err := url.Parse(target)
+	if err == nil && u.Scheme != "http" && u.Scheme != "https" {
+		err = errors.New("want http:// or https://")
+	}
+	maybefail(err, "-push %s: %v\n", target, err)
+	cc.ok("-push %s: ok", target)
+}
+
+func (cc *configCheck) report(w io.Writer) {
+	for _, line := range cc.checked {
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintln(w, "config ok")
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// -stderr, what becomes of a command's stderr
+const (
+	// sampled on its own into the "stderr" collector
+	stderrCollector = "collector"
+	// sampled into the main sample with stdout, each line's source saying which
+	stderrMerge = "merge"
+	// passed through to ssample's stderr
+	stderrPass = "pass"
+)
+
+func checkStderrMode(mode string) error {
+	switch mode {
+	case stderrCollector, stderrMerge, stderrPass:
+		return nil
+	}
+	return fmt.Errorf("-stderr %q: want %s, %s, or %s", mode, stderrCollector, stderrMerge, stderrPass)
+}
+
+// childGrace is how long a command has to exit after an interrupt before it's killed
+var childGrace = 5 * time.Second
+
+// childCommand is the command after `--` that ssample runs and samples the output of, in place of stdin
+type childCommand struct {
+	args []string
+	cmd  *exec.Cmd
+	// the read ends of its stdout and stderr, stderr nil with -stderr pass or -pty
+	stdout io.Reader
+	stderr *os.File
+	// -pty, it leads a session and process group of its own
+	pty bool
+}
+
+// name is the program run, for messages
+func (ch *childCommand) name() string {
+	return ch.args[0]
+}
+
+// startChild starts args with ssample's stdin, or with usePTY on a new pseudo-terminal.
+// Its output comes through pipes made here rather than by exec.Cmd, so that Wait doesn't close them under the readers.
+func startChild(args []string, stderrMode string, usePTY bool) (*childCommand, error) {
+	ch := &childCommand{args: args, cmd: exec.Command(args[0], args[1:]...), pty: usePTY}
+	if usePTY {
+		return ch, ch.startPTY()
+	}
+	ch.cmd.Stdin = os.Stdin
+	var pipes []*os.File
+	defer func() {
+		// the write ends are the child's now
+		for _, pw := range pipes {
+			pw.Close()
+		}
+	}()
+	stdout, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	ch.stdout = stdout
+	pipes = append(pipes, pw)
+	ch.cmd.Stdout = pw
+	if stderrMode == stderrPass {
+		ch.cmd.Stderr = os.Stderr
+	} else {
+		ch.stderr, pw, err = os.Pipe()
+		if err != nil {
+			stdout.Close()
+			return nil, err
+		}
+		pipes = append(pipes, pw)
+		ch.cmd.Stderr = pw
+	}
+	if err := ch.cmd.Start(); err != nil {
+		stdout.Close()
+		if ch.stderr != nil {
+			ch.stderr.Close()
+		}
+		return nil, err
+	}
+	debugf("started %s, pid %d", ch.name(), ch.cmd.Process.Pid)
+	return ch, nil
+}
+
+// startPTY starts the command with a pty as its stdin, stdout, and stderr, and as its controlling terminal,
+// so it runs as it would in a terminal. Its output is read from the master, which ssample's stdin is copied to.
+func (ch *childCommand) startPTY() error {
+	master, tty, err := openPTY()
+	if err != nil {
+		return err
+	}
+	ptyInputEcho(tty)
+	ch.cmd.Stdin, ch.cmd.Stdout, ch.cmd.Stderr = tty, tty, tty
+	ch.cmd.SysProcAttr = ptyProcAttr()
+	err = ch.cmd.Start()
+	// the child's now, and the master only sees the end once no one else has it open
+	tty.Close()
+	if err != nil {
+		master.Close()
+		return err
+	}
+	debugf("started %s on a pty, pid %d", ch.name(), ch.cmd.Process.Pid)
+	ptyResize(master)
+	ch.stdout = ptyOutput{master}
+	go func() {
+		io.Copy(master, os.Stdin)
+		// ^D, the end of input for a terminal
+		master.Write([]byte{4})
+	}()
+	return nil
+}
+
+// finish waits for the command to exit and returns its exit status, 128+N if signal N killed it like a shell says.
+// If ssample is stopping before its output has ended (^C, -max-lines, -max-time) it is interrupted first,
+// and killed if it's still running childGrace later, and finish returns -1 so that ssample exits with its own status.
+func (ch *childCommand) finish(outputDone bool) int {
+	exited := make(chan struct{})
+	if !outputDone {
+		if err := ch.signal(os.Interrupt); err != nil {
+			// there's no interrupt on Windows
+			ch.signal(os.Kill)
+		}
+		go func() {
+			select {
+			case <-exited:
+			case <-time.After(childGrace):
+				warnf("%s is still running %v after an interrupt, killing it", ch.name(), childGrace)
+				ch.signal(os.Kill)
+			}
+		}()
+	}
+	err := ch.cmd.Wait()
+	close(exited)
+	if err != nil && ch.cmd.ProcessState == nil {
+		errorf("%s: %v", ch.name(), err)
+		return -1
+	}
+	status, sig := childStatus(ch.cmd.ProcessState)
+	if sig != nil {
+		infof("%s was killed: %v", ch.name(), sig)
+	} else {
+		infof("%s exited with status %d", ch.name(), status)
+	}
+	if !outputDone {
+		return -1
+	}
+	return status
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+func (ch *childCommand) signal(sig os.Signal) error {
+	return ch.cmd.Process.Signal(sig)
+}
+
+// forwardSignals does nothing, there is no -pty here
+func (ch *childCommand) forwardSignals(reloads bool) {}
+
+// childStatus is a finished process's exit status; killedBy is always nil, there are no signals to tell of here
+func childStatus(ps *os.ProcessState) (status int, killedBy os.Signal) {
+	return ps.ExitCode(), nil
+}
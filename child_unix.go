@@ -0,0 +1,48 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// signal sends sig to the command. With -pty it leads a process group of its own,
+// which all gets sig as it would from a terminal.
+func (ch *childCommand) signal(sig os.Signal) error {
+	if ch.pty {
+		return syscall.Kill(-ch.cmd.Process.Pid, sig.(syscall.Signal))
+	}
+	return ch.cmd.Process.Signal(sig)
+}
+
+// forwardSignals passes SIGTERM, SIGQUIT, and unless it reloads the -config, SIGHUP on to a -pty command,
+// which is in a session of its own so that they would otherwise leave it running after ssample.
+// ssample then carries on until the command's output ends.
+func (ch *childCommand) forwardSignals(reloads bool) {
+	if !ch.pty {
+		return
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGQUIT)
+	if !reloads {
+		signal.Notify(sigs, syscall.SIGHUP)
+	}
+	go func() {
+		for sig := range sigs {
+			debugf("passing %v on to %s", sig, ch.name())
+			if err := ch.signal(sig); err != nil {
+				warnf("%s: %v: %v", ch.name(), sig, err)
+			}
+		}
+	}()
+}
+
+// childStatus is a finished process's exit status, 128+N if signal N killed it like a shell says, and that signal
+func childStatus(ps *os.ProcessState) (status int, killedBy os.Signal) {
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal()), ws.Signal()
+	}
+	return ps.ExitCode(), nil
+}
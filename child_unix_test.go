@@ -0,0 +1,82 @@
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestChildStatus(t *testing.T) {
+	for _, tc := range []struct {
+		script string
+		status int
+		sig    syscall.Signal
+	}{
+		{"exit 0", 0, 0},
+		{"exit 3", 3, 0},
+		{"kill -TERM $$", 128 + int(syscall.SIGTERM), syscall.SIGTERM},
+	} {
+		cmd := exec.Command("/bin/sh", "-c", tc.script)
+		cmd.Run()
+		if cmd.ProcessState == nil {
+			t.Fatalf("%q didn't run", tc.script)
+		}
+		status, sig := childStatus(cmd.ProcessState)
+		if status != tc.status || (tc.sig == 0) != (sig == nil) || tc.sig != 0 && sig != tc.sig {
+			t.Errorf("%q: status %d, killed by %v, want %d %v", tc.script, status, sig, tc.status, tc.sig)
+		}
+	}
+}
+
+// ptyChild starts script on a pty, skipping the test if there are no ptys
+func ptyChild(t *testing.T, script string) *childCommand {
+	master, tty, err := openPTY()
+	if err != nil {
+		t.Skipf("no pty: %v", err)
+	}
+	master.Close()
+	tty.Close()
+	ch, err := startChild([]string{"/bin/sh", "-c", script}, stderrMerge, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go io.Copy(io.Discard, ch.stdout)
+	return ch
+}
+
+func TestChildSignal(t *testing.T) {
+	// the pty's process group gets it, the shell's child too
+	ch := ptyChild(t, "sleep 30 & wait")
+	time.Sleep(100 * time.Millisecond)
+	if err := ch.signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if status := ch.finish(true); status != 128+int(syscall.SIGTERM) {
+		t.Errorf("status %d, want SIGTERM's", status)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("took %v to stop", time.Since(start))
+	}
+}
+
+func TestChildFinishKills(t *testing.T) {
+	defer func(grace time.Duration) { childGrace = grace }(childGrace)
+	childGrace = 200 * time.Millisecond
+	ch := ptyChild(t, "trap '' INT; while :; do sleep 0.05; done")
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	if status := ch.finish(false); status != -1 {
+		t.Errorf("status %d, want -1", status)
+	}
+	if took := time.Since(start); took < childGrace || took > 5*time.Second {
+		t.Errorf("took %v to stop, grace %v", took, childGrace)
+	}
+	if _, sig := childStatus(ch.cmd.ProcessState); sig != syscall.SIGKILL {
+		t.Errorf("killed by %v, want SIGKILL", sig)
+	}
+}
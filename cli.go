@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// subcommand is one `ssample NAME ...`
+type subcommand struct {
+	name    string
+	summary string
+	// nil for those main runs itself: sample, serve, and service, which share main's flags,
+	// and completion, which lists the others
+	run func(args []string)
+}
+
+var subcommands = []subcommand{
+	{"sample", "read lines from stdin or -f files and keep a uniform sample, printed at exit (the default)", nil},
+	{"serve", "sample and serve the sample over -http and/or -grpc, until interrupted", nil},
+	{"merge", "merge -state, /snapshot, or saved json samples into one", mergeMain},
+	{"query", "fetch and print the sample from a running ssample server", queryMain},
+	{"aggregate", "pull samples from many ssample servers and serve their weighted merge", aggregateMain},
+	{"verify", "check the -tee-mark-every marks in -a or -teez files", verifyMain},
+	{"resample", "sample again from -a or -teez archives", resampleMain},
+	{"seek", "quickly sample huge files by reading lines at random offsets, approximately", seekMain},
+	{"compare", "compare the message templates and fields of two saved samples", compareMain},
+	{"diff", "show the seen counts, templates, and lines that changed between two saved samples", diffMain},
+	{"estimate", "estimate how many input lines match a regex from a saved sample", estimateMain},
+	{"context", "print sampled lines with the lines around them from -a or -teez archives", contextMain},
+	{"service", "install ssample as a Windows service, or run as one", nil},
+	{"completion", "print a bash, zsh, or fish completion script", nil},
+}
+
+// serverFlags are the flags only `ssample serve` (or no subcommand) takes
+var serverFlags = map[string]bool{
+	"http":            true,
+	"http-sock-mode":  true,
+	"h2c":             true,
+	"admin-http":      true,
+	"allow-cidr":      true,
+	"tls-cert":        true,
+	"tls-key":         true,
+	"tls-self-signed": true,
+	"tls-client-ca":   true,
+	"auth-token":      true,
+	"auth-htpasswd":   true,
+	"tenants":         true,
+	"collector-ttl":   true,
+	"cors-origin":     true,
+	"cors-methods":    true,
+	"pprof":           true,
+	"graphql":         true,
+	"pprof-http":      true,
+	"snapshot-dir":    true,
+	"access-log":      true,
+	"http-rate":       true,
+	"http-burst":      true,
+	"serve-forever":   true,
+	"grpc":            true,
+}
+
+func printSubcommands() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: %s [command] [flags]\n\ncommands:\n", os.Args[0])
+	for _, sc := range subcommands {
+		fmt.Fprintf(out, "  %-10s %s\n", sc.name, sc.summary)
+	}
+	fmt.Fprintf(out, "\n`%s command -h` lists a command's flags. With no command, sample taking all of sample and serve's flags.\n", os.Args[0])
+}
+
+// parseMainFlags parses args by the flags main registered: all of them for no command (""),
+// or for "sample" those that aren't serverFlags. "serve" defaults -serve-forever on.
+// Then flags not on the command line come from SSAMPLE_* variables and the -config file, which is kept in mainConfig for reloads.
+// It returns the command to run after `--`, if any.
+func parseMainFlags(command string, args []string) []string {
+	fs := flag.CommandLine
+	if command == "" {
+		fs.Init(os.Args[0], flag.ContinueOnError)
+		flag.Usage = func() {
+			printSubcommands()
+			fmt.Fprintf(flag.CommandLine.Output(), "\nflags:\n")
+			flag.PrintDefaults()
+			fmt.Fprintf(flag.CommandLine.Output(), "\n%s\n", configHelp)
+		}
+	} else {
+		if command == "serve" {
+			sf := flag.Lookup("serve-forever")
+			sf.Value.Set("true")
+			sf.DefValue = "true"
+		}
+		fs = flag.NewFlagSet(command, flag.ContinueOnError)
+		flag.VisitAll(func(f *flag.Flag) {
+			if command == "serve" || !serverFlags[f.Name] {
+				fs.Var(f.Value, f.Name, f.Usage)
+			}
+		})
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "usage: %s %s [flags]\n\n", os.Args[0], command)
+			fs.PrintDefaults()
+			fmt.Fprintf(fs.Output(), "\n%s\n", configHelp)
+		}
+	}
+	parseFlags(fs, args)
+	child := fs.Args()
+	if n := len(args) - len(child); len(child) != 0 && (n == 0 || args[n-1] != "--") {
+		fmt.Fprintf(fs.Output(), "%s: unexpected argument %q, put a command to run after --\n", os.Args[0], fs.Arg(0))
+		fs.Usage()
+		os.Exit(exitStart)
+	}
+	err := applyEnv(fs, os.Environ())
+	maybefail(err, "%v\n", err)
+	if path := fs.Lookup("config").Value.String(); path != "" {
+		pinned := setFlags(fs)
+		err = applyConfigFile(fs, path)
+		maybefail(err, "%v\n", err)
+		mainConfig, err = newConfigReload(fs, path, pinned)
+		maybefail(err, "%v\n", err)
+	}
+	return child
+}
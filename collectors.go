@@ -0,0 +1,437 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var collectorNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+type namedCollector struct {
+	c *Collector
+	h http.Handler
+
+	// removed after this long without a request, 0 to keep
+	ttl      time.Duration
+	lastUsed time.Time
+	// lines arrive from -route, -strata, or -tenants, so it can't be deleted or expire
+	fixed bool
+}
+
+// collectorSet holds named collectors served under /collector/{name}/
+type collectorSet struct {
+	snapshotDir string
+	// for -redact and -hash of ingested lines
+	filters *inputFilters
+	// -max-line-bytes, for ingested lines
+	maxLineBytes int
+
+	named map[string]*namedCollector
+	// -collector-ttl, for collectors PUT without ?ttl=
+	defaultTTL time.Duration
+	// -cors-origin, for their /ws
+	wsOrigins []string
+	// for their /reset
+	guardReset bool
+
+	l sync.Mutex
+}
+
+func newCollectorSet(snapshotDir string) *collectorSet {
+	return &collectorSet{
+		snapshotDir: snapshotDir,
+		named:       make(map[string]*namedCollector),
+	}
+}
+
+// parseCollectorSpec parses a -collector "name=N" value
+func parseCollectorSpec(spec string) (name string, size int, err error) {
+	eq := strings.IndexByte(spec, '=')
+	if eq < 0 {
+		return "", 0, fmt.Errorf("-collector %q: want name=N", spec)
+	}
+	name = spec[:eq]
+	n, err := parseCount(spec[eq+1:])
+	size = int(n)
+	if err != nil || n <= 0 || n > math.MaxInt32 {
+		return "", 0, fmt.Errorf("-collector %q: want name=N", spec)
+	}
+	return name, size, nil
+}
+
+// Add makes a new named collector, it is an error if the name is in use
+func (cs *collectorSet) Add(name string, size int) (*Collector, error) {
+	if !collectorNameRe.MatchString(name) {
+		return nil, fmt.Errorf("bad collector name %q, want [A-Za-z0-9._-]", name)
+	}
+	cs.l.Lock()
+	defer cs.l.Unlock()
+	if _, exists := cs.named[name]; exists {
+		return nil, fmt.Errorf("collector %q exists", name)
+	}
+	return cs.add(name, size), nil
+}
+
+// add makes a collector, holding cs.l
+func (cs *collectorSet) add(name string, size int) *Collector {
+	c := NewCollector(size, name)
+	if cs.filters != nil && cs.filters.dedup != nil {
+		c.SetDedup(cs.filters.dedup)
+	}
+	server := &ssampleServer{c: c, snapshotDir: cs.snapshotDir, wsOrigins: cs.wsOrigins, guardReset: cs.guardReset}
+	cs.named[name] = &namedCollector{c: c, h: server.routes(), lastUsed: time.Now()}
+	return c
+}
+
+// getOrAdd returns the named collector, making it with size lines if there isn't one.
+// It's fed by the caller from then on, so it is fixed.
+func (cs *collectorSet) getOrAdd(name string, size int) (c *Collector, created bool) {
+	cs.l.Lock()
+	defer cs.l.Unlock()
+	if nc := cs.named[name]; nc != nil {
+		nc.fixed = true
+		return nc.c, false
+	}
+	c = cs.add(name, size)
+	cs.named[name].fixed = true
+	return c, true
+}
+
+// Delete removes a named collector, it is an error if it's fixed
+func (cs *collectorSet) Delete(name string) error {
+	cs.l.Lock()
+	defer cs.l.Unlock()
+	nc := cs.named[name]
+	if nc == nil {
+		return fmt.Errorf("no collector %q", name)
+	}
+	if nc.fixed {
+		return fmt.Errorf("collector %q is fed by -route, -strata, -first-by, -last-by, or -tenants", name)
+	}
+	delete(cs.named, name)
+	return nil
+}
+
+// expireIdle checks every interval for collectors past their ttl, and removes them
+func (cs *collectorSet) expireIdle(interval time.Duration) {
+	for range time.Tick(interval) {
+		now := time.Now()
+		cs.l.Lock()
+		for name, nc := range cs.named {
+			if nc.ttl > 0 && !nc.fixed && now.Sub(nc.lastUsed) > nc.ttl {
+				delete(cs.named, name)
+				infof("collector %s idle for %s, removed", name, nc.ttl)
+			}
+		}
+		cs.l.Unlock()
+	}
+}
+
+// Resize changes how many lines a collector keeps. Shrinking keeps a uniform sample, growing would bias it
+// toward later lines, so that's only allowed while nothing has been dropped yet (e.g. after a /reset).
+func (c *Collector) Resize(n int) error {
+	c.l.Lock()
+	if err := c.resizeErr(n); err != nil {
+		c.l.Unlock()
+		return err
+	}
+	if n >= c.LinesToKeep {
+		c.LinesToKeep = n
+		c.l.Unlock()
+		return nil
+	}
+	c.l.Unlock()
+	c.Shrink(n)
+	return nil
+}
+
+// CanResize returns the error Resize(n) would, for checking before changing anything
+func (c *Collector) CanResize(n int) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.resizeErr(n)
+}
+
+// resizeErr holds c.l
+func (c *Collector) resizeErr(n int) error {
+	if n > c.LinesToKeep && c.linesSeen > c.lines.Len() {
+		return fmt.Errorf("can't grow from %d to %d lines after %d have been seen without biasing the sample, reset it first", c.LinesToKeep, n, c.linesSeen)
+	}
+	return nil
+}
+
+func (cs *collectorSet) Get(name string) *Collector {
+	cs.l.Lock()
+	defer cs.l.Unlock()
+	nc := cs.named[name]
+	if nc == nil {
+		return nil
+	}
+	return nc.c
+}
+
+// CollectorInfo is an entry in GET /collectors
+type CollectorInfo struct {
+	Name      string `json:"name"`
+	Capacity  int    `json:"capacity"`
+	Kept      int    `json:"kept"`
+	LinesSeen int    `json:"seen"`
+	BytesSeen int64  `json:"bytesSeen"`
+	// idle time before it's removed, if it expires
+	TTLSeconds  float64 `json:"ttlSeconds,omitempty"`
+	IdleSeconds float64 `json:"idleSeconds"`
+	Fixed       bool    `json:"fixed,omitempty"`
+}
+
+func (cs *collectorSet) list() []CollectorInfo {
+	now := time.Now()
+	cs.l.Lock()
+	out := make([]CollectorInfo, 0, len(cs.named))
+	cols := make([]*Collector, 0, len(cs.named))
+	for name, nc := range cs.named {
+		out = append(out, CollectorInfo{Name: name, TTLSeconds: nc.ttl.Seconds(), IdleSeconds: now.Sub(nc.lastUsed).Seconds(), Fixed: nc.fixed})
+		cols = append(cols, nc.c)
+	}
+	cs.l.Unlock()
+	for i := range out {
+		st := cols[i].Stats()
+		out[i].Capacity = st.Capacity
+		out[i].Kept = st.Kept
+		out[i].LinesSeen = st.LinesSeen
+		out[i].BytesSeen = st.BytesSeen
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// all returns the named collectors
+func (cs *collectorSet) all() []*Collector {
+	cs.l.Lock()
+	defer cs.l.Unlock()
+	out := make([]*Collector, 0, len(cs.named))
+	for _, nc := range cs.named {
+		out = append(out, nc.c)
+	}
+	return out
+}
+
+// listCollectors serves GET /collectors
+func (cs *collectorSet) listCollectors(w http.ResponseWriter, r *http.Request) {
+	blob, err := json.Marshal(cs.list())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(blob)
+}
+
+// ServeHTTP handles /collector/{name}/...
+//
+// PUT /collector/{name}?l=N&ttl=D creates a collector keeping N lines, removed after D without a request.
+// PATCH /collector/{name}?l=N&ttl=D changes them, DELETE /collector/{name} removes it.
+// POST /collector/{name}/ingest adds the request body's lines.
+// Everything else is the same as for the stdin collector at /, e.g. /collector/{name}/?t=1
+func (cs *collectorSet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/collector/")
+	name := rest
+	sub := "/"
+	if slash := strings.IndexByte(rest, '/'); slash >= 0 {
+		name = rest[:slash]
+		sub = rest[slash:]
+	}
+	if sub == "/" {
+		switch r.Method {
+		case http.MethodPut:
+			cs.create(w, r, name)
+			return
+		case http.MethodPatch:
+			cs.configure(w, r, name)
+			return
+		case http.MethodDelete:
+			cs.delete(w, name)
+			return
+		}
+	}
+	cs.l.Lock()
+	nc := cs.named[name]
+	if nc != nil {
+		nc.lastUsed = time.Now()
+	}
+	cs.l.Unlock()
+	if nc == nil {
+		notFound(w, name)
+		return
+	}
+	if sub == "/ingest" {
+		ingest(w, r, nc.c, cs.filters.live().private(), nil, cs.maxLineBytes)
+		return
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = sub
+	r2.URL.RawPath = ""
+	nc.h.ServeHTTP(w, r2)
+}
+
+// collectorParams reads ?l= and ?ttl=, size 0 and ttl -1 if not given
+func collectorParams(r *http.Request) (size int, ttl time.Duration, err error) {
+	ttl = -1
+	if lstr := r.FormValue("l"); lstr != "" {
+		size, err = strconv.Atoi(lstr)
+		if err != nil || size <= 0 {
+			return 0, 0, fmt.Errorf("bad l=%q", lstr)
+		}
+	}
+	if tstr := r.FormValue("ttl"); tstr != "" {
+		ttl, err = time.ParseDuration(tstr)
+		if err != nil || ttl < 0 {
+			return 0, 0, fmt.Errorf("bad ttl=%q, want a duration like 30m, 0 to keep", tstr)
+		}
+	}
+	return size, ttl, nil
+}
+
+func conflict(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusConflict)
+	fmt.Fprintf(w, "%v\n", err)
+}
+
+func (cs *collectorSet) create(w http.ResponseWriter, r *http.Request, name string) {
+	size, ttl, err := collectorParams(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if size == 0 {
+		size = 100
+	}
+	if ttl < 0 {
+		ttl = cs.defaultTTL
+	}
+	_, err = cs.Add(name, size)
+	if err != nil {
+		conflict(w, err)
+		return
+	}
+	cs.l.Lock()
+	if nc := cs.named[name]; nc != nil {
+		nc.ttl = ttl
+	}
+	cs.l.Unlock()
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusCreated)
+	if ttl > 0 {
+		fmt.Fprintf(w, "created %s, %d lines, removed after %s idle\n", name, size, ttl)
+		return
+	}
+	fmt.Fprintf(w, "created %s, %d lines\n", name, size)
+}
+
+func (cs *collectorSet) configure(w http.ResponseWriter, r *http.Request, name string) {
+	size, ttl, err := collectorParams(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	cs.l.Lock()
+	nc := cs.named[name]
+	if nc != nil {
+		nc.lastUsed = time.Now()
+		if ttl >= 0 {
+			nc.ttl = ttl
+		}
+	}
+	cs.l.Unlock()
+	if nc == nil {
+		notFound(w, name)
+		return
+	}
+	if size != 0 {
+		err = nc.c.Resize(size)
+		if err != nil {
+			conflict(w, err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "%s: %d lines, ttl %s\n", name, nc.c.Stats().Capacity, nc.ttl)
+}
+
+func (cs *collectorSet) delete(w http.ResponseWriter, name string) {
+	err := cs.Delete(name)
+	if err != nil && cs.Get(name) == nil {
+		notFound(w, name)
+		return
+	}
+	if err != nil {
+		conflict(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "deleted %s\n", name)
+}
+
+func notFound(w http.ResponseWriter, name string) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprintf(w, "no collector %q\n", name)
+}
+
+// ingest adds each line of a POST body to c, after the clean stages.
+// Lines longer than maxLineBytes are cut or skipped as -long-lines says, like any other input's.
+// With a quota, lines past it are refused with a 429.
+func ingest(w http.ResponseWriter, r *http.Request, c *Collector, clean pipeline, quota *inputThrottle, maxLineBytes int) {
+	if !requirePost(w, r) {
+		return
+	}
+	ig := &ingester{c: c, clean: clean, quota: quota}
+	in := newLineReader(r.Body, maxLineBytes)
+	for in.Scan() {
+		if !ig.add(in.Bytes()) {
+			overQuota(w, ig.count, quota)
+			return
+		}
+	}
+	if err := in.Err(); err != nil {
+		badRequest(w, fmt.Errorf("after %d lines: %v", ig.count, err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "added %d lines\n", ig.count)
+}
+
+// ingester adds lines sent to a collector over the network, by POST to /ingest or /collector/{name}/ingest
+// or by gRPC Ingest, so they all get the same clean stages and quota
+type ingester struct {
+	c     *Collector
+	clean pipeline
+	// a tenant's quota, nil for none
+	quota *inputThrottle
+	// lines taken so far
+	count int
+}
+
+// add adds line after the clean stages, false if it is over the quota and was refused
+func (ig *ingester) add(line []byte) bool {
+	if ig.quota != nil && !ig.quota.allow() {
+		return false
+	}
+	if line, keep := ig.clean.apply(line); keep {
+		ig.c.AddBytes(line)
+	}
+	ig.count++
+	return true
+}
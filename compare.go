@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// templateShift is how much more or less common a template is in sample b than in a
+type templateShift struct {
+	template string
+	example  string
+	countA   int
+	countB   int
+	// fraction of each sample
+	shareA, shareB float64
+	// two proportion z statistic, positive if more common in b
+	z float64
+}
+
+// compareTemplates mines templates from both samples together so their shapes line up,
+// returning shifts sorted by the strength of the evidence
+func compareTemplates(a, b []SampleRecord) []templateShift {
+	tm := newTemplateMiner(1, math.MaxInt)
+	counts := make(map[*template][2]int)
+	var order []*template
+	for side, records := range [2][]SampleRecord{a, b} {
+		for _, rec := range records {
+			t := tm.add([]byte(rec.Line))
+			cs, seen := counts[t]
+			if !seen {
+				order = append(order, t)
+			}
+			cs[side]++
+			counts[t] = cs
+		}
+	}
+	na, nb := float64(len(a)), float64(len(b))
+	var out []templateShift
+	for _, t := range order {
+		cs := counts[t]
+		ts := templateShift{template: strings.Join(t.tokens, " "), countA: cs[0], countB: cs[1]}
+		if len(t.examples) != 0 {
+			ts.example = t.examples[0]
+		}
+		if na > 0 {
+			ts.shareA = float64(cs[0]) / na
+		}
+		if nb > 0 {
+			ts.shareB = float64(cs[1]) / nb
+		}
+		pooled := float64(cs[0]+cs[1]) / (na + nb)
+		se := math.Sqrt(pooled * (1 - pooled) * (1/na + 1/nb))
+		if se > 0 {
+			ts.z = (ts.shareB - ts.shareA) / se
+		}
+		out = append(out, ts)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return math.Abs(out[i].z) > math.Abs(out[j].z) })
+	return out
+}
+
+// ksTest is the two sample Kolmogorov-Smirnov statistic D of sorted xs and ys, and its asymptotic p-value
+func ksTest(xs, ys []float64) (d, p float64) {
+	i, j := 0, 0
+	n, m := float64(len(xs)), float64(len(ys))
+	for i < len(xs) && j < len(ys) {
+		v := min(xs[i], ys[j])
+		for i < len(xs) && xs[i] == v {
+			i++
+		}
+		for j < len(ys) && ys[j] == v {
+			j++
+		}
+		d = max(d, math.Abs(float64(i)/n-float64(j)/m))
+	}
+	ne := math.Sqrt(n * m / (n + m))
+	lambda := (ne + 0.12 + 0.11/ne) * d
+	// the series below hasn't converged in 100 terms for small lambda, where Q_KS is 1 to many places anyway
+	if lambda < 0.3 {
+		return d, 1
+	}
+	// Q_KS(lambda) = 2 sum (-1)^(k-1) e^(-2 k^2 lambda^2)
+	sign := 1.0
+	for k := 1; k <= 100; k++ {
+		term := sign * 2 * math.Exp(-2*float64(k*k)*lambda*lambda)
+		p += term
+		if math.Abs(term) < 1e-10 {
+			return d, min(1, max(0, p))
+		}
+		sign = -sign
+	}
+	return d, 1
+}
+
+// fieldValues are the -stat values of records, sorted
+func fieldValues(records []SampleRecord, sel *valueSelector) []float64 {
+	var out []float64
+	for _, rec := range records {
+		if v, ok := sel.value([]byte(rec.Line)); ok {
+			out = append(out, v)
+		}
+	}
+	sort.Float64s(out)
+	return out
+}
+
+func writeComparison(w io.Writer, a, b *loadedSample, shifts []templateShift, top int, sels []*valueSelector) {
+	fmt.Fprintf(w, "a: %s, %d lines sampled of %d\nb: %s, %d lines sampled of %d\n", a.Name, len(a.Records), a.LinesSeen, b.Name, len(b.Records), b.LinesSeen)
+	var added, vanished, changed []templateShift
+	for _, ts := range shifts {
+		switch {
+		case ts.countA == 0:
+			added = append(added, ts)
+		case ts.countB == 0:
+			vanished = append(vanished, ts)
+		default:
+			changed = append(changed, ts)
+		}
+	}
+	section := func(title string, list []templateShift) {
+		if len(list) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "\n%s (%d):\n", title, len(list))
+		for _, ts := range list[:min(top, len(list))] {
+			fmt.Fprintf(w, "  %6.2f%% -> %6.2f%%  z %+6.2f  %s\n", ts.shareA*100, ts.shareB*100, ts.z, ts.template)
+			if ts.example != ts.template {
+				fmt.Fprintf(w, "      e.g. %s\n", ts.example)
+			}
+		}
+	}
+	section("new in b", added)
+	section("gone from b", vanished)
+	// only shifts unlikely to be chance, |z| over 1.96 is p < 0.05
+	var significant []templateShift
+	for _, ts := range changed {
+		if math.Abs(ts.z) >= 1.96 {
+			significant = append(significant, ts)
+		}
+	}
+	section("changed share, |z| >= 1.96", significant)
+	for _, sel := range sels {
+		xs, ys := fieldValues(a.Records, sel), fieldValues(b.Records, sel)
+		fmt.Fprintf(w, "\n%s: %d values in a, %d in b\n", sel.spec, len(xs), len(ys))
+		if len(xs) == 0 || len(ys) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "  median %g -> %g\n", xs[len(xs)/2], ys[len(ys)/2])
+		d, p := ksTest(xs, ys)
+		fmt.Fprintf(w, "  Kolmogorov-Smirnov D %.4f, p %.4g\n", d, p)
+	}
+}
+
+// compareMain is `ssample compare [-top N] [-stat field] a.json b.json`
+func compareMain(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	top := fs.Int("top", 20, "templates to list in each section")
+	var statFields stringList
+	fs.Var(&statFields, "stat", "compare the distribution of a numeric field: N, len, json:path, logfmt:key, or clf:latency (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s compare [-top N] [-stat field] a.json b.json\n\nCompare two saved samples, say from before and after a deploy: message templates that are new, gone, or changed in share, and with -stat whether a field's distribution changed.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(exitStart)
+	}
+	var sels []*valueSelector
+	for _, spec := range statFields {
+		sel, err := parseValueSelector(spec)
+		maybefail(err, "-stat: %v\n", err)
+		sels = append(sels, sel)
+	}
+	a, err := readSampleFile(fs.Arg(0))
+	maybefail(err, "%v\n", err)
+	b, err := readSampleFile(fs.Arg(1))
+	maybefail(err, "%v\n", err)
+	writeComparison(os.Stdout, a, b, compareTemplates(a.Records, b.Records), *top, sels)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestKSTest(t *testing.T) {
+	seq := func(n int, from float64) []float64 {
+		out := make([]float64, n)
+		for i := range out {
+			out[i] = from + float64(i)
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		name       string
+		xs, ys     []float64
+		d          float64
+		pmin, pmax float64
+	}{
+		{"identical", seq(500, 0), seq(500, 0), 0, 0.999, 1},
+		{"one value", []float64{3}, []float64{3}, 0, 0.999, 1},
+		{"nearly identical", seq(500, 0), seq(500, 1), 0.002, 0.999, 1},
+		{"interleaved", seq(100, 0), seq(100, 0.5), 0.01, 0.999, 1},
+		{"shifted", seq(500, 0), seq(500, 250), 0.5, 0, 1e-10},
+		{"disjoint", seq(50, 0), seq(50, 1000), 1, 0, 1e-10},
+		// D 0.2 of 100 each, the tables put it at about 0.03
+		{"some shift", seq(100, 0), seq(100, 20), 0.2, 0.02, 0.05},
+	} {
+		d, p := ksTest(tc.xs, tc.ys)
+		if math.Abs(d-tc.d) > 1e-9 || p < tc.pmin || p > tc.pmax {
+			t.Errorf("%s: D %g, p %g, want D %g, p in [%g, %g]", tc.name, d, p, tc.d, tc.pmin, tc.pmax)
+		}
+	}
+}
+
+func TestCompareTemplates(t *testing.T) {
+	var a, b []SampleRecord
+	for i := 0; i < 100; i++ {
+		a = append(a, SampleRecord{Line: "GET /index ok"})
+		line := "GET /index ok"
+		if i%2 == 0 {
+			line = "panic: out of memory"
+		}
+		b = append(b, SampleRecord{Line: line})
+	}
+	shifts := compareTemplates(a, b)
+	if len(shifts) == 0 {
+		t.Fatal("no templates")
+	}
+	for _, ts := range shifts {
+		if ts.shareA < 0 || ts.shareA > 1 || ts.shareB < 0 || ts.shareB > 1 {
+			t.Errorf("%q: shares %g %g", ts.template, ts.shareA, ts.shareB)
+		}
+	}
+	if s := shifts[0]; math.Abs(s.z) < 1.96 {
+		t.Errorf("strongest shift %+v, want |z| >= 1.96", s)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// flagHelp is a flag as `-h` lists it
+type flagHelp struct {
+	name  string
+	usage string
+}
+
+// commandFlags runs `ssample command -h` to list command's flags, the way a user would see them,
+// since each command only makes its flags when it runs
+func commandFlags(exe, command string) []flagHelp {
+	cmd := exec.Command(exe, command, "-h")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	// -h exits 0 or exitStart depending on the command, either way with the flags printed
+	cmd.Run()
+	return parseFlagHelp(&out)
+}
+
+// parseFlagHelp reads flag.PrintDefaults output: "  -name type" then "    \tusage" lines,
+// or "  -n type\tusage" for one letter names
+func parseFlagHelp(r io.Reader) []flagHelp {
+	var out []flagHelp
+	lines := bufio.NewScanner(r)
+	for lines.Scan() {
+		line := lines.Text()
+		if rest, ok := strings.CutPrefix(line, "  -"); ok {
+			head, usage, _ := strings.Cut(rest, "\t")
+			name, _, _ := strings.Cut(head, " ")
+			out = append(out, flagHelp{name: name, usage: strings.TrimSpace(usage)})
+		} else if usage, ok := strings.CutPrefix(line, "    \t"); ok && len(out) != 0 {
+			fh := &out[len(out)-1]
+			fh.usage = strings.TrimSpace(fh.usage + " " + usage)
+		}
+	}
+	return out
+}
+
+// completionMain is `ssample completion bash|zsh|fish`
+func completionMain(args []string) {
+	if len(args) != 1 || (args[0] != "bash" && args[0] != "zsh" && args[0] != "fish") {
+		fmt.Fprintf(os.Stderr, "usage: %s completion bash|zsh|fish\n\nPrint a shell completion script for ssample's commands and flags, e.g.\n\n  %s completion bash > /etc/bash_completion.d/ssample\n  %s completion zsh > \"${fpath[1]}/_ssample\"\n  %s completion fish > ~/.config/fish/completions/ssample.fish\n", os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+		os.Exit(exitStart)
+	}
+	exe, err := os.Executable()
+	maybefail(err, "%v\n", err)
+	flags := make(map[string][]flagHelp)
+	for _, sc := range subcommands {
+		if sc.name != "completion" {
+			flags[sc.name] = commandFlags(exe, sc.name)
+		}
+	}
+	// with no command, sample takes serve's flags too
+	flags[""] = flags["serve"]
+	out := bufio.NewWriter(os.Stdout)
+	switch args[0] {
+	case "bash":
+		writeBashCompletion(out, flags)
+	case "zsh":
+		writeZshCompletion(out, flags)
+	case "fish":
+		writeFishCompletion(out, flags)
+	}
+	out.Flush()
+}
+
+func flagNames(fhs []flagHelp) string {
+	names := make([]string, len(fhs))
+	for i, fh := range fhs {
+		names[i] = "-" + fh.name
+	}
+	return strings.Join(names, " ")
+}
+
+// shellQuote single quotes s for sh, zsh, and fish
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func writeBashCompletion(out io.Writer, flags map[string][]flagHelp) {
+	fmt.Fprintf(out, "# bash completion for ssample, from `ssample completion bash`\n_ssample() {\n")
+	fmt.Fprintf(out, "\tlocal cur=${COMP_WORDS[COMP_CWORD]} cmd=${COMP_WORDS[1]} flags\n")
+	fmt.Fprintf(out, "\tcase $cmd in\n")
+	var names []string
+	for _, sc := range subcommands {
+		names = append(names, sc.name)
+		if sc.name != "completion" {
+			fmt.Fprintf(out, "\t%s) flags=%s ;;\n", sc.name, shellQuote(flagNames(flags[sc.name])))
+		}
+	}
+	fmt.Fprintf(out, "\tcompletion) flags='bash zsh fish' ;;\n")
+	fmt.Fprintf(out, "\t*) flags=%s ;;\n\tesac\n", shellQuote(flagNames(flags[""])))
+	fmt.Fprintf(out, "\tif [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then\n\t\tCOMPREPLY=($(compgen -W %s -- \"$cur\"))\n", shellQuote(strings.Join(names, " ")))
+	fmt.Fprintf(out, "\telif [[ $cur == -* || $cmd == completion ]]; then\n\t\tCOMPREPLY=($(compgen -W \"$flags\" -- \"$cur\"))\n\tfi\n}\n")
+	fmt.Fprintf(out, "complete -o default -F _ssample ssample\n")
+}
+
+// zshDescribe quotes name:description items for _describe
+func zshDescribe(name, usage string) string {
+	return shellQuote(strings.ReplaceAll(name, ":", `\:`) + ":" + usage)
+}
+
+func writeZshCompletion(out io.Writer, flags map[string][]flagHelp) {
+	fmt.Fprintf(out, "#compdef ssample\n# zsh completion for ssample, from `ssample completion zsh`\n\n_ssample() {\n\tlocal -a commands flags\n\tcommands=(\n")
+	for _, sc := range subcommands {
+		fmt.Fprintf(out, "\t\t%s\n", zshDescribe(sc.name, sc.summary))
+	}
+	fmt.Fprintf(out, "\t)\n\tcase $words[2] in\n")
+	for _, sc := range subcommands {
+		if sc.name == "completion" {
+			fmt.Fprintf(out, "\tcompletion) _values shell bash zsh fish; return ;;\n")
+			continue
+		}
+		fmt.Fprintf(out, "\t%s) flags=(", sc.name)
+		for _, fh := range flags[sc.name] {
+			fmt.Fprintf(out, "\n\t\t%s", zshDescribe("-"+fh.name, fh.usage))
+		}
+		fmt.Fprintf(out, "\n\t) ;;\n")
+	}
+	fmt.Fprintf(out, "\t*) flags=(")
+	for _, fh := range flags[""] {
+		fmt.Fprintf(out, "\n\t\t%s", zshDescribe("-"+fh.name, fh.usage))
+	}
+	fmt.Fprintf(out, "\n\t) ;;\n\tesac\n")
+	fmt.Fprintf(out, "\tif (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then\n\t\t_describe -t commands 'ssample command' commands\n\tfi\n")
+	fmt.Fprintf(out, "\tif [[ $PREFIX == -* ]]; then\n\t\t_describe -t flags 'flag' flags\n\telse\n\t\t_files\n\tfi\n}\n\n_ssample \"$@\"\n")
+}
+
+func writeFishCompletion(out io.Writer, flags map[string][]flagHelp) {
+	fmt.Fprintf(out, "# fish completion for ssample, from `ssample completion fish`\n")
+	var names []string
+	for _, sc := range subcommands {
+		names = append(names, sc.name)
+		fmt.Fprintf(out, "complete -c ssample -n __fish_use_subcommand -a %s -d %s\n", sc.name, shellQuote(sc.summary))
+	}
+	for _, sc := range subcommands {
+		if sc.name == "completion" {
+			fmt.Fprintf(out, "complete -c ssample -n '__fish_seen_subcommand_from completion' -f -a 'bash zsh fish'\n")
+			continue
+		}
+		for _, fh := range flags[sc.name] {
+			fmt.Fprintf(out, "complete -c ssample -n '__fish_seen_subcommand_from %s' -o %s -d %s\n", sc.name, fh.name, shellQuote(fh.usage))
+		}
+	}
+	none := shellQuote("not __fish_seen_subcommand_from " + strings.Join(names, " "))
+	for _, fh := range flags[""] {
+		fmt.Fprintf(out, "complete -c ssample -n %s -o %s -d %s\n", none, fh.name, shellQuote(fh.usage))
+	}
+}
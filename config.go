@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const configHelp = `Any flag can also be set by an SSAMPLE_ variable, e.g. SSAMPLE_MAX_LINES=1000 for -max-lines
+(repeatable flags take one value per line), or in the -config file, a TOML file of flag = value:
+
+  l = 1000
+  http = ":4422"
+  match = ["ERROR", "WARN"]
+
+The command line overrides the environment, which overrides -config.`
+
+// applyEnv sets flags of fs not already set from SSAMPLE_NAME=value in environ,
+// NAME being the flag name in caps with _ for -. Other SSAMPLE_ variables are ignored.
+func applyEnv(fs *flag.FlagSet, environ []string) error {
+	set := setFlags(fs)
+	for _, kv := range environ {
+		k, v, ok := strings.Cut(kv, "=")
+		name, isOurs := strings.CutPrefix(k, "SSAMPLE_")
+		if !ok || !isOurs {
+			continue
+		}
+		name = strings.ReplaceAll(strings.ToLower(name), "_", "-")
+		f := fs.Lookup(name)
+		if f == nil || set[name] || name == "config" {
+			continue
+		}
+		values := []string{v}
+		if _, repeatable := f.Value.(*stringList); repeatable {
+			values = strings.Split(v, "\n")
+		}
+		for _, v := range values {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("%s: %v", k, err)
+			}
+		}
+	}
+	return nil
+}
+
+// applyConfigFile sets flags of fs not already set from a -config file
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	fin, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("-config: %v", err)
+	}
+	defer fin.Close()
+	entries, err := parseConfig(bufio.NewScanner(fin), path)
+	if err != nil {
+		return err
+	}
+	set := setFlags(fs)
+	for _, ent := range entries {
+		name := strings.ReplaceAll(ent.key, "_", "-")
+		f := fs.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("%s:%d: no flag -%s for %s", path, ent.line, name, fs.Name())
+		}
+		if set[name] {
+			continue
+		}
+		if _, repeatable := f.Value.(*stringList); !repeatable && len(ent.values) != 1 {
+			return fmt.Errorf("%s:%d: -%s takes one value", path, ent.line, name)
+		}
+		for _, v := range ent.values {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("%s:%d: -%s: %v", path, ent.line, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// setFlags are the names of flags already set in fs
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
+
+// configEntry is a `key = value` or `key = [values, ...]` of a -config file
+type configEntry struct {
+	key    string
+	values []string
+	line   int
+}
+
+var errUnterminated = errors.New("unterminated array")
+
+// parseConfig reads the part of TOML a flag needs: top level keys with
+// string, number, boolean, or (perhaps multi-line) array of those values
+func parseConfig(lines *bufio.Scanner, path string) ([]configEntry, error) {
+	var out []configEntry
+	lineno := 0
+	for lines.Scan() {
+		lineno++
+		text := strings.TrimSpace(lines.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		if text[0] == '[' {
+			return nil, fmt.Errorf("%s:%d: tables aren't supported, put flags at the top level", path, lineno)
+		}
+		key, rest, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want name = value", path, lineno)
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"'`)
+		ent := configEntry{key: key, line: lineno}
+		for {
+			values, err := tomlValues(rest)
+			if err == errUnterminated && lines.Scan() {
+				lineno++
+				rest += "\n" + lines.Text()
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s: %v", path, ent.line, key, err)
+			}
+			ent.values = values
+			break
+		}
+		out = append(out, ent)
+	}
+	return out, lines.Err()
+}
+
+// tomlValues parses the value of a key, a list of them for an array
+func tomlValues(s string) ([]string, error) {
+	s = skipSpace(s)
+	var values []string
+	if strings.HasPrefix(s, "[") {
+		s = skipSpace(s[1:])
+		for !strings.HasPrefix(s, "]") {
+			if s == "" {
+				return nil, errUnterminated
+			}
+			v, rest, err := tomlValue(s)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			s = skipSpace(rest)
+			if strings.HasPrefix(s, ",") {
+				s = skipSpace(s[1:])
+			} else if !strings.HasPrefix(s, "]") && s != "" {
+				return nil, fmt.Errorf("want , or ] in array at %q", s)
+			}
+		}
+		s = s[1:]
+	} else {
+		v, rest, err := tomlValue(s)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		s = rest
+	}
+	if s = skipSpace(s); s != "" {
+		return nil, fmt.Errorf("unexpected %q after value", s)
+	}
+	return values, nil
+}
+
+// tomlValue parses a string or bare value from the start of s
+func tomlValue(s string) (value, rest string, err error) {
+	switch {
+	case s == "":
+		return "", "", errors.New("want a value")
+	case strings.HasPrefix(s, `"""`) || strings.HasPrefix(s, "'''"):
+		return "", "", errors.New("multi-line strings aren't supported")
+	case s[0] == '"':
+		i := 1
+		for i < len(s) && s[i] != '"' {
+			if s[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(s) {
+			return "", "", errors.New("unterminated string")
+		}
+		value, err = strconv.Unquote(s[:i+1])
+		return value, s[i+1:], err
+	case s[0] == '\'':
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", errors.New("unterminated string")
+		}
+		return s[1 : 1+end], s[2+end:], nil
+	}
+	end := strings.IndexAny(s, ",]# \t\n")
+	if end < 0 {
+		end = len(s)
+	}
+	if end == 0 {
+		return "", "", fmt.Errorf("want a value at %q", s)
+	}
+	return s[:end], s[end:], nil
+}
+
+// skipSpace skips whitespace, newlines, and # comments
+func skipSpace(s string) string {
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		if !strings.HasPrefix(s, "#") {
+			return s
+		}
+		nl := strings.IndexByte(s, '\n')
+		if nl < 0 {
+			return ""
+		}
+		s = s[nl:]
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// contextPrinter writes target lines of an archive with lines around them, like grep -C
+type contextPrinter struct {
+	out io.Writer
+	// sorted line numbers to show
+	targets       []int
+	before, after int
+
+	// line number of the next line
+	n int
+	// the last before lines, oldest first
+	ring [][]byte
+	// show lines up to here
+	until int
+	// last line number shown, -1 for none
+	shown int
+}
+
+func newContextPrinter(out io.Writer, targets []int, before, after int) *contextPrinter {
+	sort.Ints(targets)
+	return &contextPrinter{out: out, targets: targets, before: before, after: after, until: -1, shown: -1}
+}
+
+// done is true once past the last target's context
+func (cp *contextPrinter) done() bool {
+	return len(cp.targets) == 0 && cp.n > cp.until
+}
+
+func (cp *contextPrinter) line(line []byte) {
+	n := cp.n
+	cp.n++
+	target := false
+	for len(cp.targets) != 0 && cp.targets[0] == n {
+		cp.targets = cp.targets[1:]
+		target = true
+	}
+	if target {
+		if cp.shown >= 0 && n-len(cp.ring) > cp.shown+1 {
+			fmt.Fprintln(cp.out, "--")
+		}
+		for i, prev := range cp.ring {
+			cp.print(n-len(cp.ring)+i, prev, "")
+		}
+		cp.ring = cp.ring[:0]
+		cp.print(n, line, "*")
+		cp.until = n + cp.after
+		return
+	}
+	if n <= cp.until {
+		cp.print(n, line, "")
+		return
+	}
+	if cp.before > 0 {
+		if len(cp.ring) == cp.before {
+			copy(cp.ring, cp.ring[1:])
+			cp.ring = cp.ring[:len(cp.ring)-1]
+		}
+		cp.ring = append(cp.ring, bytes.Clone(line))
+	}
+}
+
+// print writes "{mark}{lineNumber}\t{line}\n", marking target lines with *
+func (cp *contextPrinter) print(n int, line []byte, mark string) {
+	fmt.Fprintf(cp.out, "%s%d\t%s\n", mark, n, line)
+	cp.shown = n
+}
+
+// contextFile feeds the lines of an -a or -teez archive to cp, skipping tee marks,
+// returning false if cp is done before the end
+func contextFile(cp *contextPrinter, path string, maxLineBytes int) (bool, error) {
+	fin, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer fin.Close()
+	br, err := gunzipMaybe(fin)
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", path, err)
+	}
+	prefix := []byte(teeMarkPrefix)
+	lr := newLineReader(br, maxLineBytes)
+	for lr.Scan() {
+		line := lr.Bytes()
+		if bytes.HasPrefix(line, prefix) {
+			continue
+		}
+		cp.line(line)
+		if cp.done() {
+			return false, nil
+		}
+	}
+	if err := lr.Err(); err != nil {
+		return false, fmt.Errorf("%s: %v", path, err)
+	}
+	return true, nil
+}
+
+// contextMain is `ssample context [-C N] -n LINE ... archive ...`
+func contextMain(args []string) {
+	fs := flag.NewFlagSet("context", flag.ContinueOnError)
+	var lineSpecs stringList
+	fs.Var(&lineSpecs, "n", "line number to show, as sampled (repeatable)")
+	samplePath := fs.String("sample", "", "show the lines of this saved sample (-state, /snapshot, or json)")
+	around := fs.Int("C", 5, "lines to show before and after each")
+	before := fs.Int("B", -1, "lines to show before each (default -C)")
+	after := fs.Int("A", -1, "lines to show after each (default -C)")
+	maxLineBytes := countFlag(fs, "max-line-bytes", defaultMaxLineBytes, "truncate longer lines to this many bytes")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s context [-C N] (-n LINE ... | -sample sample.json) archive ...\n\nPrint sampled lines with the lines around them from the -a or -teez archives of the same input (gzipped or not, read in order as one input). Lines are numbered from 0 like the sample's, so a sample of a whole unfiltered input lines up with its archive. Sampled lines are marked with *.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 || (len(lineSpecs) == 0 && *samplePath == "") {
+		fs.Usage()
+		os.Exit(exitStart)
+	}
+	var targets []int
+	for _, spec := range lineSpecs {
+		n, err := strconv.Atoi(spec)
+		if err != nil || n < 0 {
+			maybefail(fmt.Errorf("bad line number %q", spec), "-n %s: want a line number from 0\n", spec)
+		}
+		targets = append(targets, n)
+	}
+	if *samplePath != "" {
+		ls, err := readSampleFile(*samplePath)
+		maybefail(err, "%v\n", err)
+		for _, rec := range ls.Records {
+			targets = append(targets, rec.LineNumber)
+		}
+	}
+	if *before < 0 {
+		*before = *around
+	}
+	if *after < 0 {
+		*after = *around
+	}
+	out := bufio.NewWriter(os.Stdout)
+	cp := newContextPrinter(out, targets, *before, *after)
+	for _, path := range fs.Args() {
+		more, err := contextFile(cp, path, *maxLineBytes)
+		maybefail(err, "%v\n", err)
+		if !more {
+			break
+		}
+	}
+	out.Flush()
+	if len(cp.targets) != 0 {
+		warnf("%d lines asked for are past the end of the archive, %d lines", len(cp.targets), cp.n)
+	}
+}
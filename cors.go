@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsHandler adds Access-Control-* headers for allowed origins and answers preflight requests itself,
+// so it should wrap outside authHandler.
+type corsHandler struct {
+	next http.Handler
+
+	// allowed origins, or "*"
+	origins []string
+	methods string
+}
+
+func newCorsHandler(next http.Handler, origins, methods string) *corsHandler {
+	return &corsHandler{next: next, origins: splitOrigins(origins), methods: methods}
+}
+
+// splitOrigins parses a comma separated -cors-origin list
+func splitOrigins(origins string) []string {
+	var out []string
+	for _, o := range strings.Split(origins, ",") {
+		o = strings.TrimSpace(o)
+		if o != "" {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+func originListed(origins []string, origin string) bool {
+	for _, o := range origins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func (ch *corsHandler) allowed(origin string) bool {
+	return originListed(ch.origins, origin)
+}
+
+func (ch *corsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" || !ch.allowed(origin) {
+		ch.next.ServeHTTP(w, r)
+		return
+	}
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Add("Vary", "Origin")
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		h.Set("Access-Control-Allow-Methods", ch.methods)
+		h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		h.Set("Access-Control-Max-Age", "600")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	ch.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// patternCounter is a -count name=REGEX, counting input lines that match over all input.
+// Like the other /metrics counters it is never reset.
+type patternCounter struct {
+	name  string
+	re    *regexp.Regexp
+	count atomic.Uint64
+	rate  *rateMeter
+}
+
+// parseCountSpec parses -count name=REGEX
+func parseCountSpec(spec string) (*patternCounter, error) {
+	name, expr, ok := strings.Cut(spec, "=")
+	if !ok || name == "" || expr == "" {
+		return nil, fmt.Errorf("bad -count %q, want name=REGEX", spec)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("-count %s: %v", name, err)
+	}
+	pc := &patternCounter{name: name, re: re}
+	pc.rate = newRateMeter(func() int { return int(pc.count.Load()) }, 60)
+	return pc, nil
+}
+
+func (pc *patternCounter) add(line []byte) {
+	if pc.re.Match(line) {
+		pc.count.Add(1)
+	}
+}
+
+// PatternCount is a -count in /v1/sample
+type PatternCount struct {
+	Name  string `json:"name"`
+	Regex string `json:"regex"`
+	Count uint64 `json:"count"`
+	// over the last minute
+	PerSecond float64 `json:"perSecond"`
+}
+
+func (pc *patternCounter) summary() PatternCount {
+	return PatternCount{Name: pc.name, Regex: pc.re.String(), Count: pc.count.Load(), PerSecond: pc.rate.Rate()}
+}
+
+func (pcs PatternCount) String() string {
+	return fmt.Sprintf("%s: %d lines matched %s (%.3f/s over the last minute)", pcs.Name, pcs.Count, pcs.Regex, pcs.PerSecond)
+}
+
+// promCounts writes -count totals and rates, labeled by name
+func promCounts(w io.Writer, counts []PatternCount) {
+	if len(counts) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP ssample_pattern_lines_total Input lines matching a -count regex.\n# TYPE ssample_pattern_lines_total counter\n")
+	for _, pcs := range counts {
+		fmt.Fprintf(w, "ssample_pattern_lines_total{name=%q} %d\n", pcs.Name, pcs.Count)
+	}
+	fmt.Fprintf(w, "# HELP ssample_pattern_lines_per_second Input lines matching a -count regex per second over the last minute.\n# TYPE ssample_pattern_lines_per_second gauge\n")
+	for _, pcs := range counts {
+		fmt.Fprintf(w, "ssample_pattern_lines_per_second{name=%q} %.3f\n", pcs.Name, pcs.PerSecond)
+	}
+}
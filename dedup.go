@@ -0,0 +1,70 @@
+package main
+
+import (
+	"hash/maphash"
+	"math"
+	"sync/atomic"
+)
+
+// linesDuplicate counts lines -dedup left out as repeats
+var linesDuplicate uint64
+
+// bloomFalsePositive is the rate of first-seen lines -dedup wrongly leaves out once its expected number of distinct lines is reached
+const bloomFalsePositive = 0.01
+
+// bloomFilter is a set of hashes shared by every reader, safe for concurrent use.
+// It may say a line was seen when it wasn't, never the reverse.
+type bloomFilter struct {
+	bits []uint64
+	// hashes per line
+	k    uint64
+	seed maphash.Seed
+	// times each line was left out as a repeat
+	repeats countMin
+}
+
+// newBloomFilter sizes a filter for n distinct lines at bloomFalsePositive
+func newBloomFilter(n int) *bloomFilter {
+	m := math.Ceil(-float64(n) * math.Log(bloomFalsePositive) / (math.Ln2 * math.Ln2))
+	words := max(1, (uint64(m)+63)/64)
+	k := max(1, uint64(math.Round(float64(words*64)/float64(n)*math.Ln2)))
+	return &bloomFilter{bits: make([]uint64, words), k: k, seed: maphash.MakeSeed()}
+}
+
+// add puts line in the set and returns true if it was (probably) already there, counting it as a repeat
+func (bf *bloomFilter) add(line []byte) bool {
+	h := maphash.Bytes(bf.seed, line)
+	// double hashing, the k positions are h1 + i*h2
+	h1, h2 := h, h>>32|1
+	nbits := uint64(len(bf.bits)) * 64
+	seen := true
+	for i := uint64(0); i < bf.k; i++ {
+		bit := (h1 + i*h2) % nbits
+		mask := uint64(1) << (bit % 64)
+		if atomic.OrUint64(&bf.bits[bit/64], mask)&mask == 0 {
+			seen = false
+		}
+	}
+	if seen {
+		bf.repeats.add(h)
+	}
+	return seen
+}
+
+// repeatsOf estimates how many times line was left out after the first, never low
+func (bf *bloomFilter) repeatsOf(line []byte) int {
+	return int(bf.repeats.estimate(maphash.Bytes(bf.seed, line)))
+}
+
+// dedupStage drops lines -dedup has seen before
+type dedupStage struct {
+	seen *bloomFilter
+}
+
+func (ds dedupStage) apply(line []byte) ([]byte, bool) {
+	if ds.seen.add(line) {
+		atomic.AddUint64(&linesDuplicate, 1)
+		return nil, false
+	}
+	return line, true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDedup(t *testing.T) {
+	bf := newBloomFilter(1000)
+	c := NewCollector(10, "")
+	c.SetDedup(bf)
+	stage := dedupStage{seen: bf}
+	before := atomic.LoadUint64(&linesDuplicate)
+	repeats := map[string]int{"a": 4, "b": 1, "c": 0}
+	for _, line := range []string{"a", "b", "a", "c", "a", "b", "a", "a"} {
+		if out, keep := stage.apply([]byte(line)); keep {
+			c.AddBytes(out)
+		}
+	}
+	if got := atomic.LoadUint64(&linesDuplicate) - before; got != 5 {
+		t.Errorf("%d duplicates counted, want 5", got)
+	}
+	records, st, _ := c.Records()
+	if st.LinesSeen != 3 || len(records) != 3 {
+		t.Fatalf("%d lines seen, %d kept, want 3", st.LinesSeen, len(records))
+	}
+	for _, rec := range records {
+		if rec.Repeats != repeats[rec.Line] {
+			t.Errorf("%q repeated %d times, want %d", rec.Line, rec.Repeats, repeats[rec.Line])
+		}
+	}
+
+	// without -dedup there are no repeats to tell of
+	plain := NewCollector(10, "")
+	plain.AddLine("a")
+	if records, _, _ := plain.Records(); records[0].Repeats != 0 {
+		t.Errorf("repeats %d without -dedup", records[0].Repeats)
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	const n = 10000
+	bf := newBloomFilter(n)
+	for i := 0; i < n; i++ {
+		if bf.add([]byte(fmt.Sprint(i))) && i < 100 {
+			t.Errorf("%d taken for a repeat among the first 100 lines", i)
+		}
+	}
+	for i := 0; i < n; i++ {
+		if !bf.add([]byte(fmt.Sprint(i))) {
+			t.Fatalf("%d not seen the second time", i)
+		}
+	}
+	// false positives at the sized number of lines are about bloomFalsePositive
+	fp := 0
+	for i := n; i < n+1000; i++ {
+		if bf.add([]byte(fmt.Sprint(i))) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 1000; rate > 3*bloomFalsePositive {
+		t.Errorf("false positive rate %g", rate)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// sampleLineCounts counts each distinct line of a sample, keeping the order they're first seen in
+func sampleLineCounts(records []SampleRecord) (map[string]int, []string) {
+	counts := make(map[string]int)
+	var order []string
+	for _, rec := range records {
+		if counts[rec.Line] == 0 {
+			order = append(order, rec.Line)
+		}
+		counts[rec.Line]++
+	}
+	return counts, order
+}
+
+// percentChange is "+12.5%", or "" when there's nothing to compare to
+func percentChange(a, b float64) string {
+	if a == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%+.1f%%)", (b-a)/a*100)
+}
+
+// sampleSummary is a sample's sizes and, when its window is known, its rate
+func sampleSummary(ls *loadedSample) (string, float64) {
+	s := fmt.Sprintf("%s, %d lines sampled of %d, %s", ls.Name, len(ls.Records), ls.LinesSeen, formatBytes(ls.BytesSeen))
+	rate := 0.0
+	if d := ls.Window.End.Sub(ls.Window.Start); !ls.Window.Start.IsZero() && d > 0 {
+		rate = float64(ls.LinesSeen) / d.Seconds()
+		s += fmt.Sprintf(" over %s, %.1f lines/s", d.Round(time.Second), rate)
+	}
+	return s, rate
+}
+
+func writeDiff(w io.Writer, a, b *loadedSample, top int) {
+	sa, rateA := sampleSummary(a)
+	sb, rateB := sampleSummary(b)
+	fmt.Fprintf(w, "old: %s\nnew: %s\n", sa, sb)
+	fmt.Fprintf(w, "seen %+d%s, bytes %+d%s", b.LinesSeen-a.LinesSeen, percentChange(float64(a.LinesSeen), float64(b.LinesSeen)),
+		b.BytesSeen-a.BytesSeen, percentChange(float64(a.BytesSeen), float64(b.BytesSeen)))
+	if rateA > 0 && rateB > 0 {
+		fmt.Fprintf(w, ", rate %+.1f lines/s%s", rateB-rateA, percentChange(rateA, rateB))
+	}
+	fmt.Fprintln(w)
+
+	if len(a.Sources) != 0 || len(b.Sources) != 0 {
+		type shift struct {
+			source string
+			a, b   int
+		}
+		bySource := make(map[string]*shift)
+		var shifts []*shift
+		for side, list := range [2][]SourceCount{a.Sources, b.Sources} {
+			for _, sc := range list {
+				sh := bySource[sc.Source]
+				if sh == nil {
+					sh = &shift{source: sc.Source}
+					bySource[sc.Source] = sh
+					shifts = append(shifts, sh)
+				}
+				if side == 0 {
+					sh.a = sc.Lines
+				} else {
+					sh.b = sc.Lines
+				}
+			}
+		}
+		sort.SliceStable(shifts, func(i, j int) bool { return abs(shifts[i].b-shifts[i].a) > abs(shifts[j].b-shifts[j].a) })
+		fmt.Fprintf(w, "\nseen by source:\n")
+		for _, sh := range shifts[:min(top, len(shifts))] {
+			fmt.Fprintf(w, "  %10d -> %10d  %+d%s  %s\n", sh.a, sh.b, sh.b-sh.a, percentChange(float64(sh.a), float64(sh.b)), sh.source)
+		}
+	}
+
+	var onlyA, onlyB []templateShift
+	for _, ts := range compareTemplates(a.Records, b.Records) {
+		switch {
+		case ts.countA == 0:
+			onlyB = append(onlyB, ts)
+		case ts.countB == 0:
+			onlyA = append(onlyA, ts)
+		}
+	}
+	templates := func(title string, list []templateShift, count func(templateShift) int) {
+		if len(list) == 0 {
+			return
+		}
+		sort.SliceStable(list, func(i, j int) bool { return count(list[i]) > count(list[j]) })
+		fmt.Fprintf(w, "\n%s (%d):\n", title, len(list))
+		for _, ts := range list[:min(top, len(list))] {
+			fmt.Fprintf(w, "  %5d  %s\n", count(ts), ts.template)
+		}
+	}
+	templates("templates only in old", onlyA, func(ts templateShift) int { return ts.countA })
+	templates("templates only in new", onlyB, func(ts templateShift) int { return ts.countB })
+
+	countsA, orderA := sampleLineCounts(a.Records)
+	countsB, orderB := sampleLineCounts(b.Records)
+	lines := func(title, mark string, order []string, counts, other map[string]int) {
+		var only []string
+		for _, line := range order {
+			if other[line] == 0 {
+				only = append(only, line)
+			}
+		}
+		if len(only) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "\n%s (%d):\n", title, len(only))
+		for _, line := range only[:min(top, len(only))] {
+			if n := counts[line]; n > 1 {
+				fmt.Fprintf(w, "%s x%d %s\n", mark, n, line)
+			} else {
+				fmt.Fprintf(w, "%s %s\n", mark, line)
+			}
+		}
+	}
+	lines("lines only in old", "-", orderA, countsA, countsB)
+	lines("lines only in new", "+", orderB, countsB, countsA)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// diffMain is `ssample diff [-top N] old.json new.json`
+func diffMain(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	top := fs.Int("top", 20, "sources, templates, and lines to list in each section, 0 for all")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s diff [-top N] old.json new.json\n\nShow what changed between two saved samples, say from consecutive windows: their seen counts and rates,\nand the message templates and lines in one sample but not the other.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(exitStart)
+	}
+	if *top <= 0 {
+		*top = math.MaxInt
+	}
+	a, err := readSampleFile(fs.Arg(0))
+	maybefail(err, "%v\n", err)
+	b, err := readSampleFile(fs.Arg(1))
+	maybefail(err, "%v\n", err)
+	writeDiff(os.Stdout, a, b, *top)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var downloadFormats = map[string]int{
+	"csv":  fmtCSV,
+	"tsv":  fmtTSV,
+	"json": fmtJSON,
+	"txt":  fmtPlain,
+	"raw":  fmtRaw,
+}
+
+// download serves the sample as an attachment, /download?fmt=csv|tsv|json|txt|raw
+func (s *ssampleServer) download(w http.ResponseWriter, r *http.Request) {
+	ext := r.FormValue("fmt")
+	if ext == "" {
+		ext = "csv"
+	}
+	format, ok := downloadFormats[ext]
+	if !ok {
+		badRequest(w, fmt.Errorf("bad fmt %q, want csv, tsv, json, txt, or raw", ext))
+		return
+	}
+	out, err := s.query(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	fname := fmt.Sprintf("ssample-%s.%s", time.Now().UTC().Format("20060102T150405Z"), ext)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fname))
+	writeSample(w, format, out)
+}
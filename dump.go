@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// dumpSample writes the current sample without stopping: as json to path,
+// or with path "" as text like the exit output to stderr, after a line of counts.
+func dumpSample(c *Collector, path string) error {
+	lines, nos := c.LinesAndNumbers()
+	st := c.Stats()
+	if path != "" {
+		blob, err := json.Marshal(struct {
+			sampleHeader
+			*LineNoResponse
+		}{newSampleHeader(c.algorithm()), &LineNoResponse{Lines: lines, LineNumbers: nos, LinesSeen: st.LinesSeen}})
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(path, blob, 0644)
+	}
+	out := bufio.NewWriter(os.Stderr)
+	fmt.Fprintf(out, "# seen %d lines, %d bytes, kept %d\n", st.LinesSeen, st.BytesSeen, st.Kept)
+	for i, ln := range nos {
+		fmt.Fprintf(out, "%d\t%s\n", ln, lines[i])
+	}
+	return out.Flush()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// esBulkDocs is the most documents in one _bulk request
+const esBulkDocs = 1000
+
+// esExporter indexes the final sample into an Elasticsearch or OpenSearch index, a document per sampled line.
+// Document ids are the run and line number, so exporting the same run again replaces its documents.
+type esExporter struct {
+	rc     *remoteClient
+	index  string
+	apiKey string
+}
+
+// esDoc is a sampled line as indexed
+type esDoc struct {
+	// when the line was read, or its -time-regex time
+	Timestamp   time.Time   `json:"@timestamp"`
+	Line        string      `json:"line"`
+	LineNumber  int         `json:"lineNumber"`
+	Source      string      `json:"source,omitempty"`
+	Weight      float64     `json:"weight"`
+	Probability float64     `json:"probability"`
+	Record      interface{} `json:"record,omitempty"`
+	// the run: host, and when its process started
+	Host    string    `json:"host"`
+	Pid     int       `json:"pid"`
+	Started time.Time `json:"started"`
+	// lines seen by the whole sample
+	SampleSeen int `json:"sampleSeen"`
+}
+
+// newESExporter makes an exporter to target, e.g. https://user:pass@es:9200; apiKey is sent as "Authorization: ApiKey ..."
+func newESExporter(target, index, apiKey string, insecure bool) (*esExporter, error) {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("-es: want http[s]://host:port")
+	}
+	if index == "" {
+		return nil, fmt.Errorf("-es-index: empty")
+	}
+	// basic auth from the url, which then isn't in error messages
+	userpass := ""
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		userpass = u.User.Username() + ":" + pass
+		u.User = nil
+	}
+	return &esExporter{rc: newRemoteClient(u.String(), "", userpass, insecure), index: index, apiKey: apiKey}, nil
+}
+
+// esBulkResponse is the part of a _bulk response that says what failed
+type esBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// export indexes the whole sample, returning how many lines were indexed
+func (ee *esExporter) export(s *ssampleServer) (int, error) {
+	v := s.v1Sample()
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	n := 0
+	for i, rec := range v.Lines {
+		action := map[string]map[string]string{"index": {
+			"_index": ee.index,
+			"_id":    fmt.Sprintf("%s-%d-%d-%d", v.Host.Hostname, v.Host.Pid, v.Host.Started.UnixNano(), rec.LineNumber),
+		}}
+		enc.Encode(action)
+		enc.Encode(esDoc{
+			Timestamp:   rec.Time,
+			Line:        rec.Line,
+			LineNumber:  rec.LineNumber,
+			Source:      rec.Source,
+			Weight:      rec.Weight,
+			Probability: rec.Probability,
+			Record:      rec.Record,
+			Host:        v.Host.Hostname,
+			Pid:         v.Host.Pid,
+			Started:     v.Host.Started,
+			SampleSeen:  v.LinesSeen,
+		})
+		if (i+1)%esBulkDocs == 0 || i == len(v.Lines)-1 {
+			indexed, err := ee.bulk(body.Bytes())
+			n += indexed
+			if err != nil {
+				return n, err
+			}
+			body.Reset()
+		}
+	}
+	return n, nil
+}
+
+// bulk sends one _bulk request, returning how many documents were indexed
+func (ee *esExporter) bulk(ndjson []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, ee.rc.base+"/_bulk", bytes.NewReader(ndjson))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if ee.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+ee.apiKey)
+	}
+	blob, err := ee.rc.do(req)
+	if err != nil {
+		return 0, err
+	}
+	var resp esBulkResponse
+	if err := json.Unmarshal(blob, &resp); err != nil {
+		return 0, fmt.Errorf("_bulk: %v", err)
+	}
+	n := 0
+	var firstErr json.RawMessage
+	for _, item := range resp.Items {
+		for _, result := range item {
+			if result.Status >= 200 && result.Status < 300 {
+				n++
+			} else if firstErr == nil {
+				firstErr = result.Error
+			}
+		}
+	}
+	if resp.Errors {
+		return n, fmt.Errorf("_bulk: %d of %d documents failed, first: %s", len(resp.Items)-n, len(resp.Items), firstErr)
+	}
+	return n, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// emitWindow is how often -emit-rate measures the input rate
+const emitWindow = time.Second
+
+// emitBurst is how many seconds of -emit-rate lines can go out at once after the input rate jumps,
+// before the probability catches up
+const emitBurst = 10
+
+// rateEmitter is -emit-rate: it writes lines as they arrive to w, each with a probability that
+// keeps about rate lines per second going out whatever the input rate, and a token bucket holds
+// it to that while the probability catches up with a sudden flood.
+// Its methods but close are called with the Collector's lock held.
+type rateEmitter struct {
+	rate   float64
+	burst  float64
+	bucket tokenBucket
+
+	// input lines per second, a moving average updated every emitWindow, 0 until the first
+	inRate      float64
+	windowStart time.Time
+	windowLines int
+
+	offered, emitted atomic.Int64
+	// its own, to leave the Collector's for sampling
+	rng *rand.Rand
+
+	lines chan []byte
+	done  chan struct{}
+}
+
+// newRateEmitter takes a rate like "600/m" or "50/h" (see parseRate)
+func newRateEmitter(spec string, w io.Writer) (*rateEmitter, error) {
+	rate, err := parseRate(spec)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	e := &rateEmitter{
+		rate:  rate,
+		burst: max(1, rate*emitBurst),
+		// a second's worth to start, not a full bucket's burst
+		bucket:      tokenBucket{tokens: max(1, rate), last: now},
+		windowStart: now,
+		rng:         rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		lines:       make(chan []byte, 1024),
+		done:        make(chan struct{}),
+	}
+	go e.write(w)
+	return e, nil
+}
+
+// write copies lines to w off the Collector's lock, flushing when there are no more waiting
+func (e *rateEmitter) write(w io.Writer) {
+	defer close(e.done)
+	out := bufio.NewWriter(w)
+	for line := range e.lines {
+		out.Write(line)
+		out.WriteByte(recordSep)
+		if len(e.lines) == 0 {
+			out.Flush()
+		}
+	}
+	out.Flush()
+}
+
+// offer is called for every line the Collector sees
+func (e *rateEmitter) offer(line string, b []byte) {
+	e.offered.Add(1)
+	now := time.Now()
+	e.windowLines++
+	if dt := now.Sub(e.windowStart); dt >= emitWindow {
+		measured := float64(e.windowLines) / dt.Seconds()
+		if e.inRate == 0 {
+			e.inRate = measured
+		} else {
+			e.inRate = (e.inRate + measured) / 2
+		}
+		e.windowStart = now
+		e.windowLines = 0
+	}
+	bk := &e.bucket
+	bk.tokens = min(e.burst, bk.tokens+now.Sub(bk.last).Seconds()*e.rate)
+	bk.last = now
+	if e.inRate > e.rate && e.rng.Float64() >= e.rate/e.inRate {
+		return
+	}
+	if bk.tokens < 1 {
+		return
+	}
+	bk.tokens--
+	e.emitted.Add(1)
+	if b != nil {
+		e.lines <- append([]byte(nil), b...)
+	} else {
+		e.lines <- []byte(line)
+	}
+}
+
+// close waits for emitted lines to be written
+func (e *rateEmitter) close() {
+	close(e.lines)
+	<-e.done
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// Estimate is how many lines of all input match a regex, judging by the sample.
+// /v1/estimate responds with this. Fields are only ever added.
+type Estimate struct {
+	Match string `json:"match"`
+	// sampled lines, and how many of them match
+	Sampled int `json:"sampled"`
+	Matched int `json:"matched"`
+	// input lines the sample is of
+	Seen       int     `json:"seen"`
+	Confidence float64 `json:"confidence"`
+	Proportion float64 `json:"proportion"`
+	Low        float64 `json:"low"`
+	High       float64 `json:"high"`
+	// Proportion, Low, and High times Seen
+	Count     float64 `json:"count"`
+	CountLow  float64 `json:"countLow"`
+	CountHigh float64 `json:"countHigh"`
+}
+
+// estimateMatches estimates the share of seen input lines matching re from the sample lines,
+// with a Wilson score interval. The sample is drawn without replacement, so the interval
+// narrows to nothing as the sample approaches all of the input.
+// That's only so of a uniform sample, alg is its sampleHeader algorithm.
+func estimateMatches(alg string, lines []string, seen int, re *regexp.Regexp, confidence float64) (Estimate, error) {
+	if !uniformAlgorithm(alg) {
+		return Estimate{}, fmt.Errorf("can't estimate from a %s sample, its lines aren't equally likely; only reservoir samples estimate", alg)
+	}
+	est := Estimate{Match: re.String(), Sampled: len(lines), Seen: seen, Confidence: confidence}
+	for _, line := range lines {
+		if re.MatchString(line) {
+			est.Matched++
+		}
+	}
+	n := float64(est.Sampled)
+	if n == 0 {
+		est.High = 1
+		est.CountHigh = float64(seen)
+		return est, nil
+	}
+	p := float64(est.Matched) / n
+	est.Proportion = p
+	est.Low, est.High = p, p
+	if float64(seen) > n {
+		// finite population correction, as a larger effective sample size
+		nEff := n * float64(seen-1) / (float64(seen) - n)
+		z := math.Sqrt2 * math.Erfinv(confidence)
+		z2 := z * z
+		center := (p + z2/(2*nEff)) / (1 + z2/nEff)
+		half := z / (1 + z2/nEff) * math.Sqrt(p*(1-p)/nEff+z2/(4*nEff*nEff))
+		est.Low = max(0, center-half)
+		est.High = min(1, center+half)
+	}
+	est.Count = est.Proportion * float64(seen)
+	est.CountLow = est.Low * float64(seen)
+	est.CountHigh = est.High * float64(seen)
+	return est, nil
+}
+
+func recordLines(records []SampleRecord) []string {
+	out := make([]string, len(records))
+	for i, rec := range records {
+		out[i] = rec.Line
+	}
+	return out
+}
+
+func (est Estimate) String() string {
+	return fmt.Sprintf("%d of %d sampled lines match %s: %.4g%% (%.4g%% to %.4g%% at %g%% confidence), about %.0f (%.0f to %.0f) of %d lines",
+		est.Matched, est.Sampled, est.Match, est.Proportion*100, est.Low*100, est.High*100, est.Confidence*100,
+		est.Count, est.CountLow, est.CountHigh, est.Seen)
+}
+
+// parseConfidence parses a confidence level like 0.95, "" for 0.95
+func parseConfidence(s string) (float64, error) {
+	if s == "" {
+		return 0.95, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 || v >= 1 {
+		return 0, errors.New("confidence must be between 0 and 1")
+	}
+	return v, nil
+}
+
+// estimate serves GET /v1/estimate?match=REGEX[&confidence=0.95]
+func (s *ssampleServer) estimate(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	re, err := regexp.Compile(q.Get("match"))
+	if err != nil || q.Get("match") == "" {
+		badRequest(w, fmt.Errorf("match: want a regex"))
+		return
+	}
+	confidence, err := parseConfidence(q.Get("confidence"))
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	records, st, _ := s.c.Records()
+	est, err := estimateMatches(s.c.algorithm(), recordLines(records), st.LinesSeen, re, confidence)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if boolish(q.Get("t")) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "%s\n", est)
+		return
+	}
+	blob, err := json.Marshal(est)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(blob)
+}
+
+// estimateMain is `ssample estimate -match REGEX sample.json`
+func estimateMain(args []string) {
+	fs := flag.NewFlagSet("estimate", flag.ContinueOnError)
+	match := fs.String("match", "", "regex to estimate the matching lines of")
+	confidence := fs.Float64("confidence", 0.95, "confidence level of the interval")
+	asJSON := fs.Bool("json", false, "write the estimate as json")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s estimate -match REGEX [-confidence 0.95] sample.json\n\nEstimate how many lines of all the input a saved sample is of match REGEX, with a confidence interval.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 || *match == "" {
+		fs.Usage()
+		os.Exit(exitStart)
+	}
+	re, err := regexp.Compile(*match)
+	maybefail(err, "-match: %v\n", err)
+	if *confidence <= 0 || *confidence >= 1 {
+		maybefail(errors.New("out of range"), "-confidence %v: want a fraction from 0 to 1\n", *confidence)
+	}
+	ls, err := readSampleFile(fs.Arg(0))
+	maybefail(err, "%v\n", err)
+	est, err := estimateMatches(ls.Algorithm, recordLines(ls.Records), ls.LinesSeen, re, *confidence)
+	maybefail(err, "%s: %v\n", fs.Arg(0), err)
+	if *asJSON {
+		blob, err := json.Marshal(est)
+		maybefail(err, "json: %v\n", err)
+		os.Stdout.Write(append(blob, '\n'))
+		return
+	}
+	fmt.Println(est)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestEstimateMatches(t *testing.T) {
+	lines := []string{"ERROR a", "ok", "ok", "ERROR b"}
+	re := regexp.MustCompile("ERROR")
+	est, err := estimateMatches(algReservoir, lines, 400, re, 0.95)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if est.Matched != 2 || est.Proportion != 0.5 || est.Count != 200 || est.Low >= 0.5 || est.High <= 0.5 || est.CountLow >= 200 || est.CountHigh <= 200 {
+		t.Errorf("estimate %+v", est)
+	}
+	// the whole input was kept, so there's no doubt
+	if est, _ := estimateMatches(algMerge, lines, 4, re, 0.95); est.Low != 0.5 || est.High != 0.5 || est.Count != 2 {
+		t.Errorf("estimate of all the input %+v", est)
+	}
+	if est, _ := estimateMatches("", nil, 10, re, 0.95); est.Low != 0 || est.High != 1 || est.CountHigh != 10 {
+		t.Errorf("estimate of nothing %+v", est)
+	}
+	for _, alg := range []string{algPriority, algKeyed} {
+		if _, err := estimateMatches(alg, lines, 400, re, 0.95); err == nil || !strings.Contains(err.Error(), alg) {
+			t.Errorf("%s: %v, want an error", alg, err)
+		}
+	}
+}
+
+func TestEstimateHandler(t *testing.T) {
+	uniform := NewCollector(10, "")
+	unusual := NewCollector(10, "")
+	unusual.SetUnusual(newUnusualSampler(1))
+	for i := 0; i < 5; i++ {
+		uniform.AddLine(fmt.Sprintf("ERROR %d", i))
+		unusual.AddLine(fmt.Sprintf("ERROR %d", i))
+	}
+	for _, tc := range []struct {
+		c      *Collector
+		query  string
+		status int
+		body   string
+	}{
+		{uniform, "match=ERROR&t=1", http.StatusOK, "5 of 5 sampled lines match ERROR"},
+		{uniform, "match=ERROR&confidence=2", http.StatusBadRequest, "confidence"},
+		{uniform, "match=(", http.StatusBadRequest, "regex"},
+		{unusual, "match=ERROR", http.StatusBadRequest, "priority sample"},
+	} {
+		w := httptest.NewRecorder()
+		(&ssampleServer{c: tc.c}).estimate(w, httptest.NewRequest("GET", "/v1/estimate?"+tc.query, nil))
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.body) {
+			t.Errorf("%s: %d %q, want %d %q", tc.query, w.Code, w.Body.String(), tc.status, tc.body)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Version identifies the sample state: it changes whenever the sample or seen count does
+func (c *Collector) Version() (version uint64, seen int) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.version, c.linesSeen
+}
+
+// notModified sets the ETag and answers 304 if the client already has this version.
+// The ETag is weak since the gzip and identity encodings of a response share it, and responses vary by Accept-Encoding.
+// ?n= responses are random so they get no ETag.
+func notModified(w http.ResponseWriter, r *http.Request, c *Collector, format int) bool {
+	if r.FormValue("n") != "" {
+		return false
+	}
+	version, seen := c.Version()
+	tag := fmt.Sprintf(`"%d-%d-%d"`, version, seen, format)
+	h := w.Header()
+	h.Set("ETag", "W/"+tag)
+	if !slices.Contains(h.Values("Vary"), "Accept-Encoding") {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(inm, ",") {
+		// If-None-Match compares weakly
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == tag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ReservoirChange is one insertion into the sample, an eviction when it shrinks, or a Reset
+type ReservoirChange struct {
+	// line inserted, -1 if none
+	LineNumber int    `json:"lineNumber"`
+	Line       string `json:"line"`
+	// line number replaced, -1 if the reservoir was not yet full
+	Evicted int  `json:"evicted"`
+	Reset   bool `json:"reset,omitempty"`
+	// the inserted line's time and source
+	Time   time.Time `json:"time,omitzero"`
+	Source string    `json:"source,omitempty"`
+}
+
+type changeSub struct {
+	ch chan ReservoirChange
+	// set if a change didn't fit in ch
+	lost uint32
+}
+
+// Subscribe gets reservoir changes until cancel is called.
+// A slow subscriber misses changes rather than blocking AddLine; lost() reports if that happened since the last call.
+func (c *Collector) Subscribe(buffer int) (changes <-chan ReservoirChange, lost func() bool, cancel func()) {
+	sub := &changeSub{ch: make(chan ReservoirChange, buffer)}
+	c.l.Lock()
+	c.subs = append(c.subs, sub)
+	c.l.Unlock()
+	lost = func() bool {
+		return atomic.SwapUint32(&sub.lost, 0) != 0
+	}
+	cancel = func() {
+		c.l.Lock()
+		defer c.l.Unlock()
+		for i, s := range c.subs {
+			if s == sub {
+				c.subs = append(c.subs[:i], c.subs[i+1:]...)
+				return
+			}
+		}
+	}
+	return sub.ch, lost, cancel
+}
+
+// notify records a reservoir change and passes it to subscribers, it must be called with c.l held
+func (c *Collector) notify(change ReservoirChange) {
+	c.version++
+	if c.changes != nil {
+		c.changes.add(change)
+	}
+	for _, sub := range c.subs {
+		select {
+		case sub.ch <- change:
+		default:
+			atomic.StoreUint32(&sub.lost, 1)
+		}
+	}
+}
+
+// events serves /events, a text/event-stream of "insert", "evict", and "reset" events.
+// A "lost" event means changes were dropped and the client should refetch the sample.
+func (s *ssampleServer) events(w http.ResponseWriter, r *http.Request) {
+	fl, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	changes, lost, cancel := s.c.Subscribe(1000)
+	defer cancel()
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: 5000\n\n")
+	fl.Flush()
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprintf(w, ": heartbeat\n\n")
+		case ch := <-changes:
+			if lost() {
+				fmt.Fprintf(w, "event: lost\ndata: {}\n\n")
+			}
+			writeChangeEvents(w, ch)
+			// send whatever else is queued before flushing
+			for more := true; more; {
+				select {
+				case ch = <-changes:
+					writeChangeEvents(w, ch)
+				default:
+					more = false
+				}
+			}
+		}
+		fl.Flush()
+	}
+}
+
+func writeChangeEvents(w http.ResponseWriter, ch ReservoirChange) {
+	if ch.Reset {
+		fmt.Fprintf(w, "event: reset\ndata: {}\n\n")
+		return
+	}
+	if ch.Evicted >= 0 {
+		fmt.Fprintf(w, "event: evict\ndata: {\"lineNumber\":%d}\n\n", ch.Evicted)
+	}
+	if ch.LineNumber < 0 {
+		return
+	}
+	blob, _ := json.Marshal(struct {
+		LineNumber int    `json:"lineNumber"`
+		Line       string `json:"line"`
+	}{ch.LineNumber, ch.Line})
+	fmt.Fprintf(w, "event: insert\ndata: %s\n\n", blob)
+}
@@ -0,0 +1,883 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode"
+)
+
+// linesExprErrors counts lines a -filter or -transform expression failed on, e.g. comparing a string with a bool
+var linesExprErrors uint64
+
+// -filter and -transform expressions are a small CEL-like language over the line:
+//
+//	line                               the input line, a string
+//	"abc" 'abc' 12 1.5 true false null literals, and lists like ["a", "b"]
+//	! - * / % + - < <= > >= == != in && || ?:  operators, + also joins strings
+//	f(x, y) or x.f(y)                  function calls, see exprFuncs
+//
+// Values are null (a missing field), bool, number, string, or list.
+// Comparing a number with a string reads the string as a number (or a duration like "12ms" in seconds).
+// Functions taking strings treat null as "".
+//
+// It's written here rather than using CEL or expr because ssample has no dependencies outside the
+// standard library, and both bring in far more (CEL its protobuf runtime) than line filters need.
+// expr_test.go has its precedence, functions, and errors, and a fuzz test that it doesn't panic.
+
+// exprNode is a parsed expression
+type exprNode interface {
+	eval(env *exprEnv) (interface{}, error)
+}
+
+// exprEnv is one line being evaluated, with its JSON and logfmt parsed on first use
+type exprEnv struct {
+	line []byte
+
+	jsonDone bool
+	jsonV    interface{}
+	fields   [][]byte
+	split    bool
+}
+
+func (env *exprEnv) json() interface{} {
+	if !env.jsonDone {
+		env.jsonDone = true
+		dec := json.NewDecoder(bytes.NewReader(env.line))
+		dec.UseNumber()
+		if dec.Decode(&env.jsonV) != nil {
+			env.jsonV = nil
+		}
+	}
+	return env.jsonV
+}
+
+// parseExpr parses a -filter or -transform expression
+func parseExpr(src string) (exprNode, error) {
+	p := &exprParser{src: src}
+	p.next()
+	node, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != tokEOF {
+		return nil, p.errorf("unexpected %s", p.text)
+	}
+	return node, nil
+}
+
+const (
+	tokEOF = iota
+	tokNum
+	tokStr
+	tokIdent
+	tokOp
+)
+
+type exprParser struct {
+	src string
+	pos int
+
+	// the current token
+	tok  int
+	text string
+	// of tokStr, unquoted
+	str string
+	// of tokNum
+	num   float64
+	start int
+	err   error
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at %d of %q: %s", p.start+1, p.src, fmt.Sprintf(format, args...))
+}
+
+// next reads the next token, errors are kept in p.err and give tokEOF
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	p.start = p.pos
+	if p.pos >= len(p.src) {
+		p.tok, p.text = tokEOF, "end"
+		return
+	}
+	c := p.src[p.pos]
+	switch {
+	case c >= '0' && c <= '9' || (c == '.' && p.pos+1 < len(p.src) && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '9'):
+		end := p.pos
+		for end < len(p.src) && (strings.IndexByte("0123456789.eE_", p.src[end]) >= 0 ||
+			((p.src[end] == '-' || p.src[end] == '+') && (p.src[end-1] == 'e' || p.src[end-1] == 'E'))) {
+			end++
+		}
+		p.text = p.src[p.pos:end]
+		p.pos = end
+		v, err := strconv.ParseFloat(strings.ReplaceAll(p.text, "_", ""), 64)
+		if err != nil {
+			p.fail(p.errorf("bad number %s", p.text))
+			return
+		}
+		p.tok, p.num = tokNum, v
+	case c == '"' || c == '\'':
+		end := p.pos + 1
+		for end < len(p.src) && p.src[end] != c {
+			if p.src[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(p.src) {
+			p.fail(p.errorf("unterminated string"))
+			return
+		}
+		p.text = p.src[p.pos : end+1]
+		p.pos = end + 1
+		body := p.text[1 : len(p.text)-1]
+		if c == '\'' {
+			// the same escapes as "", with ' unescaped
+			body = strings.ReplaceAll(strings.ReplaceAll(body, `\'`, `'`), `"`, `\"`)
+		}
+		s, err := strconv.Unquote(`"` + body + `"`)
+		if err != nil {
+			p.fail(p.errorf("bad string %s", p.text))
+			return
+		}
+		p.tok, p.str = tokStr, s
+	case c == '_' || unicode.IsLetter(rune(c)):
+		end := p.pos
+		for end < len(p.src) && (p.src[end] == '_' || unicode.IsLetter(rune(p.src[end])) || unicode.IsDigit(rune(p.src[end]))) {
+			end++
+		}
+		p.tok, p.text = tokIdent, p.src[p.pos:end]
+		p.pos = end
+	default:
+		for _, op := range []string{"||", "&&", "==", "!=", "<=", ">=", "!", "<", ">", "+", "-", "*", "/", "%", "(", ")", "[", "]", ",", ".", "?", ":"} {
+			if strings.HasPrefix(p.src[p.pos:], op) {
+				p.tok, p.text = tokOp, op
+				p.pos += len(op)
+				return
+			}
+		}
+		p.fail(p.errorf("unexpected %q", c))
+	}
+}
+
+func (p *exprParser) fail(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+	p.tok, p.text = tokEOF, "end"
+	p.pos = len(p.src)
+}
+
+func (p *exprParser) isOp(op string) bool {
+	return p.tok == tokOp && p.text == op
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.isOp(op) {
+		return p.errorf("want %s, not %s", op, p.text)
+	}
+	p.next()
+	return nil
+}
+
+// parseCond is a ? b : c, lowest precedence
+func (p *exprParser) parseCond() (exprNode, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil || !p.isOp("?") {
+		return cond, err
+	}
+	p.next()
+	a, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	b, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	return &condNode{cond, a, b}, nil
+}
+
+// binary operators by precedence, lowest first
+var exprPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">=", "in"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) binaryOp(level int) (string, bool) {
+	if p.tok != tokOp && !(p.tok == tokIdent && p.text == "in") {
+		return "", false
+	}
+	for _, op := range exprPrecedence[level] {
+		if p.text == op {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) parseBinary(level int) (exprNode, error) {
+	if level == len(exprPrecedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.binaryOp(level)
+		if !ok {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op, left, right}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.isOp("!") || p.isOp("-") {
+		op := p.text
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op, x}, nil
+	}
+	return p.parsePostfix()
+}
+
+// parsePostfix is a primary followed by any .method(args) and [index]
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.isOp("."):
+			p.next()
+			if p.tok != tokIdent {
+				return nil, p.errorf("want a method name after ., not %s", p.text)
+			}
+			name := p.text
+			p.next()
+			if !p.isOp("(") {
+				return nil, p.errorf("want %s(...), there are no fields, use json(\"path\") or logfmt(\"key\")", name)
+			}
+			x, err = p.parseCall(name, x)
+			if err != nil {
+				return nil, err
+			}
+		case p.isOp("["):
+			p.next()
+			i, err := p.parseCond()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &indexNode{x, i}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	switch p.tok {
+	case tokNum:
+		v := p.num
+		p.next()
+		return &litNode{v}, nil
+	case tokStr:
+		v := p.str
+		p.next()
+		return &litNode{v}, nil
+	case tokIdent:
+		name, start := p.text, p.start
+		p.next()
+		switch name {
+		case "true":
+			return &litNode{true}, nil
+		case "false":
+			return &litNode{false}, nil
+		case "null":
+			return &litNode{nil}, nil
+		case "line":
+			return lineNode{}, nil
+		}
+		if p.isOp("(") {
+			return p.parseCall(name, nil)
+		}
+		p.start = start
+		return nil, p.errorf("unknown name %s, the line is `line`", name)
+	case tokOp:
+		switch p.text {
+		case "(":
+			p.next()
+			x, err := p.parseCond()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			p.next()
+			list := &listNode{}
+			for !p.isOp("]") {
+				item, err := p.parseCond()
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, item)
+				if !p.isOp(",") {
+					break
+				}
+				p.next()
+			}
+			return list, p.expect("]")
+		}
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return nil, p.errorf("unexpected %s", p.text)
+}
+
+// parseCall parses the arguments of name(, with a method call's receiver as the first
+func (p *exprParser) parseCall(name string, receiver exprNode) (exprNode, error) {
+	fn, ok := exprFuncs[name]
+	if !ok {
+		return nil, p.errorf("unknown function %s", name)
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	call := &callNode{name: name, fn: fn}
+	if receiver != nil {
+		call.args = append(call.args, receiver)
+	}
+	for !p.isOp(")") {
+		arg, err := p.parseCond()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if len(call.args) < fn.minArgs || len(call.args) > fn.maxArgs {
+		return nil, p.errorf("%s takes %s", name, fn.args)
+	}
+	if name == "matches" {
+		// compiled once here rather than per line
+		lit, ok := call.args[1].(*litNode)
+		var expr string
+		if ok {
+			expr, ok = lit.v.(string)
+		}
+		if !ok {
+			return nil, p.errorf("matches wants a regex string literal")
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, p.errorf("matches: %v", err)
+		}
+		call.args[1] = &litNode{re}
+	}
+	return call, nil
+}
+
+type litNode struct{ v interface{} }
+
+func (n *litNode) eval(env *exprEnv) (interface{}, error) { return n.v, nil }
+
+type lineNode struct{}
+
+func (lineNode) eval(env *exprEnv) (interface{}, error) { return string(env.line), nil }
+
+type listNode struct{ items []exprNode }
+
+func (n *listNode) eval(env *exprEnv) (interface{}, error) {
+	out := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+type condNode struct{ cond, a, b exprNode }
+
+func (n *condNode) eval(env *exprEnv) (interface{}, error) {
+	c, err := evalBool(n.cond, env)
+	if err != nil {
+		return nil, err
+	}
+	if c {
+		return n.a.eval(env)
+	}
+	return n.b.eval(env)
+}
+
+func evalBool(n exprNode, env *exprEnv) (bool, error) {
+	v, err := n.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s is not true or false", exprString(v))
+	}
+	return b, nil
+}
+
+type unaryNode struct {
+	op string
+	x  exprNode
+}
+
+func (n *unaryNode) eval(env *exprEnv) (interface{}, error) {
+	if n.op == "!" {
+		b, err := evalBool(n.x, env)
+		return !b, err
+	}
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := exprNumber(v)
+	if !ok {
+		return nil, fmt.Errorf("-%s: not a number", exprString(v))
+	}
+	return -f, nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n *binaryNode) eval(env *exprEnv) (interface{}, error) {
+	switch n.op {
+	case "&&", "||":
+		l, err := evalBool(n.left, env)
+		if err != nil || l == (n.op == "||") {
+			return l, err
+		}
+		return evalBool(n.right, env)
+	}
+	l, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return exprEqual(l, r), nil
+	case "!=":
+		return !exprEqual(l, r), nil
+	case "in":
+		list, ok := r.([]interface{})
+		if !ok {
+			if s, isStr := r.(string); isStr {
+				// substring, like CEL's contains
+				return strings.Contains(s, exprString(l)), nil
+			}
+			return nil, fmt.Errorf("in %s: want a list or string", exprString(r))
+		}
+		for _, item := range list {
+			if exprEqual(l, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "<", "<=", ">", ">=":
+		if l == nil || r == nil {
+			// a missing field compares false
+			return false, nil
+		}
+		c, err := exprCompare(l, r)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	case "+":
+		_, ls := l.(string)
+		_, rs := r.(string)
+		if ls || rs || l == nil || r == nil {
+			return exprString(l) + exprString(r), nil
+		}
+	}
+	lf, lok := exprNumber(l)
+	rf, rok := exprNumber(r)
+	if !lok || !rok {
+		return nil, fmt.Errorf("%s %s %s: want numbers", exprString(l), n.op, exprString(r))
+	}
+	switch n.op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return lf / rf, nil
+	}
+	// % is of whole numbers, so 5 % 0.5 is by zero too
+	if int64(rf) == 0 {
+		return nil, errors.New("modulo by zero")
+	}
+	return float64(int64(lf) % int64(rf)), nil
+}
+
+type indexNode struct{ x, i exprNode }
+
+func (n *indexNode) eval(env *exprEnv) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := n.i.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := exprNumber(iv)
+	if !ok {
+		return nil, fmt.Errorf("[%s]: want a number", exprString(iv))
+	}
+	i := int(f)
+	switch xv := x.(type) {
+	case []interface{}:
+		if i < 0 {
+			i += len(xv)
+		}
+		if i < 0 || i >= len(xv) {
+			return nil, nil
+		}
+		return xv[i], nil
+	case string:
+		if i < 0 {
+			i += len(xv)
+		}
+		if i < 0 || i >= len(xv) {
+			return nil, nil
+		}
+		return xv[i : i+1], nil
+	case nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("%s[%d]: want a list or string", exprString(x), i)
+}
+
+type callNode struct {
+	name string
+	fn   *exprFunc
+	args []exprNode
+}
+
+func (n *callNode) eval(env *exprEnv) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := n.fn.call(env, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", n.name, err)
+	}
+	return v, nil
+}
+
+type exprFunc struct {
+	minArgs, maxArgs int
+	// for errors, e.g. "(string, substring)"
+	args string
+	call func(env *exprEnv, args []interface{}) (interface{}, error)
+}
+
+func strFunc(f func(s string) interface{}) *exprFunc {
+	return &exprFunc{1, 1, "(string)", func(env *exprEnv, args []interface{}) (interface{}, error) {
+		return f(exprString(args[0])), nil
+	}}
+}
+
+func str2Func(f func(s, t string) interface{}) *exprFunc {
+	return &exprFunc{2, 2, "(string, string)", func(env *exprEnv, args []interface{}) (interface{}, error) {
+		return f(exprString(args[0]), exprString(args[1])), nil
+	}}
+}
+
+var exprFuncs map[string]*exprFunc
+
+func init() {
+	exprFuncs = map[string]*exprFunc{
+		"len": {1, 1, "(string or list)", func(env *exprEnv, args []interface{}) (interface{}, error) {
+			if list, ok := args[0].([]interface{}); ok {
+				return float64(len(list)), nil
+			}
+			return float64(len(exprString(args[0]))), nil
+		}},
+		"contains":   str2Func(func(s, t string) interface{} { return strings.Contains(s, t) }),
+		"startsWith": str2Func(func(s, t string) interface{} { return strings.HasPrefix(s, t) }),
+		"endsWith":   str2Func(func(s, t string) interface{} { return strings.HasSuffix(s, t) }),
+		"indexOf":    str2Func(func(s, t string) interface{} { return float64(strings.Index(s, t)) }),
+		"split": str2Func(func(s, sep string) interface{} {
+			var out []interface{}
+			for _, part := range strings.Split(s, sep) {
+				out = append(out, part)
+			}
+			return out
+		}),
+		"matches": {2, 2, "(string, regex)", func(env *exprEnv, args []interface{}) (interface{}, error) {
+			return args[1].(*regexp.Regexp).MatchString(exprString(args[0])), nil
+		}},
+		"lower": strFunc(func(s string) interface{} { return strings.ToLower(s) }),
+		"upper": strFunc(func(s string) interface{} { return strings.ToUpper(s) }),
+		"trim":  strFunc(func(s string) interface{} { return strings.TrimSpace(s) }),
+		"replace": {3, 3, "(string, old, new)", func(env *exprEnv, args []interface{}) (interface{}, error) {
+			return strings.ReplaceAll(exprString(args[0]), exprString(args[1]), exprString(args[2])), nil
+		}},
+		"substr": {2, 3, "(string, start[, end])", func(env *exprEnv, args []interface{}) (interface{}, error) {
+			s := exprString(args[0])
+			start, ok := exprNumber(args[1])
+			end := float64(len(s))
+			if len(args) == 3 {
+				var eok bool
+				end, eok = exprNumber(args[2])
+				ok = ok && eok
+			}
+			if !ok {
+				return nil, errors.New("want number positions")
+			}
+			i, j := max(0, min(int(start), len(s))), max(0, min(int(end), len(s)))
+			if j < i {
+				j = i
+			}
+			return s[i:j], nil
+		}},
+		"string": {1, 1, "(value)", func(env *exprEnv, args []interface{}) (interface{}, error) {
+			return exprString(args[0]), nil
+		}},
+		"double": {1, 1, "(value)", func(env *exprEnv, args []interface{}) (interface{}, error) {
+			f, ok := exprNumber(args[0])
+			if !ok {
+				return nil, nil
+			}
+			return f, nil
+		}},
+		"int": {1, 1, "(value)", func(env *exprEnv, args []interface{}) (interface{}, error) {
+			f, ok := exprNumber(args[0])
+			if !ok {
+				return nil, nil
+			}
+			return float64(int64(f)), nil
+		}},
+		"field": {1, 1, "(n), from 1", func(env *exprEnv, args []interface{}) (interface{}, error) {
+			f, ok := exprNumber(args[0])
+			if !ok {
+				return nil, errors.New("want a field number")
+			}
+			if !env.split {
+				env.fields = splitFields(env.fields[:0], env.line, nil)
+				env.split = true
+			}
+			i := int(f)
+			if i < 1 || i > len(env.fields) {
+				return nil, nil
+			}
+			return string(env.fields[i-1]), nil
+		}},
+		"json": {1, 1, "(path)", func(env *exprEnv, args []interface{}) (interface{}, error) {
+			v, ok := parseJSONPath(exprString(args[0])).lookup(env.json())
+			if !ok {
+				return nil, nil
+			}
+			switch x := v.(type) {
+			case json.Number:
+				f, err := x.Float64()
+				if err != nil {
+					return string(x), nil
+				}
+				return f, nil
+			case string, bool, nil:
+				return x, nil
+			}
+			// objects and arrays as their JSON
+			return string(appendJSONValue(nil, v)), nil
+		}},
+		"logfmt": {1, 1, "(key)", func(env *exprEnv, args []interface{}) (interface{}, error) {
+			key := exprString(args[0])
+			var out interface{}
+			scanLogfmt(env.line, func(k, v []byte) bool {
+				if string(k) == key {
+					out = string(v)
+					return false
+				}
+				return true
+			})
+			return out, nil
+		}},
+	}
+}
+
+// exprString is v as a string: null is "", numbers as short as they go
+func exprString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	case []interface{}:
+		parts := make([]string, len(x))
+		for i, item := range x {
+			parts[i] = strconv.Quote(exprString(item))
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return fmt.Sprint(v)
+}
+
+// exprNumber is v as a number, a string read as one (or a duration in seconds)
+func exprNumber(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case string:
+		return parseNumber(strings.TrimSpace(x))
+	}
+	return 0, false
+}
+
+func exprEqual(l, r interface{}) bool {
+	switch lv := l.(type) {
+	case float64:
+		rf, ok := exprNumber(r)
+		return ok && lv == rf
+	case string:
+		if rf, ok := r.(float64); ok {
+			lf, ok := exprNumber(lv)
+			return ok && lf == rf
+		}
+		rs, ok := r.(string)
+		return ok && lv == rs
+	case []interface{}:
+		rl, ok := r.([]interface{})
+		if !ok || len(rl) != len(lv) {
+			return false
+		}
+		for i := range lv {
+			if !exprEqual(lv[i], rl[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return l == r
+}
+
+func exprCompare(l, r interface{}) (int, error) {
+	ls, lstr := l.(string)
+	rs, rstr := r.(string)
+	if lstr && rstr {
+		return strings.Compare(ls, rs), nil
+	}
+	lf, lok := exprNumber(l)
+	rf, rok := exprNumber(r)
+	if !lok || !rok {
+		return 0, fmt.Errorf("can't compare %s with %s", exprString(l), exprString(r))
+	}
+	switch {
+	case lf < rf:
+		return -1, nil
+	case lf > rf:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// exprFilter is a -filter: lines it's true for are kept
+type exprFilter struct {
+	src  string
+	expr exprNode
+}
+
+func (ef *exprFilter) keep(env *exprEnv) bool {
+	ok, err := evalBool(ef.expr, env)
+	if err != nil {
+		atomic.AddUint64(&linesExprErrors, 1)
+		debugf("-filter %s: %v", ef.src, err)
+		return false
+	}
+	return ok
+}
+
+// transformStage is -transform, replacing each line with the expression's value as a string.
+// A line it fails on is kept as it was.
+type transformStage struct {
+	src  string
+	expr exprNode
+	out  []byte
+}
+
+func (ts *transformStage) apply(line []byte) ([]byte, bool) {
+	v, err := ts.expr.eval(&exprEnv{line: line})
+	if err != nil {
+		atomic.AddUint64(&linesExprErrors, 1)
+		debugf("-transform %s: %v", ts.src, err)
+		return line, true
+	}
+	ts.out = append(ts.out[:0], exprString(v)...)
+	return ts.out, true
+}
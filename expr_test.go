@@ -0,0 +1,249 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func evalExprString(t *testing.T, src, line string) (interface{}, error) {
+	t.Helper()
+	node, err := parseExpr(src)
+	if err != nil {
+		t.Fatalf("parse %q: %v", src, err)
+	}
+	return node.eval(&exprEnv{line: []byte(line)})
+}
+
+func TestExprEval(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		line string
+		want string
+	}{
+		// precedence
+		{"1 + 2 * 3", "", "7"},
+		{"(1 + 2) * 3", "", "9"},
+		{"10 - 4 - 3", "", "3"},
+		{"12 / 3 / 2", "", "2"},
+		{"7 % 4 + 1", "", "4"},
+		{"-2 * 3", "", "-6"},
+		{"--2", "", "2"},
+		{"1 + 2 < 4", "", "true"},
+		{"1 < 2 == true", "", "true"},
+		{"true || false && false", "", "true"},
+		{"(true || false) && false", "", "false"},
+		{"!true || true", "", "true"},
+		{"!(true || true)", "", "false"},
+		{"1 < 2 ? 'a' : 'b'", "", "a"},
+		{"false ? 1 : true ? 2 : 3", "", "2"},
+		{"1 + 1 == 2 && 'x' in ['x', 'y']", "", "true"},
+		// literals
+		{"1_000 + 0.5", "", "1000.5"},
+		{"1e3", "", "1000"},
+		{`"a\tb"`, "", "a\tb"},
+		{`'it\'s "q"'`, "", `it's "q"`},
+		{"null", "", ""},
+		{"[1, 'a']", "", `["1", "a"]`},
+		{"[]", "", "[]"},
+		// strings
+		{"line", "hello", "hello"},
+		{"'a' + 1", "", "a1"},
+		{"1 + null", "", "1"},
+		{"len(line)", "hello", "5"},
+		{"line.contains('ell')", "hello", "true"},
+		{"line.startsWith('he') && line.endsWith('lo')", "hello", "true"},
+		{"line.indexOf('l')", "hello", "2"},
+		{"line.indexOf('z')", "hello", "-1"},
+		{"line.upper()", "Hello", "HELLO"},
+		{"lower(line)", "Hello", "hello"},
+		{"line.trim()", "  x  ", "x"},
+		{"line.replace('l', 'L')", "hello", "heLLo"},
+		{"line.substr(1, 3)", "hello", "el"},
+		{"line.substr(3)", "hello", "lo"},
+		{"line.substr(-5, 99)", "hello", "hello"},
+		{"line.substr(4, 2)", "hello", ""},
+		{"line.split(',')", "a,b", `["a", "b"]`},
+		{"line.split(',')[1]", "a,b", "b"},
+		{"line.split(',')[-1]", "a,b,c", "c"},
+		{"line.split(',')[5]", "a,b", ""},
+		{"line[0]", "hello", "h"},
+		{"line[-1]", "hello", "o"},
+		{"line.matches('^h.*o$')", "hello", "true"},
+		{"'ell' in line", "hello", "true"},
+		{"len(line.split(' '))", "a b c", "3"},
+		{"string(1.5) + 'x'", "", "1.5x"},
+		{"int(' 12.7 ')", "", "12"},
+		{"double('x')", "", ""},
+		// comparisons
+		{"'b' > 'a'", "", "true"},
+		{"'10' > 9", "", "true"},
+		{"'12ms' < 1", "", "true"},
+		{"2 == '2'", "", "true"},
+		{"'2' == 2", "", "true"},
+		{"'a' == 1", "", "false"},
+		{"[1, 2] == [1, '2']", "", "true"},
+		{"null == null", "", "true"},
+		{"null < 1", "", "false"},
+		{"1 in [2, 3]", "", "false"},
+		// fields
+		{"field(2)", "a b  c", "b"},
+		{"field(3)", "a b  c", "c"},
+		{"field(4)", "a b  c", ""},
+		{"field(0)", "a b", ""},
+		{`json("user.id")`, `{"user":{"id":42}}`, "42"},
+		{`json("user.id") > 40`, `{"user":{"id":42}}`, "true"},
+		{`json("tags.1")`, `{"tags":["a","b"]}`, "b"},
+		{`json("tags")`, `{"tags":["a","b"]}`, `["a","b"]`},
+		{`json("missing")`, `{"a":1}`, ""},
+		{`json("a")`, `not json`, ""},
+		{`json("ok") == true`, `{"ok":true}`, "true"},
+		{`logfmt("msg")`, `level=info msg="user logged in"`, "user logged in"},
+		{`logfmt("nope") == null`, `level=info`, "true"},
+	} {
+		v, err := evalExprString(t, tc.src, tc.line)
+		if err != nil {
+			t.Errorf("%q on %q: %v", tc.src, tc.line, err)
+			continue
+		}
+		if got := exprString(v); got != tc.want {
+			t.Errorf("%q on %q = %q, want %q", tc.src, tc.line, got, tc.want)
+		}
+	}
+}
+
+func TestExprEvalErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		line string
+		want string
+	}{
+		{"'a' - 1", "", "want numbers"},
+		{"true * 2", "", "want numbers"},
+		{"1 / 0", "", "division by zero"},
+		{"5 % 0", "", "modulo by zero"},
+		{"5 % 0.5", "", "modulo by zero"},
+		{"-'x'", "", "not a number"},
+		{"!1", "", "not true or false"},
+		{"1 && true", "", "not true or false"},
+		{"1 ? 2 : 3", "", "not true or false"},
+		{"'a' < true", "", "can't compare"},
+		{"1 in 2", "", "want a list or string"},
+		{"line['x']", "abc", "want a number"},
+		{"true[0]", "", "want a list or string"},
+		{"line.substr('x')", "abc", "want number positions"},
+		{"field('x')", "a b", "want a field number"},
+	} {
+		v, err := evalExprString(t, tc.src, tc.line)
+		if err == nil {
+			t.Errorf("%q on %q = %s, want an error", tc.src, tc.line, exprString(v))
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q on %q: %v, want %q", tc.src, tc.line, err, tc.want)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		want string
+	}{
+		{"", "unexpected end"},
+		{"1 +", "unexpected end"},
+		{"(1", "want )"},
+		{"[1, 2", "want ]"},
+		{"1 2", "unexpected 2"},
+		{"'abc", "unterminated string"},
+		{`"a\qb"`, "bad string"},
+		{"1.2.3", "bad number"},
+		{"1e", "bad number"},
+		{"x", "unknown name x"},
+		{"nope(1)", "unknown function nope"},
+		{"line.nope()", "unknown function nope"},
+		{"line.len", "want len(...)"},
+		{"line.", "want a method name"},
+		{"len()", "len takes"},
+		{"contains('a')", "contains takes"},
+		{"substr('a', 1, 2, 3)", "substr takes"},
+		{"line.matches(line)", "regex string literal"},
+		{"line.matches(1)", "regex string literal"},
+		{"line.matches('(')", "matches:"},
+		{"a ? b", "unknown name a"},
+		{"true ? 1", "want :"},
+		{"#", "unexpected '#'"},
+		{"line[1", "want ]"},
+	} {
+		_, err := parseExpr(tc.src)
+		if err == nil {
+			t.Errorf("parse %q: no error, want %q", tc.src, tc.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("parse %q: %v, want %q", tc.src, err, tc.want)
+		}
+	}
+}
+
+func TestExprFilterAndTransform(t *testing.T) {
+	node, err := parseExpr("len(line) > 3 && line.contains('x')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ef := &exprFilter{src: "f", expr: node}
+	for line, want := range map[string]bool{"abcx": true, "ax": false, "abcd": false} {
+		if got := ef.keep(&exprEnv{line: []byte(line)}); got != want {
+			t.Errorf("filter on %q = %v, want %v", line, got, want)
+		}
+	}
+	// an error leaves the line out
+	node, _ = parseExpr("line > true")
+	ef = &exprFilter{src: "f", expr: node}
+	if ef.keep(&exprEnv{line: []byte("a")}) {
+		t.Errorf("filter that failed kept the line")
+	}
+
+	node, err = parseExpr(`json("user") + " " + field(2)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &transformStage{src: "t", expr: node}
+	out, keep := ts.apply([]byte(`{"user":"bob"} x`))
+	if !keep || string(out) != "bob x" {
+		t.Errorf("transform = %q, %v", out, keep)
+	}
+	// an error keeps the line as it was
+	node, _ = parseExpr("1 / 0")
+	ts = &transformStage{src: "t", expr: node}
+	out, keep = ts.apply([]byte("same"))
+	if !keep || string(out) != "same" {
+		t.Errorf("failed transform = %q, %v", out, keep)
+	}
+}
+
+func FuzzExpr(f *testing.F) {
+	for _, src := range []string{
+		"1 + 2 * 3",
+		"line.contains('x') && len(line) > 2",
+		`json("a.b") == null ? field(1) : logfmt("k")`,
+		"line.split(',')[-1].upper()",
+		"line.matches('a+') || 'b' in [1, 'b']",
+		"line.substr(1, 3) + string(1.5)",
+		"5 % 0.5",
+		"[[[]]][0][0]",
+		"!-!-1",
+		`'\''`,
+	} {
+		f.Add(src, `{"a":{"b":1}} k=v x,y`)
+	}
+	f.Fuzz(func(t *testing.T, src, line string) {
+		node, err := parseExpr(src)
+		if err != nil {
+			return
+		}
+		v, err := node.eval(&exprEnv{line: []byte(line)})
+		if err == nil {
+			exprString(v)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"expvar"
+	"runtime"
+	"sync/atomic"
+)
+
+// publishExpvars makes counters visible at /debug/vars
+func publishExpvars(c *Collector) {
+	expvar.Publish("lines_seen", expvar.Func(func() interface{} {
+		return c.Stats().LinesSeen
+	}))
+	expvar.Publish("lines_dropped", expvar.Func(func() interface{} {
+		// lines never entered into the sample
+		st := c.Stats()
+		return st.LinesSeen - st.Kept - st.Evictions
+	}))
+	expvar.Publish("bytes_seen", expvar.Func(func() interface{} {
+		return c.Stats().BytesSeen
+	}))
+	expvar.Publish("tee_bytes", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&teeBytes)
+	}))
+	expvar.Publish("tee_write_errors", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&teeWriteErrors)
+	}))
+	expvar.Publish("tee_dropped", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&teeDropped)
+	}))
+	expvar.Publish("lines_matched", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&linesMatched)
+	}))
+	expvar.Publish("lines_filtered", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&linesFiltered)
+	}))
+	expvar.Publish("lines_not_json", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&linesNotJSON)
+	}))
+	expvar.Publish("lines_expr_errors", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&linesExprErrors)
+	}))
+	expvar.Publish("lines_wasm_dropped", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&linesWasmDropped)
+	}))
+	expvar.Publish("lines_wasm_errors", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&linesWasmErrors)
+	}))
+	expvar.Publish("lines_wasm_skipped", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&linesWasmSkipped)
+	}))
+	expvar.Publish("lines_not_records", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&linesNotRecords)
+	}))
+	expvar.Publish("lines_not_clf", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&linesNotCLF)
+	}))
+	expvar.Publish("lines_invalid_utf8", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&linesInvalidUTF8)
+	}))
+	expvar.Publish("lines_truncated", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&linesTruncated)
+	}))
+	expvar.Publish("lines_skipped_long", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&linesSkippedLong)
+	}))
+	expvar.Publish("lines_duplicate", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&linesDuplicate)
+	}))
+	expvar.Publish("lines_no_time", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&linesNoTime)
+	}))
+	expvar.Publish("lines_redacted", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&linesRedacted)
+	}))
+	expvar.Publish("lines_hashed", expvar.Func(func() interface{} {
+		return atomic.LoadUint64(&linesHashed)
+	}))
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// fieldRange is fields from through to, counting from 1; to 0 means to the last field
+type fieldRange struct {
+	from, to int
+}
+
+// parseFields parses a -field list: "3", "1,4", "2-5", "3-"
+func parseFields(spec string) ([]fieldRange, error) {
+	var out []fieldRange
+	for _, part := range strings.Split(spec, ",") {
+		var fr fieldRange
+		var err error
+		from, to, isRange := strings.Cut(part, "-")
+		fr.from, err = strconv.Atoi(from)
+		if err != nil || fr.from < 1 {
+			return nil, fmt.Errorf("bad field %q, fields count from 1", part)
+		}
+		fr.to = fr.from
+		if isRange {
+			fr.to = 0
+			if to != "" {
+				fr.to, err = strconv.Atoi(to)
+				if err != nil || fr.to < fr.from {
+					return nil, fmt.Errorf("bad field range %q", part)
+				}
+			}
+		}
+		out = append(out, fr)
+	}
+	return out, nil
+}
+
+// fieldStage keeps only the -field fields of each line, split on sep like cut -d, or
+// with sep "" on runs of spaces and tabs like awk. Fields are joined by sep (or a space).
+// A line with fewer fields gets empty ones.
+type fieldStage struct {
+	fields []fieldRange
+	sep    []byte
+
+	split [][]byte
+	out   []byte
+}
+
+func (fs *fieldStage) apply(line []byte) ([]byte, bool) {
+	fs.split = splitFields(fs.split[:0], line, fs.sep)
+	join := fs.sep
+	if len(join) == 0 {
+		join = []byte{' '}
+	}
+	fs.out = fs.out[:0]
+	first := true
+	for _, fr := range fs.fields {
+		to := fr.to
+		if to == 0 {
+			to = max(len(fs.split), fr.from)
+		}
+		for i := fr.from; i <= to; i++ {
+			if !first {
+				fs.out = append(fs.out, join...)
+			}
+			first = false
+			if i <= len(fs.split) {
+				fs.out = append(fs.out, fs.split[i-1]...)
+			}
+		}
+	}
+	return fs.out, true
+}
+
+// splitFields appends line's fields to dst, without allocating once dst is big enough
+func splitFields(dst [][]byte, line, sep []byte) [][]byte {
+	if len(sep) != 0 {
+		for {
+			i := bytes.Index(line, sep)
+			if i < 0 {
+				return append(dst, line)
+			}
+			dst = append(dst, line[:i])
+			line = line[i+len(sep):]
+		}
+	}
+	start := -1
+	for i, ch := range line {
+		space := ch == ' ' || ch == '\t'
+		if space && start >= 0 {
+			dst = append(dst, line[start:i])
+			start = -1
+		} else if !space && start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		dst = append(dst, line[start:])
+	}
+	return dst
+}
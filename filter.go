@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"sync/atomic"
+)
+
+// counts of input lines passed and dropped by -match, -exclude, -min-len, -max-len, and -skip-blank
+var linesMatched uint64
+var linesFiltered uint64
+
+// lineStage changes or drops a line between reader and the Collector.
+// apply returns the line to add, which may be line itself or the stage's own buffer
+// valid until its next apply, or false to drop the line.
+type lineStage interface {
+	apply(line []byte) ([]byte, bool)
+}
+
+// pipeline is the stages of one reader, in order
+type pipeline []lineStage
+
+func (p pipeline) apply(line []byte) ([]byte, bool) {
+	for _, st := range p {
+		var ok bool
+		line, ok = st.apply(line)
+		if !ok {
+			return nil, false
+		}
+	}
+	return line, true
+}
+
+// inputFilters is how input lines are filtered and changed before sampling, from flags.
+// Each reader gets its own pipeline from it since stages may keep buffers.
+type inputFilters struct {
+	// nil unless -strata
+	strata *strata
+	// -route rules, each line goes to every one it matches
+	routes []*lineRoute
+	// -first-by and -last-by collectors, each line goes to all of them
+	keyed []*Collector
+
+	match   []*regexp.Regexp
+	exclude []*regexp.Regexp
+	// 0 for no limit
+	minLen, maxLen int
+	skipBlank      bool
+	// -filter expressions, all must be true
+	exprs []*exprFilter
+	// -transform, nil if none
+	transform    exprNode
+	transformSrc string
+	// -wasm, nil if none
+	wasm *wasmPlugin
+
+	jsonFields   []jsonPath
+	logfmtFields []string
+	clf          bool
+
+	// cleanup stages
+	stripANSI bool
+	stripCR   bool
+	stripBOM  bool
+	redact    []redaction
+	hash      []fieldHash
+	hashSalt  []byte
+
+	// -invalid-utf8
+	utf8Policy string
+
+	// -field and -sep
+	fields []fieldRange
+	sep    string
+
+	// -records, "" for none
+	records string
+
+	// -dedup, nil if off. Shared by all readers.
+	dedup *bloomFilter
+	// -filter-exec, nil if off. Shared by all readers.
+	exec *filterExec
+
+	// set when a SIGHUP reload replaces these filters
+	replaced atomic.Pointer[inputFilters]
+}
+
+// live returns the filters in effect, f or what a reload replaced it with
+func (f *inputFilters) live() *inputFilters {
+	for f != nil {
+		next := f.replaced.Load()
+		if next == nil {
+			return f
+		}
+		f = next
+	}
+	return nil
+}
+
+// addToCollectors gives a line that passed the filters to the -strata, -first-by/-last-by, and -route collectors,
+// with its stratum and routes picked by match, the line before any changes
+func (f *inputFilters) addToCollectors(match, kept []byte) {
+	if f == nil {
+		return
+	}
+	if f.strata != nil {
+		if sc := f.strata.collector(match); sc != nil {
+			sc.AddBytes(kept)
+		}
+	}
+	for _, kc := range f.keyed {
+		kc.AddBytes(kept)
+	}
+	for _, rt := range f.live().routes {
+		if rt.re.Match(match) {
+			rt.c.AddBytes(kept)
+		}
+	}
+}
+
+func compileRegexps(exprs []string) ([]*regexp.Regexp, error) {
+	out := make([]*regexp.Regexp, len(exprs))
+	for i, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = re
+	}
+	return out, nil
+}
+
+// cleanup returns the stages applied to each line before it is written to -a/-teez or -echo, nil if none
+func (f *inputFilters) cleanup() pipeline {
+	if f == nil {
+		return nil
+	}
+	var p pipeline
+	if f.stripCR || f.stripBOM {
+		p = append(p, windowsStage{cr: f.stripCR, bom: f.stripBOM})
+	}
+	if f.stripANSI {
+		p = append(p, &ansiStage{})
+	}
+	return append(p, f.private()...)
+}
+
+// private returns the -redact and -hash stages, which also apply to lines POSTed to /collector/{name}/ingest
+// and sent by gRPC Ingest
+func (f *inputFilters) private() pipeline {
+	if f == nil {
+		return nil
+	}
+	var p pipeline
+	if len(f.redact) != 0 {
+		p = append(p, &redactStage{redactions: f.redact})
+	}
+	if len(f.hash) != 0 {
+		p = append(p, &hashStage{fields: f.hash, salt: f.hashSalt})
+	}
+	return p
+}
+
+// pipeline returns the stages applied after cleanup, before sampling, nil if none
+func (f *inputFilters) pipeline() pipeline {
+	if f == nil {
+		return nil
+	}
+	var p pipeline
+	if f.utf8Policy == utf8Replace || f.utf8Policy == utf8Skip {
+		p = append(p, &utf8Stage{skip: f.utf8Policy == utf8Skip})
+	}
+	if len(f.match) != 0 || len(f.exclude) != 0 || f.minLen > 0 || f.maxLen > 0 || f.skipBlank || len(f.exprs) != 0 {
+		p = append(p, &keepFilter{match: f.match, exclude: f.exclude, minLen: f.minLen, maxLen: f.maxLen, skipBlank: f.skipBlank, exprs: f.exprs})
+	}
+	if f.transform != nil {
+		p = append(p, &transformStage{src: f.transformSrc, expr: f.transform})
+	}
+	if f.wasm != nil {
+		p = append(p, &wasmStage{p: f.wasm})
+	}
+	if len(f.jsonFields) != 0 {
+		p = append(p, &jsonFieldStage{paths: f.jsonFields})
+	}
+	if len(f.logfmtFields) != 0 {
+		p = append(p, &logfmtFieldStage{keys: f.logfmtFields})
+	}
+	if f.clf {
+		p = append(p, &clfStage{})
+	}
+	if len(f.fields) != 0 {
+		p = append(p, &fieldStage{fields: f.fields, sep: []byte(f.sep)})
+	}
+	if f.records != "" {
+		p = append(p, recordStage{format: f.records})
+	}
+	if f.dedup != nil {
+		p = append(p, dedupStage{seen: f.dedup})
+	}
+	return p
+}
+
+// keepFilter keeps lines of minLen to maxLen bytes matching any of match
+// (or all lines if there are none) that match none of exclude, and with skipBlank
+// that aren't empty or all whitespace, and that every -filter expression is true for
+type keepFilter struct {
+	match     []*regexp.Regexp
+	exclude   []*regexp.Regexp
+	minLen    int
+	maxLen    int
+	skipBlank bool
+	exprs     []*exprFilter
+}
+
+func (rf *keepFilter) apply(line []byte) ([]byte, bool) {
+	if !rf.keep(line) {
+		atomic.AddUint64(&linesFiltered, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&linesMatched, 1)
+	return line, true
+}
+
+func (rf *keepFilter) keep(line []byte) bool {
+	if len(line) < rf.minLen || (rf.maxLen > 0 && len(line) > rf.maxLen) {
+		return false
+	}
+	if rf.skipBlank && len(bytes.TrimSpace(line)) == 0 {
+		return false
+	}
+	for _, re := range rf.exclude {
+		if re.Match(line) {
+			return false
+		}
+	}
+	if len(rf.exprs) != 0 {
+		env := &exprEnv{line: line}
+		for _, ef := range rf.exprs {
+			if !ef.keep(env) {
+				return false
+			}
+		}
+	}
+	if len(rf.match) == 0 {
+		return true
+	}
+	for _, re := range rf.match {
+		if re.Match(line) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+)
+
+// filterExec is -filter-exec: lines that pass the other filters are written to a program's stdin,
+// and the lines it writes to stdout are sampled in their place.
+// The program can drop, change, or add lines, and hold them back to work in batches;
+// one runs for all readers, and its stderr is ssample's.
+type filterExec struct {
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+
+	// guards w, readers write to it at once
+	l sync.Mutex
+	w *bufio.Writer
+
+	linesIn, linesOut uint64
+	// closed when the program's stdout has ended
+	done chan struct{}
+}
+
+// shellCommand runs command with the system shell, so it can have quotes and pipes
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("/bin/sh", "-c", command)
+}
+
+// startFilterExec starts command, with its output lines going to c and filters' other collectors
+func startFilterExec(command string, c *Collector, filters *inputFilters, maxLineBytes int) (*filterExec, error) {
+	fe := &filterExec{command: command, cmd: shellCommand(command), done: make(chan struct{})}
+	fe.cmd.Stderr = os.Stderr
+	var err error
+	fe.stdin, err = fe.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := fe.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := fe.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("-filter-exec %s: %v", command, err)
+	}
+	fe.w = bufio.NewWriterSize(fe.stdin, 64*1024)
+	go fe.read(newLineReader(stdout, maxLineBytes), c, filters)
+	return fe, nil
+}
+
+// read samples the program's output, in c and the collectors of filters whose stratum or route it matches
+func (fe *filterExec) read(src *lineReader, c *Collector, filters *inputFilters) {
+	defer close(fe.done)
+	var batch lineBatch
+	for src.Scan() {
+		line := src.Bytes()
+		fe.linesOut++
+		batch.add(line)
+		c.addPatterns(line)
+		// the line it came from isn't known, so the output picks where it goes
+		filters.addToCollectors(line, line)
+		if batch.Len() >= addBatchLines || src.Buffered() == 0 {
+			c.AddBatch(&batch, "")
+		}
+	}
+	c.AddBatch(&batch, "")
+	if err := src.Err(); err != nil {
+		errorf("-filter-exec %s: read error: %v", fe.command, err)
+	}
+}
+
+// write queues a line for the program, flush sends what's queued
+func (fe *filterExec) write(line []byte) {
+	fe.l.Lock()
+	defer fe.l.Unlock()
+	fe.linesIn++
+	fe.w.Write(line)
+	if err := fe.w.WriteByte(recordSep); err != nil {
+		// it has exited or closed its stdin, there's nothing more to sample
+		failf("-filter-exec %s: %v", fe.command, err)
+	}
+}
+
+func (fe *filterExec) flush() {
+	fe.l.Lock()
+	defer fe.l.Unlock()
+	if err := fe.w.Flush(); err != nil {
+		failf("-filter-exec %s: %v", fe.command, err)
+	}
+}
+
+// close ends the program's input and waits for the rest of its output and for it to exit
+func (fe *filterExec) close() {
+	fe.flush()
+	fe.stdin.Close()
+	<-fe.done
+	if err := fe.cmd.Wait(); err != nil {
+		warnf("-filter-exec %s: %v", fe.command, err)
+	}
+	infof("-filter-exec: %d lines in, %d out", fe.linesIn, fe.linesOut)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// defaultKeyCap is -key-cap, the most keys -first-by and -last-by keep a line for
+const defaultKeyCap = 10000
+
+// keySelector picks the -first-by or -last-by key out of a line: a -stat style field
+// (3, json:path, logfmt:key, or clf:status), or else a regex's first group, or its match if it has none
+type keySelector struct {
+	spec string
+	vs   *valueSelector
+	re   *regexp.Regexp
+}
+
+func parseKeySelector(spec string) (*keySelector, error) {
+	if spec == "" {
+		return nil, fmt.Errorf("empty key, want a field number, json:path, logfmt:key, or REGEX")
+	}
+	if vs, err := parseValueSelector(spec); err == nil {
+		return &keySelector{spec: spec, vs: vs}, nil
+	}
+	re, err := regexp.Compile(spec)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %v", spec, err)
+	}
+	return &keySelector{spec: spec, re: re}, nil
+}
+
+// key returns the line's key, "" if it hasn't got one
+func (ks *keySelector) key(line []byte) string {
+	if ks.vs != nil {
+		return string(ks.vs.raw(line))
+	}
+	m := ks.re.FindSubmatch(line)
+	switch {
+	case m == nil:
+		return ""
+	case len(m) > 1:
+		return string(m[1])
+	default:
+		return string(m[0])
+	}
+}
+
+// keyedSampler is -first-by and -last-by: in place of a random sample, a Collector keeps one line
+// for each distinct key, the first or the latest seen with it, for up to LinesToKeep keys.
+// Lines without a key, and lines with a new key once LinesToKeep keys have a line, are passed by.
+// Each kept line's weight is how many lines had its key.
+type keyedSampler struct {
+	by *keySelector
+	// -last-by, replace a key's line with each new one
+	last bool
+
+	// parallel to the Collector's kept lines
+	keys   []string
+	counts []float64
+	// kept line index by key
+	index map[string]int
+
+	noKey, overCap int
+}
+
+func newKeyedSampler(by *keySelector, last bool) *keyedSampler {
+	return &keyedSampler{by: by, last: last, index: make(map[string]int)}
+}
+
+// name is the named collector the sampler is for, first-by or last-by
+func (ks *keyedSampler) name() string {
+	if ks.last {
+		return "last-by"
+	}
+	return "first-by"
+}
+
+// slot picks where c keeps a new line: c.lines.Len() to add it, the index of the line it replaces, or -1.
+// Holds c.l.
+func (ks *keyedSampler) slot(c *Collector, line string, b []byte) int {
+	if b == nil {
+		b = []byte(line)
+	}
+	key := ks.by.key(b)
+	if key == "" {
+		ks.noKey++
+		return -1
+	}
+	if i, ok := ks.index[key]; ok {
+		ks.counts[i]++
+		if ks.last {
+			return i
+		}
+		return -1
+	}
+	if c.lines.Len() >= c.LinesToKeep {
+		ks.overCap++
+		return -1
+	}
+	ks.index[key] = len(ks.keys)
+	ks.keys = append(ks.keys, key)
+	ks.counts = append(ks.counts, 1)
+	return c.lines.Len()
+}
+
+// remove drops kept line i like Shrink does, moving the last one into its place
+func (ks *keyedSampler) remove(i int) {
+	if ks.index[ks.keys[i]] == i {
+		delete(ks.index, ks.keys[i])
+	}
+	last := len(ks.keys) - 1
+	if i != last && ks.index[ks.keys[last]] == last {
+		ks.index[ks.keys[last]] = i
+	}
+	ks.keys[i] = ks.keys[last]
+	ks.keys = ks.keys[:last]
+	ks.counts[i] = ks.counts[last]
+	ks.counts = ks.counts[:last]
+}
+
+// lineWeight is how many input lines had kept line i's key
+func (ks *keyedSampler) lineWeight(i int) float64 {
+	if i >= len(ks.counts) {
+		return 1
+	}
+	return ks.counts[i]
+}
+
+func (ks *keyedSampler) reset() {
+	ks.keys = nil
+	ks.counts = nil
+	clear(ks.index)
+	ks.noKey = 0
+	ks.overCap = 0
+}
+
+// restore finds the keys of lines from a saved state, with their counts if they were saved
+func (ks *keyedSampler) restore(lines []string, counts []float64) {
+	ks.reset()
+	if len(counts) != len(lines) {
+		counts = make([]float64, len(lines))
+		for i := range counts {
+			counts[i] = 1
+		}
+	}
+	ks.counts = counts
+	ks.keys = make([]string, len(lines))
+	for i, line := range lines {
+		ks.keys[i] = ks.by.key([]byte(line))
+		if _, ok := ks.index[ks.keys[i]]; !ok {
+			ks.index[ks.keys[i]] = i
+		}
+	}
+}
+
+// KeyStats is a -first-by or -last-by collector's keys in /v1/sample
+type KeyStats struct {
+	By string `json:"by"`
+	// first or last
+	Keep string `json:"keep"`
+	Keys int    `json:"keys"`
+	Cap  int    `json:"cap"`
+	// lines passed by for having no key
+	NoKey int `json:"noKey"`
+	// lines passed by for having a new key after there were cap keys
+	OverCap int `json:"overCap"`
+}
+
+func (kst KeyStats) String() string {
+	return fmt.Sprintf("%s-by %s: %d of -key-cap %d keys, %d lines had no key, %d lines had a key over the cap", kst.Keep, kst.By, kst.Keys, kst.Cap, kst.NoKey, kst.OverCap)
+}
+
+// KeyStats returns the -first-by or -last-by key counts, nil if c isn't keeping a line per key
+func (c *Collector) KeyStats() *KeyStats {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.keyed == nil {
+		return nil
+	}
+	keep := "first"
+	if c.keyed.last {
+		keep = "last"
+	}
+	return &KeyStats{
+		By:      c.keyed.by.spec,
+		Keep:    keep,
+		Keys:    len(c.keyed.index),
+		Cap:     c.LinesToKeep,
+		NoKey:   c.keyed.noKey,
+		OverCap: c.keyed.overCap,
+	}
+}
+
+// SetKeyed makes c keep a line per key, call it before adding lines or restoring a state
+func (c *Collector) SetKeyed(ks *keyedSampler) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	ks.restore(c.lines.Strings(), nil)
+	c.keyed = ks
+}
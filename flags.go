@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// stringList is a flag.Value collecting each use of a repeatable flag
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(v string) error {
+	*sl = append(*sl, v)
+	return nil
+}
+
+// countSuffixes are the multipliers parseCount knows, SI and binary
+var countSuffixes = []struct {
+	suffix string
+	mult   float64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+	{"k", 1e3}, {"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+}
+
+// parseCount parses a count or size like 10k, 1.5M, or 2GiB: a number with an optional SI (k, M, G, T)
+// or binary (Ki, Mi, Gi, Ti) suffix and an optional B. Plain integers can have _ separators, like Go.
+func parseCount(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.TrimSpace(s), "B")
+	if n, err := strconv.ParseInt(num, 0, 64); err == nil {
+		return n, nil
+	}
+	mult := 1.0
+	for _, cs := range countSuffixes {
+		if rest, ok := strings.CutSuffix(num, cs.suffix); ok {
+			num, mult = rest, cs.mult
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("%q isn't a number like 5000, 10k, 1.5M, or 2GiB", s)
+	}
+	f = math.Round(f * mult)
+	if f > math.MaxInt64 || f < math.MinInt64 {
+		return 0, fmt.Errorf("%q is too big", s)
+	}
+	return int64(f), nil
+}
+
+// countValue is an int flag that takes parseCount values, like -l 10k
+type countValue int
+
+func (cv *countValue) String() string {
+	return strconv.Itoa(int(*cv))
+}
+
+func (cv *countValue) Set(s string) error {
+	n, err := parseCount(s)
+	if err != nil {
+		return err
+	}
+	if n > math.MaxInt || n < math.MinInt {
+		return fmt.Errorf("%q is too big", s)
+	}
+	*cv = countValue(n)
+	return nil
+}
+
+// countVar defines an int flag in fs taking parseCount values
+func countVar(fs *flag.FlagSet, p *int, name string, value int, usage string) {
+	*p = value
+	fs.Var((*countValue)(p), name, usage)
+}
+
+// sizeValue is a uint64 flag of bytes that takes parseCount values, like -max-mem 2GiB
+type sizeValue uint64
+
+func (sv *sizeValue) String() string {
+	return strconv.FormatUint(uint64(*sv), 10)
+}
+
+func (sv *sizeValue) Set(s string) error {
+	n, err := parseCount(s)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return fmt.Errorf("%q is negative", s)
+	}
+	*sv = sizeValue(n)
+	return nil
+}
+
+// sizeVar defines a uint64 flag in fs taking parseCount values
+func sizeVar(fs *flag.FlagSet, p *uint64, name string, value uint64, usage string) {
+	*p = value
+	fs.Var((*sizeValue)(p), name, usage)
+}
+
+// modeValue is a file permissions flag, read and shown in octal like chmod, e.g. -http-sock-mode 660
+type modeValue os.FileMode
+
+func (mv *modeValue) String() string {
+	return fmt.Sprintf("%#o", uint32(*mv))
+}
+
+func (mv *modeValue) Set(s string) error {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n&^uint64(os.ModePerm) != 0 {
+		return fmt.Errorf("%q isn't octal permissions like 660", s)
+	}
+	*mv = modeValue(n)
+	return nil
+}
+
+// modeVar defines an os.FileMode flag in fs taking octal values
+func modeVar(fs *flag.FlagSet, p *os.FileMode, name string, value os.FileMode, usage string) {
+	*p = value
+	fs.Var((*modeValue)(p), name, usage)
+}
+
+// countFlag is fs.Int taking parseCount values
+func countFlag(fs *flag.FlagSet, name string, value int, usage string) *int {
+	p := new(int)
+	countVar(fs, p, name, value, usage)
+	return p
+}
+
+// parseFlags parses args for a FlagSet made with flag.ContinueOnError, exiting 0 for -h
+// and exitStart for a bad flag (the flag package's ExitOnError uses 2, which is exitSignal)
+func parseFlags(fs *flag.FlagSet, args []string) {
+	switch err := fs.Parse(args); {
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(0)
+	case err != nil:
+		os.Exit(exitStart)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// lineSource is where reader gets lines, a lineReader of stdin or a followFile
+type lineSource interface {
+	Scan() bool
+	Bytes() []byte
+	Err() error
+	// Buffered is how many bytes can be read without waiting for input
+	Buffered() int
+}
+
+const followPoll = 250 * time.Millisecond
+
+// followFile reads lines from a growing file like tail -F: at the end it waits for more,
+// and starts over from the beginning if the file is truncated or replaced (rotated).
+type followFile struct {
+	path string
+	f    *os.File
+	fi   os.FileInfo
+	br   *bufio.Reader
+	// see lineReader
+	max int
+
+	// file offset after the last line returned by Scan
+	offset int64
+	// the line being read, which may be waiting for its newline, cut to max
+	partial []byte
+	// length of the whole line being read
+	partialLen int64
+	// the last whole line, trimmed, returned by Bytes
+	line []byte
+	// set when the path is found to be another file, while what was added to this one is read
+	rotated bool
+
+	err error
+}
+
+// openFollow opens path and skips to offset, or starts from 0 if the file is now shorter than that
+func openFollow(path string, offset int64, max int) (*followFile, error) {
+	ff := &followFile{path: path, max: max}
+	err := ff.open()
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 && offset <= ff.fi.Size() {
+		_, err = ff.f.Seek(offset, io.SeekStart)
+		if err != nil {
+			ff.f.Close()
+			return nil, err
+		}
+		ff.offset = offset
+	}
+	return ff, nil
+}
+
+func (ff *followFile) open() error {
+	f, err := os.Open(ff.path)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	ff.f = f
+	ff.fi = fi
+	ff.offset = 0
+	ff.rotated = false
+	if ff.br == nil {
+		ff.br = bufio.NewReader(f)
+	} else {
+		ff.br.Reset(f)
+	}
+	return nil
+}
+
+// Scan waits for the next whole line. It returns false on a read error, or when shouldquit is set while it waits.
+func (ff *followFile) Scan() bool {
+	for {
+		chunk, err := ff.br.ReadSlice(recordSep)
+		ff.partial = appendCapped(ff.partial, chunk, ff.max)
+		ff.partialLen += int64(len(chunk))
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == nil {
+			ff.offset += ff.partialLen
+			var ok bool
+			ff.line, ok = trimLine(ff.partial, ff.max, ff.partialLen-1)
+			ff.partial = ff.partial[:0]
+			ff.partialLen = 0
+			if !ok {
+				// -long-lines skip
+				continue
+			}
+			return true
+		}
+		if err != io.EOF {
+			ff.err = err
+			return false
+		}
+		fi, err := os.Stat(ff.path)
+		if err == nil && !ff.rotated && !os.SameFile(ff.fi, fi) {
+			// rotated: lines may have been added to the old file since the read that ended,
+			// so read it to its end again before opening the new one
+			ff.rotated = true
+			continue
+		}
+		// a truncated file has nothing more to read, what's past the offset is new
+		if ff.rotated || (err == nil && fi.Size() < ff.offset+ff.partialLen) {
+			// the old file's unterminated last line is still a line
+			last := ff.partialLen != 0
+			ff.f.Close()
+			err = ff.open()
+			if err != nil {
+				ff.err = err
+				return false
+			}
+			if last {
+				var ok bool
+				ff.line, ok = trimLine(ff.partial, ff.max, ff.partialLen)
+				ff.partial = ff.partial[:0]
+				ff.partialLen = 0
+				if ok {
+					return true
+				}
+			}
+			continue
+		}
+		if atomic.LoadUint32(&shouldquit) != 0 {
+			return false
+		}
+		time.Sleep(followPoll)
+	}
+}
+
+// Bytes returns the line without its newline, valid until the next Scan
+func (ff *followFile) Bytes() []byte {
+	return ff.line
+}
+
+func (ff *followFile) Buffered() int {
+	return ff.br.Buffered()
+}
+
+func (ff *followFile) Err() error {
+	return ff.err
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Every sample file ssample writes (-state, merge -o, /snapshot, /v1/sample, /push) starts with a sampleHeader.
+//
+// Format versions only ever add fields, and readers ignore fields they don't know,
+// so old and new ssample can read each other's files. A writer that changes the meaning
+// of an existing field must set minReader to the first format version that understands it.
+const (
+	sampleMagic         = "ssample"
+	sampleFormatVersion = 1
+
+	// a uniform reservoir sample, every line kept stands for seen/len(lines) lines
+	algReservoir = "reservoir"
+	// a weighted merge of reservoir samples, see mergeSamples; line weights are in each record
+	algMerge = "merge"
+	// an -unusual priority sample, line weights are in each record
+	algPriority = "priority"
+	// a -first-by or -last-by line per key, line weights (how many lines had its key) are in each record
+	algKeyed = "keyed"
+)
+
+type sampleHeader struct {
+	Format        string `json:"format"`
+	FormatVersion int    `json:"formatVersion"`
+	// oldest format version that can read this file correctly, 0 for any
+	MinReader int    `json:"minReader,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"`
+}
+
+// uniformAlgorithm reports whether samples made by alg are uniform samples of their input,
+// which mergeSamples can combine and a reservoir collector can restore. A merge of them is one too,
+// and files without an algorithm are from before -unusual and -first-by/-last-by.
+func uniformAlgorithm(alg string) bool {
+	return alg == "" || alg == algReservoir || alg == algMerge
+}
+
+// sameAlgorithm reports whether a sample made by alg can be restored into a collector that makes run's
+func sameAlgorithm(alg, run string) bool {
+	return alg == run || uniformAlgorithm(alg) && uniformAlgorithm(run)
+}
+
+func newSampleHeader(algorithm string) sampleHeader {
+	return sampleHeader{Format: sampleMagic, FormatVersion: sampleFormatVersion, Algorithm: algorithm}
+}
+
+var errNotSample = errors.New("not an ssample file")
+
+// checkSampleHeader returns an error if blob is not a sample this version can read.
+// Files from before the header was added have none and are accepted.
+func checkSampleHeader(blob []byte) error {
+	var h sampleHeader
+	err := json.Unmarshal(blob, &h)
+	if err != nil {
+		return err
+	}
+	if h.Format != "" && h.Format != sampleMagic {
+		return fmt.Errorf("%w, format %q", errNotSample, h.Format)
+	}
+	if h.MinReader > sampleFormatVersion {
+		return fmt.Errorf("format version %d needs a newer ssample (this reads up to %d)", h.FormatVersion, sampleFormatVersion)
+	}
+	return nil
+}
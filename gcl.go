@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gclEntriesPerWrite is the most entries in one entries:write
+const gclEntriesPerWrite = 1000
+
+// gclScope is what the access token is for
+const gclScope = "https://www.googleapis.com/auth/logging.write"
+
+// gclMetadata is the GCE metadata server, which has the attached service account's tokens
+const gclMetadata = "http://metadata.google.internal/computeMetadata/v1"
+
+// gclExporter writes the final sample to Google Cloud Logging, an entry per sampled line
+// labelled with its source, line number, and run, so it can be explored beside the full logs
+type gclExporter struct {
+	rc      *remoteClient
+	project string
+	logName string
+	// a service account key, nil to get tokens from the metadata server
+	key *gclKey
+}
+
+// gclKey is the part of a service account key file that's needed
+type gclKey struct {
+	Type         string `json:"type"`
+	ProjectID    string `json:"project_id"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	PrivateKeyID string `json:"private_key_id"`
+
+	rsa *rsa.PrivateKey
+}
+
+// newGCLExporter reads credentials, a service account key file or "" for the metadata server,
+// and finds the project from them if it's ""
+func newGCLExporter(project, logID, credentials, endpoint string) (*gclExporter, error) {
+	ge := &gclExporter{rc: newRemoteClient(endpoint, "", "", false), project: project}
+	if credentials != "" {
+		blob, err := os.ReadFile(credentials)
+		if err != nil {
+			return nil, err
+		}
+		ge.key = &gclKey{}
+		if err := json.Unmarshal(blob, ge.key); err != nil {
+			return nil, fmt.Errorf("%s: %v", credentials, err)
+		}
+		if ge.key.Type != "service_account" {
+			return nil, fmt.Errorf("%s: a %q key, want a service_account key", credentials, ge.key.Type)
+		}
+		block, _ := pem.Decode([]byte(ge.key.PrivateKey))
+		if block == nil {
+			return nil, fmt.Errorf("%s: no private key", credentials)
+		}
+		pk, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", credentials, err)
+		}
+		var ok bool
+		if ge.key.rsa, ok = pk.(*rsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("%s: not an RSA key", credentials)
+		}
+		if ge.key.TokenURI == "" {
+			ge.key.TokenURI = "https://oauth2.googleapis.com/token"
+		}
+		if ge.project == "" {
+			ge.project = ge.key.ProjectID
+		}
+	}
+	if ge.project == "" {
+		p, err := gclMetadataGet("/project/project-id")
+		if err != nil {
+			return nil, fmt.Errorf("-gcl-project not given and not on GCP: %v", err)
+		}
+		ge.project = string(p)
+	}
+	ge.logName = "projects/" + ge.project + "/logs/" + url.PathEscape(logID)
+	return ge, nil
+}
+
+// gclMetadataGet fetches from the metadata server
+func gclMetadataGet(path string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, gclMetadata+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	rc := newRemoteClient(gclMetadata, "", "", false)
+	rc.client.Timeout = 5 * time.Second
+	return rc.do(req)
+}
+
+// gclTokenResponse is an OAuth token, from the token endpoint or the metadata server
+type gclTokenResponse struct {
+	AccessToken string `json:"access_token"`
+}
+
+// token gets an access token for writing logs
+func (ge *gclExporter) token() (string, error) {
+	var blob []byte
+	var err error
+	if ge.key == nil {
+		blob, err = gclMetadataGet("/instance/service-accounts/default/token?scopes=" + url.QueryEscape(gclScope))
+	} else {
+		var assertion string
+		assertion, err = ge.key.jwt(time.Now())
+		if err != nil {
+			return "", err
+		}
+		form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+		var req *http.Request
+		req, err = http.NewRequest(http.MethodPost, ge.key.TokenURI, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		blob, err = ge.rc.do(req)
+	}
+	if err != nil {
+		return "", fmt.Errorf("access token: %v", err)
+	}
+	var tr gclTokenResponse
+	if err := json.Unmarshal(blob, &tr); err != nil || tr.AccessToken == "" {
+		return "", errors.New("access token: no access_token in the response")
+	}
+	return tr.AccessToken, nil
+}
+
+// jwt is the signed assertion exchanged for an access token
+func (key *gclKey) jwt(now time.Time) (string, error) {
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": key.PrivateKeyID})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   key.ClientEmail,
+		"scope": gclScope,
+		"aud":   key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(nil, key.rsa, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
+
+// gclEntry is a LogEntry, with the line as its textPayload or its -records object as its jsonPayload
+type gclEntry struct {
+	Timestamp   string            `json:"timestamp"`
+	TextPayload string            `json:"textPayload,omitempty"`
+	JSONPayload interface{}       `json:"jsonPayload,omitempty"`
+	Labels      map[string]string `json:"labels"`
+}
+
+// gclWrite is an entries:write request
+type gclWrite struct {
+	LogName        string            `json:"logName"`
+	Resource       map[string]string `json:"resource"`
+	Labels         map[string]string `json:"labels"`
+	Entries        []gclEntry        `json:"entries"`
+	PartialSuccess bool              `json:"partialSuccess"`
+}
+
+// export writes the whole sample, returning how many lines were written
+func (ge *gclExporter) export(s *ssampleServer) (int, error) {
+	token, err := ge.token()
+	if err != nil {
+		return 0, err
+	}
+	v := s.v1Sample()
+	runID := fmt.Sprintf("%s-%d-%d", v.Host.Hostname, v.Host.Pid, v.Host.Started.UnixNano())
+	w := gclWrite{
+		LogName:  ge.logName,
+		Resource: map[string]string{"type": "global"},
+		Labels: map[string]string{
+			"run_id":      runID,
+			"host":        v.Host.Hostname,
+			"sample_seen": strconv.Itoa(v.LinesSeen),
+		},
+		// one bad entry doesn't lose the rest
+		PartialSuccess: true,
+	}
+	n := 0
+	for i, rec := range v.Lines {
+		e := gclEntry{
+			Timestamp: rec.Time.UTC().Format(time.RFC3339Nano),
+			Labels: map[string]string{
+				"source":      rec.Source,
+				"line_number": strconv.Itoa(rec.LineNumber),
+				"weight":      strconv.FormatFloat(rec.Weight, 'g', -1, 64),
+			},
+		}
+		if rec.Record != nil {
+			e.JSONPayload = rec.Record
+		} else {
+			e.TextPayload = rec.Line
+		}
+		w.Entries = append(w.Entries, e)
+		if len(w.Entries) == gclEntriesPerWrite || i == len(v.Lines)-1 {
+			if err := ge.write(token, &w); err != nil {
+				return n, err
+			}
+			n += len(w.Entries)
+			w.Entries = w.Entries[:0]
+		}
+	}
+	return n, nil
+}
+
+func (ge *gclExporter) write(token string, w *gclWrite) error {
+	blob, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, ge.rc.base+"/v2/entries:write", strings.NewReader(string(blob)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	_, err = ge.rc.do(req)
+	return err
+}
@@ -0,0 +1,1182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// gqlMaxDepth bounds how deep selections, fragment spreads, and list and object literals go
+const gqlMaxDepth = 20
+
+// gqlSchema is what GET /graphql without a query returns, as there's no introspection
+const gqlSchema = `# ssample -graphql schema. POST {"query": ..., "variables": {...}} or GET ?query=...
+# Introspection (__schema, __type) isn't supported; __typename is.
+
+type Query {
+  # the fields of Sample for the main sample, and:
+  collectors: [Collector!]!
+  collector(name: String!): Collector
+}
+
+type Sample {
+  algorithm: String!
+  host: Host!
+  source: String
+  capacity: Int!
+  seen: Int!
+  bytesSeen: Int!
+  samplingRate: Float!
+  window: Window!
+  # sampled lines in line number order, all arguments optional
+  lines(since: Int, match: String, exclude: String, source: String, rare: Boolean, n: Int, limit: Int): [Line!]!
+  stats: [FieldStats!]
+  histogram: Histogram
+  counts: [PatternCount!]
+  perMinute: [MinuteCount!]
+  sources: [SourceCount!]
+  arrivals: ArrivalStats
+  templates: Templates
+  estimate(match: String!, confidence: Float): Estimate!
+}
+
+# the fields of Sample for a named collector, and:
+type Collector {
+  name: String!
+  kept: Int!
+  ttlSeconds: Float
+  idleSeconds: Float!
+  fixed: Boolean
+}
+
+# Line, Host, Window, FieldStats, Histogram, PatternCount, MinuteCount, SourceCount, ArrivalStats,
+# Templates, and Estimate have the fields of their json in GET /v1/sample, /v1/templates,
+# and /v1/estimate, e.g. Line { lineNumber line bytes time source weight probability rare gap record }
+`
+
+// gqlToken is a GraphQL lexical token: kind is 'n' name, 'i' int, 'f' float, 's' string, 'p' punctuator, 0 the end
+type gqlToken struct {
+	kind byte
+	val  string
+	pos  int
+}
+
+func gqlLex(src string) ([]gqlToken, error) {
+	var toks []gqlToken
+	i := 0
+	if strings.HasPrefix(src, "\ufeff") {
+		i = 3
+	}
+	for i < len(src) {
+		ch := src[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == ',':
+			i++
+		case ch == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.IndexByte("!$&()=:@[]{}|", ch) >= 0:
+			toks = append(toks, gqlToken{'p', src[i : i+1], i})
+			i++
+		case strings.HasPrefix(src[i:], "..."):
+			toks = append(toks, gqlToken{'p', "...", i})
+			i += 3
+		case ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z':
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			toks = append(toks, gqlToken{'n', src[i:j], i})
+			i = j
+		case ch == '-' || ch >= '0' && ch <= '9':
+			j := i + 1
+			kind := byte('i')
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || strings.IndexByte(".eE+-", src[j]) >= 0) {
+				if strings.IndexByte(".eE", src[j]) >= 0 {
+					kind = 'f'
+				}
+				j++
+			}
+			toks = append(toks, gqlToken{kind, src[i:j], i})
+			i = j
+		case ch == '"':
+			if strings.HasPrefix(src[i:], `"""`) {
+				// the first """ that isn't an escaped \"""
+				end := 0
+				for {
+					k := strings.Index(src[i+3+end:], `"""`)
+					if k < 0 {
+						end = -1
+						break
+					}
+					end += k
+					if src[i+3+end-1] != '\\' {
+						break
+					}
+					end += 3
+				}
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated block string at %d", i)
+				}
+				toks = append(toks, gqlToken{'s', gqlBlockString(src[i+3 : i+3+end]), i})
+				i += 6 + end
+				continue
+			}
+			j := i + 1
+			for j < len(src) && src[j] != '"' && src[j] != '\n' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) || src[j] != '"' {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			s, ok := gqlUnquote(src[i+1 : j])
+			if !ok {
+				return nil, fmt.Errorf("bad string at %d", i)
+			}
+			toks = append(toks, gqlToken{'s', s, i})
+			i = j + 1
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", ch, i)
+		}
+	}
+	return append(toks, gqlToken{pos: len(src)}), nil
+}
+
+// gqlUnquote decodes the escapes of a "string": \" \\ \/ \b \f \n \r \t and \uXXXX
+func gqlUnquote(raw string) (string, bool) {
+	if !strings.Contains(raw, `\`) {
+		return raw, true
+	}
+	var sb strings.Builder
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '\\' {
+			sb.WriteByte(raw[i])
+			continue
+		}
+		i++
+		if i == len(raw) {
+			return "", false
+		}
+		switch raw[i] {
+		case '"', '\\', '/':
+			sb.WriteByte(raw[i])
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'u':
+			if i+5 > len(raw) {
+				return "", false
+			}
+			r, err := strconv.ParseUint(raw[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", false
+			}
+			i += 4
+			// a surrogate pair, as JSON writes characters past the BMP
+			if utf16.IsSurrogate(rune(r)) && i+7 <= len(raw) && raw[i+1:i+3] == `\u` {
+				if r2, err := strconv.ParseUint(raw[i+3:i+7], 16, 16); err == nil {
+					if pair := utf16.DecodeRune(rune(r), rune(r2)); pair != utf8.RuneError {
+						sb.WriteRune(pair)
+						i += 6
+						continue
+					}
+				}
+			}
+			sb.WriteRune(rune(r))
+		default:
+			return "", false
+		}
+	}
+	return sb.String(), true
+}
+
+// gqlBlockString strips a """block string"""'s common indentation and blank first and last lines
+func gqlBlockString(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, `\"""`, `"""`), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		if t := strings.TrimLeft(line, " \t"); t != "" && (indent < 0 || len(line)-len(t) < indent) {
+			indent = len(line) - len(t)
+		}
+	}
+	for i := 1; i < len(lines) && indent > 0; i++ {
+		lines[i] = lines[i][min(indent, len(lines[i])):]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// gqlVariable is a $name in a query
+type gqlVariable string
+
+// gqlSelection is a field, a ...Fragment spread, or an inline fragment (with neither name nor spread)
+type gqlSelection struct {
+	alias, name string
+	args        map[string]interface{}
+	directives  []gqlDirective
+	sel         []gqlSelection
+	spread      string
+}
+
+type gqlDirective struct {
+	name string
+	args map[string]interface{}
+}
+
+type gqlOperation struct {
+	kind string
+	name string
+	// declared variables' defaults, and which must be given
+	defaults map[string]interface{}
+	required map[string]bool
+	sel      []gqlSelection
+}
+
+type gqlDocument struct {
+	ops       []*gqlOperation
+	fragments map[string][]gqlSelection
+}
+
+type gqlParser struct {
+	toks []gqlToken
+	i    int
+	// how many selection sets, list and object literals, and list types the parser is in
+	depth int
+}
+
+func (p *gqlParser) peek() gqlToken {
+	return p.toks[p.i]
+}
+
+func (p *gqlParser) next() gqlToken {
+	t := p.toks[p.i]
+	if t.kind != 0 {
+		p.i++
+	}
+	return t
+}
+
+// is is true if the next token is punctuator or name val
+func (p *gqlParser) is(val string) bool {
+	t := p.peek()
+	return (t.kind == 'p' || t.kind == 'n') && t.val == val
+}
+
+func (p *gqlParser) expect(val string) error {
+	if t := p.next(); t.val != val || (t.kind != 'p' && t.kind != 'n') {
+		return p.errorf(t, "expected %q", val)
+	}
+	return nil
+}
+
+func (p *gqlParser) name() (string, error) {
+	t := p.next()
+	if t.kind != 'n' {
+		return "", p.errorf(t, "expected a name")
+	}
+	return t.val, nil
+}
+
+// nest is called going into a selection set or literal, and its func coming back out
+func (p *gqlParser) nest() (func(), error) {
+	if p.depth >= gqlMaxDepth {
+		return nil, p.errorf(p.peek(), "nested over %d deep", gqlMaxDepth)
+	}
+	p.depth++
+	return func() { p.depth-- }, nil
+}
+
+func (p *gqlParser) errorf(t gqlToken, format string, args ...interface{}) error {
+	got := "the end"
+	if t.kind != 0 {
+		got = strconv.Quote(t.val)
+	}
+	return fmt.Errorf("syntax error at %d: %s, got %s", t.pos, fmt.Sprintf(format, args...), got)
+}
+
+func gqlParse(src string) (*gqlDocument, error) {
+	toks, err := gqlLex(src)
+	if err != nil {
+		return nil, fmt.Errorf("syntax error: %v", err)
+	}
+	p := &gqlParser{toks: toks}
+	doc := &gqlDocument{fragments: make(map[string][]gqlSelection)}
+	for p.peek().kind != 0 {
+		switch {
+		case p.is("{"):
+			sel, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.ops = append(doc.ops, &gqlOperation{kind: "query", sel: sel})
+		case p.is("query"), p.is("mutation"), p.is("subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.ops = append(doc.ops, op)
+		case p.is("fragment"):
+			p.next()
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("on"); err != nil {
+				return nil, err
+			}
+			if _, err := p.name(); err != nil {
+				return nil, err
+			}
+			if _, err := p.directives(); err != nil {
+				return nil, err
+			}
+			sel, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.fragments[name] = sel
+		default:
+			return nil, p.errorf(p.peek(), "expected a query or fragment")
+		}
+	}
+	if len(doc.ops) == 0 {
+		return nil, errors.New("no query")
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) operation() (*gqlOperation, error) {
+	op := &gqlOperation{kind: p.next().val, defaults: make(map[string]interface{}), required: make(map[string]bool)}
+	if p.peek().kind == 'n' {
+		op.name = p.next().val
+	}
+	if p.is("(") {
+		p.next()
+		for !p.is(")") {
+			if err := p.expect("$"); err != nil {
+				return nil, err
+			}
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			nonNull, err := p.typeRef()
+			if err != nil {
+				return nil, err
+			}
+			if p.is("=") {
+				p.next()
+				v, err := p.value(true)
+				if err != nil {
+					return nil, err
+				}
+				op.defaults[name] = v
+			} else if nonNull {
+				op.required[name] = true
+			}
+			if _, err := p.directives(); err != nil {
+				return nil, err
+			}
+		}
+		p.next()
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	var err error
+	op.sel, err = p.selectionSet()
+	return op, err
+}
+
+// typeRef skips a variable's type, e.g. [String!]!, returning if it's non-null
+func (p *gqlParser) typeRef() (bool, error) {
+	if p.is("[") {
+		done, err := p.nest()
+		if err != nil {
+			return false, err
+		}
+		defer done()
+		p.next()
+		if _, err := p.typeRef(); err != nil {
+			return false, err
+		}
+		if err := p.expect("]"); err != nil {
+			return false, err
+		}
+	} else if _, err := p.name(); err != nil {
+		return false, err
+	}
+	if p.is("!") {
+		p.next()
+		return true, nil
+	}
+	return false, nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlSelection, error) {
+	done, err := p.nest()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var sels []gqlSelection
+	for !p.is("}") {
+		if p.peek().kind == 0 {
+			return nil, p.errorf(p.peek(), "expected }")
+		}
+		var s gqlSelection
+		var err error
+		if p.is("...") {
+			p.next()
+			if p.is("on") {
+				p.next()
+				if _, err := p.name(); err != nil {
+					return nil, err
+				}
+			} else if p.peek().kind == 'n' {
+				s.spread = p.next().val
+			}
+			if s.directives, err = p.directives(); err != nil {
+				return nil, err
+			}
+			if s.spread == "" {
+				if s.sel, err = p.selectionSet(); err != nil {
+					return nil, err
+				}
+			}
+			sels = append(sels, s)
+			continue
+		}
+		if s.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		s.alias = s.name
+		if p.is(":") {
+			p.next()
+			if s.name, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if s.args, err = p.arguments(); err != nil {
+			return nil, err
+		}
+		if s.directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		if p.is("{") {
+			if s.sel, err = p.selectionSet(); err != nil {
+				return nil, err
+			}
+		}
+		sels = append(sels, s)
+	}
+	p.next()
+	if len(sels) == 0 {
+		return nil, errors.New("syntax error: empty selection")
+	}
+	return sels, nil
+}
+
+func (p *gqlParser) arguments() (map[string]interface{}, error) {
+	if !p.is("(") {
+		return nil, nil
+	}
+	p.next()
+	args := make(map[string]interface{})
+	for !p.is(")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if args[name], err = p.value(false); err != nil {
+			return nil, err
+		}
+	}
+	p.next()
+	return args, nil
+}
+
+func (p *gqlParser) directives() ([]gqlDirective, error) {
+	var ds []gqlDirective
+	for p.is("@") {
+		p.next()
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		ds = append(ds, gqlDirective{name, args})
+	}
+	return ds, nil
+}
+
+// value parses a literal: int64, float64, string (enum values too), bool, nil, []interface{},
+// map[string]interface{}, or, unless const, a gqlVariable
+func (p *gqlParser) value(isConst bool) (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case 'i':
+		n, err := strconv.ParseInt(t.val, 10, 64)
+		if err != nil {
+			return nil, p.errorf(t, "bad int")
+		}
+		return n, nil
+	case 'f':
+		f, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			return nil, p.errorf(t, "bad float")
+		}
+		return f, nil
+	case 's':
+		return t.val, nil
+	case 'n':
+		switch t.val {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return t.val, nil
+	case 'p':
+		switch t.val {
+		case "$":
+			if isConst {
+				return nil, p.errorf(t, "variables aren't allowed here")
+			}
+			name, err := p.name()
+			return gqlVariable(name), err
+		case "[":
+			done, err := p.nest()
+			if err != nil {
+				return nil, err
+			}
+			defer done()
+			list := []interface{}{}
+			for !p.is("]") {
+				if p.peek().kind == 0 {
+					return nil, p.errorf(p.peek(), "expected ]")
+				}
+				v, err := p.value(isConst)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			p.next()
+			return list, nil
+		case "{":
+			done, err := p.nest()
+			if err != nil {
+				return nil, err
+			}
+			defer done()
+			obj := make(map[string]interface{})
+			for !p.is("}") {
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				if obj[name], err = p.value(isConst); err != nil {
+					return nil, err
+				}
+			}
+			p.next()
+			return obj, nil
+		}
+	}
+	return nil, p.errorf(t, "expected a value")
+}
+
+// gqlObject is a value whose fields are computed when they're asked for
+type gqlObject struct {
+	typename string
+	fields   map[string]gqlField
+}
+
+type gqlField struct {
+	// argument name to type: String, Int, Float, or Boolean
+	args    map[string]string
+	resolve func(args map[string]interface{}) (interface{}, error)
+}
+
+// gqlResult is an object in a response, its fields in the order they were asked for
+type gqlResult struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (r *gqlResult) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range r.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		kb, _ := json.Marshal(k)
+		buf.Write(kb)
+		buf.WriteByte(':')
+		vb, err := json.Marshal(r.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(vb)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type gqlError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// gqlExec runs one operation
+type gqlExec struct {
+	doc  *gqlDocument
+	vars map[string]interface{}
+	errs []gqlError
+}
+
+func (ex *gqlExec) fail(path []interface{}, err error) {
+	ex.errs = append(ex.errs, gqlError{Message: err.Error(), Path: append([]interface{}(nil), path...)})
+}
+
+// resolve replaces variables in a literal with their values
+func (ex *gqlExec) resolve(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case gqlVariable:
+		val, ok := ex.vars[string(x)]
+		if !ok {
+			return nil, fmt.Errorf("variable $%s is not defined", x)
+		}
+		return val, nil
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, e := range x {
+			var err error
+			if out[i], err = ex.resolve(e); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			var err error
+			if out[k], err = ex.resolve(e); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// coerce converts a value to an argument type, from a literal or a json variable
+func gqlCoerce(v interface{}, typ string) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch typ {
+	case "String":
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case "Boolean":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case "Int":
+		switch n := v.(type) {
+		case int64:
+			return int(n), nil
+		case json.Number:
+			if i, err := n.Int64(); err == nil {
+				return int(i), nil
+			}
+		}
+	case "Float":
+		switch n := v.(type) {
+		case int64:
+			return float64(n), nil
+		case float64:
+			return n, nil
+		case json.Number:
+			if f, err := n.Float64(); err == nil {
+				return f, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("want %s, got %v", typ, v)
+}
+
+// included applies @skip(if:) and @include(if:)
+func (ex *gqlExec) included(ds []gqlDirective) (bool, error) {
+	for _, d := range ds {
+		if d.name != "skip" && d.name != "include" {
+			return false, fmt.Errorf("unknown directive @%s", d.name)
+		}
+		v, err := ex.resolve(d.args["if"])
+		if err != nil {
+			return false, err
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return false, fmt.Errorf("@%s needs if: Boolean", d.name)
+		}
+		if (d.name == "skip") == b {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// collect flattens fragments into the fields to resolve
+func (ex *gqlExec) collect(sels []gqlSelection, depth int, out []gqlSelection) ([]gqlSelection, error) {
+	if depth > gqlMaxDepth {
+		return nil, errors.New("fragments nested too deep")
+	}
+	for _, s := range sels {
+		ok, err := ex.included(s.directives)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		switch {
+		case s.spread != "":
+			frag, found := ex.doc.fragments[s.spread]
+			if !found {
+				return nil, fmt.Errorf("unknown fragment %s", s.spread)
+			}
+			if out, err = ex.collect(frag, depth+1, out); err != nil {
+				return nil, err
+			}
+		case s.name == "":
+			if out, err = ex.collect(s.sel, depth+1, out); err != nil {
+				return nil, err
+			}
+		default:
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// selectFields resolves a selection on an object, a *gqlObject or a map from json
+func (ex *gqlExec) selectFields(obj interface{}, sels []gqlSelection, path []interface{}) interface{} {
+	if len(path) > gqlMaxDepth {
+		ex.fail(path, errors.New("query nested too deep"))
+		return nil
+	}
+	fields, err := ex.collect(sels, 0, nil)
+	if err != nil {
+		ex.fail(path, err)
+		return nil
+	}
+	res := &gqlResult{values: make(map[string]interface{})}
+	for _, f := range fields {
+		if _, dup := res.values[f.alias]; dup {
+			continue
+		}
+		fpath := append(path, f.alias)
+		res.keys = append(res.keys, f.alias)
+		v, err := ex.field(obj, f)
+		if err != nil {
+			ex.fail(fpath, err)
+			res.values[f.alias] = nil
+			continue
+		}
+		res.values[f.alias] = ex.complete(v, f, fpath)
+	}
+	return res
+}
+
+func (ex *gqlExec) field(obj interface{}, f gqlSelection) (interface{}, error) {
+	switch o := obj.(type) {
+	case *gqlObject:
+		if f.name == "__typename" {
+			return o.typename, nil
+		}
+		gf, ok := o.fields[f.name]
+		if !ok {
+			return nil, fmt.Errorf("cannot query field %q on type %s", f.name, o.typename)
+		}
+		args := make(map[string]interface{})
+		for name, lit := range f.args {
+			typ, ok := gf.args[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown argument %q on field %s", name, f.name)
+			}
+			v, err := ex.resolve(lit)
+			if err != nil {
+				return nil, err
+			}
+			if args[name], err = gqlCoerce(v, typ); err != nil {
+				return nil, fmt.Errorf("argument %s: %v", name, err)
+			}
+		}
+		return gf.resolve(args)
+	case map[string]interface{}:
+		if len(f.args) != 0 {
+			return nil, fmt.Errorf("field %s takes no arguments", f.name)
+		}
+		if f.name == "__typename" {
+			return nil, nil
+		}
+		return o[f.name], nil
+	}
+	return nil, fmt.Errorf("field %s of a scalar", f.name)
+}
+
+// complete applies a field's subselection to its value
+func (ex *gqlExec) complete(v interface{}, f gqlSelection, path []interface{}) interface{} {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case *gqlObject, map[string]interface{}:
+		if f.sel == nil {
+			ex.fail(path, fmt.Errorf("field %s is an object, select its fields", f.name))
+			return nil
+		}
+		return ex.selectFields(x, f.sel, path)
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, e := range x {
+			out[i] = ex.complete(e, f, append(path, i))
+		}
+		return out
+	case []*gqlObject:
+		out := make([]interface{}, len(x))
+		for i, e := range x {
+			out[i] = ex.complete(e, f, append(path, i))
+		}
+		return out
+	}
+	if f.sel != nil {
+		ex.fail(path, fmt.Errorf("field %s has no fields to select", f.name))
+		return nil
+	}
+	return v
+}
+
+// gqlGeneric turns a response struct into the maps, lists, and json.Numbers of its json
+func gqlGeneric(v interface{}) (interface{}, error) {
+	blob, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(blob))
+	dec.UseNumber()
+	var out interface{}
+	err = dec.Decode(&out)
+	return out, err
+}
+
+// gqlSampleFields are the fields of a collector's sample, computed from one /v1/sample snapshot
+func (s *ssampleServer) gqlSampleFields() map[string]gqlField {
+	var v *V1Sample
+	var g map[string]interface{}
+	snapshot := func() (*V1Sample, map[string]interface{}, error) {
+		if v == nil {
+			v = s.v1Sample()
+			gv, err := gqlGeneric(v)
+			if err != nil {
+				return nil, nil, err
+			}
+			g, _ = gv.(map[string]interface{})
+		}
+		return v, g, nil
+	}
+	fields := make(map[string]gqlField)
+	for _, key := range []string{"algorithm", "host", "source", "capacity", "seen", "bytesSeen", "samplingRate", "window", "stats", "histogram", "counts", "perMinute", "sources", "arrivals"} {
+		fields[key] = gqlField{resolve: func(map[string]interface{}) (interface{}, error) {
+			_, g, err := snapshot()
+			return g[key], err
+		}}
+	}
+	fields["lines"] = gqlField{
+		args: map[string]string{"since": "Int", "match": "String", "exclude": "String", "source": "String", "rare": "Boolean", "n": "Int", "limit": "Int"},
+		resolve: func(args map[string]interface{}) (interface{}, error) {
+			v, _, err := snapshot()
+			if err != nil {
+				return nil, err
+			}
+			return gqlLines(v.Lines, args)
+		},
+	}
+	fields["templates"] = gqlField{resolve: func(map[string]interface{}) (interface{}, error) {
+		ts := s.c.Templates()
+		if ts == nil {
+			return nil, nil
+		}
+		return gqlGeneric(ts)
+	}}
+	fields["estimate"] = gqlField{
+		args: map[string]string{"match": "String", "confidence": "Float"},
+		resolve: func(args map[string]interface{}) (interface{}, error) {
+			expr, _ := args["match"].(string)
+			re, err := regexp.Compile(expr)
+			if err != nil || expr == "" {
+				return nil, errors.New("estimate needs match: a regex")
+			}
+			confidence := 0.95
+			if c, ok := args["confidence"].(float64); ok {
+				if confidence, err = parseConfidence(strconv.FormatFloat(c, 'g', -1, 64)); err != nil {
+					return nil, err
+				}
+			}
+			v, _, err := snapshot()
+			if err != nil {
+				return nil, err
+			}
+			est, err := estimateMatches(v.Algorithm, recordLines(v.Lines), v.LinesSeen, re, confidence)
+			if err != nil {
+				return nil, err
+			}
+			return gqlGeneric(est)
+		},
+	}
+	return fields
+}
+
+// gqlLines filters sampled lines by the arguments of lines(...)
+func gqlLines(records []SampleRecord, args map[string]interface{}) (interface{}, error) {
+	var match, exclude *regexp.Regexp
+	for name, re := range map[string]**regexp.Regexp{"match": &match, "exclude": &exclude} {
+		if expr, ok := args[name].(string); ok && expr != "" {
+			var err error
+			if *re, err = regexp.Compile(expr); err != nil {
+				return nil, fmt.Errorf("bad %s: %v", name, err)
+			}
+		}
+	}
+	since, hasSince := args["since"].(int)
+	source, hasSource := args["source"].(string)
+	rare, hasRare := args["rare"].(bool)
+	var out []SampleRecord
+	for _, rec := range records {
+		if hasSince && rec.LineNumber <= since {
+			continue
+		}
+		if match != nil && !match.MatchString(rec.Line) || exclude != nil && exclude.MatchString(rec.Line) {
+			continue
+		}
+		if hasSource && rec.Source != source || hasRare && rec.Rare != rare {
+			continue
+		}
+		out = append(out, rec)
+	}
+	if n, ok := args["n"].(int); ok && n >= 0 && n < len(out) {
+		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+		pick := rng.Perm(len(out))[:n]
+		sort.Ints(pick)
+		sub := make([]SampleRecord, n)
+		for i, pi := range pick {
+			sub[i] = out[pi]
+		}
+		out = sub
+	}
+	if limit, ok := args["limit"].(int); ok && limit >= 0 && limit < len(out) {
+		out = out[:limit]
+	}
+	if out == nil {
+		out = []SampleRecord{}
+	}
+	return gqlGeneric(out)
+}
+
+// graphqlServer serves POST and GET /graphql over the main sample and the named collectors
+type graphqlServer struct {
+	s          *ssampleServer
+	collectors *collectorSet
+}
+
+// root is the Query type: the main sample's fields, and the named collectors
+func (gs *graphqlServer) root() *gqlObject {
+	root := &gqlObject{typename: "Query", fields: gs.s.gqlSampleFields()}
+	collector := func(info CollectorInfo, c *Collector) *gqlObject {
+		obj := &gqlObject{typename: "Collector", fields: (&ssampleServer{c: c}).gqlSampleFields()}
+		gi, _ := gqlGeneric(info)
+		fields, _ := gi.(map[string]interface{})
+		for _, key := range []string{"name", "kept", "ttlSeconds", "idleSeconds", "fixed"} {
+			obj.fields[key] = gqlField{resolve: func(map[string]interface{}) (interface{}, error) {
+				return fields[key], nil
+			}}
+		}
+		return obj
+	}
+	root.fields["collectors"] = gqlField{resolve: func(map[string]interface{}) (interface{}, error) {
+		objs := []*gqlObject{}
+		for _, info := range gs.collectors.list() {
+			if c := gs.collectors.Get(info.Name); c != nil {
+				objs = append(objs, collector(info, c))
+			}
+		}
+		return objs, nil
+	}}
+	root.fields["collector"] = gqlField{
+		args: map[string]string{"name": "String"},
+		resolve: func(args map[string]interface{}) (interface{}, error) {
+			name, _ := args["name"].(string)
+			for _, info := range gs.collectors.list() {
+				if info.Name == name {
+					if c := gs.collectors.Get(name); c != nil {
+						return collector(info, c), nil
+					}
+				}
+			}
+			return nil, nil
+		},
+	}
+	return root
+}
+
+// gqlRequest is a GraphQL over HTTP request
+type gqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+func (gs *graphqlServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req gqlRequest
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if vars := q.Get("variables"); vars != "" {
+			dec := json.NewDecoder(strings.NewReader(vars))
+			dec.UseNumber()
+			if err := dec.Decode(&req.Variables); err != nil {
+				gqlRespond(w, http.StatusBadRequest, nil, []gqlError{{Message: "bad variables: " + err.Error()}})
+				return
+			}
+		}
+		if req.Query == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			io.WriteString(w, gqlSchema)
+			return
+		}
+	case http.MethodPost:
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			gqlRespond(w, http.StatusBadRequest, nil, []gqlError{{Message: err.Error()}})
+			return
+		}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/graphql") {
+			req.Query = string(body)
+		} else {
+			dec := json.NewDecoder(bytes.NewReader(body))
+			dec.UseNumber()
+			if err := dec.Decode(&req); err != nil {
+				gqlRespond(w, http.StatusBadRequest, nil, []gqlError{{Message: "want a json body with query: " + err.Error()}})
+				return
+			}
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	data, errs, err := gs.run(&req)
+	if err != nil {
+		gqlRespond(w, http.StatusBadRequest, nil, []gqlError{{Message: err.Error()}})
+		return
+	}
+	gqlRespond(w, http.StatusOK, data, errs)
+}
+
+// run parses and executes a request, returning an error for a request that can't run at all
+func (gs *graphqlServer) run(req *gqlRequest) (interface{}, []gqlError, error) {
+	doc, err := gqlParse(req.Query)
+	if err != nil {
+		return nil, nil, err
+	}
+	var op *gqlOperation
+	for _, o := range doc.ops {
+		if req.OperationName == "" || o.name == req.OperationName {
+			if op != nil {
+				return nil, nil, errors.New("several operations, give operationName")
+			}
+			op = o
+		}
+	}
+	if op == nil {
+		return nil, nil, fmt.Errorf("no operation %q", req.OperationName)
+	}
+	if op.kind != "query" {
+		return nil, nil, fmt.Errorf("%s isn't supported, only query", op.kind)
+	}
+	ex := &gqlExec{doc: doc, vars: make(map[string]interface{})}
+	for name, v := range op.defaults {
+		ex.vars[name] = v
+	}
+	for name, v := range req.Variables {
+		ex.vars[name] = v
+	}
+	for name := range op.required {
+		if ex.vars[name] == nil {
+			return nil, nil, fmt.Errorf("variable $%s is required", name)
+		}
+	}
+	return ex.selectFields(gs.root(), op.sel, nil), ex.errs, nil
+}
+
+func gqlRespond(w http.ResponseWriter, status int, data interface{}, errs []gqlError) {
+	out := struct {
+		Data   interface{} `json:"data,omitempty"`
+		Errors []gqlError  `json:"errors,omitempty"`
+	}{data, errs}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(out)
+}
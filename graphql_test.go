@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGqlParse(t *testing.T) {
+	doc, err := gqlParse(`
+		# a comment
+		query Lines($re: String = "x", $n: Int!, $tags: [String!]) @live {
+			lines(match: $re, n: $n, opts: {a: [1, 2.5, "s", true, null, ENUM]}) @skip(if: false) {
+				line, n: lineNumber
+				...F
+				... on Line { source }
+				... @include(if: true) { weight }
+			}
+		}
+		fragment F on Line { time }
+		{ seen }
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.ops) != 2 || len(doc.fragments) != 1 {
+		t.Fatalf("%d operations and %d fragments", len(doc.ops), len(doc.fragments))
+	}
+	op := doc.ops[0]
+	if op.kind != "query" || op.name != "Lines" {
+		t.Errorf("operation %s %s", op.kind, op.name)
+	}
+	if !reflect.DeepEqual(op.defaults, map[string]interface{}{"re": "x"}) || !reflect.DeepEqual(op.required, map[string]bool{"n": true}) {
+		t.Errorf("defaults %v, required %v", op.defaults, op.required)
+	}
+	lines := op.sel[0]
+	wantArgs := map[string]interface{}{
+		"match": gqlVariable("re"),
+		"n":     gqlVariable("n"),
+		"opts":  map[string]interface{}{"a": []interface{}{int64(1), 2.5, "s", true, nil, "ENUM"}},
+	}
+	if lines.name != "lines" || !reflect.DeepEqual(lines.args, wantArgs) {
+		t.Errorf("lines = %+v", lines)
+	}
+	if len(lines.directives) != 1 || lines.directives[0].name != "skip" || lines.directives[0].args["if"] != false {
+		t.Errorf("directives %+v", lines.directives)
+	}
+	if len(lines.sel) != 5 {
+		t.Fatalf("%d selections in lines", len(lines.sel))
+	}
+	if s := lines.sel[1]; s.alias != "n" || s.name != "lineNumber" {
+		t.Errorf("aliased field %+v", s)
+	}
+	if s := lines.sel[2]; s.spread != "F" {
+		t.Errorf("spread %+v", s)
+	}
+	if s := lines.sel[3]; s.name != "" || s.spread != "" || len(s.sel) != 1 || s.sel[0].name != "source" {
+		t.Errorf("inline fragment %+v", s)
+	}
+	if s := lines.sel[4]; len(s.directives) != 1 || len(s.sel) != 1 {
+		t.Errorf("inline fragment with a directive %+v", s)
+	}
+	if doc.ops[1].kind != "query" || doc.ops[1].sel[0].name != "seen" {
+		t.Errorf("shorthand query %+v", doc.ops[1])
+	}
+}
+
+func TestGqlStrings(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{`"plain"`, "plain"},
+		{`""`, ""},
+		{`"a\"b\\c\/d"`, `a"b\c/d`},
+		{`"\b\f\n\r\t"`, "\b\f\n\r\t"},
+		{`"é中"`, "é中"},
+		{`"😀"`, "😀"},
+		{`"\ud83d"`, "�"},
+		{`"é"`, "é"},
+		{"\"\"\"\n    first\n      second\n    \"\"\"", "first\n  second"},
+		{`"""no "escapes" \n here"""`, `no "escapes" \n here`},
+		{`"""a \""" b"""`, `a """ b`},
+		{"\"\"\"\n\n  x\n\n\"\"\"", "x"},
+	} {
+		toks, err := gqlLex(tc.src)
+		if err != nil {
+			t.Errorf("%s: %v", tc.src, err)
+			continue
+		}
+		if toks[0].kind != 's' || toks[0].val != tc.want {
+			t.Errorf("%s = %q, want %q", tc.src, toks[0].val, tc.want)
+		}
+	}
+	for _, src := range []string{`"open`, `"a` + "\n" + `"`, `"\x41"`, `"\u12"`, `"\uZZZZ"`, `"\`, `"""open`, `"\q"`} {
+		if toks, err := gqlLex(src); err == nil {
+			t.Errorf("%s: lexed as %+v", src, toks)
+		}
+	}
+}
+
+func TestGqlParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"", "no query"},
+		{"# only a comment", "no query"},
+		{"{", "expected }"},
+		{"{}", "empty selection"},
+		{"{ a(x: ) }", "expected a value"},
+		{"{ a(x 1) }", `expected ":"`},
+		{"{ a(x: 1 }", "expected a name"},
+		{"{ a: }", "expected a name"},
+		{"{ a @ }", "expected a name"},
+		{"{ a(x: [1, 2) }", `expected a value, got ")"`},
+		{"{ a(x: [1, 2", "expected ]"},
+		{"{ a(x: {b 1}) }", `expected ":"`},
+		{"{ a(x: 9223372036854775808) }", "bad int"},
+		{"{ a(x: 1.2.3) }", "bad float"},
+		{"{ a(x: -) }", "bad int"},
+		{"query { a } }", "expected a query or fragment"},
+		{"query($v: Int = $w) { a }", "variables aren't allowed"},
+		{"query($v Int) { a }", `expected ":"`},
+		{"query(v: Int) { a }", `expected "$"`},
+		{"query($v: [Int) { a }", `expected "]"`},
+		{"query($v: Int", `expected "$"`},
+		{"fragment F { a }", `expected "on"`},
+		{"fragment on T { a }", `expected "on"`},
+		{"fragment F on { a }", "expected a name"},
+		{"query", `expected "{"`},
+		{"{ a } ?", "unexpected '?'"},
+		{"{ ... on { a } }", "expected a name"},
+		{"{ a" + strings.Repeat("{ a", gqlMaxDepth) + strings.Repeat("}", gqlMaxDepth+1) + " }", "nested over"},
+		{"{ a(x: " + strings.Repeat("[", 10000) + ") }", "nested over"},
+		{"{ a(x: " + strings.Repeat("{a: ", 10000) + ") }", "nested over"},
+		{"query($v: " + strings.Repeat("[", 10000) + ") { a }", "nested over"},
+	} {
+		doc, err := gqlParse(tc.src)
+		if err == nil {
+			t.Errorf("%.40q: parsed as %+v", tc.src, doc)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%.40q: %v, want %q", tc.src, err, tc.want)
+		}
+	}
+	// as deep as is allowed
+	src := strings.Repeat("{ a ", gqlMaxDepth) + strings.Repeat("}", gqlMaxDepth)
+	if _, err := gqlParse(src); err != nil {
+		t.Errorf("%d deep: %v", gqlMaxDepth, err)
+	}
+}
+
+// gqlTestRoot is a schema to run queries against:
+// greet(name: String), add(a: Int, b: Float), obj (from json), items [Item], item { id, child: Item }
+func gqlTestRoot() *gqlObject {
+	var item func(id int) *gqlObject
+	item = func(id int) *gqlObject {
+		return &gqlObject{typename: "Item", fields: map[string]gqlField{
+			"id":    {resolve: func(map[string]interface{}) (interface{}, error) { return id, nil }},
+			"child": {resolve: func(map[string]interface{}) (interface{}, error) { return item(id + 1), nil }},
+			"fail":  {resolve: func(map[string]interface{}) (interface{}, error) { return nil, errors.New("item failed") }},
+		}}
+	}
+	return &gqlObject{typename: "Query", fields: map[string]gqlField{
+		"greet": {args: map[string]string{"name": "String", "loud": "Boolean"}, resolve: func(args map[string]interface{}) (interface{}, error) {
+			name, ok := args["name"].(string)
+			if !ok {
+				name = "nobody"
+			}
+			if args["loud"] == true {
+				name = strings.ToUpper(name)
+			}
+			return "hi " + name, nil
+		}},
+		"add": {args: map[string]string{"a": "Int", "b": "Float"}, resolve: func(args map[string]interface{}) (interface{}, error) {
+			a, _ := args["a"].(int)
+			b, _ := args["b"].(float64)
+			return float64(a) + b, nil
+		}},
+		"obj": {resolve: func(map[string]interface{}) (interface{}, error) {
+			return gqlGeneric(map[string]interface{}{"x": 1, "nested": map[string]string{"y": "z"}, "list": []map[string]int{{"k": 1}, {"k": 2}}})
+		}},
+		"items": {resolve: func(map[string]interface{}) (interface{}, error) {
+			return []*gqlObject{item(1), item(2)}, nil
+		}},
+		"item": {resolve: func(map[string]interface{}) (interface{}, error) { return item(1), nil }},
+	}}
+}
+
+func gqlRun(t *testing.T, src string, vars map[string]interface{}) (string, []gqlError) {
+	t.Helper()
+	doc, err := gqlParse(src)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	ex := &gqlExec{doc: doc, vars: vars}
+	for name, v := range doc.ops[0].defaults {
+		if _, ok := ex.vars[name]; !ok {
+			ex.vars[name] = v
+		}
+	}
+	out, err := json.Marshal(ex.selectFields(gqlTestRoot(), doc.ops[0].sel, nil))
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	return string(out), ex.errs
+}
+
+func TestGqlExec(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		vars map[string]interface{}
+		want string
+	}{
+		{`{ greet }`, nil, `{"greet":"hi nobody"}`},
+		{`{ greet(name: "ann") __typename }`, nil, `{"greet":"hi ann","__typename":"Query"}`},
+		{`{ b: greet(name: "b") a: greet(name: "a") }`, nil, `{"b":"hi b","a":"hi a"}`},
+		{`{ greet greet }`, nil, `{"greet":"hi nobody"}`},
+		{`query($n: String, $l: Boolean = true) { greet(name: $n, loud: $l) }`, map[string]interface{}{"n": "ann"}, `{"greet":"hi ANN"}`},
+		{`query($n: String) { greet(name: $n) }`, map[string]interface{}{"n": nil}, `{"greet":"hi nobody"}`},
+		{`{ add(a: 2, b: 0.5) }`, nil, `{"add":2.5}`},
+		{`{ add(a: 2, b: 3) }`, nil, `{"add":5}`},
+		{`query($a: Int, $b: Float) { add(a: $a, b: $b) }`, map[string]interface{}{"a": json.Number("4"), "b": json.Number("1e1")}, `{"add":14}`},
+		{`{ obj { x nested { y } list { k } } }`, nil, `{"obj":{"x":1,"nested":{"y":"z"},"list":[{"k":1},{"k":2}]}}`},
+		{`{ obj { missing } }`, nil, `{"obj":{"missing":null}}`},
+		{`{ items { id child { id child { id } } } }`, nil, `{"items":[{"id":1,"child":{"id":2,"child":{"id":3}}},{"id":2,"child":{"id":3,"child":{"id":4}}}]}`},
+		{`{ item { ...A } } fragment A on Item { id ...B } fragment B on Item { child { id } }`, nil, `{"item":{"id":1,"child":{"id":2}}}`},
+		{`{ item { ... on Item { id } ... @skip(if: true) { child { id } } } }`, nil, `{"item":{"id":1}}`},
+		{`query($s: Boolean!) { greet @include(if: $s) item @skip(if: $s) { id } }`, map[string]interface{}{"s": false}, `{"item":{"id":1}}`},
+	} {
+		got, errs := gqlRun(t, tc.src, tc.vars)
+		if len(errs) != 0 {
+			t.Errorf("%s: %+v", tc.src, errs)
+		}
+		if got != tc.want {
+			t.Errorf("%s = %s, want %s", tc.src, got, tc.want)
+		}
+	}
+}
+
+// gqlChain is a query of item { child { child ... } } n deep, each child in its own fragment,
+// which parses as the selections are only two deep
+func gqlChain(n int) string {
+	var sb strings.Builder
+	sb.WriteString("{ item { ...F0 } }")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, " fragment F%d on Item { child { ...F%d } }", i, i+1)
+	}
+	fmt.Fprintf(&sb, " fragment F%d on Item { id }", n)
+	return sb.String()
+}
+
+func TestGqlExecErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		vars map[string]interface{}
+		want string
+		path string
+	}{
+		{`{ nope }`, nil, `cannot query field "nope" on type Query`, `["nope"]`},
+		{`{ greet(nope: 1) }`, nil, `unknown argument "nope"`, `["greet"]`},
+		{`{ greet(name: 1) }`, nil, "argument name: want String, got 1", `["greet"]`},
+		{`{ add(a: 1.5) }`, nil, "argument a: want Int", `["add"]`},
+		{`query($a: Int) { add(a: $a) }`, map[string]interface{}{"a": json.Number("1.5")}, "argument a: want Int", `["add"]`},
+		{`{ greet(name: $v) }`, nil, "variable $v is not defined", `["greet"]`},
+		{`{ item }`, nil, "is an object, select its fields", `["item"]`},
+		{`{ greet { x } }`, nil, "has no fields to select", `["greet"]`},
+		{`{ item { fail } }`, nil, "item failed", `["item","fail"]`},
+		{`{ obj { x(a: 1) } }`, nil, "takes no arguments", `["obj","x"]`},
+		{`{ obj { x { y } } }`, nil, "has no fields to select", `["obj","x"]`},
+		{`{ ...Nope }`, nil, "unknown fragment Nope", `null`},
+		{`{ greet @nope }`, nil, "unknown directive @nope", `null`},
+		{`{ greet @skip(if: 1) }`, nil, "@skip needs if: Boolean", `null`},
+		{`{ ...A } fragment A on Query { ...A }`, nil, "fragments nested too deep", `null`},
+		{gqlChain(gqlMaxDepth), nil, "query nested too deep", ""},
+	} {
+		_, errs := gqlRun(t, tc.src, tc.vars)
+		if len(errs) != 1 {
+			t.Errorf("%.60s: errors %+v, want one", tc.src, errs)
+			continue
+		}
+		if !strings.Contains(errs[0].Message, tc.want) {
+			t.Errorf("%.60s: %q, want %q", tc.src, errs[0].Message, tc.want)
+		}
+		if path, _ := json.Marshal(errs[0].Path); tc.path != "" && string(path) != tc.path {
+			t.Errorf("%.60s: error at %s, want %s", tc.src, path, tc.path)
+		}
+	}
+}
+
+func TestGraphQLServer(t *testing.T) {
+	c := NewCollector(10, "")
+	for _, line := range []string{"alpha 1", "beta 2", "alpha 3"} {
+		c.AddLine(line)
+	}
+	cs := newCollectorSet("")
+	cs.add("errors", 5).AddLine("oops")
+	gs := &graphqlServer{s: &ssampleServer{c: c}, collectors: cs}
+
+	for _, tc := range []struct {
+		name   string
+		req    *http.Request
+		status int
+		want   string
+	}{
+		{"POST json", httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query": "query($m: String) { seen lines(match: $m) { line } }", "variables": {"m": "^alpha"}}`)),
+			200, `{"data":{"seen":3,"lines":[{"line":"alpha 1"},{"line":"alpha 3"}]}}`},
+		{"POST graphql", httptest.NewRequest("POST", "/graphql", strings.NewReader(`{ collector(name: "errors") { name kept lines { line } } }`)),
+			200, `{"data":{"collector":{"name":"errors","kept":1,"lines":[{"line":"oops"}]}}}`},
+		{"GET", httptest.NewRequest("GET", "/graphql?query="+url.QueryEscape(`query A { seen } query B($n: Int) { lines(limit: $n) { line } }`)+"&operationName=B&variables="+url.QueryEscape(`{"n": 1}`), nil),
+			200, `{"data":{"lines":[{"line":"alpha 1"}]}}`},
+		{"collectors", httptest.NewRequest("GET", "/graphql?query="+url.QueryEscape(`{ collectors { name } collector(name: "nope") { name } }`), nil),
+			200, `{"data":{"collectors":[{"name":"errors"}],"collector":null}}`},
+		{"field error", httptest.NewRequest("GET", "/graphql?query="+url.QueryEscape(`{ seen estimate(match: "(") { matched } }`), nil),
+			200, `{"data":{"seen":3,"estimate":null},"errors":[{"message":"estimate needs match: a regex","path":["estimate"]}]}`},
+		{"syntax error", httptest.NewRequest("GET", "/graphql?query="+url.QueryEscape(`{ seen`), nil),
+			400, `{"errors":[{"message":"syntax error at 6: expected }, got the end"}]}`},
+		{"several operations", httptest.NewRequest("GET", "/graphql?query="+url.QueryEscape(`query A { seen } query B { seen }`), nil),
+			400, `{"errors":[{"message":"several operations, give operationName"}]}`},
+		{"no such operation", httptest.NewRequest("GET", "/graphql?query="+url.QueryEscape(`query A { seen }`)+"&operationName=B", nil),
+			400, `{"errors":[{"message":"no operation \"B\""}]}`},
+		{"mutation", httptest.NewRequest("GET", "/graphql?query="+url.QueryEscape(`mutation { seen }`), nil),
+			400, `{"errors":[{"message":"mutation isn't supported, only query"}]}`},
+		{"required variable", httptest.NewRequest("GET", "/graphql?query="+url.QueryEscape(`query($n: Int!) { lines(n: $n) { line } }`), nil),
+			400, `{"errors":[{"message":"variable $n is required"}]}`},
+		{"bad variables", httptest.NewRequest("GET", "/graphql?query=%7Bseen%7D&variables=%7B", nil),
+			400, `{"errors":[{"message":"bad variables: unexpected EOF"}]}`},
+		{"bad body", httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query": 1}`)),
+			400, `{"errors":[{"message":"want a json body with query: json: cannot unmarshal number into Go struct field gqlRequest.query of type string"}]}`},
+	} {
+		if tc.name == "POST graphql" {
+			tc.req.Header.Set("Content-Type", "application/graphql")
+		}
+		w := httptest.NewRecorder()
+		gs.ServeHTTP(w, tc.req)
+		if w.Code != tc.status || strings.TrimSpace(w.Body.String()) != tc.want {
+			t.Errorf("%s: %d %s\nwant %d %s", tc.name, w.Code, w.Body.String(), tc.status, tc.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	gs.ServeHTTP(w, httptest.NewRequest("GET", "/graphql", nil))
+	if !strings.HasPrefix(w.Body.String(), "# ssample -graphql schema") {
+		t.Errorf("GET without a query: %s", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	gs.ServeHTTP(w, httptest.NewRequest("PUT", "/graphql", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, POST" {
+		t.Errorf("PUT: %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func FuzzGqlParse(f *testing.F) {
+	for _, src := range []string{
+		`{ greet(name: "x") add(a: 1, b: 2.5) }`,
+		`query Q($n: String = "d", $l: [Boolean!]!) @x { greet(name: $n, loud: $l) @skip(if: false) }`,
+		`{ item { ...A ... on Item { id } } } fragment A on Item { child { id } }`,
+		`{ obj { x nested { y } } items { id fail } }`,
+		"\"\"\"\n  block \\\"\"\" \n\"\"\"",
+		`{ greet(name: "é\/") }`,
+	} {
+		f.Add(src)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		doc, err := gqlParse(src)
+		if err != nil {
+			return
+		}
+		for _, op := range doc.ops {
+			ex := &gqlExec{doc: doc, vars: op.defaults}
+			if _, err := json.Marshal(ex.selectFields(gqlTestRoot(), op.sel, nil)); err != nil {
+				t.Errorf("%q: %v", src, err)
+			}
+		}
+	})
+}
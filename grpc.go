@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// grpcServer implements the Sampler service of ssample.proto on net/http's HTTP/2 support.
+type grpcServer struct {
+	stdin      *Collector
+	collectors *collectorSet
+	// with -admin-http, Reset and Ingest are refused
+	readOnly bool
+	// Ingest applies their -redact and -hash stages, and a tenant's quota to its collector
+	filters *inputFilters
+	tenants []*tenant
+}
+
+// grpc status codes
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcNotFound          = 5
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+)
+
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (ge *grpcError) Error() string {
+	return ge.msg
+}
+
+func grpcErrorf(code int, xf string, args ...interface{}) error {
+	return &grpcError{code, fmt.Sprintf(xf, args...)}
+}
+
+const grpcMaxMessage = 16 << 20
+
+// readGrpcMessage reads one length prefixed message, io.EOF at a clean end of stream
+func readGrpcMessage(in io.Reader, encoding string) ([]byte, error) {
+	var hdr [5]byte
+	_, err := io.ReadFull(in, hdr[:])
+	if err == io.ErrUnexpectedEOF {
+		return nil, grpcErrorf(grpcInvalidArgument, "truncated message")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if hdr[0] > 1 {
+		return nil, grpcErrorf(grpcInvalidArgument, "bad message flags %#x", hdr[0])
+	}
+	size := binary.BigEndian.Uint32(hdr[1:])
+	if size > grpcMaxMessage {
+		return nil, grpcErrorf(grpcResourceExhausted, "message of %d bytes is too big", size)
+	}
+	msg := make([]byte, size)
+	_, err = io.ReadFull(in, msg)
+	if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "truncated message")
+	}
+	if hdr[0] == 0 {
+		return msg, nil
+	}
+	if encoding != "gzip" {
+		return nil, grpcErrorf(grpcUnimplemented, "unsupported grpc-encoding %q", encoding)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(msg))
+	if err != nil {
+		return nil, grpcErrorf(grpcInternal, "gzip: %v", err)
+	}
+	msg, err = io.ReadAll(io.LimitReader(zr, grpcMaxMessage+1))
+	if err != nil || len(msg) > grpcMaxMessage {
+		return nil, grpcErrorf(grpcResourceExhausted, "bad or too big gzip message")
+	}
+	return msg, nil
+}
+
+func writeGrpcMessage(w http.ResponseWriter, msg []byte) error {
+	var hdr [5]byte
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(msg)))
+	w.Write(hdr[:])
+	_, err := w.Write(msg)
+	if fl, ok := w.(http.Flusher); ok {
+		fl.Flush()
+	}
+	return err
+}
+
+func (gs *grpcServer) collector(name string) (*Collector, error) {
+	if name == "" {
+		return gs.stdin, nil
+	}
+	c := gs.collectors.Get(name)
+	if c == nil {
+		return nil, grpcErrorf(grpcNotFound, "no collector %q", name)
+	}
+	return c, nil
+}
+
+// ingester adds Ingest lines to a collector the way POSTs to its ingest endpoint are
+func (gs *grpcServer) ingester(name string) (*ingester, error) {
+	c, err := gs.collector(name)
+	if err != nil {
+		return nil, err
+	}
+	ig := &ingester{c: c, clean: gs.filters.live().private()}
+	for _, t := range gs.tenants {
+		if t.c == c {
+			ig.quota = t.quota
+		}
+	}
+	return ig, nil
+}
+
+func (gs *grpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		fmt.Fprintf(w, "grpc only\n")
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "application/grpc")
+	h.Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	err := gs.call(w, r)
+	code := grpcOK
+	msg := ""
+	if err != nil {
+		code = grpcInternal
+		var ge *grpcError
+		if errors.As(err, &ge) {
+			code = ge.code
+		}
+		msg = err.Error()
+	}
+	h.Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		h.Set("Grpc-Message", url.PathEscape(msg))
+	}
+}
+
+func (gs *grpcServer) call(w http.ResponseWriter, r *http.Request) error {
+	encoding := r.Header.Get("Grpc-Encoding")
+	if gs.readOnly && (r.URL.Path == "/ssample.Sampler/Reset" || r.URL.Path == "/ssample.Sampler/Ingest") {
+		return grpcErrorf(grpcPermissionDenied, "%s is only on the -admin-http listener", r.URL.Path)
+	}
+	switch r.URL.Path {
+	case "/ssample.Sampler/GetSample":
+		c, err := gs.unaryCollector(r, encoding)
+		if err != nil {
+			return err
+		}
+		records, st, _ := c.Records()
+		return writeGrpcMessage(w, pbSample(records, st, st.Capacity))
+	case "/ssample.Sampler/Reset":
+		c, err := gs.unaryCollector(r, encoding)
+		if err != nil {
+			return err
+		}
+		lines, lineNumbers, st := c.Reset()
+		records := make([]SampleRecord, len(lines))
+		for i, line := range lines {
+			records[i] = SampleRecord{LineNumber: lineNumbers[i], Line: line, Source: c.Source}
+			records[i].fixUTF8()
+		}
+		return writeGrpcMessage(w, pbSample(records, st, st.Capacity))
+	case "/ssample.Sampler/StreamChanges":
+		c, err := gs.unaryCollector(r, encoding)
+		if err != nil {
+			return err
+		}
+		changes, lost, cancel := c.Subscribe(1000)
+		defer cancel()
+		for {
+			select {
+			case <-r.Context().Done():
+				return nil
+			case ch := <-changes:
+				err = writeGrpcMessage(w, pbChange(ch, lost()))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	case "/ssample.Sampler/Ingest":
+		added := 0
+		for {
+			msg, err := readGrpcMessage(r.Body, encoding)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			name, lines, err := pbIngestRequest(msg)
+			if err != nil {
+				return grpcErrorf(grpcInvalidArgument, "%v", err)
+			}
+			ig, err := gs.ingester(name)
+			if err != nil {
+				return err
+			}
+			for _, line := range lines {
+				if !ig.add([]byte(line)) {
+					return grpcErrorf(grpcResourceExhausted, "added %d lines, then over quota of %g lines/s", added+ig.count, ig.quota.rate)
+				}
+			}
+			added += ig.count
+		}
+		return writeGrpcMessage(w, pbIngestResponse(added))
+	}
+	return grpcErrorf(grpcUnimplemented, "unknown method %s", r.URL.Path)
+}
+
+// unaryCollector reads a request that is just a collector name
+func (gs *grpcServer) unaryCollector(r *http.Request, encoding string) (*Collector, error) {
+	msg, err := readGrpcMessage(r.Body, encoding)
+	if err == io.EOF {
+		return nil, grpcErrorf(grpcInvalidArgument, "missing request message")
+	}
+	if err != nil {
+		return nil, err
+	}
+	name, err := pbCollector(msg)
+	if err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	return gs.collector(name)
+}
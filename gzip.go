@@ -0,0 +1,99 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	},
+}
+
+// gzipHandler compresses responses for clients sending "Accept-Encoding: gzip"
+type gzipHandler struct {
+	next http.Handler
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc = strings.TrimSpace(enc)
+		if semi := strings.IndexByte(enc, ';'); semi >= 0 {
+			if strings.TrimSpace(enc[semi+1:]) == "q=0" {
+				continue
+			}
+			enc = strings.TrimSpace(enc[:semi])
+		}
+		if enc == "gzip" || enc == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (gh *gzipHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		gh.next.ServeHTTP(w, r)
+		return
+	}
+	gz := gzipWriters.Get().(*gzip.Writer)
+	gz.Reset(w)
+	gw := &gzipResponseWriter{ResponseWriter: w, gz: gz}
+	gh.next.ServeHTTP(gw, r)
+	if gw.compress {
+		gz.Close()
+	}
+	gzipWriters.Put(gz)
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+	compress    bool
+}
+
+func (gw *gzipResponseWriter) WriteHeader(code int) {
+	if gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+	h := gw.ResponseWriter.Header()
+	if code != http.StatusNotModified && code != http.StatusNoContent {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		gw.compress = true
+	}
+	gw.ResponseWriter.WriteHeader(code)
+}
+
+func (gw *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !gw.wroteHeader {
+		if gw.Header().Get("Content-Type") == "" {
+			gw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		gw.WriteHeader(http.StatusOK)
+	}
+	if !gw.compress {
+		return gw.ResponseWriter.Write(b)
+	}
+	return gw.gz.Write(b)
+}
+
+// Flush lets streaming handlers push compressed data through
+func (gw *gzipResponseWriter) Flush() {
+	if !gw.wroteHeader {
+		gw.WriteHeader(http.StatusOK)
+	}
+	if gw.compress {
+		gw.gz.Flush()
+	}
+	if fl, ok := gw.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
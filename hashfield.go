@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// linesHashed counts input lines -hash changed
+var linesHashed uint64
+
+// fieldHash is a -hash: the first group (or whole match) of a regex, or a JSON value
+type fieldHash struct {
+	re   *regexp.Regexp
+	json jsonPath
+}
+
+// parseFieldHash parses -hash json:path or -hash REGEX
+func parseFieldHash(spec string) (fieldHash, error) {
+	if path, ok := strings.CutPrefix(spec, "json:"); ok {
+		if path == "" {
+			return fieldHash{}, fmt.Errorf("bad -hash %q, want json:path", spec)
+		}
+		return fieldHash{json: parseJSONPath(path)}, nil
+	}
+	re, err := regexp.Compile(spec)
+	if err != nil {
+		return fieldHash{}, fmt.Errorf("-hash %q: %v", spec, err)
+	}
+	return fieldHash{re: re}, nil
+}
+
+// randomSalt is the -hash key when there's no -hash-salt
+func randomSalt() []byte {
+	salt := make([]byte, 32)
+	rand.Read(salt)
+	return salt
+}
+
+// hashStage replaces -hash values with a keyed hash of them, the same for the same value
+// and -hash-salt, so lines can still be joined on a user id without showing it
+type hashStage struct {
+	fields []fieldHash
+	salt   []byte
+	// each field writes to the buffer the line isn't in
+	bufs [2][]byte
+	next int
+}
+
+// hash is the first 16 hex digits of HMAC-SHA256(salt, value)
+func (hs *hashStage) hash(out, value []byte) []byte {
+	mac := hmac.New(sha256.New, hs.salt)
+	mac.Write(value)
+	var sum [sha256.Size]byte
+	return hex.AppendEncode(out, mac.Sum(sum[:0])[:8])
+}
+
+func (hs *hashStage) apply(line []byte) ([]byte, bool) {
+	changed := false
+	for _, fh := range hs.fields {
+		out := hs.bufs[hs.next][:0]
+		var ok bool
+		if fh.re != nil {
+			out, ok = hs.hashRegex(out, line, fh.re)
+		} else {
+			out, ok = hs.hashJSON(out, line, fh.json)
+		}
+		if !ok {
+			continue
+		}
+		hs.bufs[hs.next] = out
+		hs.next = 1 - hs.next
+		line = out
+		changed = true
+	}
+	if changed {
+		atomic.AddUint64(&linesHashed, 1)
+	}
+	return line, true
+}
+
+// hashRegex appends line with each match's first group, or the match, hashed
+func (hs *hashStage) hashRegex(out, line []byte, re *regexp.Regexp) ([]byte, bool) {
+	matches := re.FindAllSubmatchIndex(line, -1)
+	prev := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if len(m) > 2 {
+			start, end = m[2], m[3]
+		}
+		if start < 0 || end == start {
+			continue
+		}
+		out = append(out, line[prev:start]...)
+		out = hs.hash(out, line[start:end])
+		prev = end
+	}
+	if prev == 0 {
+		return out, false
+	}
+	return append(out, line[prev:]...), true
+}
+
+// hashJSON appends a JSON line with the value at path hashed, as a string.
+// The line is re-encoded, so keys come out sorted.
+func (hs *hashStage) hashJSON(out, line []byte, path jsonPath) ([]byte, bool) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if dec.Decode(&v) != nil {
+		return out, false
+	}
+	parent, ok := path[:len(path)-1].lookup(v)
+	if !ok {
+		return out, false
+	}
+	key := path[len(path)-1]
+	switch x := parent.(type) {
+	case map[string]interface{}:
+		old, ok := x[key]
+		if !ok || old == nil {
+			return out, false
+		}
+		x[key] = string(hs.hash(nil, appendJSONValue(nil, old)))
+	case []interface{}:
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i >= len(x) || x[i] == nil {
+			return out, false
+		}
+		x[i] = string(hs.hash(nil, appendJSONValue(nil, x[i])))
+	default:
+		return out, false
+	}
+	buf := bytes.NewBuffer(out)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if enc.Encode(v) != nil {
+		return out, false
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), true
+}
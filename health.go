@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// inputAttached is 1 while reader() is consuming input
+var inputAttached uint32
+
+// healthz is liveness: the process is up and serving http
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "ok\n")
+}
+
+// readyz is readiness: input is attached and the collector responds
+func (s *ssampleServer) readyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	if atomic.LoadUint32(&inputAttached) == 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "input not attached\n")
+		return
+	}
+	// takes the collector lock, a wedged collector hangs here and the probe times out
+	seen := s.c.Seen()
+	fmt.Fprintf(w, "ok %d\n", seen)
+}
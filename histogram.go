@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// histogram counts a -histogram field over all input, in fixed buckets or by powers of 2
+type histogram struct {
+	sel *valueSelector
+	// upper bounds of fixed buckets, nil for log scale
+	bounds []float64
+
+	l     sync.Mutex
+	count int64
+	sum   float64
+	// len(bounds)+1 counts for fixed buckets, the last for values over every bound
+	fixed []int64
+	// log scale: values <= 2^k and > 2^(k-1) by k; values <= 0 are in zero
+	pow2 map[int]int64
+	zero int64
+}
+
+// parseBuckets parses -histogram-buckets "1,10,100", "" for log scale
+func parseBuckets(spec string) ([]float64, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	var out []float64
+	for _, part := range strings.Split(spec, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(v) {
+			return nil, fmt.Errorf("bad bucket bound %q", part)
+		}
+		if len(out) != 0 && v <= out[len(out)-1] {
+			return nil, fmt.Errorf("bucket bounds must increase, %v after %v", v, out[len(out)-1])
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func newHistogram(sel *valueSelector, bounds []float64) *histogram {
+	h := &histogram{sel: sel, bounds: bounds}
+	h.reset()
+	return h
+}
+
+func (h *histogram) reset() {
+	h.l.Lock()
+	defer h.l.Unlock()
+	h.count = 0
+	h.sum = 0
+	if h.bounds != nil {
+		h.fixed = make([]int64, len(h.bounds)+1)
+	} else {
+		h.pow2 = make(map[int]int64)
+		h.zero = 0
+	}
+}
+
+func (h *histogram) add(line []byte) {
+	v, ok := h.sel.value(line)
+	if !ok {
+		return
+	}
+	h.l.Lock()
+	defer h.l.Unlock()
+	h.count++
+	h.sum += v
+	if h.bounds != nil {
+		h.fixed[sort.SearchFloat64s(h.bounds, v)]++
+	} else if v <= 0 {
+		h.zero++
+	} else {
+		h.pow2[int(math.Ceil(math.Log2(v)))]++
+	}
+}
+
+// Histogram is a -histogram over all input in /v1/sample
+type Histogram struct {
+	Field string  `json:"field"`
+	Count int64   `json:"count"`
+	Sum   float64 `json:"sum"`
+	// in increasing order, each counting values <= LE and over the bucket before
+	Buckets []HistogramBucket `json:"buckets"`
+	// values over the last fixed bucket
+	Overflow int64 `json:"overflow,omitempty"`
+}
+
+type HistogramBucket struct {
+	LE    float64 `json:"le"`
+	Count int64   `json:"count"`
+}
+
+func (h *histogram) summary() *Histogram {
+	h.l.Lock()
+	defer h.l.Unlock()
+	out := &Histogram{Field: h.sel.spec, Count: h.count, Sum: h.sum}
+	if h.bounds != nil {
+		for i, le := range h.bounds {
+			out.Buckets = append(out.Buckets, HistogramBucket{LE: le, Count: h.fixed[i]})
+		}
+		out.Overflow = h.fixed[len(h.bounds)]
+		return out
+	}
+	if h.zero != 0 {
+		out.Buckets = append(out.Buckets, HistogramBucket{LE: 0, Count: h.zero})
+	}
+	ks := make([]int, 0, len(h.pow2))
+	for k := range h.pow2 {
+		ks = append(ks, k)
+	}
+	sort.Ints(ks)
+	for _, k := range ks {
+		out.Buckets = append(out.Buckets, HistogramBucket{LE: math.Ldexp(1, k), Count: h.pow2[k]})
+	}
+	return out
+}
+
+// print writes a bar chart of the histogram
+func (hs *Histogram) print(w io.Writer) {
+	fmt.Fprintf(w, "histogram of %s, %d values:\n", hs.Field, hs.Count)
+	var most int64 = 1
+	for _, b := range hs.Buckets {
+		most = max(most, b.Count)
+	}
+	most = max(most, hs.Overflow)
+	const width = 50
+	for _, b := range hs.Buckets {
+		fmt.Fprintf(w, "  <= %-10g %10d %s\n", b.LE, b.Count, strings.Repeat("#", int(b.Count*width/most)))
+	}
+	if hs.Overflow != 0 {
+		fmt.Fprintf(w, "   > %-10g %10d %s\n", hs.Buckets[len(hs.Buckets)-1].LE, hs.Overflow, strings.Repeat("#", int(hs.Overflow*width/most)))
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// linesNotJSON counts lines -json-field dropped for not being a JSON object
+var linesNotJSON uint64
+
+// jsonPath is a dotted path into a JSON value, "a.b.c" or "items.0.id"
+type jsonPath []string
+
+func parseJSONPath(s string) jsonPath {
+	return jsonPath(strings.Split(s, "."))
+}
+
+// lookup finds the path in a value from json.Unmarshal
+func (jp jsonPath) lookup(v interface{}) (interface{}, bool) {
+	for _, key := range jp {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			v, ok = x[key]
+			if !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(x) {
+				return nil, false
+			}
+			v = x[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// appendJSONValue appends a string as itself and anything else as JSON
+func appendJSONValue(out []byte, v interface{}) []byte {
+	if s, ok := v.(string); ok {
+		return append(out, s...)
+	}
+	blob, _ := json.Marshal(v)
+	return append(out, blob...)
+}
+
+// jsonFieldStage replaces an NDJSON line with the values at its -json-field paths, tab separated.
+// A missing field is empty, a line that isn't a JSON object is dropped.
+type jsonFieldStage struct {
+	paths []jsonPath
+	out   []byte
+}
+
+func (js *jsonFieldStage) apply(line []byte) ([]byte, bool) {
+	var v map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if dec.Decode(&v) != nil || v == nil {
+		atomic.AddUint64(&linesNotJSON, 1)
+		return nil, false
+	}
+	js.out = js.out[:0]
+	for i, jp := range js.paths {
+		if i != 0 {
+			js.out = append(js.out, '\t')
+		}
+		fv, ok := jp.lookup(v)
+		if ok && fv != nil {
+			js.out = appendJSONValue(js.out, fv)
+		}
+	}
+	return js.out, true
+}
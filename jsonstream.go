@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// jsonStream writes a json document a value at a time, so a big sample is never a
+// single []byte in memory. The first error sticks and is returned by flush.
+type jsonStream struct {
+	w       *bufio.Writer
+	scratch bytes.Buffer
+	enc     *json.Encoder
+	err     error
+}
+
+func newJSONStream(w io.Writer) *jsonStream {
+	js := &jsonStream{w: bufio.NewWriterSize(w, 64*1024)}
+	js.enc = json.NewEncoder(&js.scratch)
+	return js
+}
+
+// raw writes s as is, e.g. `{"lines":[`
+func (js *jsonStream) raw(s string) {
+	if js.err == nil {
+		_, js.err = js.w.WriteString(s)
+	}
+}
+
+// value writes v as json.Marshal would
+func (js *jsonStream) value(v interface{}) {
+	if js.err != nil {
+		return
+	}
+	js.scratch.Reset()
+	js.err = js.enc.Encode(v)
+	if js.err == nil {
+		// Encode adds a newline
+		_, js.err = js.w.Write(bytes.TrimSuffix(js.scratch.Bytes(), []byte("\n")))
+	}
+}
+
+// array writes [v(0),v(1),...v(n-1)]
+func (js *jsonStream) array(n int, v func(i int) interface{}) {
+	js.raw("[")
+	for i := 0; i < n && js.err == nil; i++ {
+		if i != 0 {
+			js.raw(",")
+		}
+		js.value(v(i))
+	}
+	js.raw("]")
+}
+
+func (js *jsonStream) flush() error {
+	if js.err == nil {
+		js.err = js.w.Flush()
+	}
+	return js.err
+}
+
+// writeLineNoJSON streams a LineNoResponse like json.Marshal(out), except nil slices are [] not null
+func writeLineNoJSON(w io.Writer, out *LineNoResponse) error {
+	js := newJSONStream(w)
+	js.raw(`{"lines":`)
+	js.array(len(out.Lines), func(i int) interface{} { return out.Lines[i] })
+	js.raw(`,"lineNumbers":`)
+	js.array(len(out.LineNumbers), func(i int) interface{} { return out.LineNumbers[i] })
+	js.raw(`,"seen":`)
+	js.value(out.LinesSeen)
+	js.raw(`,"bytesSeen":`)
+	js.value(out.BytesSeen)
+	js.raw("}")
+	return js.flush()
+}
+
+// writeV1JSON streams a V1Sample like json.Marshal(v), except nil Lines is [] not null
+func writeV1JSON(w io.Writer, v *V1Sample) error {
+	lines := v.Lines
+	head := *v
+	head.Lines = nil
+	blob, err := json.Marshal(&head)
+	if err != nil {
+		return err
+	}
+	// "lines" is V1Sample's last field
+	suffix := []byte(`"lines":null}`)
+	if !bytes.HasSuffix(blob, suffix) {
+		blob, err = json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(blob)
+		return err
+	}
+	js := newJSONStream(w)
+	js.raw(string(blob[:len(blob)-len(suffix)]))
+	js.raw(`"lines":`)
+	js.array(len(lines), func(i int) interface{} { return &lines[i] })
+	js.raw("}")
+	return js.flush()
+}
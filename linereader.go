@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// linesTruncated counts input lines cut to -max-line-bytes, linesSkippedLong those left out by -long-lines skip
+var linesTruncated, linesSkippedLong uint64
+
+// -long-lines values, what becomes of lines longer than -max-line-bytes
+const (
+	// cut to -max-line-bytes
+	longTruncate = "truncate"
+	// cut, ending with a note of how long the line was
+	longHead = "head"
+	// left out
+	longSkip = "skip"
+)
+
+// longLines is the -long-lines policy
+var longLines = longTruncate
+
+func checkLongLines(policy string) error {
+	switch policy {
+	case longTruncate, longHead, longSkip:
+		return nil
+	}
+	return fmt.Errorf("unknown -long-lines %q, want truncate, head, or skip", policy)
+}
+
+const defaultMaxLineBytes = 1 << 20
+
+// recordSep ends input lines, with -nul a NUL byte so that records can hold newlines and any other bytes.
+// The -a/-teez file and -echo and -filter-exec get lines ending in it too.
+var recordSep byte = '\n'
+
+// lineReader splits input into lines like bufio.Scanner, but a line longer than max bytes
+// is truncated to max or skipped by -long-lines (and counted) instead of ending the input.
+type lineReader struct {
+	br   *bufio.Reader
+	max  int
+	line []byte
+	err  error
+}
+
+func newLineReader(r io.Reader, max int) *lineReader {
+	return &lineReader{br: bufio.NewReaderSize(r, 64*1024), max: max}
+}
+
+// appendCapped appends chunk to line, keeping no more than max bytes of line content plus "\r\n"
+func appendCapped(line, chunk []byte, max int) []byte {
+	room := max + 2 - len(line)
+	if room <= 0 {
+		return line
+	}
+	if len(chunk) > room {
+		chunk = chunk[:room]
+	}
+	return append(line, chunk...)
+}
+
+// trimLine removes the newline (and \r before it, like bufio.ScanLines) and cuts line to max.
+// With -nul only the NUL is removed.
+// total is the length of the whole line without its newline, which -long-lines head reports; false is to skip it.
+func trimLine(line []byte, max int, total int64) ([]byte, bool) {
+	if recordSep != '\n' {
+		if len(line) != 0 && line[len(line)-1] == recordSep {
+			line = line[:len(line)-1]
+		}
+	} else if len(line) != 0 && line[len(line)-1] == '\n' {
+		line = line[:len(line)-1]
+		if len(line) != 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+	}
+	if len(line) > max {
+		switch longLines {
+		case longSkip:
+			atomic.AddUint64(&linesSkippedLong, 1)
+			return nil, false
+		case longHead:
+			atomic.AddUint64(&linesTruncated, 1)
+			return fmt.Appendf(line[:max], " [truncated from %d bytes]", total), true
+		}
+		atomic.AddUint64(&linesTruncated, 1)
+		line = line[:max]
+	}
+	return line, true
+}
+
+func (lr *lineReader) Scan() bool {
+	lr.line = lr.line[:0]
+	n := 0
+	for {
+		chunk, err := lr.br.ReadSlice(recordSep)
+		n += len(chunk)
+		lr.line = appendCapped(lr.line, chunk, lr.max)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == nil {
+			var ok bool
+			lr.line, ok = trimLine(lr.line, lr.max, int64(n-1))
+			if !ok {
+				lr.line, n = lr.line[:0], 0
+				continue
+			}
+			return true
+		}
+		if err != io.EOF {
+			lr.err = err
+		}
+		if n != 0 {
+			// last line without a newline
+			var ok bool
+			lr.line, ok = trimLine(lr.line, lr.max, int64(n))
+			return ok
+		}
+		return false
+	}
+}
+
+// Bytes returns the line without its newline, valid until the next Scan
+func (lr *lineReader) Bytes() []byte {
+	return lr.line
+}
+
+func (lr *lineReader) Buffered() int {
+	return lr.br.Buffered()
+}
+
+// Err returns the read error that ended input, nil at EOF
+func (lr *lineReader) Err() error {
+	return lr.err
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// lineRoute is a -route name=REGEX, sending input lines that match to a named collector
+// as well as the sample of all input
+type lineRoute struct {
+	name string
+	re   *regexp.Regexp
+	c    *Collector
+}
+
+// parseRoute parses -route name=REGEX. The collector is from -collector name=N if there is one,
+// otherwise it is made with size lines.
+func parseRoute(spec string, set *collectorSet, size int) (*lineRoute, error) {
+	name, expr, ok := strings.Cut(spec, "=")
+	if !ok || name == "" || expr == "" {
+		return nil, fmt.Errorf("bad -route %q, want name=REGEX", spec)
+	}
+	if !collectorNameRe.MatchString(name) {
+		return nil, fmt.Errorf("bad -route name %q, want [A-Za-z0-9._-]", name)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("-route %s: %v", name, err)
+	}
+	c, _ := set.getOrAdd(name, size)
+	return &lineRoute{name: name, re: re, c: c}, nil
+}
+
+// printCollector writes a named collector's sample to stdout after a line naming it
+func printCollector(name string, c *Collector) {
+	stats := c.Stats()
+	fmt.Printf("# %s: seen %d lines, %d bytes, kept %d\n", name, stats.LinesSeen, stats.BytesSeen, stats.Kept)
+	printSample(c.LinesAndNumbers())
+}
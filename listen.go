@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strings"
+)
+
+// listen opens a tcp "host:port" or "unix:/path/to.sock" listener, or takes "systemd" or "systemd:name" from socket activation.
+// A unix socket is chmod'd to sockMode, and a stale socket file left by a previous run is removed first.
+func listen(addr string, sockMode os.FileMode) (net.Listener, error) {
+	if addr == "systemd" || strings.HasPrefix(addr, "systemd:") {
+		return sdListen(strings.TrimPrefix(strings.TrimPrefix(addr, "systemd"), ":"))
+	}
+	if !strings.HasPrefix(addr, "unix:") {
+		return net.Listen("tcp", addr)
+	}
+	path := strings.TrimPrefix(addr, "unix:")
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, sockMode)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// logger is where ssample's own messages go, set up by -v, -q, and -log-format
+var logger atomic.Pointer[slog.Logger]
+
+func init() {
+	logger.Store(slog.New(&textLog{out: os.Stderr, level: slog.LevelInfo, mu: new(sync.Mutex)}))
+}
+
+// logSettings are the -v/-q level and -log-format logger was made with
+var logSettings = struct {
+	level  slog.Level
+	format string
+}{slog.LevelInfo, "text"}
+
+// setupLogging makes logger print debug messages too with verbose,
+// only warnings and errors with quiet, and json lines with format "json"
+func setupLogging(verbose, quiet bool, format string) error {
+	level := slog.LevelInfo
+	if verbose {
+		level = slog.LevelDebug
+	} else if quiet {
+		level = slog.LevelWarn
+	}
+	if format != "text" && format != "json" {
+		return errors.New("-log-format: want text or json")
+	}
+	logSettings.level = level
+	logSettings.format = format
+	setLogOutput(os.Stderr)
+	return nil
+}
+
+// setLogOutput sends log messages to out, e.g. the -tui status line
+func setLogOutput(out io.Writer) {
+	if logSettings.format == "json" {
+		logger.Store(slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: logSettings.level})))
+	} else {
+		logger.Store(slog.New(&textLog{out: out, level: logSettings.level, mu: new(sync.Mutex)}))
+	}
+}
+
+func logf(level slog.Level, format string, args ...interface{}) {
+	ctx := context.Background()
+	l := logger.Load()
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	l.Log(ctx, level, strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"))
+}
+
+// debugf is for -v
+func debugf(format string, args ...interface{}) { logf(slog.LevelDebug, format, args...) }
+
+// infof is for what a run did, -q leaves these out
+func infof(format string, args ...interface{}) { logf(slog.LevelInfo, format, args...) }
+
+func warnf(format string, args ...interface{}) { logf(slog.LevelWarn, format, args...) }
+
+func errorf(format string, args ...interface{}) { logf(slog.LevelError, format, args...) }
+
+// textLog is the default -log-format, just the message (and any attributes) for a person to read
+type textLog struct {
+	out   io.Writer
+	level slog.Level
+	attrs []slog.Attr
+	mu    *sync.Mutex
+}
+
+func (tl *textLog) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= tl.level
+}
+
+func (tl *textLog) Handle(_ context.Context, r slog.Record) error {
+	buf := []byte(r.Message)
+	appendAttr := func(a slog.Attr) bool {
+		buf = fmt.Appendf(buf, " %s=%v", a.Key, a.Value)
+		return true
+	}
+	for _, a := range tl.attrs {
+		appendAttr(a)
+	}
+	r.Attrs(appendAttr)
+	buf = append(buf, '\n')
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	_, err := tl.out.Write(buf)
+	return err
+}
+
+func (tl *textLog) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := *tl
+	out.attrs = append(tl.attrs[:len(tl.attrs):len(tl.attrs)], attrs...)
+	return &out
+}
+
+// WithGroup is unused, attributes stay ungrouped
+func (tl *textLog) WithGroup(name string) slog.Handler {
+	return tl
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strconv"
+)
+
+// scanLogfmt calls fn for each key=value pair of a logfmt line, in order.
+// Quoted values are unquoted, a bare key has an empty value. fn's arguments are only
+// valid during the call. Stops early if fn returns false.
+func scanLogfmt(line []byte, fn func(key, value []byte) bool) {
+	i := 0
+	for i < len(line) {
+		for i < len(line) && line[i] <= ' ' {
+			i++
+		}
+		start := i
+		for i < len(line) && line[i] > ' ' && line[i] != '=' {
+			i++
+		}
+		key := line[start:i]
+		var value []byte
+		if i < len(line) && line[i] == '=' {
+			i++
+			if i < len(line) && line[i] == '"' {
+				end := i + 1
+				for end < len(line) && line[end] != '"' {
+					if line[end] == '\\' {
+						end++
+					}
+					end++
+				}
+				if end >= len(line) {
+					end = len(line) - 1
+				}
+				quoted := line[i : end+1]
+				i = end + 1
+				s, err := strconv.Unquote(string(quoted))
+				if err == nil {
+					value = []byte(s)
+				} else {
+					// unterminated or bad escapes, keep what's inside the quotes
+					value = quoted[1:]
+					if len(value) != 0 && value[len(value)-1] == '"' {
+						value = value[:len(value)-1]
+					}
+				}
+			} else {
+				start = i
+				for i < len(line) && line[i] > ' ' {
+					i++
+				}
+				value = line[start:i]
+			}
+		}
+		if len(key) != 0 && !fn(key, value) {
+			return
+		}
+		if len(key) == 0 && i < len(line) && line[i] == '=' {
+			// stray '='
+			i++
+		}
+	}
+}
+
+// logfmtFieldStage replaces a logfmt line with the values of its -logfmt-field keys, tab separated.
+// A missing key is empty.
+type logfmtFieldStage struct {
+	keys   []string
+	values [][]byte
+	found  []bool
+	out    []byte
+}
+
+func (ls *logfmtFieldStage) apply(line []byte) ([]byte, bool) {
+	if ls.values == nil {
+		ls.values = make([][]byte, len(ls.keys))
+		ls.found = make([]bool, len(ls.keys))
+	}
+	for i := range ls.values {
+		ls.values[i] = ls.values[i][:0]
+		ls.found[i] = false
+	}
+	scanLogfmt(line, func(key, value []byte) bool {
+		for i, k := range ls.keys {
+			if !ls.found[i] && k == string(key) {
+				ls.values[i] = append(ls.values[i], value...)
+				ls.found[i] = true
+			}
+		}
+		return true
+	})
+	ls.out = ls.out[:0]
+	for i, v := range ls.values {
+		if i != 0 {
+			ls.out = append(ls.out, '\t')
+		}
+		ls.out = append(ls.out, v...)
+	}
+	return ls.out, true
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"runtime/debug"
+	"runtime/metrics"
+	"time"
+)
+
+// Shrink evicts random lines until at most n are kept and lowers LinesToKeep to n.
+// A uniform sample of a uniform sample is still uniform, so sampling carries on unbiased at the new size.
+// An -unusual sample evicts its lowest priority lines instead, a -first-by one forgets the keys of those it evicts.
+func (c *Collector) Shrink(n int) {
+	if n < 1 {
+		n = 1
+	}
+	c.l.Lock()
+	defer c.l.Unlock()
+	if n >= c.LinesToKeep {
+		return
+	}
+	c.LinesToKeep = n
+	for c.lines.Len() > n {
+		i := c.rng.IntN(c.lines.Len())
+		if c.unusual != nil {
+			// the lowest priority goes first, as if LinesToKeep had always been n
+			i = c.unusual.lowestIndex()
+			c.unusual.remove(i)
+		}
+		if c.keyed != nil {
+			c.keyed.remove(i)
+		}
+		last := c.lines.Len() - 1
+		c.notify(ReservoirChange{LineNumber: -1, Evicted: c.lineNumbers[i]})
+		c.lines.Remove(i)
+		c.lineNumbers[i] = c.lineNumbers[last]
+		c.lineNumbers = c.lineNumbers[:last]
+		c.lineTimes[i] = c.lineTimes[last]
+		c.lineTimes = c.lineTimes[:last]
+		if c.arrivals != nil {
+			c.lineGaps[i] = c.lineGaps[last]
+			c.lineGaps = c.lineGaps[:last]
+		}
+		if c.lineSources != nil {
+			c.lineSources[i] = c.lineSources[last]
+			c.lineSources = c.lineSources[:last]
+		}
+		c.evictions++
+	}
+}
+
+// memLimiter watches the heap and shrinks the collectors' samples when it nears max bytes
+type memLimiter struct {
+	max        uint64
+	collectors func() []*Collector
+}
+
+// heap in use, as the GC sees it
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+func heapBytes() uint64 {
+	sample := []metrics.Sample{{Name: heapMetric}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
+
+// GCs finished
+func gcCycles() uint64 {
+	sample := []metrics.Sample{{Name: "/gc/cycles/total:gc-cycles"}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
+
+// at most how often the memLimiter forces a GC to hand evicted lines back to the OS
+const freeOSMemoryEvery = time.Minute
+
+// run checks every interval; above 90% of max every sample is cut in proportion to bring the heap down to 75%.
+// It says so once each time the heap goes above 90%, and only looks again once a GC has freed what it evicted.
+func (ml *memLimiter) run(interval time.Duration) {
+	// also have the GC work harder before the heap reaches max
+	debug.SetMemoryLimit(int64(ml.max))
+	above := false
+	var shrunkAt uint64
+	var freed time.Time
+	for now := range time.Tick(interval) {
+		heap, gcs := heapBytes(), gcCycles()
+		if heap < ml.max/10*9 {
+			above = false
+			continue
+		}
+		if above && gcs == shrunkAt {
+			// the lines evicted last time are still counted in the heap
+			continue
+		}
+		frac := float64(ml.max) * 0.75 / float64(heap)
+		for _, c := range ml.collectors() {
+			was := c.Stats().Capacity
+			c.Shrink(int(float64(was) * frac))
+			if above {
+				debugf("-max-mem: heap %d bytes, %s sample shrunk from %d to %d lines", heap, c.Source, was, c.Stats().Capacity)
+			} else {
+				infof("-max-mem: heap %d bytes, %s sample shrunk from %d to %d lines", heap, c.Source, was, c.Stats().Capacity)
+			}
+		}
+		above = true
+		shrunkAt = gcs
+		if now.Sub(freed) >= freeOSMemoryEvery {
+			debug.FreeOSMemory()
+			freed = now
+		}
+	}
+}
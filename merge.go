@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"time"
+)
+
+// mergeSamples combines samples of different streams into one sample of size keep of their union.
+// Each output line is drawn from input i with probability proportional to the input lines i's sample
+// stands for which haven't been drawn yet (a multivariate hypergeometric draw), so inputs are weighted
+// by their seen counts rather than by their sample sizes. That is only right for uniform samples,
+// so callers check each one is mergeable first.
+func mergeSamples(samples []*loadedSample, keep int, rng *rand.Rand) *V1Sample {
+	remaining := make([]int, len(samples))
+	pools := make([][]SampleRecord, len(samples))
+	total := 0
+	out := &V1Sample{sampleHeader: newSampleHeader(algMerge), Version: 1, Capacity: keep}
+	for i, ls := range samples {
+		remaining[i] = ls.LinesSeen
+		total += ls.LinesSeen
+		pools[i] = append([]SampleRecord(nil), ls.Records...)
+		out.LinesSeen += ls.LinesSeen
+		out.BytesSeen += ls.BytesSeen
+		if !ls.Window.Start.IsZero() && (out.Window.Start.IsZero() || ls.Window.Start.Before(out.Window.Start)) {
+			out.Window.Start = ls.Window.Start
+		}
+		if ls.Window.End.After(out.Window.End) {
+			out.Window.End = ls.Window.End
+		}
+	}
+	for len(out.Lines) < keep && total > 0 {
+		x := rng.IntN(total)
+		i := 0
+		for x >= remaining[i] {
+			x -= remaining[i]
+			i++
+		}
+		pool := pools[i]
+		if len(pool) == 0 {
+			// this sample is used up, it can't contribute more
+			total -= remaining[i]
+			remaining[i] = 0
+			continue
+		}
+		pick := rng.IntN(len(pool))
+		rec := pool[pick]
+		pool[pick] = pool[len(pool)-1]
+		pools[i] = pool[:len(pool)-1]
+		if rec.Source == "" {
+			rec.Source = samples[i].Name
+		} else {
+			rec.Source = samples[i].Name + ":" + rec.Source
+		}
+		out.Lines = append(out.Lines, rec)
+		remaining[i]--
+		total--
+	}
+	weight := 1.0
+	if len(out.Lines) > 0 {
+		weight = float64(out.LinesSeen) / float64(len(out.Lines))
+	}
+	for i := range out.Lines {
+		out.Lines[i].Weight = weight
+		out.Lines[i].Probability = inclusionProbability(weight)
+	}
+	out.SamplingRate = samplingRate(len(out.Lines), out.LinesSeen)
+	sort.SliceStable(out.Lines, func(i, j int) bool {
+		if out.Lines[i].Source != out.Lines[j].Source {
+			return out.Lines[i].Source < out.Lines[j].Source
+		}
+		return out.Lines[i].LineNumber < out.Lines[j].LineNumber
+	})
+	return out
+}
+
+// mergeMain is `ssample merge [-l N] [-o out.json] a.json b.json ...`
+func mergeMain(args []string) {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	keep := countFlag(fs, "l", 0, "lines in the merged sample (default the largest input capacity)")
+	outPath := fs.String("o", "", "write merged json here instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s merge [-l N] [-o out.json] sample.json ...\n\nMerge -state, /snapshot, or saved json samples into one sample of all their input.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(exitStart)
+	}
+	var samples []*loadedSample
+	capacity := 0
+	for _, path := range fs.Args() {
+		ls, err := readSampleFile(path)
+		maybefail(err, "%v\n", err)
+		err = ls.mergeable()
+		maybefail(err, "%s: %v\n", path, err)
+		samples = append(samples, ls)
+		if ls.Capacity > capacity {
+			capacity = ls.Capacity
+		}
+	}
+	if *keep <= 0 {
+		*keep = capacity
+	}
+	rng := rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), uint64(os.Getpid())))
+	merged := mergeSamples(samples, *keep, rng)
+	merged.Host = thisHost()
+	blob, err := json.Marshal(merged)
+	maybefail(err, "json: %v\n", err)
+	blob = append(blob, '\n')
+	if *outPath == "" {
+		os.Stdout.Write(blob)
+		return
+	}
+	err = writeFileAtomic(*outPath, blob, 0644)
+	maybefail(err, "%s: %v\n", *outPath, err)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestMergeSamples(t *testing.T) {
+	sample := func(name string, seen int) *loadedSample {
+		ls := &loadedSample{Name: name, Capacity: 100, LinesSeen: seen, BytesSeen: int64(seen) * 10}
+		for i := 0; i < 100; i++ {
+			ls.Records = append(ls.Records, SampleRecord{LineNumber: i * seen / 100, Line: fmt.Sprintf("%s %d", name, i)})
+		}
+		return ls
+	}
+	// equal sample sizes, but a stands for 9 times the lines b does
+	a, b := sample("a", 9000), sample("b", 1000)
+	rng := rand.New(rand.NewPCG(1, 2))
+	const trials = 200
+	fromA := 0
+	for trial := 0; trial < trials; trial++ {
+		out := mergeSamples([]*loadedSample{a, b}, 100, rng)
+		if len(out.Lines) != 100 || out.LinesSeen != 10000 || out.BytesSeen != 100000 || out.Capacity != 100 {
+			t.Fatalf("merged %d lines of %d seen, %d bytes, capacity %d", len(out.Lines), out.LinesSeen, out.BytesSeen, out.Capacity)
+		}
+		for _, rec := range out.Lines {
+			if rec.Source == "a" {
+				fromA++
+			}
+			if rec.Weight != 100 {
+				t.Fatalf("line weight %v, want 100", rec.Weight)
+			}
+		}
+	}
+	if frac := float64(fromA) / (trials * 100); frac < 0.88 || frac > 0.92 {
+		t.Errorf("%.3f of merged lines from a, want 0.9", frac)
+	}
+	if len(a.Records) != 100 || len(b.Records) != 100 {
+		t.Errorf("inputs changed: %d and %d records", len(a.Records), len(b.Records))
+	}
+
+	// keeping more than there are takes everything
+	out := mergeSamples([]*loadedSample{a, b}, 500, rng)
+	if len(out.Lines) != 200 {
+		t.Errorf("merged %d lines, want all 200", len(out.Lines))
+	}
+	// sorted by source then line number
+	for i := 1; i < len(out.Lines); i++ {
+		p, q := out.Lines[i-1], out.Lines[i]
+		if p.Source > q.Source || (p.Source == q.Source && p.LineNumber >= q.LineNumber) {
+			t.Errorf("line %d %s:%d after %s:%d", i, q.Source, q.LineNumber, p.Source, p.LineNumber)
+			break
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// metricsHandler serves Prometheus text exposition format
+type metricsHandler struct {
+	c    *Collector
+	rate *rateMeter
+}
+
+func promMetric(w io.Writer, name, mtype, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, mtype, name, value)
+}
+
+// promHistogram writes a -histogram as a Prometheus histogram, whose buckets are cumulative
+func promHistogram(w io.Writer, hs *Histogram) {
+	const name = "ssample_field"
+	fmt.Fprintf(w, "# HELP %s -histogram of %s over all input.\n# TYPE %s histogram\n", name, hs.Field, name)
+	var cum int64
+	for _, b := range hs.Buckets {
+		cum += b.Count
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, b.LE, cum)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", name, hs.Count, name, hs.Sum, name, hs.Count)
+}
+
+func (mh *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	st := mh.c.Stats()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	promMetric(w, "ssample_lines_seen_total", "counter", "Input lines seen.", st.LinesSeen)
+	promMetric(w, "ssample_bytes_seen_total", "counter", "Input bytes seen, including newlines.", st.BytesSeen)
+	promMetric(w, "ssample_reservoir_size", "gauge", "Lines currently held in the sample.", st.Kept)
+	promMetric(w, "ssample_reservoir_capacity", "gauge", "Maximum lines held in the sample.", st.Capacity)
+	promMetric(w, "ssample_reservoir_distinct", "gauge", "Different lines among those held in the sample.", st.Distinct)
+	promMetric(w, "ssample_evictions_total", "counter", "Sampled lines replaced by a newer line.", st.Evictions)
+	promMetric(w, "ssample_tee_write_errors_total", "counter", "Failed writes to the -a/-teez file.", atomic.LoadUint64(&teeWriteErrors))
+	promMetric(w, "ssample_tee_dropped_total", "counter", "Lines left out of the -a/-teez file by -tee-policy drop.", atomic.LoadUint64(&teeDropped))
+	promMetric(w, "ssample_lines_matched_total", "counter", "Input lines passing -match, -exclude, -min-len, -max-len, and -skip-blank.", atomic.LoadUint64(&linesMatched))
+	promMetric(w, "ssample_lines_filtered_total", "counter", "Input lines dropped by -match, -exclude, -min-len, -max-len, or -skip-blank.", atomic.LoadUint64(&linesFiltered))
+	promMetric(w, "ssample_lines_not_json_total", "counter", "Input lines dropped by -json-field for not being a JSON object.", atomic.LoadUint64(&linesNotJSON))
+	promMetric(w, "ssample_lines_not_records_total", "counter", "Input lines dropped by -records for not parsing.", atomic.LoadUint64(&linesNotRecords))
+	promMetric(w, "ssample_lines_expr_errors_total", "counter", "Input lines a -filter or -transform expression failed on.", atomic.LoadUint64(&linesExprErrors))
+	promMetric(w, "ssample_lines_wasm_dropped_total", "counter", "Input lines left out by -wasm.", atomic.LoadUint64(&linesWasmDropped))
+	promMetric(w, "ssample_lines_wasm_errors_total", "counter", "Input lines -wasm failed on, kept as they were.", atomic.LoadUint64(&linesWasmErrors))
+	promMetric(w, "ssample_lines_wasm_skipped_total", "counter", "Input lines passed by without -wasm after it failed too many times in a row.", atomic.LoadUint64(&linesWasmSkipped))
+	promMetric(w, "ssample_lines_not_clf_total", "counter", "Input lines dropped by -clf for not being access log lines.", atomic.LoadUint64(&linesNotCLF))
+	promMetric(w, "ssample_lines_invalid_utf8_total", "counter", "Input lines with invalid UTF-8 replaced or left out by -invalid-utf8.", atomic.LoadUint64(&linesInvalidUTF8))
+	promMetric(w, "ssample_lines_truncated_total", "counter", "Input lines cut to -max-line-bytes.", atomic.LoadUint64(&linesTruncated))
+	promMetric(w, "ssample_lines_skipped_long_total", "counter", "Input lines longer than -max-line-bytes left out by -long-lines skip.", atomic.LoadUint64(&linesSkippedLong))
+	promMetric(w, "ssample_lines_duplicate_total", "counter", "Repeated input lines left out by -dedup.", atomic.LoadUint64(&linesDuplicate))
+	promMetric(w, "ssample_lines_no_time_total", "counter", "Input lines -time-regex found no time in.", atomic.LoadUint64(&linesNoTime))
+	promMetric(w, "ssample_lines_redacted_total", "counter", "Input lines changed by -redact.", atomic.LoadUint64(&linesRedacted))
+	promMetric(w, "ssample_lines_hashed_total", "counter", "Input lines with values replaced by -hash.", atomic.LoadUint64(&linesHashed))
+	if hs := mh.c.Histogram(); hs != nil {
+		promHistogram(w, hs)
+	}
+	promCounts(w, mh.c.PatternCounts())
+	promMetric(w, "ssample_input_lines_per_second", "gauge", "Input rate over the last minute.", fmt.Sprintf("%.3f", mh.rate.Rate()))
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusRecorder remembers the response code and size for access logging.
+// It passes through Flush and Hijack so /events and /ws still work.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.size += int64(n)
+	return n, err
+}
+
+func (sr *statusRecorder) Flush() {
+	if fl, ok := sr.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+func (sr *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := sr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection can't be hijacked")
+	}
+	sr.status = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
+
+// accessLog writes a line per request: time client method uri status bytes duration
+type accessLog struct {
+	next http.Handler
+	out  io.Writer
+
+	l sync.Mutex
+}
+
+func (al *accessLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	sr := &statusRecorder{ResponseWriter: w}
+	al.next.ServeHTTP(sr, r)
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	al.l.Lock()
+	defer al.l.Unlock()
+	fmt.Fprintf(al.out, "%s %s %s %q %d %d %s\n",
+		start.UTC().Format(time.RFC3339), clientIP(r), r.Method, r.RequestURI, sr.status, sr.size, time.Since(start))
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// unix socket
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket per client IP, refilling at rate per second up to burst
+type rateLimiter struct {
+	next  http.Handler
+	rate  float64
+	burst float64
+
+	buckets map[string]*tokenBucket
+	lastGC  time.Time
+
+	l sync.Mutex
+}
+
+func newRateLimiter(next http.Handler, rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		next:    next,
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		lastGC:  time.Now(),
+	}
+}
+
+func (rl *rateLimiter) allow(ip string) (ok bool, wait time.Duration) {
+	now := time.Now()
+	rl.l.Lock()
+	defer rl.l.Unlock()
+	if now.Sub(rl.lastGC) > time.Minute {
+		// forget clients whose buckets have refilled
+		for k, b := range rl.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= rl.burst {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.lastGC = now
+	}
+	b := rl.buckets[ip]
+	if b == nil {
+		b = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[ip] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * rl.rate
+	if b.tokens > rl.burst {
+		b.tokens = rl.burst
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+}
+
+func (rl *rateLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ok, wait := rl.allow(clientIP(r))
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintf(w, "rate limited\n")
+		return
+	}
+	rl.next.ServeHTTP(w, r)
+}
+
+// ipAllowlist answers 403 to clients outside nets. Unix socket clients have no IP and are let in, the socket's permissions guard it.
+type ipAllowlist struct {
+	next http.Handler
+	nets []netip.Prefix
+}
+
+// parseAllowCIDRs reads -allow-cidr networks, a bare address is just that host
+func parseAllowCIDRs(specs []string) ([]netip.Prefix, error) {
+	var nets []netip.Prefix
+	for _, spec := range specs {
+		for _, part := range strings.Split(spec, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			if !strings.Contains(part, "/") {
+				addr, err := netip.ParseAddr(part)
+				if err != nil {
+					return nil, fmt.Errorf("-allow-cidr %q: %v", part, err)
+				}
+				nets = append(nets, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+				continue
+			}
+			prefix, err := netip.ParsePrefix(part)
+			if err != nil {
+				return nil, fmt.Errorf("-allow-cidr %q: %v", part, err)
+			}
+			nets = append(nets, prefix.Masked())
+		}
+	}
+	return nets, nil
+}
+
+func (al *ipAllowlist) allowed(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// unix socket
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range al.nets {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (al *ipAllowlist) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !al.allowed(r) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "forbidden\n")
+		return
+	}
+	al.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,59 @@
+package main
+
+import "time"
+
+// MinuteCount is lines seen in the minute from Start
+type MinuteCount struct {
+	Start time.Time `json:"start"`
+	Lines int       `json:"lines"`
+}
+
+// minuteCounts is a ring of lines seen in each of the last 60 minutes of wall clock time,
+// for the shape of the traffic over the last hour. It isn't cleared by Reset.
+type minuteCounts struct {
+	counts [60]int
+	// minutes since the epoch of the first line and of the newest slot, 0 before any lines
+	first, newest int64
+}
+
+func (mc *minuteCounts) add(t time.Time) {
+	m := t.Unix() / 60
+	if mc.first == 0 {
+		mc.first = m
+		mc.newest = m
+	}
+	mc.advance(m)
+	if mc.newest-m < int64(len(mc.counts)) {
+		// m is older than newest if the clock went back
+		mc.counts[m%int64(len(mc.counts))]++
+	}
+}
+
+// advance clears the slots of the minutes up to m
+func (mc *minuteCounts) advance(m int64) {
+	for i := mc.newest + 1; i <= m && i <= mc.newest+int64(len(mc.counts)); i++ {
+		mc.counts[i%int64(len(mc.counts))] = 0
+	}
+	mc.newest = max(mc.newest, m)
+}
+
+// series is the counts oldest first through the current, partial, minute; nil before any lines
+func (mc *minuteCounts) series(now time.Time) []MinuteCount {
+	if mc.first == 0 {
+		return nil
+	}
+	mc.advance(now.Unix() / 60)
+	from := max(mc.first, mc.newest-int64(len(mc.counts))+1)
+	out := make([]MinuteCount, 0, mc.newest-from+1)
+	for m := from; m <= mc.newest; m++ {
+		out = append(out, MinuteCount{Start: time.Unix(m*60, 0), Lines: mc.counts[m%int64(len(mc.counts))]})
+	}
+	return out
+}
+
+// PerMinute is lines seen in each minute of the last hour, oldest first
+func (c *Collector) PerMinute() []MinuteCount {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.minutes.series(time.Now())
+}
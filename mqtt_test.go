@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func mqttScript(server []byte) (*mqttConn, *scriptConn) {
+	sc := &scriptConn{}
+	return &mqttConn{c: sc, r: bufio.NewReader(bytes.NewReader(server)), done: make(chan struct{})}, sc
+}
+
+// mqttPacket is a packet as send writes it
+func mqttPacket(first byte, body []byte) []byte {
+	mc, sc := mqttScript(nil)
+	mc.send(first, body)
+	return sc.wrote.Bytes()
+}
+
+func TestMQTTRemainingLength(t *testing.T) {
+	for _, tc := range []struct {
+		n    int
+		want []byte
+	}{
+		{0, []byte{0}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+	} {
+		body := bytes.Repeat([]byte{'x'}, tc.n)
+		pkt := mqttPacket(mqttPublish<<4, body)
+		if got := pkt[1 : len(pkt)-tc.n]; !bytes.Equal(got, tc.want) {
+			t.Errorf("remaining length %d sent as % x, want % x", tc.n, got, tc.want)
+		}
+		mc, _ := mqttScript(pkt)
+		typ, got, err := mc.recv()
+		if err != nil || typ != mqttPublish<<4 || !bytes.Equal(got, body) {
+			t.Errorf("remaining length %d: read back %d bytes, %v", tc.n, len(got), err)
+		}
+	}
+}
+
+func TestMQTTRecvErrors(t *testing.T) {
+	for _, tc := range []struct {
+		in   []byte
+		want string
+	}{
+		{nil, "EOF"},
+		{[]byte{0x30}, "EOF"},
+		{[]byte{0x30, 0x80}, "EOF"},
+		{[]byte{0x30, 0x05, 'a'}, "EOF"},
+		{[]byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01}, "bad remaining length"},
+		{[]byte{0x30, 0xff, 0xff, 0xff, 0x7f}, "too big"},
+		{[]byte{0x30, 0x81, 0x80, 0x80, 0x20}, "too big"},
+	} {
+		mc, _ := mqttScript(tc.in)
+		_, body, err := mc.recv()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("% x: %d bytes, %v, want %q", tc.in, len(body), err, tc.want)
+		}
+	}
+}
+
+func TestMQTTPublish(t *testing.T) {
+	// a body with the topic a/b, then rest
+	topic := func(rest ...byte) []byte { return append(mqttString(nil, "a/b"), rest...) }
+	for _, tc := range []struct {
+		name    string
+		typ     byte
+		body    []byte
+		payload string
+		acked   []byte
+		err     string
+	}{
+		{"QoS 0", mqttPublish << 4, topic('h', 'i'), "hi", nil, ""},
+		{"QoS 0, retained", mqttPublish<<4 | 1, topic('h', 'i'), "hi", nil, ""},
+		{"QoS 1", mqttPublish<<4 | 2, topic(0x12, 0x34, 'h', 'i'), "hi", []byte{mqttPuback << 4, 2, 0x12, 0x34}, ""},
+		{"QoS 1, empty", mqttPublish<<4 | 2, topic(0, 1), "", []byte{mqttPuback << 4, 2, 0, 1}, ""},
+		{"empty", mqttPublish << 4, nil, "", nil, "short PUBLISH"},
+		{"short topic", mqttPublish << 4, []byte{0, 9, 'a'}, "", nil, "short PUBLISH topic"},
+		{"QoS 1 without an id", mqttPublish<<4 | 2, topic(1), "", nil, "packet id"},
+		{"QoS 3", mqttPublish<<4 | 6, topic('h', 'i'), "", nil, "QoS 3"},
+	} {
+		mc, sc := mqttScript(nil)
+		m, err := mc.publish(tc.typ, tc.body)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: %v, want %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if m.subject != "a/b" || string(m.payload) != tc.payload {
+			t.Errorf("%s: %q %q, want a/b %q", tc.name, m.subject, m.payload, tc.payload)
+		}
+		if !bytes.Equal(sc.wrote.Bytes(), tc.acked) {
+			t.Errorf("%s: wrote % x, want % x", tc.name, sc.wrote.Bytes(), tc.acked)
+		}
+	}
+}
+
+func TestMQTTHandshake(t *testing.T) {
+	mt := &mqttTarget{topic: "dev/+/logs", qos: 1, clientID: "c1", user: "u", password: "p"}
+	retained := mqttPacket(mqttPublish<<4|1, append(mqttString(nil, "dev/1/logs"), "old"...))
+	server := append(mqttPacket(mqttConnack<<4, []byte{0, 0}), retained...)
+	server = append(server, mqttPacket(mqttSuback<<4, []byte{0, 1, 1})...)
+	server = append(server, mqttPacket(0xd0, nil)...)
+	server = append(server, mqttPacket(mqttPublish<<4, append(mqttString(nil, "dev/2/logs"), "new"...))...)
+	mc, sc := mqttScript(server)
+	if err := mc.handshake(mt); err != nil {
+		t.Fatal(err)
+	}
+	connect := mqttString(nil, "MQTT")
+	connect = append(connect, 4, 0xc2, 0, 60)
+	connect = mqttString(mqttString(mqttString(connect, "c1"), "u"), "p")
+	want := mqttPacket(mqttConnect<<4, connect)
+	want = append(want, mqttPacket(mqttSubscribe<<4|2, append(mqttString([]byte{0, 1}, "dev/+/logs"), 1))...)
+	if !bytes.Equal(sc.wrote.Bytes(), want) {
+		t.Errorf("wrote % x\nwant % x", sc.wrote.Bytes(), want)
+	}
+	// the retained message before the SUBACK, then the PINGRESP is passed over
+	for _, want := range []string{"old", "new"} {
+		m, err := mc.next()
+		if err != nil || string(m.payload) != want {
+			t.Errorf("next = %q, %v, want %q", m.payload, err, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		server [][]byte
+		want   string
+	}{
+		{"bad password", [][]byte{mqttPacket(mqttConnack<<4, []byte{0, 4})}, "bad user name or password"},
+		{"unknown refusal", [][]byte{mqttPacket(mqttConnack<<4, []byte{0, 9})}, "code 9"},
+		{"not a CONNACK", [][]byte{mqttPacket(mqttSuback<<4, []byte{0, 0})}, "expected CONNACK"},
+		{"short CONNACK", [][]byte{mqttPacket(mqttConnack<<4, nil)}, "expected CONNACK"},
+		{"subscription refused", [][]byte{mqttPacket(mqttConnack<<4, []byte{0, 0}), mqttPacket(mqttSuback<<4, []byte{0, 1, 0x80})}, "refused"},
+		{"short SUBACK", [][]byte{mqttPacket(mqttConnack<<4, []byte{0, 0}), mqttPacket(mqttSuback<<4, []byte{0, 1})}, "refused"},
+		{"not a SUBACK", [][]byte{mqttPacket(mqttConnack<<4, []byte{0, 0}), mqttPacket(mqttConnack<<4, []byte{0, 0})}, "expected SUBACK"},
+		{"bad retained message", [][]byte{mqttPacket(mqttConnack<<4, []byte{0, 0}), mqttPacket(mqttPublish<<4, []byte{0})}, "short PUBLISH"},
+	} {
+		mc, _ := mqttScript(bytes.Join(tc.server, nil))
+		err := mc.handshake(&mqttTarget{topic: "t", clientID: "c"})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestParseMQTTTarget(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		want mqttTarget
+	}{
+		{"broker/a/#", mqttTarget{addr: "broker:1883", topic: "a/#", clientID: "c"}},
+		{"mqtts://u:p@broker/+/x", mqttTarget{addr: "broker:8883", tls: true, user: "u", password: "p", topic: "+/x", clientID: "c"}},
+		{"tcp://[::1]:1900/#", mqttTarget{addr: "[::1]:1900", topic: "#", clientID: "c"}},
+	} {
+		mt, err := parseMQTTTarget(tc.spec, 0, "c")
+		if err != nil {
+			t.Errorf("%q: %v", tc.spec, err)
+			continue
+		}
+		if *mt != tc.want {
+			t.Errorf("%q = %+v, want %+v", tc.spec, *mt, tc.want)
+		}
+	}
+	if mt, err := parseMQTTTarget("broker/t", 1, ""); err != nil || mt.qos != 1 || !strings.HasPrefix(mt.clientID, "ssample-") {
+		t.Errorf("parsed %+v, %v", mt, err)
+	}
+	for _, spec := range []string{"broker", "broker/", "ws://broker/t", "/t", "u:p@:1883/t"} {
+		if _, err := parseMQTTTarget(spec, 0, ""); err == nil {
+			t.Errorf("%q: no error", spec)
+		}
+	}
+	if _, err := parseMQTTTarget("broker/t", 2, ""); err == nil {
+		t.Errorf("QoS 2: no error")
+	}
+}
+
+func FuzzMQTTRecv(f *testing.F) {
+	f.Add(mqttPacket(mqttPublish<<4, append(mqttString(nil, "a/b"), "hi"...)))
+	f.Add(mqttPacket(mqttPublish<<4|2, append(mqttString(nil, "a"), 0, 1, 'x')))
+	f.Add(mqttPacket(mqttSuback<<4, []byte{0, 1, 0}))
+	f.Add([]byte{0x30, 0xff, 0xff, 0xff, 0x7f})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		mc, _ := mqttScript(b)
+		for {
+			typ, body, err := mc.recv()
+			if err != nil {
+				return
+			}
+			if typ>>4 == mqttPublish {
+				mc.publish(typ, body)
+			}
+		}
+	})
+}
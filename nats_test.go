@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptConn is a net.Conn that reads a server's side of a conversation given up front,
+// keeping what's written to it
+type scriptConn struct {
+	net.Conn
+	wrote bytes.Buffer
+}
+
+func (sc *scriptConn) Write(b []byte) (int, error)        { return sc.wrote.Write(b) }
+func (sc *scriptConn) SetDeadline(time.Time) error        { return nil }
+func (sc *scriptConn) SetReadDeadline(time.Time) error    { return nil }
+func (sc *scriptConn) Close() error                       { return nil }
+func (sc *scriptConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (sc *scriptConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func natsScript(nt *natsTarget, server string) (*natsConn, *scriptConn) {
+	sc := &scriptConn{}
+	return &natsConn{nt: nt, c: sc, r: bufio.NewReader(strings.NewReader(server))}, sc
+}
+
+func TestNATSReadMsg(t *testing.T) {
+	for _, tc := range []struct {
+		in    string
+		want  natsMsg
+		wrote string
+	}{
+		{"MSG a.b 1 5\r\nhello\r\n", natsMsg{subject: "a.b", payload: []byte("hello")}, ""},
+		{"MSG a.b 1 _INBOX.x 0\r\n\r\n", natsMsg{subject: "a.b", reply: "_INBOX.x", payload: []byte{}}, ""},
+		{"+OK\r\nINFO {}\r\nPONG\r\nPING\r\nMSG a 1 3\r\na\r\n\r\n", natsMsg{subject: "a", payload: []byte("a\r\n")}, "PONG\r\n"},
+		{"HMSG a 1 r 12 14\r\nNATS/1.0\r\n\r\nhi\r\n",
+			natsMsg{subject: "a", reply: "r", header: []byte("NATS/1.0\r\n\r\n"), payload: []byte("hi")}, ""},
+		{"HMSG a 1 16 16\r\nNATS/1.0 404\r\n\r\n\r\n",
+			natsMsg{subject: "a", header: []byte("NATS/1.0 404\r\n\r\n"), payload: []byte{}}, ""},
+	} {
+		nc, sc := natsScript(&natsTarget{}, tc.in)
+		got, err := nc.readMsg()
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+			continue
+		}
+		if got.subject != tc.want.subject || got.reply != tc.want.reply ||
+			!bytes.Equal(got.header, tc.want.header) || !bytes.Equal(got.payload, tc.want.payload) {
+			t.Errorf("%q = %+v, want %+v", tc.in, got, tc.want)
+		}
+		if sc.wrote.String() != tc.wrote {
+			t.Errorf("%q: wrote %q, want %q", tc.in, sc.wrote.String(), tc.wrote)
+		}
+	}
+}
+
+func TestNATSReadMsgErrors(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string
+	}{
+		{"", "EOF"},
+		{"-ERR 'Authorization Violation'\r\n", "Authorization Violation"},
+		{"MSG a 1\r\n", "bad MSG"},
+		{"MSG a 1 r x 3\r\n", "bad MSG"},
+		{"HMSG a 1 3\r\n", "bad HMSG"},
+		{"MSG a 1 x\r\n", "bad MSG size"},
+		{"MSG a 1 -1\r\n", "bad MSG size"},
+		{"MSG a 1 99999999999999999999\r\n", "bad MSG size"},
+		{"MSG a 1 67108865\r\n", "bad MSG size"},
+		{"HMSG a 1 5 3\r\n", "bad HMSG size"},
+		{"HMSG a 1 -2 3\r\n", "bad HMSG size"},
+		{"MSG a 1 5\r\nhi\r\n", "EOF"},
+		{"MSG a 1 2\r\nhello\r\n", "not ended by CRLF"},
+	} {
+		nc, _ := natsScript(&natsTarget{}, tc.in)
+		m, err := nc.readMsg()
+		if err == nil {
+			t.Errorf("%q = %+v, want an error", tc.in, m)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: %v, want %q", tc.in, err, tc.want)
+		}
+	}
+}
+
+func TestNATSStatus(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                                 "",
+		"NATS/1.0\r\n\r\n":                 "",
+		"NATS/1.0 404 No Messages\r\n\r\n": "404",
+		"NATS/1.0 100 Idle Heartbeat\r\n":  "100",
+		"HTTP/1.1 200 OK\r\n":              "",
+	} {
+		if got := natsStatus([]byte(header)); got != want {
+			t.Errorf("natsStatus(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestNATSHandshake(t *testing.T) {
+	nt := &natsTarget{subject: "app.>", queue: "q", user: "u", password: "p"}
+	nc, sc := natsScript(nt, "INFO {\"headers\":true}\r\nPONG\r\n+OK\r\nPONG\r\n")
+	if err := nc.handshake(); err != nil {
+		t.Fatal(err)
+	}
+	wrote := sc.wrote.String()
+	for _, want := range []string{`"user":"u"`, `"pass":"p"`, `"headers":true`, "\r\nPING\r\nSUB app.> q 1\r\nPING\r\n"} {
+		if !strings.Contains(wrote, want) {
+			t.Errorf("wrote %q, want %q in it", wrote, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		nt     natsTarget
+		server string
+		want   string
+	}{
+		{natsTarget{subject: "a"}, "HTTP/1.1 400 Bad Request\r\n", "not a NATS server"},
+		{natsTarget{subject: "a"}, "INFO {\r\n", "INFO"},
+		{natsTarget{subject: "a", token: "t"}, "INFO {}\r\n-ERR 'Authorization Violation'\r\n", "Authorization Violation"},
+		{natsTarget{subject: "a"}, "INFO {}\r\nPONG\r\n-ERR 'Permissions Violation for Subscription to \"a\"'\r\n", "Permissions Violation"},
+		{natsTarget{subject: "a", stream: "s", durable: "d"}, "INFO {}\r\nPONG\r\n", "doesn't support headers"},
+	} {
+		nc, _ := natsScript(&tc.nt, tc.server)
+		err := nc.handshake()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: %v, want %q", tc.server, err, tc.want)
+		}
+	}
+}
+
+// natsStatusMsg is an HMSG with only a status, as JetStream sends to say how a pull is going
+func natsStatusMsg(subject, status string) string {
+	header := "NATS/1.0 " + status + "\r\n\r\n"
+	return fmt.Sprintf("HMSG %s 1 %d %d\r\n%s\r\n", subject, len(header), len(header), header)
+}
+
+func TestNATSPull(t *testing.T) {
+	nt := &natsTarget{subject: "app.logs", stream: "LOGS", durable: "d"}
+	in := "_INBOX.x"
+	nc, sc := natsScript(nt,
+		natsStatusMsg(in+".pull", "100 Idle Heartbeat")+
+			"MSG app.logs 1 $JS.ACK.LOGS.d.1.1.1 3\r\none\r\n"+
+			natsStatusMsg(in+".pull", "408 Request Timeout")+
+			"MSG app.logs 1 $JS.ACK.LOGS.d.1.2.2 3\r\ntwo\r\n"+
+			natsStatusMsg(in+".pull", "409 Consumer Deleted"))
+	nc.inbox = in
+	for _, want := range []string{"one", "two"} {
+		m, err := nc.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(m.payload) != want || m.subject != "app.logs" {
+			t.Errorf("next = %q %q, want %q", m.subject, m.payload, want)
+		}
+	}
+	if _, err := nc.next(); err == nil || !strings.Contains(err.Error(), "409") {
+		t.Errorf("consumer deleted: %v", err)
+	}
+	// a pull at the start, another after the 408, and the ack of "one" with it
+	wrote := sc.wrote.String()
+	if n := strings.Count(wrote, "PUB $JS.API.CONSUMER.MSG.NEXT.LOGS.d "+in+".pull "); n != 2 {
+		t.Errorf("%d pulls in %q, want 2", n, wrote)
+	}
+	if !strings.Contains(wrote, "PUB $JS.ACK.LOGS.d.1.1.1 0\r\n\r\n") {
+		t.Errorf("no ack in %q", wrote)
+	}
+}
+
+func TestParseNATSTarget(t *testing.T) {
+	for _, tc := range []struct {
+		spec, queue, stream, durable string
+		want                         natsTarget
+	}{
+		{"nats://h/a.b", "", "", "", natsTarget{addr: "h:4222", subject: "a.b"}},
+		{"tls://u:p@h:1/a.*", "q", "", "", natsTarget{addr: "h:1", tls: true, user: "u", password: "p", subject: "a.*", queue: "q"}},
+		{"nats://tok@h/a", "", "S", "", natsTarget{addr: "h:4222", token: "tok", subject: "a", stream: "S", durable: "ssample"}},
+	} {
+		nt, err := parseNATSTarget(tc.spec, tc.queue, tc.stream, tc.durable)
+		if err != nil {
+			t.Errorf("%q: %v", tc.spec, err)
+			continue
+		}
+		if *nt != tc.want {
+			t.Errorf("%q = %+v, want %+v", tc.spec, *nt, tc.want)
+		}
+	}
+	for _, tc := range []struct{ spec, queue, stream, durable string }{
+		{"nats://h", "", "", ""},
+		{"http://h/a", "", "", ""},
+		{"nats://h/a", "q", "S", ""},
+		{"nats://h/a", "", "S.x", ""},
+		{"nats://h/a", "", "S", "d d"},
+	} {
+		if _, err := parseNATSTarget(tc.spec, tc.queue, tc.stream, tc.durable); err == nil {
+			t.Errorf("%+v: no error", tc)
+		}
+	}
+}
+
+func FuzzNATSReadMsg(f *testing.F) {
+	for _, s := range []string{
+		"MSG a.b 1 5\r\nhello\r\n",
+		"MSG a 1 r 0\r\n\r\n",
+		"HMSG a 1 r 12 14\r\nNATS/1.0\r\n\r\nhi\r\n",
+		"PING\r\n+OK\r\nMSG a 1 1\r\nx\r\n",
+		"-ERR 'x'\r\n",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		nc, _ := natsScript(&natsTarget{}, s)
+		m, err := nc.readMsg()
+		if err != nil {
+			return
+		}
+		if len(m.header)+len(m.payload) > len(s) {
+			t.Errorf("%q read as %d bytes", s, len(m.header)+len(m.payload))
+		}
+		natsStatus(m.header)
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// response formats
+const (
+	fmtJSON = iota
+	// "{lineNumber}\t{line}\n"
+	fmtText
+	// "{line}\n"
+	fmtPlain
+	fmtTSV
+	fmtCSV
+	// "{line}\0", the exact bytes
+	fmtRaw
+)
+
+var formatContentTypes = []struct {
+	mime   string
+	format int
+}{
+	{"application/json", fmtJSON},
+	{"text/tab-separated-values", fmtTSV},
+	{"text/csv", fmtCSV},
+	{"text/plain", fmtPlain},
+	{"application/octet-stream", fmtRaw},
+	// browsers
+	{"text/html", fmtText},
+}
+
+// negotiateFormat picks a response format from ?t= ?p= or the Accept header, defaulting to json
+func negotiateFormat(r *http.Request) int {
+	if boolish(r.FormValue("p")) {
+		return fmtPlain
+	}
+	if boolish(r.FormValue("t")) {
+		return fmtText
+	}
+	best := fmtJSON
+	bestq := 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mime := strings.TrimSpace(part)
+		q := 1.0
+		if semi := strings.IndexByte(mime, ';'); semi >= 0 {
+			for _, param := range strings.Split(mime[semi+1:], ";") {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					v, err := strconv.ParseFloat(param[2:], 64)
+					if err == nil {
+						q = v
+					}
+				}
+			}
+			mime = strings.TrimSpace(mime[:semi])
+		}
+		if q <= bestq {
+			continue
+		}
+		for _, ct := range formatContentTypes {
+			if strings.EqualFold(mime, ct.mime) {
+				best = ct.format
+				bestq = q
+				break
+			}
+		}
+	}
+	return best
+}
+
+func writeCSV(w http.ResponseWriter, out *LineNoResponse) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"lineNumber", "line"})
+	for i, ln := range out.LineNumbers {
+		cw.Write([]string{strconv.Itoa(ln), out.Lines[i]})
+	}
+	cw.Flush()
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// valueSelector picks a number out of an input line for -stat:
+//
+//	3              third field split on spaces and tabs
+//	json:req.dur   NDJSON value at a dotted path
+//	logfmt:dur     logfmt key
+//	clf:latency    -clf access log latency (or clf:status)
+//	len            line length in bytes
+type valueSelector struct {
+	spec   string
+	field  int
+	json   jsonPath
+	logfmt string
+	clf    string
+	length bool
+}
+
+func parseValueSelector(spec string) (*valueSelector, error) {
+	vs := &valueSelector{spec: spec}
+	kind, arg, hasKind := strings.Cut(spec, ":")
+	switch {
+	case spec == "len":
+		vs.length = true
+	case !hasKind:
+		n, err := strconv.Atoi(spec)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("bad field %q, want a field number from 1, len, json:path, logfmt:key, or clf:latency", spec)
+		}
+		vs.field = n
+	case kind == "json":
+		vs.json = parseJSONPath(arg)
+	case kind == "logfmt":
+		vs.logfmt = arg
+	case kind == "clf" && (arg == "latency" || arg == "status"):
+		vs.clf = arg
+	default:
+		return nil, fmt.Errorf("bad field %q, want a field number from 1, len, json:path, logfmt:key, or clf:latency", spec)
+	}
+	return vs, nil
+}
+
+// value returns the selected number, as seconds if it is a duration like "12ms"
+func (vs *valueSelector) value(line []byte) (float64, bool) {
+	if vs.length {
+		return float64(len(line)), true
+	}
+	return parseNumber(string(vs.raw(line)))
+}
+
+// raw returns the selected text, nil if the line hasn't got it
+func (vs *valueSelector) raw(line []byte) []byte {
+	var raw []byte
+	switch {
+	case vs.length:
+		return strconv.AppendInt(nil, int64(len(line)), 10)
+	case vs.field > 0:
+		fields := splitFields(nil, line, nil)
+		if vs.field > len(fields) {
+			return nil
+		}
+		raw = fields[vs.field-1]
+	case vs.json != nil:
+		var v interface{}
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		if dec.Decode(&v) != nil {
+			return nil
+		}
+		fv, ok := vs.json.lookup(v)
+		if !ok {
+			return nil
+		}
+		switch x := fv.(type) {
+		case json.Number:
+			raw = []byte(x)
+		case string:
+			raw = []byte(x)
+		default:
+			return nil
+		}
+	case vs.logfmt != "":
+		scanLogfmt(line, func(key, value []byte) bool {
+			if string(key) == vs.logfmt {
+				raw = value
+				return false
+			}
+			return true
+		})
+	case vs.clf != "":
+		e, ok := parseCLF(line)
+		if !ok {
+			return nil
+		}
+		raw = e.latency
+		if vs.clf == "status" {
+			raw = e.status
+		}
+	}
+	return raw
+}
+
+// parseNumber parses a float, or a duration as seconds
+func parseNumber(s string) (float64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err == nil {
+		return v, !math.IsNaN(v) && !math.IsInf(v, 0)
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil {
+		return d.Seconds(), true
+	}
+	return 0, false
+}
+
+// FieldStats summarizes a -stat field over all input, not just the sample
+type FieldStats struct {
+	Field string `json:"field"`
+	Count int64  `json:"count"`
+	// lines where the field was missing or not a number
+	NonNumeric int64   `json:"nonNumeric"`
+	Min        float64 `json:"min"`
+	Max        float64 `json:"max"`
+	Mean       float64 `json:"mean"`
+	Stddev     float64 `json:"stddev"`
+	// estimated from a t-digest, by name like "p99"
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+}
+
+// numericStats is the running count, min, max, mean, and variance (Welford's method) of a field
+type numericStats struct {
+	sel *valueSelector
+	// -quantiles to report
+	quantiles []float64
+
+	l          sync.Mutex
+	count      int64
+	nonNumeric int64
+	min, max   float64
+	mean, m2   float64
+	digest     tdigest
+}
+
+func (ns *numericStats) add(line []byte) {
+	v, ok := ns.sel.value(line)
+	ns.l.Lock()
+	defer ns.l.Unlock()
+	if !ok {
+		ns.nonNumeric++
+		return
+	}
+	ns.count++
+	if ns.count == 1 || v < ns.min {
+		ns.min = v
+	}
+	if ns.count == 1 || v > ns.max {
+		ns.max = v
+	}
+	delta := v - ns.mean
+	ns.mean += delta / float64(ns.count)
+	ns.m2 += delta * (v - ns.mean)
+	ns.digest.add(v)
+}
+
+func (ns *numericStats) reset() {
+	ns.l.Lock()
+	defer ns.l.Unlock()
+	ns.count = 0
+	ns.nonNumeric = 0
+	ns.min, ns.max, ns.mean, ns.m2 = 0, 0, 0, 0
+	ns.digest.reset()
+}
+
+func (ns *numericStats) summary() FieldStats {
+	ns.l.Lock()
+	defer ns.l.Unlock()
+	fs := FieldStats{Field: ns.sel.spec, Count: ns.count, NonNumeric: ns.nonNumeric, Min: ns.min, Max: ns.max, Mean: ns.mean}
+	if ns.count > 1 {
+		fs.Stddev = math.Sqrt(ns.m2 / float64(ns.count-1))
+	}
+	if ns.count > 0 && len(ns.quantiles) != 0 {
+		fs.Quantiles = make(map[string]float64, len(ns.quantiles))
+		for _, q := range ns.quantiles {
+			fs.Quantiles[quantileName(q)] = ns.digest.quantile(q, ns.min, ns.max)
+		}
+	}
+	return fs
+}
+
+func (fs FieldStats) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: count %d, min %g, max %g, mean %g, stddev %g", fs.Field, fs.Count, fs.Min, fs.Max, fs.Mean, fs.Stddev)
+	for _, name := range sortedQuantileNames(fs.Quantiles) {
+		fmt.Fprintf(&sb, ", %s %g", name, fs.Quantiles[name])
+	}
+	fmt.Fprintf(&sb, " (%d not numbers)", fs.NonNumeric)
+	return sb.String()
+}
+
+// sortedQuantileNames is the names of quantiles like "p99", lowest first
+func sortedQuantileNames(quantiles map[string]float64) []string {
+	names := make([]string, 0, len(quantiles))
+	for name := range quantiles {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, _ := strconv.ParseFloat(names[i][1:], 64)
+		b, _ := strconv.ParseFloat(names[j][1:], 64)
+		return a < b
+	})
+	return names
+}
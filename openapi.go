@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// openapiDoc builds an OpenAPI 3 document from the route table and the Go response types
+func openapiDoc(routes []route) map[string]interface{} {
+	schemas := make(map[string]interface{})
+	paths := make(map[string]interface{})
+	for _, rt := range routes {
+		method := strings.ToLower(rt.method)
+		if method == "" {
+			method = "get"
+		}
+		content := make(map[string]interface{})
+		if rt.response != nil {
+			content["application/json"] = map[string]interface{}{
+				"schema": schemaFor(reflect.TypeOf(rt.response), schemas),
+			}
+		}
+		for _, ct := range rt.produces {
+			if _, have := content[ct]; !have {
+				content[ct] = map[string]interface{}{}
+			}
+		}
+		okResponse := map[string]interface{}{"description": "OK"}
+		if len(content) != 0 {
+			okResponse["content"] = content
+		}
+		op := map[string]interface{}{
+			"summary":   rt.summary,
+			"responses": map[string]interface{}{"200": okResponse},
+		}
+		if len(rt.params) != 0 {
+			params := make([]interface{}, len(rt.params))
+			for i, p := range rt.params {
+				params[i] = map[string]interface{}{
+					"name":        p.name,
+					"in":          "query",
+					"description": p.desc,
+					"schema":      map[string]interface{}{"type": p.typ},
+				}
+			}
+			op["parameters"] = params
+		}
+		path := rt.path
+		if strings.HasSuffix(path, "/{name}/") {
+			op["parameters"] = append([]interface{}{map[string]interface{}{
+				"name": "name", "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+			}}, paramList(op["parameters"])...)
+		}
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		item[method] = op
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "ssample",
+			"version": "1",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+func paramList(v interface{}) []interface{} {
+	if l, ok := v.([]interface{}); ok {
+		return l
+	}
+	return nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor describes t, adding named structs to schemas and returning a $ref to them
+func schemaFor(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem(), schemas)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		name := t.Name()
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + name}
+		if name != "" {
+			if _, done := schemas[name]; done {
+				return ref
+			}
+			// placeholder for recursive types
+			schemas[name] = map[string]interface{}{}
+		}
+		props := make(map[string]interface{})
+		required := structProps(t, schemas, props, nil)
+		obj := map[string]interface{}{"type": "object", "properties": props}
+		if len(required) != 0 {
+			obj["required"] = required
+		}
+		if name == "" {
+			return obj
+		}
+		schemas[name] = obj
+		return ref
+	}
+	return map[string]interface{}{}
+}
+
+// structProps adds the json fields of t, and of structs embedded in it, to props.
+// It returns required with the names that are not omitempty appended.
+func structProps(t reflect.Type, schemas map[string]interface{}, props map[string]interface{}, required []string) []string {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			required = structProps(f.Type, schemas, props, required)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		fname := parts[0]
+		if fname == "" {
+			fname = f.Name
+		}
+		omit := false
+		for _, opt := range parts[1:] {
+			if opt == "omitempty" {
+				omit = true
+			}
+		}
+		props[fname] = schemaFor(f.Type, schemas)
+		if !omit {
+			required = append(required, fname)
+		}
+	}
+	return required
+}
+
+// openapiHandler serves /v1/openapi.json
+func openapiHandler(routes []route) http.Handler {
+	blob, err := json.MarshalIndent(openapiDoc(routes), "", "  ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(blob)
+	})
+}
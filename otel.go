@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// otel is the -otlp exporter, nil without -otlp
+var otel *otlpExporter
+
+// http requests, for -otlp metrics
+var (
+	httpRequests       uint64
+	httpRequestErrors  uint64
+	httpRequestNanos   uint64
+	httpRequestsActive int64
+)
+
+// otlpExporter sends metrics and spans to an OpenTelemetry collector as OTLP/HTTP JSON
+type otlpExporter struct {
+	endpoint string
+	headers  http.Header
+	client   *http.Client
+	resource otlpResource
+	start    time.Time
+
+	c    *Collector
+	tee  *teeWriter
+	rate *rateMeter
+
+	l     sync.Mutex
+	spans []otlpSpan
+	// spans that didn't fit while the collector was unreachable
+	dropped int
+}
+
+// otlpMaxSpans is as many spans as are held between exports
+const otlpMaxSpans = 2048
+
+type otlpKV struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func otlpString(key, value string) otlpKV {
+	return otlpKV{Key: key, Value: map[string]interface{}{"stringValue": value}}
+}
+
+func otlpInt(key string, value int64) otlpKV {
+	// 64 bit ints are strings in OTLP JSON
+	return otlpKV{Key: key, Value: map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}}
+}
+
+type otlpResource struct {
+	Attributes []otlpKV `json:"attributes"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpDataPoint struct {
+	StartTimeUnixNano string   `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string   `json:"timeUnixNano"`
+	AsInt             string   `json:"asInt,omitempty"`
+	AsDouble          *float64 `json:"asDouble,omitempty"`
+}
+
+type otlpSum struct {
+	// 2, cumulative
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpMetric struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Unit        string     `json:"unit"`
+	Sum         *otlpSum   `json:"sum,omitempty"`
+	Gauge       *otlpGauge `json:"gauge,omitempty"`
+}
+
+// span kinds and status codes
+const (
+	spanInternal = 1
+	spanServer   = 2
+	spanClient   = 3
+
+	spanStatusError = 2
+)
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpKV   `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// newOTLPExporter sends to endpoint, like http://localhost:4318, with headers "key=value" and
+// resource attributes from $OTEL_SERVICE_NAME and $OTEL_RESOURCE_ATTRIBUTES
+func newOTLPExporter(c *Collector, tee *teeWriter, endpoint string, headers []string) (*otlpExporter, error) {
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("endpoint %q: want http:// or https://", endpoint)
+	}
+	exp := &otlpExporter{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		headers:  make(http.Header),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		start:    time.Now(),
+		c:        c,
+		tee:      tee,
+		rate:     newRateMeter(c.Seen, 60),
+	}
+	for _, h := range headers {
+		for _, kv := range strings.Split(h, ",") {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				return nil, fmt.Errorf("header %q: want key=value", kv)
+			}
+			exp.headers.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+		}
+	}
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "ssample"
+	}
+	hostname, _ := os.Hostname()
+	exp.resource.Attributes = []otlpKV{
+		otlpString("service.name", service),
+		otlpString("host.name", hostname),
+		otlpInt("process.pid", int64(os.Getpid())),
+	}
+	if attrs := os.Getenv("OTEL_RESOURCE_ATTRIBUTES"); attrs != "" {
+		for _, kv := range strings.Split(attrs, ",") {
+			if k, v, ok := strings.Cut(kv, "="); ok && k != "service.name" {
+				exp.resource.Attributes = append(exp.resource.Attributes, otlpString(strings.TrimSpace(k), strings.TrimSpace(v)))
+			}
+		}
+	}
+	return exp, nil
+}
+
+// run exports every interval until the process exits
+func (exp *otlpExporter) run(every time.Duration) {
+	go exp.rate.run()
+	for range time.Tick(every) {
+		err := exp.export()
+		if err != nil {
+			warnf("otlp: %v", err)
+		}
+	}
+}
+
+// export sends the metrics and any spans since the last export
+func (exp *otlpExporter) export() error {
+	err := exp.exportMetrics()
+	if serr := exp.exportSpans(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+func (exp *otlpExporter) exportMetrics() error {
+	now := unixNano(time.Now())
+	start := unixNano(exp.start)
+	var metrics []otlpMetric
+	counter := func(name, unit, description string, v int64) {
+		metrics = append(metrics, otlpMetric{Name: name, Unit: unit, Description: description, Sum: &otlpSum{
+			AggregationTemporality: 2, IsMonotonic: true,
+			DataPoints: []otlpDataPoint{{StartTimeUnixNano: start, TimeUnixNano: now, AsInt: strconv.FormatInt(v, 10)}},
+		}})
+	}
+	gauge := func(name, unit, description string, v float64) {
+		metrics = append(metrics, otlpMetric{Name: name, Unit: unit, Description: description, Gauge: &otlpGauge{
+			DataPoints: []otlpDataPoint{{TimeUnixNano: now, AsDouble: &v}},
+		}})
+	}
+	st := exp.c.Stats()
+	counter("ssample.lines", "{line}", "Input lines seen.", int64(st.LinesSeen))
+	counter("ssample.bytes", "By", "Input bytes seen, including newlines.", st.BytesSeen)
+	counter("ssample.evictions", "{line}", "Sampled lines replaced by a newer line.", int64(st.Evictions))
+	counter("ssample.lines.filtered", "{line}", "Input lines dropped by -match, -exclude, and the other filters.", int64(atomic.LoadUint64(&linesFiltered)))
+	counter("ssample.lines.truncated", "{line}", "Input lines cut to -max-line-bytes.", int64(atomic.LoadUint64(&linesTruncated)))
+	counter("ssample.lines.skipped_long", "{line}", "Input lines longer than -max-line-bytes left out by -long-lines skip.", int64(atomic.LoadUint64(&linesSkippedLong)))
+	gauge("ssample.reservoir.size", "{line}", "Lines currently held in the sample.", float64(st.Kept))
+	gauge("ssample.reservoir.capacity", "{line}", "Maximum lines held in the sample.", float64(st.Capacity))
+	gauge("ssample.lines.rate", "{line}/s", "Input rate over the last minute.", exp.rate.Rate())
+	if exp.tee != nil {
+		counter("ssample.tee.bytes", "By", "Bytes written to the -a/-teez file.", int64(atomic.LoadUint64(&teeBytes)))
+		counter("ssample.tee.write_errors", "{write}", "Failed writes to the -a/-teez file.", int64(atomic.LoadUint64(&teeWriteErrors)))
+		counter("ssample.tee.dropped", "{line}", "Lines left out of the -a/-teez file by -tee-policy drop.", int64(atomic.LoadUint64(&teeDropped)))
+		gauge("ssample.tee.queued", "By", "Bytes of lines queued for the -a/-teez file.", float64(exp.tee.Queued()))
+	}
+	counter("http.server.requests", "{request}", "HTTP requests served.", int64(atomic.LoadUint64(&httpRequests)))
+	counter("http.server.errors", "{request}", "HTTP requests answered with a 5xx status.", int64(atomic.LoadUint64(&httpRequestErrors)))
+	counter("http.server.request.time", "ms", "Total time spent serving HTTP requests.", int64(atomic.LoadUint64(&httpRequestNanos)/1e6))
+	gauge("http.server.active_requests", "{request}", "HTTP requests being served.", float64(atomic.LoadInt64(&httpRequestsActive)))
+	body := map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource":     exp.resource,
+			"scopeMetrics": []interface{}{map[string]interface{}{"scope": otlpScope{Name: "ssample"}, "metrics": metrics}},
+		}},
+	}
+	return exp.post("/v1/metrics", body)
+}
+
+func (exp *otlpExporter) exportSpans() error {
+	exp.l.Lock()
+	spans := exp.spans
+	exp.spans = nil
+	dropped := exp.dropped
+	exp.dropped = 0
+	exp.l.Unlock()
+	if dropped != 0 {
+		warnf("otlp: %d spans dropped", dropped)
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+	body := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource":   exp.resource,
+			"scopeSpans": []interface{}{map[string]interface{}{"scope": otlpScope{Name: "ssample"}, "spans": spans}},
+		}},
+	}
+	return exp.post("/v1/traces", body)
+}
+
+func (exp *otlpExporter) post(path string, body interface{}) error {
+	blob, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, exp.endpoint+path, bytes.NewReader(blob))
+	if err != nil {
+		return err
+	}
+	req.Header = exp.headers.Clone()
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := exp.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1000))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s: %s", path, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// traceContext is a W3C traceparent's ids, hex
+type traceContext struct {
+	traceID string
+	spanID  string
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	for i := 0; i < n; i += 8 {
+		v := rand.Uint64()
+		for j := i; j < n && j < i+8; j++ {
+			b[j] = byte(v >> (8 * (j - i)))
+		}
+	}
+	return hex.EncodeToString(b)
+}
+
+// parseTraceparent reads "00-{trace id}-{parent id}-{flags}"
+func parseTraceparent(h string) (traceContext, bool) {
+	parts := strings.Split(h, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return traceContext{}, false
+	}
+	if _, err := hex.DecodeString(parts[1] + parts[2]); err != nil || strings.Trim(parts[1], "0") == "" {
+		return traceContext{}, false
+	}
+	return traceContext{traceID: parts[1], spanID: parts[2]}, true
+}
+
+// span records a finished span, in a new trace unless parent has one. Nil-safe, for when -otlp is off.
+func (exp *otlpExporter) span(name string, kind int, parent traceContext, start time.Time, attrs []otlpKV, err error) {
+	if exp == nil {
+		return
+	}
+	s := otlpSpan{
+		TraceID:           parent.traceID,
+		SpanID:            randomID(8),
+		ParentSpanID:      parent.spanID,
+		Name:              name,
+		Kind:              kind,
+		StartTimeUnixNano: unixNano(start),
+		EndTimeUnixNano:   unixNano(time.Now()),
+		Attributes:        attrs,
+	}
+	if s.TraceID == "" {
+		s.TraceID = randomID(16)
+	}
+	if err != nil {
+		s.Status = otlpStatus{Code: spanStatusError, Message: err.Error()}
+	}
+	exp.l.Lock()
+	defer exp.l.Unlock()
+	if len(exp.spans) >= otlpMaxSpans {
+		exp.dropped++
+		return
+	}
+	exp.spans = append(exp.spans, s)
+}
+
+// otlpTracing counts http requests for -otlp metrics and records a server span for each,
+// continuing the client's trace from a traceparent header
+type otlpTracing struct {
+	next http.Handler
+	exp  *otlpExporter
+}
+
+func (ot *otlpTracing) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	atomic.AddInt64(&httpRequestsActive, 1)
+	sr := &statusRecorder{ResponseWriter: w}
+	ot.next.ServeHTTP(sr, r)
+	atomic.AddInt64(&httpRequestsActive, -1)
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	atomic.AddUint64(&httpRequests, 1)
+	atomic.AddUint64(&httpRequestNanos, uint64(time.Since(start)))
+	var err error
+	if sr.status >= 500 {
+		atomic.AddUint64(&httpRequestErrors, 1)
+		err = fmt.Errorf("%d %s", sr.status, http.StatusText(sr.status))
+	}
+	parent, _ := parseTraceparent(r.Header.Get("traceparent"))
+	ot.exp.span(r.Method+" "+r.URL.Path, spanServer, parent, start, []otlpKV{
+		otlpString("http.request.method", r.Method),
+		otlpString("url.path", r.URL.Path),
+		otlpString("client.address", clientIP(r)),
+		otlpInt("http.response.status_code", int64(sr.status)),
+		otlpInt("http.response.body.size", sr.size),
+	}, err)
+}
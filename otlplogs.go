@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// otlpMaxLogRecords is as many log records as go in one request
+const otlpMaxLogRecords = 1000
+
+type otlpLogRecord struct {
+	TimeUnixNano         string                 `json:"timeUnixNano"`
+	ObservedTimeUnixNano string                 `json:"observedTimeUnixNano"`
+	Body                 map[string]interface{} `json:"body"`
+	Attributes           []otlpKV               `json:"attributes"`
+}
+
+// otlpLogShipper is -otlp-logs: lines in the sample are sent as OTLP log records once each.
+// A line replaced before the next export is never sent, so what arrives is a uniform sample of the input.
+type otlpLogShipper struct {
+	exp *otlpExporter
+	// the next line number to send, and seen at the last export, to notice a reset
+	next     int
+	lastSeen int
+	l        sync.Mutex
+}
+
+// run exports every interval until the process exits
+func (ls *otlpLogShipper) run(every time.Duration) {
+	for range time.Tick(every) {
+		n, err := ls.export()
+		if err != nil {
+			warnf("otlp logs: %v", err)
+		} else if n != 0 {
+			debugf("otlp logs: sent %d lines", n)
+		}
+	}
+}
+
+// export sends the sampled lines added since the last export, returning how many
+func (ls *otlpLogShipper) export() (int, error) {
+	ls.l.Lock()
+	defer ls.l.Unlock()
+	c := ls.exp.c
+	records, st, _ := c.Records()
+	if st.LinesSeen < ls.lastSeen {
+		// Reset
+		ls.next = 0
+	}
+	ls.lastSeen = st.LinesSeen
+	// records are sorted by line number, and lines are numbered as they're seen
+	for len(records) > 0 && records[0].LineNumber < ls.next {
+		records = records[1:]
+	}
+	sent := 0
+	for len(records) > 0 {
+		batch := records[:min(len(records), otlpMaxLogRecords)]
+		logs := make([]otlpLogRecord, len(batch))
+		for i, rec := range batch {
+			source := rec.Source
+			if source == "" {
+				source = c.Source
+			}
+			ts := unixNano(rec.Time)
+			logs[i] = otlpLogRecord{
+				TimeUnixNano:         ts,
+				ObservedTimeUnixNano: ts,
+				Body:                 map[string]interface{}{"stringValue": rec.Line},
+				Attributes: []otlpKV{
+					otlpString("log.source", source),
+					otlpInt("ssample.line_number", int64(rec.LineNumber)),
+					{Key: "ssample.weight", Value: map[string]interface{}{"doubleValue": rec.Weight}},
+				},
+			}
+		}
+		body := map[string]interface{}{
+			"resourceLogs": []interface{}{map[string]interface{}{
+				"resource":  ls.exp.resource,
+				"scopeLogs": []interface{}{map[string]interface{}{"scope": otlpScope{Name: "ssample"}, "logRecords": logs}},
+			}},
+		}
+		err := ls.exp.post("/v1/logs", body)
+		if err != nil {
+			// the unsent lines are tried again next time, if they're still in the sample
+			return sent, err
+		}
+		sent += len(batch)
+		ls.next = batch[len(batch)-1].LineNumber + 1
+		records = records[len(batch):]
+	}
+	ls.next = max(ls.next, st.LinesSeen)
+	return sent, nil
+}
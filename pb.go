@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+)
+
+// Minimal protobuf wire format for the messages in ssample.proto
+
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+type pbBuf []byte
+
+func (b *pbBuf) tag(field, wire int) {
+	*b = binary.AppendUvarint(*b, uint64(field)<<3|uint64(wire))
+}
+
+func (b *pbBuf) int64(field int, v int64) {
+	if v == 0 {
+		return
+	}
+	b.tag(field, pbVarint)
+	*b = binary.AppendUvarint(*b, uint64(v))
+}
+
+func (b *pbBuf) bool(field int, v bool) {
+	if v {
+		b.tag(field, pbVarint)
+		*b = append(*b, 1)
+	}
+}
+
+func (b *pbBuf) double(field int, v float64) {
+	if v == 0 {
+		return
+	}
+	b.tag(field, pbFixed64)
+	*b = binary.LittleEndian.AppendUint64(*b, math.Float64bits(v))
+}
+
+func (b *pbBuf) bytes(field int, v []byte) {
+	b.tag(field, pbBytes)
+	*b = binary.AppendUvarint(*b, uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *pbBuf) string(field int, v string) {
+	if v == "" {
+		return
+	}
+	b.tag(field, pbBytes)
+	*b = binary.AppendUvarint(*b, uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+var errPbTruncated = errors.New("protobuf: truncated message")
+
+// pbFields calls fn for each field of msg. For varint and fixed fields v is the value,
+// for length delimited fields data is the contents. Unknown fields can just be ignored.
+func pbFields(msg []byte, fn func(field, wire int, v uint64, data []byte) error) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errPbTruncated
+		}
+		msg = msg[n:]
+		field := int(key >> 3)
+		wire := int(key & 7)
+		if field == 0 {
+			return errors.New("protobuf: field number 0")
+		}
+		var v uint64
+		var data []byte
+		switch wire {
+		case pbVarint:
+			v, n = binary.Uvarint(msg)
+			if n <= 0 {
+				return errPbTruncated
+			}
+			msg = msg[n:]
+		case pbFixed64:
+			if len(msg) < 8 {
+				return errPbTruncated
+			}
+			v = binary.LittleEndian.Uint64(msg)
+			msg = msg[8:]
+		case pbFixed32:
+			if len(msg) < 4 {
+				return errPbTruncated
+			}
+			v = uint64(binary.LittleEndian.Uint32(msg))
+			msg = msg[4:]
+		case pbBytes:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return errPbTruncated
+			}
+			data = msg[n : n+int(l)]
+			msg = msg[n+int(l):]
+		default:
+			return errors.New("protobuf: unsupported wire type")
+		}
+		if err := fn(field, wire, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pbCollector decodes the "string collector = 1" that every request but Ingest consists of
+func pbCollector(msg []byte) (collector string, err error) {
+	err = pbFields(msg, func(field, wire int, v uint64, data []byte) error {
+		if field == 1 && wire == pbBytes {
+			collector = string(data)
+		}
+		return nil
+	})
+	return
+}
+
+func pbIngestRequest(msg []byte) (collector string, lines []string, err error) {
+	err = pbFields(msg, func(field, wire int, v uint64, data []byte) error {
+		if wire != pbBytes {
+			return nil
+		}
+		switch field {
+		case 1:
+			collector = string(data)
+		case 2:
+			lines = append(lines, string(data))
+		}
+		return nil
+	})
+	return
+}
+
+func pbSample(records []SampleRecord, st CollectorStats, capacity int) []byte {
+	var b pbBuf
+	b.int64(1, int64(st.LinesSeen))
+	b.int64(2, st.BytesSeen)
+	b.int64(3, int64(capacity))
+	var lb pbBuf
+	for _, rec := range records {
+		lb = lb[:0]
+		lb.int64(1, int64(rec.LineNumber))
+		lb.string(2, rec.Line)
+		if !rec.Time.IsZero() {
+			lb.int64(3, rec.Time.UnixNano())
+		}
+		lb.string(4, rec.Source)
+		lb.double(5, rec.Weight)
+		if rec.LineBase64 != nil {
+			lb.bytes(6, rec.LineBase64)
+		}
+		lb.int64(7, int64(rec.Bytes))
+		b.bytes(4, lb)
+	}
+	return b
+}
+
+func pbChange(ch ReservoirChange, lost bool) []byte {
+	var b pbBuf
+	b.int64(1, int64(ch.LineNumber))
+	b.string(2, strings.ToValidUTF8(ch.Line, "\uFFFD"))
+	b.int64(3, int64(ch.Evicted))
+	b.bool(4, ch.Reset)
+	b.bool(5, lost)
+	return b
+}
+
+func pbIngestResponse(added int) []byte {
+	var b pbBuf
+	b.int64(1, int64(added))
+	return b
+}
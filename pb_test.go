@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type pbField struct {
+	field, wire int
+	v           uint64
+	data        string
+}
+
+func pbDecode(msg []byte) ([]pbField, error) {
+	var fields []pbField
+	err := pbFields(msg, func(field, wire int, v uint64, data []byte) error {
+		fields = append(fields, pbField{field, wire, v, string(data)})
+		return nil
+	})
+	return fields, err
+}
+
+func TestPbFields(t *testing.T) {
+	var b pbBuf
+	b.int64(1, 150)
+	b.int64(2, -1)
+	b.int64(3, 0)
+	b.bool(4, true)
+	b.bool(5, false)
+	b.double(6, 1.5)
+	b.string(7, "testing")
+	b.string(8, "")
+	b.bytes(9, nil)
+	b.tag(10, pbFixed32)
+	b = binary.LittleEndian.AppendUint32(b, 7)
+	b.int64(1000, 1)
+	if !bytes.HasPrefix(b, []byte{0x08, 0x96, 0x01}) {
+		t.Errorf("150 as field 1 encoded as % x, want 08 96 01", b[:3])
+	}
+	got, err := pbDecode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []pbField{
+		{1, pbVarint, 150, ""},
+		{2, pbVarint, math.MaxUint64, ""},
+		{4, pbVarint, 1, ""},
+		{6, pbFixed64, math.Float64bits(1.5), ""},
+		{7, pbBytes, 0, "testing"},
+		{9, pbBytes, 0, ""},
+		{10, pbFixed32, 7, ""},
+		{1000, pbVarint, 1, ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %+v\nwant %+v", got, want)
+	}
+
+	for _, tc := range []struct {
+		name string
+		msg  []byte
+		want string
+	}{
+		{"truncated key", []byte{0x80}, "truncated"},
+		{"truncated varint", []byte{0x08, 0x96}, "truncated"},
+		{"varint over 64 bits", append([]byte{0x08}, bytes.Repeat([]byte{0xff}, 10)...), "truncated"},
+		{"truncated fixed64", []byte{0x09, 1, 2, 3}, "truncated"},
+		{"truncated fixed32", []byte{0x0d, 1, 2, 3}, "truncated"},
+		{"truncated length", []byte{0x0a, 0x80}, "truncated"},
+		{"length past the end", []byte{0x0a, 0x05, 'a'}, "truncated"},
+		{"huge length", []byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, "truncated"},
+		{"start group", []byte{0x0b}, "unsupported wire type"},
+		{"wire type 7", []byte{0x0f}, "unsupported wire type"},
+		{"field 0", []byte{0x00, 0x01}, "field number 0"},
+	} {
+		if _, err := pbDecode(tc.msg); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want %q", tc.name, err, tc.want)
+		}
+	}
+
+	stop := errors.New("stop")
+	if err := pbFields(b, func(int, int, uint64, []byte) error { return stop }); err != stop {
+		t.Errorf("callback error: %v", err)
+	}
+}
+
+func TestPbRequests(t *testing.T) {
+	var b pbBuf
+	b.string(1, "web")
+	b.string(2, "one")
+	b.int64(3, 9) // unknown, skipped
+	b.string(2, "two")
+	b.bytes(2, nil)
+	b.int64(1, 5) // collector, but not as a string
+	name, lines, err := pbIngestRequest(b)
+	if err != nil || name != "web" || !reflect.DeepEqual(lines, []string{"one", "two", ""}) {
+		t.Errorf("pbIngestRequest = %q %q, %v", name, lines, err)
+	}
+	name, err = pbCollector(b)
+	if err != nil || name != "web" {
+		t.Errorf("pbCollector = %q, %v", name, err)
+	}
+	if name, err := pbCollector(nil); err != nil || name != "" {
+		t.Errorf("pbCollector of nothing = %q, %v", name, err)
+	}
+	if _, _, err := pbIngestRequest([]byte{0x12, 0x09, 'x'}); err == nil {
+		t.Errorf("pbIngestRequest of a truncated line: no error")
+	}
+}
+
+func TestPbResponses(t *testing.T) {
+	at := time.Unix(1700000000, 5)
+	records := []SampleRecord{
+		{LineNumber: 3, Line: "hello", Time: at, Source: "a.log", Weight: 2, Bytes: 5},
+		{LineNumber: 9, Line: "bad �", LineBase64: []byte("bad \xff"), Bytes: 5},
+	}
+	msg := pbSample(records, CollectorStats{LinesSeen: 10, BytesSeen: 60}, 2)
+	fields, err := pbDecode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 5 || fields[0] != (pbField{1, pbVarint, 10, ""}) || fields[1] != (pbField{2, pbVarint, 60, ""}) || fields[2] != (pbField{3, pbVarint, 2, ""}) {
+		t.Fatalf("sample fields %+v", fields)
+	}
+	rec, err := pbDecode([]byte(fields[3].data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []pbField{
+		{1, pbVarint, 3, ""},
+		{2, pbBytes, 0, "hello"},
+		{3, pbVarint, uint64(at.UnixNano()), ""},
+		{4, pbBytes, 0, "a.log"},
+		{5, pbFixed64, math.Float64bits(2), ""},
+		{7, pbVarint, 5, ""},
+	}
+	if !reflect.DeepEqual(rec, want) {
+		t.Errorf("record %+v\nwant %+v", rec, want)
+	}
+	rec, _ = pbDecode([]byte(fields[4].data))
+	if len(rec) != 4 || rec[2] != (pbField{6, pbBytes, 0, "bad \xff"}) {
+		t.Errorf("record with lineBase64 %+v", rec)
+	}
+
+	fields, _ = pbDecode(pbChange(ReservoirChange{LineNumber: 4, Line: "x\xff", Evicted: 2, Reset: true}, true))
+	want = []pbField{{1, pbVarint, 4, ""}, {2, pbBytes, 0, "x�"}, {3, pbVarint, 2, ""}, {4, pbVarint, 1, ""}, {5, pbVarint, 1, ""}}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("change %+v\nwant %+v", fields, want)
+	}
+	if got := pbIngestResponse(0); len(got) != 0 {
+		t.Errorf("empty IngestResponse encoded as % x", got)
+	}
+}
+
+// grpcFrame is msg as a length prefixed gRPC message, gzipped if flag is 1
+func grpcFrame(flag byte, msg []byte) []byte {
+	if flag == 1 {
+		var z bytes.Buffer
+		zw := gzip.NewWriter(&z)
+		zw.Write(msg)
+		zw.Close()
+		msg = z.Bytes()
+	}
+	return append(binary.BigEndian.AppendUint32([]byte{flag}, uint32(len(msg))), msg...)
+}
+
+func TestReadGrpcMessage(t *testing.T) {
+	two := append(grpcFrame(0, []byte("one")), grpcFrame(1, []byte("two"))...)
+	r := bytes.NewReader(two)
+	for _, want := range []string{"one", "two"} {
+		msg, err := readGrpcMessage(r, "gzip")
+		if err != nil || string(msg) != want {
+			t.Errorf("read %q, %v, want %q", msg, err, want)
+		}
+	}
+	if _, err := readGrpcMessage(r, "gzip"); err != io.EOF {
+		t.Errorf("at the end: %v, want io.EOF", err)
+	}
+
+	big := make([]byte, grpcMaxMessage+1)
+	for _, tc := range []struct {
+		name     string
+		in       []byte
+		encoding string
+		code     int
+	}{
+		{"truncated header", []byte{0, 0, 0}, "", grpcInvalidArgument},
+		{"truncated message", grpcFrame(0, []byte("abc"))[:7], "", grpcInvalidArgument},
+		{"bad flags", grpcFrame(2, []byte("abc")), "", grpcInvalidArgument},
+		{"too big", []byte{0, 0xff, 0xff, 0xff, 0xff}, "", grpcResourceExhausted},
+		{"compressed without an encoding", grpcFrame(1, []byte("abc")), "", grpcUnimplemented},
+		{"compressed with another encoding", grpcFrame(1, []byte("abc")), "snappy", grpcUnimplemented},
+		{"not gzip", append(binary.BigEndian.AppendUint32([]byte{1}, 3), "abc"...), "gzip", grpcInternal},
+		{"gzip of too much", grpcFrame(1, big), "gzip", grpcResourceExhausted},
+		{"truncated gzip", func() []byte {
+			f := grpcFrame(1, []byte("hello hello"))
+			f = f[:len(f)-4]
+			binary.BigEndian.PutUint32(f[1:], uint32(len(f)-5))
+			return f
+		}(), "gzip", grpcResourceExhausted},
+	} {
+		_, err := readGrpcMessage(bytes.NewReader(tc.in), tc.encoding)
+		var ge *grpcError
+		if !errors.As(err, &ge) || ge.code != tc.code {
+			t.Errorf("%s: %v, want code %d", tc.name, err, tc.code)
+		}
+	}
+}
+
+func grpcCall(gs *grpcServer, method string, body []byte) (*http.Response, []byte) {
+	req := httptest.NewRequest("POST", "/ssample.Sampler/"+method, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/grpc")
+	w := httptest.NewRecorder()
+	gs.ServeHTTP(w, req)
+	resp := w.Result()
+	out, _ := io.ReadAll(resp.Body)
+	return resp, out
+}
+
+func TestGrpcServer(t *testing.T) {
+	stdin := NewCollector(10, "")
+	cs := newCollectorSet("")
+	web := cs.add("web", 10)
+	quota, err := newInputThrottle("2/h", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := &grpcServer{stdin: stdin, collectors: cs, tenants: []*tenant{{name: "web", c: web, quota: quota}}}
+
+	var req pbBuf
+	req.string(2, "a")
+	req.string(2, "b")
+	resp, out := grpcCall(gs, "Ingest", grpcFrame(0, req))
+	if resp.Trailer.Get("Grpc-Status") != "0" || !bytes.Equal(out, grpcFrame(0, pbIngestResponse(2))) {
+		t.Errorf("Ingest: status %s %q, body % x", resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message"), out)
+	}
+	var get pbBuf
+	_, out = grpcCall(gs, "GetSample", grpcFrame(0, get))
+	msg, err := readGrpcMessage(bytes.NewReader(out), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields, _ := pbDecode(msg); len(fields) != 5 || fields[0].v != 2 {
+		t.Errorf("GetSample: %+v", fields)
+	}
+
+	// the tenant's quota of 2 lines
+	req = req[:0]
+	req.string(1, "web")
+	for _, line := range []string{"1", "2", "3"} {
+		req.string(2, line)
+	}
+	resp, _ = grpcCall(gs, "Ingest", grpcFrame(0, req))
+	if resp.Trailer.Get("Grpc-Status") != "8" || !strings.Contains(resp.Trailer.Get("Grpc-Message"), "added%202%20lines") {
+		t.Errorf("Ingest over quota: status %s %q", resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message"))
+	}
+	if _, st, _ := web.Records(); st.LinesSeen != 2 {
+		t.Errorf("web saw %d lines, want 2", st.LinesSeen)
+	}
+
+	for _, tc := range []struct {
+		method string
+		body   []byte
+		status string
+	}{
+		{"GetSample", nil, "3"},
+		{"GetSample", grpcFrame(0, []byte{0x0a, 0x04, 'n', 'o', 'p', 'e'}), "5"},
+		{"GetSample", grpcFrame(0, []byte{0x0a, 0x09}), "3"},
+		{"Ingest", grpcFrame(0, []byte{0x0f}), "3"},
+		{"Ingest", []byte{0, 0, 0}, "3"},
+		{"Nope", nil, "12"},
+	} {
+		resp, _ := grpcCall(gs, tc.method, tc.body)
+		if got := resp.Trailer.Get("Grpc-Status"); got != tc.status {
+			t.Errorf("%s % x: status %s %q, want %s", tc.method, tc.body, got, resp.Trailer.Get("Grpc-Message"), tc.status)
+		}
+	}
+
+	gs.readOnly = true
+	if resp, _ := grpcCall(gs, "Ingest", grpcFrame(0, nil)); resp.Trailer.Get("Grpc-Status") != "7" {
+		t.Errorf("read only Ingest: status %s", resp.Trailer.Get("Grpc-Status"))
+	}
+	w := httptest.NewRecorder()
+	gs.ServeHTTP(w, httptest.NewRequest("GET", "/ssample.Sampler/GetSample", nil))
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("GET: %d", w.Code)
+	}
+}
+
+func FuzzPbIngestRequest(f *testing.F) {
+	var b pbBuf
+	b.string(1, "web")
+	b.string(2, "line")
+	b.double(3, 1)
+	b.tag(4, pbFixed32)
+	b = append(b, 1, 2, 3, 4)
+	f.Add([]byte(b))
+	f.Add([]byte{0x12, 0x80, 0x01})
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		name, lines, err := pbIngestRequest(msg)
+		if err != nil {
+			return
+		}
+		// what was read encodes back to no more than it came from
+		var out pbBuf
+		out.string(1, name)
+		for _, line := range lines {
+			out.bytes(2, []byte(line))
+		}
+		if len(out) > len(msg) {
+			t.Errorf("% x decoded as %q %q, %d bytes encoded", msg, name, lines, len(out))
+		}
+		again, relines, err := pbIngestRequest(out)
+		if err != nil || again != name || len(relines) != len(lines) {
+			t.Errorf("% x: round trip %q %q, %v", msg, again, relines, err)
+		}
+		pbCollector(msg)
+	})
+}
+
+func FuzzReadGrpcMessage(f *testing.F) {
+	f.Add(grpcFrame(0, []byte("abc")))
+	f.Add(grpcFrame(1, []byte("abc")))
+	f.Add([]byte{0, 0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, in []byte) {
+		r := bytes.NewReader(in)
+		for {
+			msg, err := readGrpcMessage(r, "gzip")
+			if err != nil {
+				return
+			}
+			if len(msg) > grpcMaxMessage {
+				t.Fatalf("read %d bytes", len(msg))
+			}
+		}
+	})
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func pgScript(server []byte) (*pgConn, *scriptConn) {
+	sc := &scriptConn{}
+	return &pgConn{c: sc, r: bufio.NewReader(bytes.NewReader(server))}, sc
+}
+
+// pgMsg is a backend message as a server sends it
+func pgMsg(typ byte, body []byte) []byte {
+	conn, sc := pgScript(nil)
+	conn.send(typ, body)
+	return sc.wrote.Bytes()
+}
+
+func pgAuth(code uint32, rest ...byte) []byte {
+	return pgMsg('R', append(binary.BigEndian.AppendUint32(nil, code), rest...))
+}
+
+var pgReady = pgMsg('Z', []byte{'I'})
+
+func TestPGRecv(t *testing.T) {
+	in := bytes.Join([][]byte{pgMsg('S', cstring("a\x00b")), pgMsg('Z', nil), pgMsg('D', bytes.Repeat([]byte{1}, 70000))}, nil)
+	conn, _ := pgScript(in)
+	for _, want := range []struct {
+		typ byte
+		n   int
+	}{{'S', 4}, {'Z', 0}, {'D', 70000}} {
+		typ, body, err := conn.recv()
+		if err != nil || typ != want.typ || len(body) != want.n {
+			t.Errorf("recv = %c %d bytes, %v, want %c %d", typ, len(body), err, want.typ, want.n)
+		}
+	}
+
+	for _, tc := range []struct {
+		in   []byte
+		want string
+	}{
+		{nil, "EOF"},
+		{[]byte{'Z', 0, 0}, "unexpected EOF"},
+		{[]byte{'Z', 0, 0, 0, 3}, "bad message length 3"},
+		{[]byte{'Z', 0xff, 0xff, 0xff, 0xff}, "bad message length"},
+		{[]byte{'Z', 0x04, 0, 0, 1}, "bad message length"},
+		{[]byte{'Z', 0, 0, 0, 9, 'a'}, "unexpected EOF"},
+	} {
+		conn, _ := pgScript(tc.in)
+		_, _, err := conn.recv()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("% x: %v, want %q", tc.in, err, tc.want)
+		}
+	}
+}
+
+func TestPGParseError(t *testing.T) {
+	for _, tc := range []struct {
+		body string
+		want pgError
+	}{
+		{"SERROR\x00VERROR\x00C42P01\x00Mrelation \"x\" does not exist\x00P15\x00\x00", pgError{"ERROR", "42P01", `relation "x" does not exist`}},
+		{"SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00", pgError{"FATAL", "28P01", "password authentication failed"}},
+		{"", pgError{}},
+		{"\x00", pgError{}},
+		{"Mno terminator", pgError{message: "no terminator"}},
+		{"M", pgError{}},
+	} {
+		if got := parseError([]byte(tc.body)); *got != tc.want {
+			t.Errorf("parseError(%q) = %+v, want %+v", tc.body, *got, tc.want)
+		}
+	}
+}
+
+func TestPGStartup(t *testing.T) {
+	pc := &pgConfig{user: "alice", password: "secret", database: "logs"}
+	startup := []byte{0, 3, 0, 0}
+	for _, s := range []string{"user", "alice", "database", "logs", "application_name", "ssample", "client_encoding", "UTF8", ""} {
+		startup = append(startup, cstring(s)...)
+	}
+	startupMsg := pgMsg(0, startup)
+	for _, tc := range []struct {
+		name   string
+		server [][]byte
+		wrote  [][]byte
+		err    string
+	}{
+		{"trust", [][]byte{pgAuth(0), pgMsg('S', cstring("TimeZone\x00UTC")), pgMsg('K', make([]byte, 8)), pgReady},
+			[][]byte{startupMsg}, ""},
+		{"password", [][]byte{pgAuth(3), pgAuth(0), pgReady},
+			[][]byte{startupMsg, pgMsg('p', cstring("secret"))}, ""},
+		{"md5", [][]byte{pgAuth(5, 1, 2, 3, 4), pgAuth(0), pgReady},
+			[][]byte{startupMsg, pgMsg('p', cstring("md598a0412b9c31436fc53776e863350083"))}, ""},
+		{"bad password", [][]byte{pgAuth(3), pgMsg('E', []byte("SFATAL\x00C28P01\x00Mpassword authentication failed for user \"alice\"\x00\x00"))},
+			nil, "FATAL 28P01: password authentication failed"},
+		{"short md5 salt", [][]byte{pgAuth(5, 1, 2)}, nil, "short md5 salt"},
+		{"short auth", [][]byte{pgMsg('R', []byte{0})}, nil, "short authentication"},
+		{"gss", [][]byte{pgAuth(7)}, nil, "method 7 isn't supported"},
+		{"SASL without SCRAM", [][]byte{pgAuth(10, []byte("SCRAM-SHA-256-PLUS\x00\x00")...)}, nil, "no SASL mechanism"},
+		{"SASL continue first", [][]byte{pgAuth(11, []byte("r=x,s=eA==,i=1")...)}, nil, "without SASL"},
+		{"SASL final first", [][]byte{pgAuth(12, []byte("v=eA==")...)}, nil, "SCRAM signature is wrong"},
+		{"closed", nil, nil, "EOF"},
+	} {
+		conn, sc := pgScript(bytes.Join(tc.server, nil))
+		err := conn.startup(pc)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: %v, want %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if want := bytes.Join(tc.wrote, nil); !bytes.Equal(sc.wrote.Bytes(), want) {
+			t.Errorf("%s: wrote %q, want %q", tc.name, sc.wrote.Bytes(), want)
+		}
+	}
+}
+
+func TestPGQuery(t *testing.T) {
+	conn, sc := pgScript(bytes.Join([][]byte{
+		pgMsg('C', cstring("CREATE TABLE")),
+		pgMsg('E', []byte("SERROR\x00C42601\x00Msyntax error\x00\x00")),
+		pgMsg('E', []byte("SERROR\x00C25P02\x00Mcurrent transaction is aborted\x00\x00")),
+		pgReady,
+		pgMsg('C', cstring("INSERT 0 2")),
+		pgReady,
+	}, nil))
+	err := conn.query("CREATE TABLE t (); INSERT")
+	if pe, ok := err.(*pgError); !ok || pe.code != "42601" {
+		t.Errorf("query = %v, want the first error, 42601", err)
+	}
+	if err := conn.query("INSERT"); err != nil {
+		t.Errorf("query after an error: %v", err)
+	}
+	want := append(pgMsg('Q', cstring("CREATE TABLE t (); INSERT")), pgMsg('Q', cstring("INSERT"))...)
+	if !bytes.Equal(sc.wrote.Bytes(), want) {
+		t.Errorf("wrote %q, want %q", sc.wrote.Bytes(), want)
+	}
+	if err := conn.query("x"); err == nil {
+		t.Errorf("query on a closed connection: no error")
+	}
+}
+
+// scramServer is the server side of SCRAM-SHA-256, for checking scramClient against
+type scramServer struct {
+	salt        []byte
+	iters       int
+	storedKey   [32]byte
+	serverKey   []byte
+	first       string
+	serverFirst string
+}
+
+func newSCRAMServer(password string, iters int) *scramServer {
+	ss := &scramServer{salt: []byte("0123456789abcdef"), iters: iters}
+	salted, _ := pbkdf2.Key(sha256.New, password, ss.salt, iters, sha256.Size)
+	ss.storedKey = sha256.Sum256(scramHMAC(salted, "Client Key"))
+	ss.serverKey = scramHMAC(salted, "Server Key")
+	return ss
+}
+
+func (ss *scramServer) challenge(clientFirst string) string {
+	ss.first = strings.TrimPrefix(clientFirst, "n,,")
+	_, nonce, _ := strings.Cut(ss.first, "r=")
+	ss.serverFirst = "r=" + nonce + "SERVERNONCE,s=" + base64.StdEncoding.EncodeToString(ss.salt) + ",i=" + strconv.Itoa(ss.iters)
+	return ss.serverFirst
+}
+
+// check verifies the client's proof, returning the server-final-message
+func (ss *scramServer) check(clientFinal string) (string, bool) {
+	withoutProof, proof64, ok := strings.Cut(clientFinal, ",p=")
+	if !ok {
+		return "", false
+	}
+	proof, err := base64.StdEncoding.DecodeString(proof64)
+	if err != nil || len(proof) != sha256.Size {
+		return "", false
+	}
+	authMessage := ss.first + "," + ss.serverFirst + "," + withoutProof
+	sig := scramHMAC(ss.storedKey[:], authMessage)
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ sig[i]
+	}
+	if stored := sha256.Sum256(clientKey); !hmac.Equal(stored[:], ss.storedKey[:]) {
+		return "", false
+	}
+	return "v=" + base64.StdEncoding.EncodeToString(scramHMAC(ss.serverKey, authMessage)), true
+}
+
+func TestSCRAM(t *testing.T) {
+	ss := newSCRAMServer("pencil", 4096)
+	sc := newSCRAM("pencil")
+	final, err := sc.clientFinal(ss.challenge(sc.clientFirst()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(final, "c=biws,r="+sc.nonce+"SERVERNONCE,p=") {
+		t.Errorf("client-final-message %q", final)
+	}
+	serverFinal, ok := ss.check(final)
+	if !ok {
+		t.Fatalf("the server refused the client's proof")
+	}
+	if !sc.verify(serverFinal) {
+		t.Errorf("the client refused the server's signature")
+	}
+	if sc.verify("v=" + base64.StdEncoding.EncodeToString(make([]byte, 32))) {
+		t.Errorf("the client took a wrong signature")
+	}
+
+	// the wrong password
+	sc = newSCRAM("pen")
+	final, _ = sc.clientFinal(ss.challenge(sc.clientFirst()))
+	if _, ok := ss.check(final); ok {
+		t.Errorf("the server took a proof from the wrong password")
+	}
+
+	for _, serverFirst := range []string{
+		"",
+		"r=othernonce,s=eA==,i=4096",
+		"r=" + sc.nonce + "x,s=eA==,i=0",
+		"r=" + sc.nonce + "x,s=eA==,i=x",
+		"r=" + sc.nonce + "x,s=!!,i=4096",
+		"r=" + sc.nonce + "x,s=eA==,i=2000000000",
+	} {
+		sc := &scramClient{password: "p", nonce: sc.nonce}
+		if _, err := sc.clientFinal(serverFirst); err == nil {
+			t.Errorf("%q: no error", serverFirst)
+		}
+	}
+	if (&scramClient{}).verify("v=eA==") {
+		t.Errorf("verify before clientFinal")
+	}
+}
+
+func TestPGStartupSCRAM(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		sconn := &pgConn{c: server, r: bufio.NewReader(server)}
+		// the startup message has no type byte, so read it by hand
+		var n [4]byte
+		if _, err := io.ReadFull(sconn.r, n[:]); err != nil {
+			return
+		}
+		sconn.r.Discard(int(binary.BigEndian.Uint32(n[:])) - 4)
+		ss := newSCRAMServer("secret", 4096)
+		sconn.send('R', append(binary.BigEndian.AppendUint32(nil, 10), "SCRAM-SHA-256-PLUS\x00SCRAM-SHA-256\x00\x00"...))
+		_, resp, err := sconn.recv()
+		if err != nil {
+			return
+		}
+		// the mechanism, the length of the client-first-message, and the message
+		_, first, _ := bytes.Cut(resp, []byte{0})
+		sconn.send('R', append(binary.BigEndian.AppendUint32(nil, 11), ss.challenge(string(first[4:]))...))
+		_, final, err := sconn.recv()
+		if err != nil {
+			return
+		}
+		serverFinal, ok := ss.check(string(final))
+		if !ok {
+			sconn.send('E', []byte("SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00"))
+			return
+		}
+		sconn.send('R', append(binary.BigEndian.AppendUint32(nil, 12), serverFinal...))
+		sconn.send('R', binary.BigEndian.AppendUint32(nil, 0))
+		sconn.send('Z', []byte{'I'})
+	}()
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	conn := &pgConn{c: client, r: bufio.NewReader(client)}
+	if err := conn.startup(&pgConfig{user: "alice", password: "secret", database: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewPGConfig(t *testing.T) {
+	for _, tc := range []struct {
+		dsn  string
+		want pgConfig
+	}{
+		{"postgres://u:p@h/db", pgConfig{addr: "h:5432", user: "u", password: "p", database: "db", sslmode: "prefer", serverName: "h"}},
+		{"postgres://u@h:6000?sslmode=verify-full", pgConfig{addr: "h:6000", user: "u", database: "u", sslmode: "verify-full", serverName: "h"}},
+	} {
+		u, _ := url.Parse(tc.dsn)
+		pc, err := newPGConfig(u)
+		if err != nil {
+			t.Errorf("%q: %v", tc.dsn, err)
+			continue
+		}
+		if *pc != tc.want {
+			t.Errorf("%q = %+v, want %+v", tc.dsn, *pc, tc.want)
+		}
+	}
+	for _, dsn := range []string{"postgres://h/db", "postgres://u@h/db?sslmode=allow"} {
+		u, _ := url.Parse(dsn)
+		if _, err := newPGConfig(u); err == nil {
+			t.Errorf("%q: no error", dsn)
+		}
+	}
+}
+
+func TestPGString(t *testing.T) {
+	for in, want := range map[string]string{
+		"plain":        "E'plain'",
+		"it's":         `E'it\'s'`,
+		`back\slash`:   `E'back\\slash'`,
+		"nul\x00":      "E'nul�'",
+		"bad \xff utf": "E'bad � utf'",
+		"":             "E''",
+	} {
+		if got := pgString(in); got != want {
+			t.Errorf("pgString(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func FuzzPGRecv(f *testing.F) {
+	f.Add(bytes.Join([][]byte{pgAuth(5, 1, 2, 3, 4), pgAuth(0), pgReady}, nil))
+	f.Add(pgMsg('E', []byte("SERROR\x00C42601\x00Msyntax error\x00\x00")))
+	f.Add(pgAuth(10, []byte("SCRAM-SHA-256\x00\x00")...))
+	f.Add([]byte{'Z', 0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		// the same server as a startup and as query results
+		conn, _ := pgScript(b)
+		conn.startup(&pgConfig{user: "u", password: "p", database: "d"})
+		conn, _ = pgScript(b)
+		conn.query("SELECT 1")
+		conn, _ = pgScript(b)
+		for {
+			typ, body, err := conn.recv()
+			if err != nil {
+				return
+			}
+			if typ == 'E' {
+				parseError(body)
+			}
+		}
+	})
+}
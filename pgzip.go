@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// pgzipBlock is how much input each gzip member holds
+const pgzipBlock = 1 << 20
+
+// pgzipWriter gzips like gzip.Writer but compresses blocks on all CPUs.
+// Each block is written as its own gzip member; gzip readers, including gunzip and
+// compress/gzip, read concatenated members as one stream. It's this rather than klauspost/pgzip
+// because ssample sticks to the standard library, and independent members need nothing more.
+type pgzipWriter struct {
+	w   io.Writer
+	buf []byte
+
+	// compressed blocks in input order, written by writer()
+	blocks chan chan []byte
+	// limits blocks being compressed at once
+	sem    chan struct{}
+	done   chan struct{}
+	closed bool
+	// set once a block has been started, so Close knows to write an empty member for no input
+	started bool
+
+	errl sync.Mutex
+	err  error
+}
+
+func newPgzipWriter(w io.Writer) *pgzipWriter {
+	procs := runtime.GOMAXPROCS(0)
+	pw := &pgzipWriter{
+		w:      w,
+		blocks: make(chan chan []byte, procs),
+		sem:    make(chan struct{}, procs),
+		done:   make(chan struct{}),
+	}
+	go pw.writer()
+	return pw
+}
+
+func (pw *pgzipWriter) writer() {
+	defer close(pw.done)
+	for bc := range pw.blocks {
+		z := <-bc
+		if pw.getErr() != nil {
+			continue
+		}
+		_, err := pw.w.Write(z)
+		if err != nil {
+			pw.setErr(err)
+		}
+	}
+}
+
+func (pw *pgzipWriter) getErr() error {
+	pw.errl.Lock()
+	defer pw.errl.Unlock()
+	return pw.err
+}
+
+func (pw *pgzipWriter) setErr(err error) {
+	pw.errl.Lock()
+	defer pw.errl.Unlock()
+	if pw.err == nil {
+		pw.err = err
+	}
+}
+
+// Write returns earlier errors from writing to the underlying writer
+func (pw *pgzipWriter) Write(b []byte) (int, error) {
+	if err := pw.getErr(); err != nil {
+		return 0, err
+	}
+	if pw.closed {
+		return 0, errTeeClosed
+	}
+	n := len(b)
+	for len(b) != 0 {
+		if pw.buf == nil {
+			pw.buf = make([]byte, 0, pgzipBlock)
+		}
+		room := cap(pw.buf) - len(pw.buf)
+		if room > len(b) {
+			room = len(b)
+		}
+		pw.buf = append(pw.buf, b[:room]...)
+		b = b[room:]
+		if len(pw.buf) == cap(pw.buf) {
+			pw.flushBlock()
+		}
+	}
+	return n, nil
+}
+
+// flushBlock starts compressing buf, even if it's empty when nothing has been written yet
+func (pw *pgzipWriter) flushBlock() {
+	if len(pw.buf) == 0 && pw.started {
+		return
+	}
+	pw.started = true
+	block := pw.buf
+	pw.buf = nil
+	bc := make(chan []byte, 1)
+	pw.sem <- struct{}{}
+	pw.blocks <- bc
+	go func() {
+		defer func() { <-pw.sem }()
+		var out bytes.Buffer
+		zw := gzipWriters.Get().(*gzip.Writer)
+		zw.Reset(&out)
+		zw.Write(block)
+		zw.Close()
+		gzipWriters.Put(zw)
+		bc <- out.Bytes()
+	}()
+}
+
+// Close writes out the last block, or an empty gzip member if there was no input, so the output is
+// always a gzip stream. Like gzip.Writer it doesn't close the underlying writer.
+func (pw *pgzipWriter) Close() error {
+	if pw.closed {
+		return pw.getErr()
+	}
+	pw.closed = true
+	pw.flushBlock()
+	close(pw.blocks)
+	<-pw.done
+	return pw.getErr()
+}
+
+// readAhead decompresses r in another goroutine, so gunzip and whatever reads its output run on two CPUs
+func readAhead(r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		bw := bufio.NewWriterSize(pw, 256*1024)
+		_, err := io.Copy(bw, r)
+		if err == nil {
+			err = bw.Flush()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
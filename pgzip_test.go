@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"testing"
+)
+
+// pgzipInput is n bytes of lines, compressible but not trivially
+func pgzipInput(n int) []byte {
+	rng := rand.New(rand.NewPCG(1, uint64(n)))
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		out = append(out, "line "...)
+		for i := rng.IntN(40); i >= 0 && len(out) < n; i-- {
+			out = append(out, byte('a'+rng.IntN(26)))
+		}
+		out = append(out, '\n')
+	}
+	return out[:n]
+}
+
+// gzipMembers counts the members of a gzip stream, checking each reads
+func gzipMembers(t *testing.T, z []byte) int {
+	t.Helper()
+	// a bufio.Reader is a flate.Reader, so each member is read no further than its end
+	br := bufio.NewReader(bytes.NewReader(z))
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	zr.Multistream(false)
+	members := 0
+	for {
+		if _, err := io.Copy(io.Discard, zr); err != nil {
+			t.Fatalf("member %d: %v", members, err)
+		}
+		members++
+		err = zr.Reset(br)
+		if err == io.EOF {
+			return members
+		}
+		if err != nil {
+			t.Fatalf("after member %d: %v", members, err)
+		}
+		// Reset turns it back on
+		zr.Multistream(false)
+	}
+}
+
+func TestPgzipRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		size, chunk, members int
+	}{
+		{0, 1, 1},
+		{1, 1, 1},
+		{1000, 7, 1},
+		{pgzipBlock - 1, 4096, 1},
+		{pgzipBlock, pgzipBlock, 1},
+		{pgzipBlock + 1, 1 << 16, 2},
+		{3*pgzipBlock + pgzipBlock/2, 100_000, 4},
+		{2 * pgzipBlock, 3 * pgzipBlock, 2},
+	} {
+		in := pgzipInput(tc.size)
+		var z bytes.Buffer
+		pw := newPgzipWriter(&z)
+		for rest := in; len(rest) != 0; {
+			n := min(tc.chunk, len(rest))
+			w, err := pw.Write(rest[:n])
+			if err != nil || w != n {
+				t.Fatalf("size %d: Write = %d, %v", tc.size, w, err)
+			}
+			rest = rest[n:]
+		}
+		if err := pw.Close(); err != nil {
+			t.Fatalf("size %d: Close: %v", tc.size, err)
+		}
+		if got := gzipMembers(t, z.Bytes()); got != tc.members {
+			t.Errorf("size %d: %d gzip members, want %d", tc.size, got, tc.members)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(z.Bytes()))
+		if err != nil {
+			t.Fatalf("size %d: %v", tc.size, err)
+		}
+		out, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("size %d: %v", tc.size, err)
+		}
+		if !bytes.Equal(out, in) {
+			t.Errorf("size %d: read back %d bytes differing from the input", tc.size, len(out))
+		}
+	}
+}
+
+func TestPgzipClose(t *testing.T) {
+	var z bytes.Buffer
+	pw := newPgzipWriter(&z)
+	pw.Write([]byte("a\n"))
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if _, err := pw.Write([]byte("b\n")); err != errTeeClosed {
+		t.Errorf("Write after Close: %v, want errTeeClosed", err)
+	}
+}
+
+type failWriter struct{ err error }
+
+func (fw failWriter) Write(b []byte) (int, error) { return 0, fw.err }
+
+func TestPgzipWriteError(t *testing.T) {
+	diskFull := errors.New("disk full")
+	pw := newPgzipWriter(failWriter{diskFull})
+	in := pgzipInput(3 * pgzipBlock)
+	for i := 0; i < len(in); i += 1 << 16 {
+		// the first failure may not show until a later Write
+		if _, err := pw.Write(in[i : i+1<<16]); err != nil && err != diskFull {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := pw.Close(); err != diskFull {
+		t.Errorf("Close: %v, want %v", err, diskFull)
+	}
+	if _, err := pw.Write([]byte("x")); err != diskFull {
+		t.Errorf("Write after a failure: %v, want %v", err, diskFull)
+	}
+}
+
+func TestGunzipMaybe(t *testing.T) {
+	// members from compress/gzip, as written by other tools or older ssample versions
+	var z bytes.Buffer
+	var want []byte
+	for _, part := range []string{"one\ntwo\n", "", "three\n"} {
+		zw := gzip.NewWriter(&z)
+		zw.Write([]byte(part))
+		zw.Close()
+		want = append(want, part...)
+	}
+	// and from pgzipWriter appended to the same file
+	big := pgzipInput(pgzipBlock + 10)
+	pw := newPgzipWriter(&z)
+	pw.Write(big)
+	pw.Close()
+	want = append(want, big...)
+	br, err := gunzipMaybe(bytes.NewReader(z.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("read %d bytes, want %d", len(got), len(want))
+	}
+
+	// plain text is read as it is
+	br, err = gunzipMaybe(bytes.NewReader([]byte("plain\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(br); string(got) != "plain\n" {
+		t.Errorf("plain read as %q", got)
+	}
+
+	// a truncated stream is an error from the reader, not a short read
+	cut := z.Bytes()[:z.Len()-100]
+	br, err = gunzipMaybe(bytes.NewReader(cut))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(br); err == nil {
+		t.Errorf("no error reading a truncated gzip stream")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+)
+
+func addPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// startProfiles starts a CPU profile to cpuPath if it is set.
+// The returned stop finishes it and writes a heap profile to memPath if that is set.
+func startProfiles(cpuPath, memPath string) (stop func(), err error) {
+	var cpuf *os.File
+	if cpuPath != "" {
+		cpuf, err = os.Create(cpuPath)
+		if err != nil {
+			return nil, err
+		}
+		err = rpprof.StartCPUProfile(cpuf)
+		if err != nil {
+			cpuf.Close()
+			return nil, err
+		}
+	}
+	return func() {
+		if cpuf != nil {
+			rpprof.StopCPUProfile()
+			err := cpuf.Close()
+			if err != nil {
+				failf("%s: %v", cpuPath, err)
+			}
+		}
+		if memPath != "" {
+			err := writeHeapProfile(memPath)
+			if err != nil {
+				failf("%s: %v", memPath, err)
+			}
+		}
+	}, nil
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	// up to date allocation counts
+	runtime.GC()
+	err = rpprof.WriteHeapProfile(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// reportProgress logs lines and bytes seen, the rate since the last report, and how
+// far through each -f file reading is, every interval until input ends
+func reportProgress(c *Collector, paths []string, every time.Duration) {
+	start := time.Now()
+	last, lastTime := c.Stats(), start
+	for now := range time.Tick(every) {
+		if atomic.LoadUint32(&inputDone) != 0 {
+			return
+		}
+		st := c.Stats()
+		prev := last.LinesSeen
+		if st.LinesSeen < prev {
+			// Reset
+			prev = 0
+		}
+		rate := float64(st.LinesSeen-prev) / now.Sub(lastTime).Seconds()
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "progress %s: %d lines, %s, %.0f lines/s", now.Sub(start).Round(time.Second), st.LinesSeen, formatBytes(st.BytesSeen), rate)
+		for _, path := range paths {
+			fi, err := os.Stat(path)
+			if err != nil || fi.Size() == 0 {
+				continue
+			}
+			fmt.Fprintf(&sb, ", %s %.1f%%", path, 100*float64(min(c.InputOffset(path), fi.Size()))/float64(fi.Size()))
+		}
+		infof("%s", sb.String())
+		last, lastTime = st, now
+	}
+}
+
+// formatBytes is n in B, KiB, MiB, ...
+func formatBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	v := float64(n)
+	unit := 0
+	for v >= 1024 && unit < 5 {
+		v /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %ciB", v, "KMGTPE"[unit-1])
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openPTY opens a new pseudo-terminal, returning its master and the terminal for a child
+func openPTY() (master, tty *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var name [128]byte
+	for _, req := range []uintptr{syscall.TIOCPTYGRANT, syscall.TIOCPTYUNLK} {
+		if err := ptyIoctl(master, req, nil); err != nil {
+			master.Close()
+			return nil, nil, fmt.Errorf("/dev/ptmx: %v", err)
+		}
+	}
+	if err := ptyIoctl(master, syscall.TIOCPTYGNAME, unsafe.Pointer(&name)); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("/dev/ptmx: %v", err)
+	}
+	path := string(name[:bytes.IndexByte(name[:], 0)])
+	tty, err = os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, tty, nil
+}
@@ -0,0 +1,31 @@
+//go:build !(linux || darwin)
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// openPTY fails, -pty is only done on linux and macOS
+func openPTY() (master, tty *os.File, err error) {
+	return nil, nil, errors.New("-pty isn't supported on this system")
+}
+
+func ptyProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+func ptyInputEcho(tty *os.File) {}
+
+func ptyResize(master *os.File) {}
+
+type ptyOutput struct {
+	master *os.File
+}
+
+func (po ptyOutput) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
@@ -0,0 +1,79 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+func ptyIoctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// ptyProcAttr makes the child the leader of a new session with its stdin, the pty, as the controlling terminal
+func ptyProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+}
+
+// ptyInputEcho turns off tty's echo unless ssample's stdin is a terminal,
+// so that piped input copied to the child isn't also sampled as its output
+func ptyInputEcho(tty *os.File) {
+	var t syscall.Termios
+	if termios(os.Stdin, ioctlGetTermios, &t) == nil {
+		return
+	}
+	if termios(tty, ioctlGetTermios, &t) == nil {
+		t.Lflag &^= syscall.ECHO
+		termios(tty, ioctlSetTermios, &t)
+	}
+}
+
+// ptyResize sets master's window size to that of ssample's terminal, now and on every SIGWINCH
+func ptyResize(master *os.File) {
+	term, err := os.Open("/dev/tty")
+	if err != nil {
+		// no terminal to follow, the pty stays 80x24
+		term = nil
+	}
+	resize := func() {
+		ws := struct{ Row, Col, X, Y uint16 }{24, 80, 0, 0}
+		if term != nil {
+			w, h := terminalSize(term)
+			ws.Col, ws.Row = uint16(w), uint16(h)
+		}
+		ptyIoctl(master, syscall.TIOCSWINSZ, unsafe.Pointer(&ws))
+	}
+	resize()
+	if term == nil {
+		return
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGWINCH)
+	go func() {
+		for range sigs {
+			resize()
+		}
+	}()
+}
+
+// ptyOutput reads what the child writes to the pty. After it exits a read gets EIO on Linux, which is the end.
+type ptyOutput struct {
+	master *os.File
+}
+
+func (po ptyOutput) Read(p []byte) (int, error) {
+	n, err := po.master.Read(p)
+	if errors.Is(err, syscall.EIO) {
+		err = io.EOF
+	}
+	return n, err
+}
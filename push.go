@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// pusher sends the sample to an `ssample aggregate` server's /push. The first push is the whole sample
+// with its /v1/changes token; after that only the reservoir changes since the token the aggregator
+// last took, which it applies to its copy. If the aggregator hasn't got that token, say it restarted,
+// it answers 409 and the whole sample is pushed again. The aggregator replaces the previous push
+// with the same id, so resending is always safe.
+type pusher struct {
+	s  *ssampleServer
+	rc *remoteClient
+	id string
+
+	l           sync.Mutex
+	lastVersion uint64
+	lastSeen    int
+	pushed      bool
+	// the aggregator's copy is the sample as of this change token, "" to push the whole sample
+	token string
+}
+
+// pushDelta is POST /push?id=ID&base=TOKEN, the changes to a pushed sample since TOKEN
+type pushDelta struct {
+	Token     string            `json:"token"`
+	Capacity  int               `json:"capacity"`
+	LinesSeen int               `json:"seen"`
+	BytesSeen int64             `json:"bytesSeen"`
+	Window    V1Window          `json:"window"`
+	Sources   []SourceCount     `json:"sources,omitempty"`
+	Changes   []ReservoirChange `json:"changes"`
+}
+
+func newPusher(c *Collector, target, id, token string, insecure bool) *pusher {
+	if id == "" {
+		hostname, _ := os.Hostname()
+		id = fmt.Sprintf("%s:%d", hostname, os.Getpid())
+	}
+	return &pusher{
+		s:  &ssampleServer{c: c},
+		rc: newRemoteClient(target, token, "", insecure),
+		id: id,
+	}
+}
+
+// push sends the sample if it changed since the last successful push, or if force
+func (p *pusher) push(force bool) error {
+	p.l.Lock()
+	defer p.l.Unlock()
+	c := p.s.c
+	version, seen := c.Version()
+	if p.pushed && !force && version == p.lastVersion && seen == p.lastSeen {
+		return nil
+	}
+	// only a uniform sample's lines can be sent as changes, other samples' line weights change as they go
+	if p.token != "" && c.algorithm() == algReservoir {
+		sent, err := p.pushChanges(seen)
+		var se *remoteStatusError
+		switch {
+		case errors.As(err, &se) && se.code == http.StatusConflict:
+			infof("push: the aggregator hasn't got the last push, pushing the whole sample")
+		case err != nil:
+			return err
+		case sent:
+			p.lastVersion = version
+			p.lastSeen = seen
+			return nil
+		}
+	}
+	// changes from here on may be in the sample already, applying them again doesn't change it
+	token := c.ChangeToken()
+	blob, err := json.Marshal(p.s.v1Sample())
+	if err != nil {
+		return err
+	}
+	err = p.post("/push?id="+url.QueryEscape(p.id)+"&token="+url.QueryEscape(token), blob, seen)
+	if err != nil {
+		return err
+	}
+	p.token = token
+	p.lastVersion = version
+	p.lastSeen = seen
+	p.pushed = true
+	return nil
+}
+
+// pushChanges sends the changes since p.token, false if they aren't all kept
+// (too many since the last push, or c was restored) and the whole sample has to be pushed
+func (p *pusher) pushChanges(seen int) (bool, error) {
+	c := p.s.c
+	ch, err := c.Changes(p.token)
+	if err != nil || ch.Resync {
+		return false, err
+	}
+	st := c.Stats()
+	blob, err := json.Marshal(pushDelta{
+		Token:     ch.Token,
+		Capacity:  st.Capacity,
+		LinesSeen: ch.LinesSeen,
+		BytesSeen: st.BytesSeen,
+		Window:    c.Window(),
+		Sources:   c.Sources(),
+		Changes:   ch.Changes,
+	})
+	if err != nil {
+		return false, err
+	}
+	err = p.post("/push?id="+url.QueryEscape(p.id)+"&base="+url.QueryEscape(p.token), blob, seen)
+	if err != nil {
+		return false, err
+	}
+	p.token = ch.Token
+	return true, nil
+}
+
+func (p *pusher) post(path string, blob []byte, seen int) error {
+	start := time.Now()
+	_, err := p.rc.post(path, blob)
+	otel.span("push", spanClient, traceContext{}, start, []otlpKV{
+		otlpString("url.full", p.rc.base), otlpInt("ssample.lines_seen", int64(seen)), otlpInt("ssample.push_bytes", int64(len(blob))),
+	}, err)
+	return err
+}
+
+// run pushes every interval, and every tenth interval even if nothing changed, to find out if the aggregator restarted
+func (p *pusher) run(every time.Duration) {
+	i := 0
+	for range time.Tick(every) {
+		i++
+		err := p.push(i%10 == 0)
+		if err != nil {
+			errorf("push: %v", err)
+		}
+	}
+}
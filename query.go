@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// query gets the sample and applies request parameters:
+// ?since=LINENO returns only lines numbered after LINENO
+// ?match=REGEX and ?exclude=REGEX keep lines that do and don't match
+// ?n=N returns a uniform random N of the sampled lines
+func (s *ssampleServer) query(r *http.Request) (*LineNoResponse, error) {
+	var out LineNoResponse
+	out.Lines, out.LineNumbers = s.c.LinesAndNumbers()
+	st := s.c.Stats()
+	out.LinesSeen = st.LinesSeen
+	out.BytesSeen = st.BytesSeen
+	if sstr := r.FormValue("since"); sstr != "" {
+		since, err := strconv.Atoi(sstr)
+		if err != nil {
+			return nil, fmt.Errorf("bad since=%q", sstr)
+		}
+		linesSince(&out, since)
+	}
+	match, err := queryRegexp(r, "match")
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := queryRegexp(r, "exclude")
+	if err != nil {
+		return nil, err
+	}
+	if match != nil || exclude != nil {
+		filterLines(&out, match, exclude)
+	}
+	if nstr := r.FormValue("n"); nstr != "" {
+		n, err := strconv.Atoi(nstr)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("bad n=%q", nstr)
+		}
+		subsample(&out, n)
+	}
+	return &out, nil
+}
+
+// subsample keeps a uniform random n of the lines, still in line number order
+func subsample(out *LineNoResponse, n int) {
+	if n >= len(out.Lines) {
+		return
+	}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	pick := rng.Perm(len(out.Lines))[:n]
+	sort.Ints(pick)
+	lines := make([]string, n)
+	lineNumbers := make([]int, n)
+	for i, pi := range pick {
+		lines[i] = out.Lines[pi]
+		lineNumbers[i] = out.LineNumbers[pi]
+	}
+	out.Lines = lines
+	out.LineNumbers = lineNumbers
+}
+
+// linesSince drops lines numbered <= since
+func linesSince(out *LineNoResponse, since int) {
+	// lines are sorted by line number
+	start := sort.SearchInts(out.LineNumbers, since+1)
+	out.Lines = out.Lines[start:]
+	out.LineNumbers = out.LineNumbers[start:]
+}
+
+func queryRegexp(r *http.Request, param string) (*regexp.Regexp, error) {
+	expr := r.FormValue(param)
+	if expr == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("bad %s: %v", param, err)
+	}
+	return re, nil
+}
+
+// filterLines keeps lines matching match (if set) and not matching exclude (if set)
+func filterLines(out *LineNoResponse, match, exclude *regexp.Regexp) {
+	lines := out.Lines[:0:0]
+	lineNumbers := out.LineNumbers[:0:0]
+	for i, line := range out.Lines {
+		if match != nil && !match.MatchString(line) {
+			continue
+		}
+		if exclude != nil && exclude.MatchString(line) {
+			continue
+		}
+		lines = append(lines, line)
+		lineNumbers = append(lineNumbers, out.LineNumbers[i])
+	}
+	out.Lines = lines
+	out.LineNumbers = lineNumbers
+}
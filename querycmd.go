@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// remoteClient fetches from an ssample -http server, "http[s]://host:port" or "unix:/path.sock"
+type remoteClient struct {
+	base   string
+	client *http.Client
+	token  string
+	user   string
+	pass   string
+}
+
+func newRemoteClient(target, token, userpass string, insecure bool) *remoteClient {
+	rc := &remoteClient{token: token}
+	if userpass != "" {
+		rc.user, rc.pass, _ = strings.Cut(userpass, ":")
+	}
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+	}
+	if strings.HasPrefix(target, "unix:") {
+		sock := strings.TrimPrefix(target, "unix:")
+		tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		}
+		target = "http://unix"
+	} else if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	rc.base = strings.TrimSuffix(target, "/")
+	rc.client = &http.Client{Transport: tr, Timeout: time.Minute}
+	return rc
+}
+
+// get fetches path (which may have a ?query) and returns the body, or an error for non-200 responses
+func (rc *remoteClient) get(path string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, rc.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return rc.do(req)
+}
+
+// post sends a json body to path, see get
+func (rc *remoteClient) post(path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, rc.base+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return rc.do(req)
+}
+
+func (rc *remoteClient) do(req *http.Request) ([]byte, error) {
+	req.Header.Set("Accept", "application/json")
+	if rc.token != "" {
+		req.Header.Set("Authorization", "Bearer "+rc.token)
+	} else if rc.user != "" {
+		req.SetBasicAuth(rc.user, rc.pass)
+	}
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &remoteStatusError{url: req.URL.String(), status: resp.Status, code: resp.StatusCode, body: strings.TrimSpace(string(body))}
+	}
+	return body, nil
+}
+
+// remoteStatusError is a response that wasn't 200 OK
+type remoteStatusError struct {
+	url    string
+	status string
+	code   int
+	body   string
+}
+
+func (e *remoteStatusError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.url, e.status, e.body)
+}
+
+// queryMain is `ssample query [flags] http://host:port`
+func queryMain(args []string) {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	match := fs.String("match", "", "only lines matching this regex")
+	exclude := fs.String("exclude", "", "only lines not matching this regex")
+	since := fs.Int("since", -1, "only lines numbered after this")
+	n := fs.Int("n", 0, "a random n of the sampled lines")
+	collector := fs.String("collector", "", "query this named collector instead of stdin")
+	jsonOut := fs.Bool("json", false, "print indented json instead of text")
+	plain := fs.Bool("p", false, "print only the lines, no line numbers")
+	collapse := fs.Bool("collapse", false, "print identical lines once, with an x{count} column before the line")
+	save := fs.String("o", "", "also save the json response to this file (usable by merge)")
+	token := fs.String("token", os.Getenv("SSAMPLE_TOKEN"), "bearer token (default $SSAMPLE_TOKEN)")
+	userpass := fs.String("user", "", "user:password for basic auth")
+	insecure := fs.Bool("insecure", false, "don't verify https certificates")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s query [flags] http://host:port | unix:/path.sock\n\nFetch and print the sample from a running ssample -http server.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitStart)
+	}
+	rc := newRemoteClient(fs.Arg(0), *token, *userpass, *insecure)
+	params := url.Values{}
+	if *match != "" {
+		params.Set("match", *match)
+	}
+	if *exclude != "" {
+		params.Set("exclude", *exclude)
+	}
+	if *since >= 0 {
+		params.Set("since", strconv.Itoa(*since))
+	}
+	if *n > 0 {
+		params.Set("n", strconv.Itoa(*n))
+	}
+	path := "/"
+	if *collector != "" {
+		path = "/collector/" + url.PathEscape(*collector) + "/"
+	}
+	if len(params) != 0 {
+		path += "?" + params.Encode()
+	}
+	body, err := rc.get(path)
+	maybefail(err, "%v\n", err)
+	var out LineNoResponse
+	err = json.Unmarshal(body, &out)
+	maybefail(err, "bad response: %v\n", err)
+	if *save != "" {
+		err = writeFileAtomic(*save, body, 0644)
+		maybefail(err, "%s: %v\n", *save, err)
+	}
+	if *jsonOut {
+		blob, _ := json.MarshalIndent(out, "", "  ")
+		fmt.Printf("%s\n", blob)
+		return
+	}
+	infof("%d of %d lines seen, %d bytes", len(out.Lines), out.LinesSeen, out.BytesSeen)
+	lines, nos := out.Lines, out.LineNumbers
+	var counts []int
+	if *collapse {
+		lines, nos, counts = collapseLines(lines, nos)
+	}
+	for i, line := range lines {
+		if counts != nil {
+			line = fmt.Sprintf("x%d\t%s", counts[i], line)
+		}
+		if *plain {
+			fmt.Printf("%s\n", line)
+		} else {
+			fmt.Printf("%d\t%s\n", nos[i], line)
+		}
+	}
+}
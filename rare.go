@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash/maphash"
+	"os"
+	"sort"
+	"sync/atomic"
+)
+
+// count-min sketch dimensions, 64KB
+const (
+	sketchDepth = 4
+	sketchWidth = 4096
+)
+
+// countMin is a count-min sketch of hashes, safe for concurrent use.
+// Estimates are never low and are high by at most about total/sketchWidth.
+type countMin struct {
+	counts [sketchDepth][sketchWidth]uint32
+	total  atomic.Uint64
+}
+
+// cells returns the counter index in each row for a hash
+func (cm *countMin) cells(hash uint64) [sketchDepth]uint32 {
+	var out [sketchDepth]uint32
+	h1, h2 := hash, hash>>32|1
+	for i := range out {
+		out[i] = uint32((h1 + uint64(i)*h2) % sketchWidth)
+	}
+	return out
+}
+
+func (cm *countMin) add(hash uint64) {
+	for row, cell := range cm.cells(hash) {
+		atomic.AddUint32(&cm.counts[row][cell], 1)
+	}
+	cm.total.Add(1)
+}
+
+// estimate returns about how many times hash was added
+func (cm *countMin) estimate(hash uint64) uint32 {
+	var least uint32
+	for row, cell := range cm.cells(hash) {
+		n := atomic.LoadUint32(&cm.counts[row][cell])
+		if row == 0 || n < least {
+			least = n
+		}
+	}
+	return least
+}
+
+func (cm *countMin) reset() {
+	for row := range cm.counts {
+		for cell := range cm.counts[row] {
+			atomic.StoreUint32(&cm.counts[row][cell], 0)
+		}
+	}
+	cm.total.Store(0)
+}
+
+// patternSketch estimates how often each line pattern has been seen over all input, for -rare.
+// A line's pattern is its tokens with the ones containing digits wildcarded, as for -templates.
+type patternSketch struct {
+	// a line is rare if its pattern is under this fraction of all lines
+	fraction float64
+
+	seed maphash.Seed
+	countMin
+}
+
+func newPatternSketch(fraction float64) *patternSketch {
+	return &patternSketch{fraction: fraction, seed: maphash.MakeSeed()}
+}
+
+// patternHash hashes the pattern of line
+func (ps *patternSketch) patternHash(line []byte) uint64 {
+	var h maphash.Hash
+	h.SetSeed(ps.seed)
+	var tokens [32][]byte
+	for _, tok := range splitFields(tokens[:0], line, nil) {
+		h.Write(maskToken(tok))
+		h.WriteByte(' ')
+	}
+	return h.Sum64()
+}
+
+// maskToken is tok, or the wildcard if it has digits and so is probably variable
+func maskToken(tok []byte) []byte {
+	if hasDigit(tok) {
+		return []byte(templateWildcard)
+	}
+	return tok
+}
+
+func (ps *patternSketch) addLine(line []byte) {
+	ps.add(ps.patternHash(line))
+}
+
+// rare is true if line's pattern is under -rare of all input so far
+func (ps *patternSketch) rare(line []byte) bool {
+	return float64(ps.estimate(ps.patternHash(line))) < ps.fraction*float64(ps.total.Load())
+}
+
+// printSampleRareFirst writes the sample like printSample, but lines -rare flags come first
+// and are marked "*{lineNumber}\t{line}\n"; -collapse adds its count column the same way
+func printSampleRareFirst(c *Collector) {
+	records, _, _ := c.Records()
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Rare && !records[j].Rare })
+	lines := make([]string, len(records))
+	nos := make([]int, len(records))
+	for i, rec := range records {
+		lines[i] = rec.Line
+		if rec.LineBase64 != nil {
+			lines[i] = string(rec.LineBase64)
+		}
+		nos[i] = i
+	}
+	var counts []int
+	if collapseDups {
+		lines, nos, counts = collapseLines(lines, nos)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	for i, j := range nos {
+		rec := records[j]
+		mark := ""
+		if rec.Rare {
+			mark = "*"
+		}
+		if counts != nil {
+			fmt.Fprintf(out, "%s%d\tx%d\t%s\n", mark, rec.LineNumber, counts[i], lines[i])
+		} else {
+			fmt.Fprintf(out, "%s%d\t%s\n", mark, rec.LineNumber, lines[i])
+		}
+	}
+	out.Flush()
+}
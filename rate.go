@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateMeter samples a counter once a second so a recent rate can be reported.
+type rateMeter struct {
+	count func() int
+
+	// ring of per-second samples
+	counts []int
+	times  []time.Time
+	pos    int
+	filled bool
+
+	l sync.Mutex
+}
+
+func newRateMeter(count func() int, seconds int) *rateMeter {
+	return &rateMeter{
+		count:  count,
+		counts: make([]int, seconds+1),
+		times:  make([]time.Time, seconds+1),
+	}
+}
+
+// run samples until the process exits
+func (rm *rateMeter) run() {
+	rm.sample()
+	for range time.Tick(time.Second) {
+		rm.sample()
+	}
+}
+
+func (rm *rateMeter) sample() {
+	v := rm.count()
+	now := time.Now()
+	rm.l.Lock()
+	defer rm.l.Unlock()
+	rm.counts[rm.pos] = v
+	rm.times[rm.pos] = now
+	rm.pos = (rm.pos + 1) % len(rm.counts)
+	if rm.pos == 0 {
+		rm.filled = true
+	}
+}
+
+// Rate is the counter increase per second over the sampling window
+func (rm *rateMeter) Rate() float64 {
+	rm.l.Lock()
+	defer rm.l.Unlock()
+	oldest := 0
+	if rm.filled {
+		oldest = rm.pos
+	} else if rm.pos < 2 {
+		return 0
+	}
+	newest := (rm.pos + len(rm.counts) - 1) % len(rm.counts)
+	dt := rm.times[newest].Sub(rm.times[oldest]).Seconds()
+	if dt <= 0 {
+		return 0
+	}
+	return float64(rm.counts[newest]-rm.counts[oldest]) / dt
+}
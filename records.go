@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// linesNotRecords counts lines -records left out for not parsing
+var linesNotRecords uint64
+
+// -records formats
+const (
+	recordsNDJSON = "ndjson"
+	recordsLogfmt = "logfmt"
+)
+
+// recordFormat is -records, "" if lines aren't structured records.
+// Records are kept as their text, which is smaller than the decoded objects, and decoded for output.
+var recordFormat string
+
+func checkRecordFormat(format string) error {
+	switch format {
+	case "", recordsNDJSON, recordsLogfmt:
+		return nil
+	}
+	return fmt.Errorf("unknown -records %q, want ndjson or logfmt", format)
+}
+
+// parseRecord decodes a line of format, nil if it isn't a record: a JSON object, or logfmt with at least a key
+func parseRecord(format string, line []byte) map[string]interface{} {
+	switch format {
+	case recordsNDJSON:
+		var v map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		if dec.Decode(&v) != nil {
+			return nil
+		}
+		return v
+	case recordsLogfmt:
+		var v map[string]interface{}
+		scanLogfmt(line, func(k, val []byte) bool {
+			if v == nil {
+				v = make(map[string]interface{})
+			}
+			v[string(k)] = string(val)
+			return true
+		})
+		return v
+	}
+	return nil
+}
+
+// recordValue is a sampled line as it goes in /v1/sample's record, nil if it isn't one
+func recordValue(line string) interface{} {
+	if recordFormat == recordsNDJSON {
+		// already JSON, if it's an object
+		b := bytes.TrimSpace([]byte(line))
+		if len(b) != 0 && b[0] == '{' && json.Valid(b) {
+			return json.RawMessage(b)
+		}
+		return nil
+	}
+	if v := parseRecord(recordFormat, []byte(line)); v != nil {
+		return v
+	}
+	return nil
+}
+
+// recordStage is -records, leaving out lines that aren't records
+type recordStage struct {
+	format string
+}
+
+func (rs recordStage) apply(line []byte) ([]byte, bool) {
+	if parseRecord(rs.format, line) == nil {
+		atomic.AddUint64(&linesNotRecords, 1)
+		return nil, false
+	}
+	return line, true
+}
+
+// project is the values at fields' paths in v, keyed by the path. A missing one is null.
+func project(v map[string]interface{}, fields []string) map[string]interface{} {
+	out := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		fv, _ := parseJSONPath(field).lookup(v)
+		out[field] = fv
+	}
+	return out
+}
+
+// v1Records serves /v1/records?fields=a,b.c: the sampled records as a JSON array of objects,
+// or of just those fields. The sample query parameters apply as for /.
+func (s *ssampleServer) v1Records(w http.ResponseWriter, r *http.Request) {
+	if recordFormat == "" {
+		badRequest(w, fmt.Errorf("lines aren't records, run with -records ndjson or logfmt"))
+		return
+	}
+	out, err := s.query(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	var fields []string
+	if fs := r.FormValue("fields"); fs != "" {
+		fields = strings.Split(fs, ",")
+	}
+	records := make([]interface{}, 0, len(out.Lines))
+	for _, line := range out.Lines {
+		v := parseRecord(recordFormat, []byte(line))
+		if v == nil {
+			// e.g. POSTed to ingest, which -records doesn't check
+			continue
+		}
+		if fields != nil {
+			records = append(records, project(v, fields))
+		} else {
+			records = append(records, v)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
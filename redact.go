@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// linesRedacted counts input lines -redact changed
+var linesRedacted uint64
+
+// redactPatterns are the built in -redact names
+var redactPatterns = map[string]string{
+	"email": `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	"ipv4":  `\b(?:(?:25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])\b`,
+	"ipv6":  `\b(?:[0-9A-Fa-f]{1,4}:){7}[0-9A-Fa-f]{1,4}\b|\b(?:[0-9A-Fa-f]{1,4}:){1,7}:(?:[0-9A-Fa-f]{1,4}(?::[0-9A-Fa-f]{1,4}){0,6})?\b`,
+	// checked with luhn so other long numbers are left alone
+	"card": `\b[0-9](?:[ -]?[0-9]){12,18}\b`,
+}
+
+func redactNames() string {
+	var names []string
+	for k := range redactPatterns {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// redaction replaces the matches of a regex with "<name>"
+type redaction struct {
+	name string
+	re   *regexp.Regexp
+	// for card, a match is only replaced if it passes this
+	check func(match []byte) bool
+}
+
+// parseRedaction parses -redact: a built in name, or name=REGEX
+func parseRedaction(spec string) (redaction, error) {
+	name, expr, custom := strings.Cut(spec, "=")
+	if !custom {
+		expr = redactPatterns[name]
+		if expr == "" {
+			return redaction{}, fmt.Errorf("unknown -redact %q, want one of %s or name=REGEX", spec, redactNames())
+		}
+	} else if name == "" || expr == "" {
+		return redaction{}, fmt.Errorf("bad -redact %q, want name=REGEX", spec)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return redaction{}, fmt.Errorf("-redact %s: %v", name, err)
+	}
+	rd := redaction{name: name, re: re}
+	if name == "card" && !custom {
+		rd.check = luhn
+	}
+	return rd, nil
+}
+
+// luhn is true for card numbers with a valid check digit, ignoring spaces and dashes
+func luhn(number []byte) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		ch := number[i]
+		if ch < '0' || ch > '9' {
+			continue
+		}
+		d := int(ch - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// redactStage applies each -redact in order
+type redactStage struct {
+	redactions []redaction
+	// each redaction writes to the buffer the line isn't in
+	bufs [2][]byte
+	next int
+}
+
+func (rs *redactStage) apply(line []byte) ([]byte, bool) {
+	changed := false
+	for _, rd := range rs.redactions {
+		matches := rd.re.FindAllIndex(line, -1)
+		if matches == nil {
+			continue
+		}
+		out := rs.bufs[rs.next][:0]
+		prev := 0
+		for _, m := range matches {
+			if rd.check != nil && !rd.check(line[m[0]:m[1]]) {
+				continue
+			}
+			out = append(out, line[prev:m[0]]...)
+			out = append(out, '<')
+			out = append(out, rd.name...)
+			out = append(out, '>')
+			prev = m[1]
+		}
+		if prev == 0 {
+			continue
+		}
+		out = append(out, line[prev:]...)
+		rs.bufs[rs.next] = out
+		rs.next = 1 - rs.next
+		line = out
+		changed = true
+	}
+	if changed {
+		atomic.AddUint64(&linesRedacted, 1)
+	}
+	return line, true
+}
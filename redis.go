@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisBlock is how long one XREAD waits for entries
+const redisBlock = 5 * time.Second
+
+// the limits on a reply: the longest bulk string, and the most elements and nesting of arrays,
+// well past what stream entries have, so a bad or hostile server can't take all of memory
+const (
+	redisMaxBulk  = 64 << 20
+	redisMaxArray = 1 << 20
+	redisMaxDepth = 16
+)
+
+// redisStream is a -redis-stream, e.g. redis://:pass@host:6379/events?db=2
+type redisStream struct {
+	addr     string
+	tls      bool
+	user     string
+	password string
+	db       int
+	key      string
+	// with a group, XREADGROUP as consumer; these share the stream's entries between them
+	group    string
+	consumer string
+	// the entry field that is the line, "" for the only field or a JSON object of them all
+	field string
+}
+
+func parseRedisStream(spec, group, consumer, field string) (*redisStream, error) {
+	u, err := url.Parse(spec)
+	if err != nil || u.Hostname() == "" || strings.Trim(u.Path, "/") == "" {
+		return nil, fmt.Errorf("-redis-stream: want redis[s]://[user:pass@]host[:port]/stream[?db=N]")
+	}
+	rs := &redisStream{key: strings.Trim(u.Path, "/"), group: group, consumer: consumer, field: field}
+	port := "6379"
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		rs.tls = true
+	default:
+		return nil, fmt.Errorf("-redis-stream: unknown scheme %q, want redis or rediss", u.Scheme)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	rs.addr = net.JoinHostPort(u.Hostname(), port)
+	if u.User != nil {
+		rs.user = u.User.Username()
+		rs.password, _ = u.User.Password()
+	}
+	if db := u.Query().Get("db"); db != "" {
+		rs.db, err = strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("-redis-stream: db=%s: %v", db, err)
+		}
+	}
+	if rs.group != "" && rs.consumer == "" {
+		hostname, _ := os.Hostname()
+		rs.consumer = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	return rs, nil
+}
+
+// name is the server and stream, for messages
+func (rs *redisStream) name() string {
+	return "redis " + rs.addr + "/" + rs.key
+}
+
+// redisConn reads a stream's entries
+type redisConn struct {
+	rs *redisStream
+	c  net.Conn
+	r  *bufio.Reader
+	// the last entry read without a group, "$" until setup finds the stream's last.
+	// A reconnect carries on from here, so nothing added while reconnecting is missed.
+	lastID string
+	// entries read but not yet returned by next
+	entries []message
+}
+
+// redisError is an error reply
+type redisError string
+
+func (re redisError) Error() string {
+	return string(re)
+}
+
+// dial connects, authenticates, and with a group makes sure the group exists
+func (rs *redisStream) dial(lastID string) (*redisConn, error) {
+	var c net.Conn
+	var err error
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	if rs.tls {
+		host, _, _ := net.SplitHostPort(rs.addr)
+		c, err = tls.DialWithDialer(d, "tcp", rs.addr, &tls.Config{ServerName: host})
+	} else {
+		c, err = d.Dial("tcp", rs.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{rs: rs, c: c, r: bufio.NewReaderSize(c, 64*1024), lastID: lastID}
+	if err := rc.setup(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return rc, nil
+}
+
+func (rc *redisConn) setup() error {
+	rs := rc.rs
+	if rs.password != "" {
+		args := []string{"AUTH", rs.password}
+		if rs.user != "" {
+			args = []string{"AUTH", rs.user, rs.password}
+		}
+		if _, err := rc.do(args...); err != nil {
+			return err
+		}
+	}
+	if rs.db != 0 {
+		if _, err := rc.do("SELECT", strconv.Itoa(rs.db)); err != nil {
+			return err
+		}
+	}
+	if rs.group != "" {
+		// new entries from now on, if the group is new
+		_, err := rc.do("XGROUP", "CREATE", rs.key, rs.group, "$", "MKSTREAM")
+		if re, ok := err.(redisError); ok && strings.HasPrefix(string(re), "BUSYGROUP") {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+	} else if rc.lastID == "$" {
+		// XREAD's $ is the end as of each XREAD, entries added between them would be missed
+		v, err := rc.do("XREVRANGE", rs.key, "+", "-", "COUNT", "1")
+		if err != nil {
+			return err
+		}
+		rc.lastID = "0-0"
+		if entries, _ := v.([]interface{}); len(entries) == 1 {
+			if e, _ := entries[0].([]interface{}); len(e) == 2 {
+				rc.lastID, _ = e[0].(string)
+			}
+		}
+	}
+	return nil
+}
+
+// do sends a command and reads its reply
+func (rc *redisConn) do(args ...string) (interface{}, error) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	rc.c.SetDeadline(time.Now().Add(redisBlock + 30*time.Second))
+	if _, err := io.WriteString(rc.c, sb.String()); err != nil {
+		return nil, err
+	}
+	return rc.reply(0)
+}
+
+// reply reads a RESP reply: a string, int64, []interface{}, nil, or a redisError
+func (rc *redisConn) reply(depth int) (interface{}, error) {
+	line, err := rc.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+	rest := line[1:]
+	switch line[0] {
+	case '+':
+		return rest, nil
+	case '-':
+		return nil, redisError(rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if n > redisMaxBulk {
+			return nil, fmt.Errorf("reply of %d bytes is too big", n)
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.r, b); err != nil {
+			return nil, err
+		}
+		if b[n] != '\r' || b[n+1] != '\n' {
+			return nil, fmt.Errorf("reply of %d bytes not ended by CRLF", n)
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if n > redisMaxArray {
+			return nil, fmt.Errorf("array reply of %d elements is too big", n)
+		}
+		if depth >= redisMaxDepth {
+			return nil, fmt.Errorf("reply arrays nested over %d deep", redisMaxDepth)
+		}
+		a := make([]interface{}, n)
+		for i := range a {
+			a[i], err = rc.reply(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
+
+func (rc *redisConn) next() (message, error) {
+	for len(rc.entries) == 0 {
+		if err := rc.read(); err != nil {
+			return message{}, err
+		}
+	}
+	m := rc.entries[0]
+	rc.entries = rc.entries[1:]
+	return m, nil
+}
+
+// read waits for entries. A group's are read with NOACK: a sample doesn't need them redelivered,
+// and unacknowledged they'd pile up in the group's pending list.
+func (rc *redisConn) read() error {
+	rs := rc.rs
+	block := strconv.FormatInt(redisBlock.Milliseconds(), 10)
+	var v interface{}
+	var err error
+	if rs.group != "" {
+		v, err = rc.do("XREADGROUP", "GROUP", rs.group, rs.consumer, "COUNT", "256", "BLOCK", block, "NOACK", "STREAMS", rs.key, ">")
+	} else {
+		v, err = rc.do("XREAD", "COUNT", "256", "BLOCK", block, "STREAMS", rs.key, rc.lastID)
+	}
+	if err != nil {
+		return err
+	}
+	// [[key, [[id, [field, value, ...]], ...]]], or nil when none came in time
+	streams, _ := v.([]interface{})
+	for _, stream := range streams {
+		kv, _ := stream.([]interface{})
+		if len(kv) != 2 {
+			return errors.New("unexpected XREAD reply")
+		}
+		entries, _ := kv[1].([]interface{})
+		for _, entry := range entries {
+			e, _ := entry.([]interface{})
+			if len(e) != 2 {
+				return errors.New("unexpected XREAD entry")
+			}
+			id, _ := e[0].(string)
+			fields, _ := e[1].([]interface{})
+			rc.lastID = id
+			if payload, ok := rs.payload(fields); ok {
+				rc.entries = append(rc.entries, message{subject: rs.key, payload: payload})
+			}
+		}
+	}
+	return nil
+}
+
+// payload is the line of an entry's fields, false if it doesn't have -redis-field
+func (rs *redisStream) payload(fields []interface{}) ([]byte, bool) {
+	if rs.field != "" {
+		for i := 0; i+1 < len(fields); i += 2 {
+			if f, _ := fields[i].(string); f == rs.field {
+				v, _ := fields[i+1].(string)
+				return []byte(v), true
+			}
+		}
+		return nil, false
+	}
+	if len(fields) == 2 {
+		v, _ := fields[1].(string)
+		return []byte(v), true
+	}
+	obj := make(map[string]string, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		f, _ := fields[i].(string)
+		obj[f], _ = fields[i+1].(string)
+	}
+	b, _ := json.Marshal(obj)
+	return b, true
+}
+
+func (rc *redisConn) buffered() int {
+	return len(rc.entries) + rc.r.Buffered()
+}
+
+func (rc *redisConn) close() {
+	rc.c.Close()
+}
+
+// dialer is messageSource's dial, resuming from the last entry a previous connection read
+func (rs *redisStream) dialer() func() (brokerConn, error) {
+	lastID := "$"
+	var last *redisConn
+	return func() (brokerConn, error) {
+		if last != nil {
+			lastID = last.lastID
+		}
+		rc, err := rs.dial(lastID)
+		if err != nil {
+			return nil, err
+		}
+		last = rc
+		return rc, nil
+	}
+}
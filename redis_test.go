@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func redisReply(s string) (interface{}, error) {
+	rc := &redisConn{r: bufio.NewReader(strings.NewReader(s))}
+	return rc.reply(0)
+}
+
+func TestRedisReply(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want interface{}
+	}{
+		{"+OK\r\n", "OK"},
+		{":42\r\n", int64(42)},
+		{":-1\r\n", int64(-1)},
+		{"$5\r\nhello\r\n", "hello"},
+		{"$0\r\n\r\n", ""},
+		{"$-1\r\n", nil},
+		{"$4\r\na\r\nb\r\n", "a\r\nb"},
+		{"*-1\r\n", nil},
+		{"*0\r\n", []interface{}{}},
+		{"*2\r\n$1\r\na\r\n:1\r\n", []interface{}{"a", int64(1)}},
+		{"*1\r\n*2\r\n+x\r\n$-1\r\n", []interface{}{[]interface{}{"x", nil}}},
+		{strings.Repeat("*1\r\n", redisMaxDepth) + ":1\r\n", nest(redisMaxDepth, int64(1))},
+	} {
+		got, err := redisReply(tc.in)
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q = %#v, want %#v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func nest(depth int, v interface{}) interface{} {
+	for ; depth > 0; depth-- {
+		v = []interface{}{v}
+	}
+	return v
+}
+
+func TestRedisReplyErrors(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string
+	}{
+		{"", "EOF"},
+		{"\r\n", "empty reply"},
+		{"+OK", "EOF"},
+		{"-ERR no such key\r\n", "ERR no such key"},
+		{":x\r\n", "invalid syntax"},
+		{"$x\r\n", "invalid syntax"},
+		{"$5\r\nhi\r\n", "EOF"},
+		{"$2\r\nhello\r\n", "not ended by CRLF"},
+		{"$67108865\r\n", "too big"},
+		{"$99999999999999999999\r\n", "out of range"},
+		{"*1048577\r\n", "too big"},
+		{"*3\r\n:1\r\n", "EOF"},
+		{"*1\r\n!x\r\n", "unexpected reply"},
+		{strings.Repeat("*1\r\n", redisMaxDepth+1) + ":1\r\n", "nested"},
+	} {
+		v, err := redisReply(tc.in)
+		if err == nil {
+			t.Errorf("%q = %#v, want an error", tc.in, v)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: %v, want %q", tc.in, err, tc.want)
+		}
+	}
+	// an error reply is a redisError, so setup can look at it
+	_, err := redisReply("-BUSYGROUP Consumer Group name already exists\r\n")
+	var re redisError
+	if !errors.As(err, &re) {
+		t.Errorf("error reply is %T, want redisError", err)
+	}
+}
+
+// fakeRedis serves one connection, answering each command with the next reply,
+// and returns the commands it got
+func fakeRedis(t *testing.T, replies ...string) (*redisConn, <-chan []interface{}) {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+	cmds := make(chan []interface{}, len(replies))
+	go func() {
+		defer close(cmds)
+		sc := &redisConn{r: bufio.NewReader(server)}
+		for _, reply := range replies {
+			cmd, err := sc.reply(0)
+			if err != nil {
+				return
+			}
+			cmds <- cmd.([]interface{})
+			if _, err := server.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+	}()
+	return &redisConn{c: client, r: bufio.NewReader(client)}, cmds
+}
+
+func TestRedisRead(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		rs    redisStream
+		reply string
+		cmd   string
+		want  []string
+		id    string
+	}{
+		{"one field", redisStream{key: "s"},
+			"*1\r\n*2\r\n$1\r\ns\r\n*2\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\nm\r\n$2\r\nhi\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nm\r\n$2\r\nho\r\n",
+			"XREAD COUNT 256 BLOCK 5000 STREAMS s 0-0", []string{"hi", "ho"}, "2-0"},
+		{"all fields", redisStream{key: "s"},
+			"*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n",
+			"XREAD COUNT 256 BLOCK 5000 STREAMS s 0-0", []string{`{"a":"1","b":"2"}`}, "1-0"},
+		{"-redis-field", redisStream{key: "s", field: "b"},
+			"*1\r\n*2\r\n$1\r\ns\r\n*2\r\n*2\r\n$3\r\n1-0\r\n*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\na\r\n$1\r\n3\r\n",
+			"XREAD COUNT 256 BLOCK 5000 STREAMS s 0-0", []string{"2"}, "2-0"},
+		{"group", redisStream{key: "s", group: "g", consumer: "c"},
+			"*-1\r\n",
+			"XREADGROUP GROUP g c COUNT 256 BLOCK 5000 NOACK STREAMS s >", nil, "0-0"},
+	} {
+		rc, cmds := fakeRedis(t, tc.reply)
+		rc.rs, rc.lastID = &tc.rs, "0-0"
+		if err := rc.read(); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if cmd := <-cmds; redisJoin(cmd) != tc.cmd {
+			t.Errorf("%s: sent %q, want %q", tc.name, redisJoin(cmd), tc.cmd)
+		}
+		var got []string
+		for _, m := range rc.entries {
+			got = append(got, string(m.payload))
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: read %q, want %q", tc.name, got, tc.want)
+		}
+		if rc.lastID != tc.id {
+			t.Errorf("%s: last ID %q, want %q", tc.name, rc.lastID, tc.id)
+		}
+	}
+}
+
+func redisJoin(cmd []interface{}) string {
+	var args []string
+	for _, a := range cmd {
+		args = append(args, a.(string))
+	}
+	return strings.Join(args, " ")
+}
+
+func TestRedisSetup(t *testing.T) {
+	// a new reader without a group starts from the stream's last entry
+	rc, cmds := fakeRedis(t, "+OK\r\n", "+OK\r\n", "*1\r\n*2\r\n$3\r\n7-1\r\n*0\r\n")
+	rc.rs = &redisStream{key: "s", user: "u", password: "p", db: 2}
+	rc.lastID = "$"
+	if err := rc.setup(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"AUTH u p", "SELECT 2", "XREVRANGE s + - COUNT 1"} {
+		if got := redisJoin(<-cmds); got != want {
+			t.Errorf("sent %q, want %q", got, want)
+		}
+	}
+	if rc.lastID != "7-1" {
+		t.Errorf("last ID %q, want 7-1", rc.lastID)
+	}
+
+	// a group that's there already is fine
+	rc, _ = fakeRedis(t, "-BUSYGROUP Consumer Group name already exists\r\n")
+	rc.rs = &redisStream{key: "s", group: "g", consumer: "c"}
+	if err := rc.setup(); err != nil {
+		t.Errorf("existing group: %v", err)
+	}
+	rc, _ = fakeRedis(t, "-WRONGPASS invalid username-password pair\r\n")
+	rc.rs = &redisStream{key: "s", password: "p"}
+	if err := rc.setup(); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("bad password: %v", err)
+	}
+}
+
+func TestParseRedisStream(t *testing.T) {
+	rs, err := parseRedisStream("rediss://u:p@host:6380/a/b?db=3", "g", "", "msg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.addr != "host:6380" || !rs.tls || rs.user != "u" || rs.password != "p" || rs.db != 3 || rs.key != "a/b" || rs.consumer == "" {
+		t.Errorf("parsed %+v", rs)
+	}
+	rs, err = parseRedisStream("redis://[::1]/s", "", "", "")
+	if err != nil || rs.addr != "[::1]:6379" {
+		t.Errorf("parsed %+v, %v", rs, err)
+	}
+	for _, spec := range []string{"redis://host", "redis:///s", "http://host/s", "redis://host/s?db=x", "::"} {
+		if _, err := parseRedisStream(spec, "", "", ""); err == nil {
+			t.Errorf("%q: no error", spec)
+		}
+	}
+}
+
+func FuzzRedisReply(f *testing.F) {
+	for _, s := range []string{
+		"+OK\r\n",
+		":12\r\n",
+		"$3\r\nabc\r\n",
+		"$-1\r\n",
+		"*2\r\n$1\r\na\r\n*1\r\n:1\r\n",
+		"-ERR x\r\n",
+		"*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\nm\r\n$2\r\nhi\r\n",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		v, err := redisReply(s)
+		if err != nil {
+			return
+		}
+		// a whole reply is at least as long as what it holds
+		var size func(v interface{}) int
+		size = func(v interface{}) int {
+			switch v := v.(type) {
+			case string:
+				return len(v)
+			case []interface{}:
+				n := 0
+				for _, e := range v {
+					n += size(e) + 1
+				}
+				return n
+			}
+			return 0
+		}
+		if n := size(v); n > len(s) {
+			t.Errorf("%q read as %d bytes of values", s, n)
+		}
+		rs := &redisStream{}
+		if a, ok := v.([]interface{}); ok {
+			rs.payload(a)
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// reloadable are the flags a SIGHUP re-reads from the -config file, changes to others are warned about
+var reloadable = map[string]bool{
+	"match": true, "exclude": true, "min-len": true, "max-len": true, "skip-blank": true,
+	"field": true, "sep": true, "json-field": true, "logfmt-field": true, "clf": true,
+	"redact": true, "hash": true, "hash-salt": true, "strip-ansi": true, "strip-cr": true, "strip-bom": true,
+	"invalid-utf8": true, "route": true, "filter": true, "transform": true, "wasm": true,
+	"a": true, "l": true, "collector": true,
+}
+
+// configReload re-applies the -config file on SIGHUP, without touching the samples or listeners
+type configReload struct {
+	path string
+	fs   *flag.FlagSet
+	// set on the command line or by SSAMPLE_ variables, which the file doesn't override
+	pinned map[string]bool
+	// values of the flags from the file as last applied
+	fromFile map[string][]string
+
+	// apply puts the changed flags into effect, main sets it.
+	// "l" isn't set in fs since it's the running collector's own field, apply gets its value in changed.
+	apply func(changed map[string][]string) error
+
+	l sync.Mutex
+}
+
+// mainConfig is set by parseMainFlags when there's a -config file
+var mainConfig *configReload
+
+// readConfigValues reads a -config file into flag name -> values, checking the flags exist in fs
+func readConfigValues(fs *flag.FlagSet, path string) (map[string][]string, error) {
+	fin, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("-config: %v", err)
+	}
+	defer fin.Close()
+	entries, err := parseConfig(bufio.NewScanner(fin), path)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]string)
+	for _, ent := range entries {
+		name := strings.ReplaceAll(ent.key, "_", "-")
+		if fs.Lookup(name) == nil || name == "config" {
+			return nil, fmt.Errorf("%s:%d: no flag -%s for %s", path, ent.line, name, fs.Name())
+		}
+		out[name] = ent.values
+	}
+	return out, nil
+}
+
+func newConfigReload(fs *flag.FlagSet, path string, pinned map[string]bool) (*configReload, error) {
+	values, err := readConfigValues(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	for name := range pinned {
+		delete(values, name)
+	}
+	return &configReload{path: path, fs: fs, pinned: pinned, fromFile: values}, nil
+}
+
+// setFlag sets a flag to values, or back to its default for none
+func setFlag(fs *flag.FlagSet, name string, values []string) error {
+	f := fs.Lookup(name)
+	if sl, ok := f.Value.(*stringList); ok {
+		*sl = nil
+		for _, v := range values {
+			sl.Set(v)
+		}
+		return nil
+	}
+	if len(values) == 0 {
+		return f.Value.Set(f.DefValue)
+	}
+	if len(values) != 1 {
+		return fmt.Errorf("-%s takes one value", name)
+	}
+	return f.Value.Set(values[0])
+}
+
+// reload reads the file again and applies what changed. On an error nothing changes.
+func (cr *configReload) reload() {
+	cr.l.Lock()
+	defer cr.l.Unlock()
+	values, err := readConfigValues(cr.fs, cr.path)
+	if err != nil {
+		errorf("reload: %v", err)
+		return
+	}
+	names := make(map[string]bool)
+	for name := range values {
+		names[name] = true
+	}
+	for name := range cr.fromFile {
+		names[name] = true
+	}
+	changed := make(map[string][]string)
+	var restart []string
+	for name := range names {
+		if cr.pinned[name] {
+			continue
+		}
+		if strings.Join(values[name], "\x00") == strings.Join(cr.fromFile[name], "\x00") {
+			continue
+		}
+		if !reloadable[name] {
+			restart = append(restart, "-"+name)
+			continue
+		}
+		changed[name] = values[name]
+	}
+	sort.Strings(restart)
+	if len(restart) != 0 {
+		warnf("reload: %s changed, restart to apply", strings.Join(restart, ", "))
+	}
+	if len(changed) == 0 {
+		infof("reload: %s: nothing to apply", cr.path)
+		return
+	}
+	// remember the old values to put back on an error
+	old := make(map[string][]string)
+	for name, vs := range changed {
+		if name == "l" {
+			continue
+		}
+		old[name] = cr.fromFile[name]
+		if err := setFlag(cr.fs, name, vs); err != nil {
+			errorf("reload: -%s: %v", name, err)
+			cr.restore(old)
+			return
+		}
+	}
+	if err := cr.apply(changed); err != nil {
+		errorf("reload: %v", err)
+		cr.restore(old)
+		return
+	}
+	for name := range changed {
+		if v, ok := values[name]; ok {
+			cr.fromFile[name] = v
+		} else {
+			delete(cr.fromFile, name)
+		}
+	}
+	applied := make([]string, 0, len(changed))
+	for name := range changed {
+		applied = append(applied, "-"+name)
+	}
+	sort.Strings(applied)
+	infof("reload: applied %s", strings.Join(applied, ", "))
+}
+
+func (cr *configReload) restore(old map[string][]string) {
+	for name, vs := range old {
+		setFlag(cr.fs, name, vs)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssample.toml")
+	write := func(config string) {
+		if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs := flag.NewFlagSet("ssample", flag.ContinueOnError)
+	match := fs.String("match", "", "")
+	fs.Int("l", 100, "")
+	write("match = \"a\"\nl = 10\n")
+	fs.Set("match", "a")
+	cr, err := newConfigReload(fs, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCollector(10, "")
+	for i := 0; i < 20; i++ {
+		c.AddLine("x")
+	}
+	var applied []map[string][]string
+	cr.apply = func(changed map[string][]string) error {
+		applied = append(applied, changed)
+		if vs, ok := changed["l"]; ok && vs[0] == "50" {
+			return c.Resize(50)
+		}
+		return nil
+	}
+
+	// growing after lines were dropped fails, and the new -match goes back too
+	write("match = \"b\"\nl = 50\n")
+	cr.reload()
+	if len(applied) != 1 || *match != "a" || cr.fromFile["l"][0] != "10" || cr.fromFile["match"][0] != "a" {
+		t.Errorf("after a failed reload: -match %q, from the file %q", *match, cr.fromFile)
+	}
+	// so the next reload tries again
+	cr.reload()
+	if len(applied) != 2 {
+		t.Errorf("%d applies, want 2", len(applied))
+	}
+	c.Reset()
+	cr.reload()
+	if len(applied) != 3 || *match != "b" || cr.fromFile["l"][0] != "50" || c.LinesToKeep != 50 {
+		t.Errorf("after a reset: -match %q, -l %d, from the file %q", *match, c.LinesToKeep, cr.fromFile)
+	}
+	// nothing changed, nothing applied
+	cr.reload()
+	if len(applied) != 3 {
+		t.Errorf("%d applies, want 3", len(applied))
+	}
+}
+
+func TestCanResize(t *testing.T) {
+	c := NewCollector(4, "")
+	for i := 0; i < 4; i++ {
+		c.AddLine("x")
+	}
+	if err := c.CanResize(8); err != nil {
+		t.Errorf("growing before any were dropped: %v", err)
+	}
+	c.AddLine("x")
+	for _, n := range []int{2, 4} {
+		if err := c.CanResize(n); err != nil {
+			t.Errorf("CanResize(%d): %v", n, err)
+		}
+	}
+	if err := c.CanResize(8); err == nil {
+		t.Errorf("growing after lines were dropped: no error")
+	}
+	if err := c.Resize(8); err == nil {
+		t.Errorf("Resize(8) after lines were dropped: no error")
+	}
+	if c.LinesToKeep != 4 {
+		t.Errorf("LinesToKeep %d after a refused Resize", c.LinesToKeep)
+	}
+}
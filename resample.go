@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// resampleFile adds the lines of an -a or -teez archive to c, skipping tee marks
+func resampleFile(c *Collector, path string, maxLineBytes int) error {
+	fin, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fin.Close()
+	br, err := gunzipMaybe(fin)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if err := resampleLines(c, br, maxLineBytes); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// resampleLines adds the lines of r to c, skipping tee marks
+func resampleLines(c *Collector, r io.Reader, maxLineBytes int) error {
+	prefix := []byte(teeMarkPrefix)
+	lr := newLineReader(r, maxLineBytes)
+	for lr.Scan() {
+		line := lr.Bytes()
+		if bytes.HasPrefix(line, prefix) {
+			continue
+		}
+		c.AddBytes(line)
+	}
+	return lr.Err()
+}
+
+// resampleChunkMin is the smallest chunk -j splits a file into
+const resampleChunkMin = 16 << 20
+
+// resampleChunks samples an archive in up to jobs chunks at once, each read by its own goroutine
+// into its own Collector, returning their samples in order with line numbers counted from base.
+// A gzipped or small file is one chunk.
+func resampleChunks(path string, keep, maxLineBytes, jobs, base int) ([]*loadedSample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 2)
+	n, _ := f.ReadAt(magic, 0)
+	gzipped := n == 2 && magic[0] == 0x1f && magic[1] == 0x8b
+	// chunk i is [starts[i], starts[i+1])
+	starts := []int64{0}
+	if st.Mode().IsRegular() && !gzipped {
+		chunks := min(int64(jobs), st.Size()/resampleChunkMin)
+		for i := int64(1); i < chunks; i++ {
+			// each chunk after the first starts after a newline
+			at, err := nextLineStart(f, st.Size()*i/chunks, st.Size())
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			if at > starts[len(starts)-1] && at < st.Size() {
+				starts = append(starts, at)
+			}
+		}
+	}
+	starts = append(starts, st.Size())
+	parts := make([]*Collector, len(starts)-1)
+	errs := make([]error, len(parts))
+	if len(parts) == 1 {
+		parts[0] = NewCollector(keep, "resample")
+		br, err := gunzipMaybe(f)
+		if err == nil {
+			err = resampleLines(parts[0], br, maxLineBytes)
+		}
+		errs[0] = err
+	} else {
+		// made in order, for -seed
+		for i := range parts {
+			parts[i] = NewCollector(keep, "resample")
+		}
+		var wg sync.WaitGroup
+		for i := range parts {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = resampleLines(parts[i], io.NewSectionReader(f, starts[i], starts[i+1]-starts[i]), maxLineBytes)
+			}()
+		}
+		wg.Wait()
+	}
+	samples := make([]*loadedSample, len(parts))
+	for i, c := range parts {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("%s: %v", path, errs[i])
+		}
+		records, stats, window := c.Records()
+		for j := range records {
+			records[j].LineNumber += base
+			// mergeSamples names them after the sample
+			records[j].Source = ""
+		}
+		base += stats.LinesSeen
+		samples[i] = &loadedSample{Name: "resample", Capacity: keep, LinesSeen: stats.LinesSeen, BytesSeen: stats.BytesSeen, Records: records, Window: window}
+	}
+	return samples, nil
+}
+
+// nextLineStart is the offset of the first line starting at or after at
+func nextLineStart(f *os.File, at, size int64) (int64, error) {
+	if at == 0 {
+		return 0, nil
+	}
+	buf := make([]byte, 64*1024)
+	for pos := at - 1; pos < size; {
+		n, err := f.ReadAt(buf, pos)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return pos + int64(i) + 1, nil
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		pos += int64(n)
+	}
+	return size, nil
+}
+
+// resampleMain is `ssample resample [-l N] archive ...`
+func resampleMain(args []string) {
+	fs := flag.NewFlagSet("resample", flag.ContinueOnError)
+	keep := countFlag(fs, "l", 100, "lines to keep")
+	outPath := fs.String("o", "", "write the sample as json here instead of text to stdout")
+	maxLineBytes := countFlag(fs, "max-line-bytes", defaultMaxLineBytes, "truncate longer lines to this many bytes")
+	seed := fs.Uint64("seed", 0, "seed the sampling to get the same sample every time, 0 for a random one")
+	jobs := fs.Int("j", runtime.NumCPU(), "read an uncompressed archive in this many chunks at once, each sampled on its own and then merged")
+	fs.BoolVar(&collapseDups, "collapse", false, "print identical sampled lines once, with an x{count} column after the line number")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s resample [-l N] [-o out.json] archive ...\n\nSample again from -a or -teez archives (gzipped or not), read in order as one input.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(exitStart)
+	}
+	if *seed != 0 {
+		seedCollectors(*seed)
+	}
+	c := NewCollector(*keep, "resample")
+	if *jobs <= 1 {
+		for _, path := range fs.Args() {
+			err := resampleFile(c, path, *maxLineBytes)
+			maybefail(err, "%v\n", err)
+		}
+	} else {
+		// each chunk's sample stands for its lines, merging them is a sample of them all
+		var samples []*loadedSample
+		base := 0
+		for _, path := range fs.Args() {
+			parts, err := resampleChunks(path, *keep, *maxLineBytes, *jobs, base)
+			maybefail(err, "%v\n", err)
+			for _, ls := range parts {
+				base += ls.LinesSeen
+			}
+			samples = append(samples, parts...)
+		}
+		merged := mergeSamples(samples, *keep, rand.New(newCollectorPCG()))
+		blob, err := json.Marshal(stateFromSample(merged))
+		if err == nil {
+			err = c.RestoreState(blob)
+		}
+		maybefail(err, "%v\n", err)
+	}
+	if *outPath != "" {
+		err := dumpSample(c, *outPath)
+		maybefail(err, "%s: %v\n", *outPath, err)
+		return
+	}
+	printSample(c.LinesAndNumbers())
+}
@@ -0,0 +1,102 @@
+package main
+
+import "net/http"
+
+// route is an http endpoint and its description for /v1/openapi.json
+type route struct {
+	path string
+	// "" for GET
+	method  string
+	summary string
+	params  []routeParam
+	// json response body, nil if the response isn't json
+	response interface{}
+	// other response content types
+	produces []string
+
+	handler http.Handler
+}
+
+type routeParam struct {
+	name string
+	// openapi type: string, integer, number, boolean
+	typ  string
+	desc string
+}
+
+var sampleQueryParams = []routeParam{
+	{"t", "boolean", "text \"{lineNumber}\\t{line}\\n\""},
+	{"p", "boolean", "plain \"{line}\\n\""},
+	{"since", "integer", "only lines numbered after this"},
+	{"match", "string", "only lines matching this regex"},
+	{"exclude", "string", "only lines not matching this regex"},
+	{"n", "integer", "a uniform random n of the sampled lines"},
+}
+
+func gz(hf http.HandlerFunc) http.Handler {
+	return &gzipHandler{hf}
+}
+
+// routeTable is the endpoints for one collector
+func (s *ssampleServer) routeTable() []route {
+	return []route{
+		{path: "/", summary: "current sample", params: sampleQueryParams, response: LineNoResponse{},
+			produces: []string{"text/plain", "text/tab-separated-values", "text/csv", "application/octet-stream"}, handler: &gzipHandler{s}},
+		{path: "/download", summary: "sample as an attachment",
+			params:   append([]routeParam{{"fmt", "string", "csv (default), tsv, json, txt, or raw (NUL terminated)"}}, sampleQueryParams...),
+			produces: []string{"text/csv", "text/tab-separated-values", "application/json", "text/plain", "application/octet-stream"}, handler: gz(s.download)},
+		{path: "/reset", method: http.MethodPost, summary: "clear the sample and counters; without auth or -admin-http, needs an X-Ssample-Reset header",
+			params: []routeParam{{"final", "boolean", "respond with the sample from before the reset"}}, response: LineNoResponse{},
+			produces: []string{"text/plain"}, handler: gz(s.reset)},
+		{path: "/wait", summary: "long poll, respond like / after more lines have been seen or a timeout",
+			params: append([]routeParam{
+				{"lines", "integer", "new lines to wait for, default 1"},
+				{"timeout", "string", "duration, default 30s, max 5m"},
+			}, sampleQueryParams...), response: LineNoResponse{},
+			produces: []string{"text/plain", "text/tab-separated-values", "text/csv"}, handler: gz(s.wait)},
+		{path: "/snapshot", method: http.MethodPost, summary: "write the sample to a file in -snapshot-dir",
+			params: append([]routeParam{{"name", "string", "file name"}}, sampleQueryParams...), produces: []string{"text/plain"},
+			handler: http.HandlerFunc(s.snapshot)},
+		{path: "/events", summary: "server-sent events: insert, evict, reset, lost", produces: []string{"text/event-stream"},
+			handler: http.HandlerFunc(s.events)},
+		{path: "/ws", summary: "websocket of input lines as they arrive, json TappedLine messages",
+			params: []routeParam{{"rate", "number", "fraction of lines to send, (0,1]"}}, handler: http.HandlerFunc(s.wsTail)},
+		{path: "/ui", summary: "html dashboard", produces: []string{"text/html"}, handler: gz(ui)},
+		{path: "/v1/sample", summary: "sample with per-line metadata", response: V1Sample{}, handler: gz(s.v1SampleHandler)},
+		{path: "/v1/records", summary: "-records as a JSON array of objects",
+			params:   append([]routeParam{{"fields", "string", "comma separated dotted paths to keep of each record, e.g. user.id,status"}}, sampleQueryParams...),
+			response: []map[string]interface{}{}, handler: gz(s.v1Records)},
+		{path: "/v1/changes", summary: "insertions and evictions since a token, to keep an exact mirror of the sample",
+			params:   []routeParam{{"token", "string", "from the last response; without one, or if too far behind, the whole sample with resync"}},
+			response: V1Changes{}, handler: gz(s.v1Changes)},
+		{path: "/v1/estimate", summary: "estimated count of all input lines matching a regex, with a confidence interval",
+			params: []routeParam{
+				{"match", "string", "regex"},
+				{"confidence", "number", "confidence level, default 0.95"},
+				{"t", "boolean", "a line of text"},
+			}, response: Estimate{}, produces: []string{"text/plain"}, handler: http.HandlerFunc(s.estimate)},
+		{path: "/v1/templates", summary: "-templates message shapes, most common first, with example lines",
+			params:   []routeParam{{"t", "boolean", "text \"{count}\\t{template}\\n\" each followed by \"\\t{example}\\n\""}},
+			response: V1Templates{}, produces: []string{"text/plain"}, handler: gz(s.v1TemplatesHandler)},
+	}
+}
+
+// addRoutes registers routes on mux.
+// A path listed for several methods has one handler, routes with a nil handler are documentation only.
+func addRoutes(mux *http.ServeMux, routes []route) {
+	done := make(map[string]bool)
+	for _, rt := range routes {
+		if done[rt.path] || rt.handler == nil {
+			continue
+		}
+		done[rt.path] = true
+		mux.Handle(rt.path, rt.handler)
+	}
+}
+
+// routes are the endpoints for one collector
+func (s *ssampleServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	addRoutes(mux, s.routeTable())
+	return mux
+}
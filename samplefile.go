@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// loadedSample is a sample read from a -state file, a /snapshot, or saved / or /v1/sample json
+type loadedSample struct {
+	Name string
+	// the sampleHeader algorithm, "" for older files
+	Algorithm string
+	Capacity  int
+	LinesSeen int
+	BytesSeen int64
+	Records   []SampleRecord
+	Window    V1Window
+	// the process that made it, nil for older files
+	Host *V1Host
+	// lines and bytes seen from each input, if there was more than one
+	Sources []SourceCount
+}
+
+func readSampleFile(path string) (*loadedSample, error) {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ls, err := parseSample(blob)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	ls.Name = path
+	return ls, nil
+}
+
+// mergeable returns an error if ls isn't a uniform sample of its input, the only kind mergeSamples can combine
+func (ls *loadedSample) mergeable() error {
+	if !uniformAlgorithm(ls.Algorithm) {
+		return fmt.Errorf("can't merge a %s sample, its lines aren't equally likely; only reservoir samples merge", ls.Algorithm)
+	}
+	return nil
+}
+
+func parseSample(blob []byte) (*loadedSample, error) {
+	err := checkSampleHeader(blob)
+	if err != nil {
+		return nil, err
+	}
+	var raw struct {
+		Algorithm   string          `json:"algorithm"`
+		LinesToKeep int             `json:"linesToKeep"`
+		Capacity    int             `json:"capacity"`
+		Source      string          `json:"source"`
+		Seen        *int            `json:"seen"`
+		BytesSeen   int64           `json:"bytesSeen"`
+		Lines       json.RawMessage `json:"lines"`
+		LineNumbers []int           `json:"lineNumbers"`
+		LineTimes   []time.Time     `json:"lineTimes"`
+		Start       time.Time       `json:"start"`
+		End         time.Time       `json:"end"`
+		Window      *V1Window       `json:"window"`
+		Host        *V1Host         `json:"host"`
+		Sources     []SourceCount   `json:"sources"`
+	}
+	err = json.Unmarshal(blob, &raw)
+	if err != nil {
+		return nil, err
+	}
+	if raw.Seen == nil || raw.Lines == nil {
+		return nil, errors.New("not a sample, need \"seen\" and \"lines\"")
+	}
+	ls := &loadedSample{
+		Algorithm: raw.Algorithm,
+		Capacity:  raw.Capacity,
+		LinesSeen: *raw.Seen,
+		BytesSeen: raw.BytesSeen,
+		Host:      raw.Host,
+		Sources:   raw.Sources,
+	}
+	if ls.Capacity == 0 {
+		ls.Capacity = raw.LinesToKeep
+	}
+	if raw.Window != nil {
+		ls.Window = *raw.Window
+	} else {
+		ls.Window.Start = raw.Start
+		ls.Window.End = raw.End
+	}
+	var lines []string
+	if json.Unmarshal(raw.Lines, &lines) == nil {
+		if len(raw.LineNumbers) != len(lines) {
+			return nil, errors.New("lines and lineNumbers differ in length")
+		}
+		ls.Records = make([]SampleRecord, len(lines))
+		for i, line := range lines {
+			ls.Records[i] = SampleRecord{LineNumber: raw.LineNumbers[i], Line: line, Source: raw.Source}
+			if len(raw.LineTimes) == len(lines) {
+				ls.Records[i].Time = raw.LineTimes[i]
+			}
+		}
+	} else {
+		err = json.Unmarshal(raw.Lines, &ls.Records)
+		if err != nil {
+			return nil, fmt.Errorf("lines: %v", err)
+		}
+	}
+	for i := range ls.Records {
+		rec := &ls.Records[i]
+		if rec.Bytes == 0 {
+			// from before records had bytes
+			rec.Bytes = len(rec.Line)
+			if rec.LineBase64 != nil {
+				rec.Bytes = len(rec.LineBase64)
+			}
+		}
+	}
+	if ls.Capacity == 0 {
+		ls.Capacity = len(ls.Records)
+	}
+	if ls.Window.End.IsZero() {
+		// from before states saved their end, the latest line is as close as it gets
+		for _, rec := range ls.Records {
+			if rec.Time.After(ls.Window.End) {
+				ls.Window.End = rec.Time
+			}
+		}
+	}
+	if len(ls.Records) > ls.LinesSeen {
+		return nil, fmt.Errorf("%d lines but only %d seen", len(ls.Records), ls.LinesSeen)
+	}
+	return ls, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sdNotify sends state to systemd's $NOTIFY_SOCKET, e.g. "READY=1".
+// It does nothing when not run by systemd with Type=notify or WatchdogSec.
+func sdNotify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	if path[0] == '@' {
+		// abstract namespace
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("NOTIFY_SOCKET: %v", err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval is how often systemd wants WATCHDOG=1, 0 if it doesn't
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// sdWatchdog pings systemd's watchdog at half its interval for as long as c responds,
+// with the lines seen as the unit's status
+func sdWatchdog(c *Collector, interval time.Duration) {
+	for range time.Tick(interval / 2) {
+		// takes c's lock, so a wedged collector stops the pings and systemd restarts ssample
+		seen := c.Seen()
+		err := sdNotify(fmt.Sprintf("WATCHDOG=1\nSTATUS=%d lines seen", seen))
+		if err != nil {
+			errorf("watchdog: %v", err)
+		}
+	}
+}
+
+// sdSockets are the sockets systemd passed for socket activation, fds from 3 on
+var sdSockets struct {
+	once  sync.Once
+	files []*os.File
+	// LISTEN_FDNAMES, FileDescriptorName= in the .socket unit
+	names []string
+	used  []bool
+	l     sync.Mutex
+}
+
+func sdLoadSockets() {
+	if pid := os.Getenv("LISTEN_PID"); pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		sdSockets.files = append(sdSockets.files, os.NewFile(uintptr(3+i), name))
+		sdSockets.names = append(sdSockets.names, name)
+	}
+	sdSockets.used = make([]bool, n)
+	// not for -exec children
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+}
+
+// sdListen returns a listener on a socket from systemd: the one named name, or with "" the next not yet used
+func sdListen(name string) (net.Listener, error) {
+	sdSockets.once.Do(sdLoadSockets)
+	sdSockets.l.Lock()
+	defer sdSockets.l.Unlock()
+	if len(sdSockets.files) == 0 {
+		return nil, fmt.Errorf("no sockets from systemd (LISTEN_FDS), run from a .socket unit")
+	}
+	for i, f := range sdSockets.files {
+		if sdSockets.used[i] || (name != "" && sdSockets.names[i] != name) {
+			continue
+		}
+		sdSockets.used[i] = true
+		// FileListener dups the fd
+		ln, err := net.FileListener(f)
+		f.Close()
+		return ln, err
+	}
+	if name != "" {
+		return nil, fmt.Errorf("no socket named %q from systemd, have %s", name, strings.Join(sdSockets.names, ", "))
+	}
+	return nil, fmt.Errorf("all %d sockets from systemd are in use", len(sdSockets.files))
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"sort"
+)
+
+// seekChunk is how much seek reads at a time looking for the ends of a line
+const seekChunk = 64 * 1024
+
+// seekPilot is how many draws -correct reject takes to find the shortest line
+const seekPilot = 100
+
+// seekFile is a file `ssample seek` samples, at base in the offsets of all of them
+type seekFile struct {
+	path string
+	f    *os.File
+	size int64
+	base int64
+}
+
+// seekLine is the line a random offset landed in
+type seekLine struct {
+	file  *seekFile
+	start int64
+	line  []byte
+	// bytes with its newline, which the line's chance of being landed in is proportional to
+	span int64
+}
+
+// lineAt finds the line byte off is in, false if it's longer than max bytes
+func (sf *seekFile) lineAt(off int64, max int, buf []byte) (seekLine, bool, error) {
+	start := int64(0)
+	for pos := off; pos > 0; {
+		from := pos - min(pos, seekChunk)
+		n, err := sf.f.ReadAt(buf[:pos-from], from)
+		if err != nil && err != io.EOF {
+			return seekLine{}, false, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			start = from + int64(i) + 1
+			break
+		}
+		if off-from > int64(max) {
+			return seekLine{}, false, nil
+		}
+		pos = from
+	}
+	sl := seekLine{file: sf, start: start}
+	for pos := start; pos < sf.size; {
+		n, err := sf.f.ReadAt(buf[:min(seekChunk, sf.size-pos)], pos)
+		if err != nil && err != io.EOF {
+			return seekLine{}, false, err
+		}
+		if n == 0 {
+			break
+		}
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			sl.line = append(sl.line, buf[:i]...)
+			sl.span = int64(len(sl.line)) + 1
+			break
+		}
+		sl.line = append(sl.line, buf[:n]...)
+		pos += int64(n)
+		if len(sl.line) > max {
+			return seekLine{}, false, nil
+		}
+	}
+	if sl.span == 0 {
+		// the last line, with no newline
+		sl.span = int64(len(sl.line))
+	}
+	if len(sl.line) > max {
+		return seekLine{}, false, nil
+	}
+	return sl, true, nil
+}
+
+// lineSeeker draws lines at uniformly random byte offsets of a set of files
+type lineSeeker struct {
+	files []*seekFile
+	total int64
+	max   int
+	rng   *rand.Rand
+	buf   []byte
+
+	draws, tooLong int
+	// sum of 1/span over draws, for estimating how many lines there are
+	invSpan float64
+}
+
+// draw lands on a line. Lines are landed in in proportion to their length; -correct undoes that.
+func (ls *lineSeeker) draw() (seekLine, bool, error) {
+	off := ls.rng.Int64N(ls.total)
+	i := sort.Search(len(ls.files), func(i int) bool { return ls.files[i].base+ls.files[i].size > off })
+	sf := ls.files[i]
+	sl, ok, err := sf.lineAt(off-sf.base, ls.max, ls.buf)
+	if err != nil {
+		return sl, false, fmt.Errorf("%s: %v", sf.path, err)
+	}
+	ls.draws++
+	if !ok {
+		ls.tooLong++
+		return sl, false, nil
+	}
+	ls.invSpan += 1 / float64(sl.span)
+	return sl, true, nil
+}
+
+// sample draws until it has want distinct lines or has made maxDraws draws.
+// correct is none, reject, or weight, see seekMain; weight keeps lines as none does, for seekMain to weight.
+func (ls *lineSeeker) sample(want int, correct string, maxDraws int) ([]seekLine, error) {
+	type key struct {
+		f     *seekFile
+		start int64
+	}
+	seen := make(map[key]bool)
+	var out []seekLine
+	// distinct lines landed in, in order, for reject to go back over after its pilot
+	var candidates []seekLine
+	var pilot int
+	if correct == "reject" {
+		pilot = min(seekPilot, want)
+	}
+	shortest := int64(0)
+	accept := func(sl seekLine) {
+		// short lines are landed in rarely, keep long ones as rarely
+		if shortest > 0 && sl.span > shortest && ls.rng.Int64N(sl.span) >= shortest {
+			// it may be landed in again
+			delete(seen, key{sl.file, sl.start})
+			return
+		}
+		out = append(out, sl)
+	}
+	for ls.draws < maxDraws && len(out) < want {
+		sl, ok, err := ls.draw()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || seen[key{sl.file, sl.start}] {
+			continue
+		}
+		seen[key{sl.file, sl.start}] = true
+		switch correct {
+		case "none", "weight":
+			out = append(out, sl)
+		case "reject":
+			if pilot > 0 {
+				candidates = append(candidates, sl)
+				if len(candidates) < pilot {
+					continue
+				}
+				shortest = candidates[0].span
+				for _, c := range candidates {
+					shortest = min(shortest, c.span)
+				}
+				pilot = 0
+				for _, c := range candidates {
+					accept(c)
+				}
+				candidates = nil
+				continue
+			}
+			accept(sl)
+		}
+	}
+	if pilot > 0 {
+		// ran out of draws during the pilot
+		out = append(out, candidates...)
+	}
+	return out, nil
+}
+
+// seekMain is `ssample seek [-l N] file ...`
+func seekMain(args []string) {
+	fs := flag.NewFlagSet("seek", flag.ContinueOnError)
+	keep := countFlag(fs, "l", 100, "lines to sample")
+	correct := fs.String("correct", "reject", "undo the bias toward long lines: reject lines in proportion to length, keep them but print how many lines each stands for (weight), or none")
+	maxDraws := countFlag(fs, "max-draws", 0, "stop after this many random offsets even with fewer than -l lines (default 1000 times -l)")
+	maxLineBytes := countFlag(fs, "max-line-bytes", defaultMaxLineBytes, "skip lines longer than this many bytes")
+	seed := fs.Uint64("seed", 0, "seed the offsets to get the same sample every time, 0 for a random one")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s seek [-l N] [-correct reject|weight|none] file ...\n\nSample lines of huge files quickly by reading the lines at random byte offsets instead of all of them.\nThe sample is approximate, see -correct. Files must be regular, uncompressed files.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(exitStart)
+	}
+	switch *correct {
+	case "reject", "weight", "none":
+	default:
+		maybefail(fmt.Errorf("bad -correct %q", *correct), "-correct %q: want reject, weight, or none\n", *correct)
+	}
+	if *maxDraws <= 0 {
+		*maxDraws = 1000 * max(*keep, 1)
+	}
+	if *seed != 0 {
+		seedCollectors(*seed)
+	}
+	ls := &lineSeeker{max: *maxLineBytes, rng: rand.New(newCollectorPCG()), buf: make([]byte, seekChunk)}
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		maybefail(err, "%v\n", err)
+		defer f.Close()
+		st, err := f.Stat()
+		maybefail(err, "%v\n", err)
+		if !st.Mode().IsRegular() {
+			maybefail(fmt.Errorf("%s: not a regular file", path), "%s: not a regular file, seek needs to seek in it\n", path)
+		}
+		if st.Size() == 0 {
+			continue
+		}
+		ls.files = append(ls.files, &seekFile{path: path, f: f, size: st.Size(), base: ls.total})
+		ls.total += st.Size()
+	}
+	if ls.total == 0 {
+		return
+	}
+	lines, err := ls.sample(*keep, *correct, *maxDraws)
+	maybefail(err, "%v\n", err)
+	if ls.draws >= *maxDraws && len(lines) < *keep {
+		warnf("stopped after -max-draws %d with %d lines", *maxDraws, len(lines))
+	}
+	if ls.tooLong != 0 {
+		warnf("%d of %d offsets were in lines over -max-line-bytes %d", ls.tooLong, ls.draws, *maxLineBytes)
+	}
+	if n := ls.draws - ls.tooLong; n > 0 {
+		infof("%d offsets, about %.0f lines of %s", ls.draws, float64(ls.total)*ls.invSpan/float64(n), formatBytes(ls.total))
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].file != lines[j].file {
+			return lines[i].file.base < lines[j].file.base
+		}
+		return lines[i].start < lines[j].start
+	})
+	out := bufio.NewWriter(os.Stdout)
+	for _, sl := range lines {
+		if len(ls.files) > 1 {
+			fmt.Fprintf(out, "%s:", sl.file.path)
+		}
+		if *correct == "weight" {
+			// each was landed in with chance about len(lines)*span/total
+			fmt.Fprintf(out, "%d\t%.4g\t%s\n", sl.start, float64(ls.total)/float64(int64(len(lines))*sl.span), sl.line)
+		} else {
+			fmt.Fprintf(out, "%d\t%s\n", sl.start, sl.line)
+		}
+	}
+	out.Flush()
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// serviceMain fails, `ssample service` is for Windows; elsewhere use systemd or the like
+func serviceMain(args []string) []string {
+	fmt.Fprintf(os.Stderr, "%s service: only on Windows, elsewhere run ssample serve under systemd, launchd, or the like\n", os.Args[0])
+	os.Exit(exitStart)
+	return nil
+}
+
+func serviceLogging() error { return nil }
+
+func serviceStopped(exitCode int) {}
@@ -0,0 +1,257 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32                          = syscall.NewLazyDLL("advapi32.dll")
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+	procRegisterEventSourceW          = advapi32.NewProc("RegisterEventSourceW")
+	procReportEventW                  = advapi32.NewProc("ReportEventW")
+)
+
+const (
+	serviceWin32OwnProcess = 0x10
+
+	stateStopped      = 1
+	stateStartPending = 2
+	stateStopPending  = 3
+	stateRunning      = 4
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+
+	serviceAcceptStop     = 1
+	serviceAcceptShutdown = 4
+
+	eventlogError       = 1
+	eventlogWarning     = 2
+	eventlogInformation = 4
+)
+
+// serviceStatus is a SERVICE_STATUS
+type serviceStatus struct {
+	serviceType             uint32
+	currentState            uint32
+	controlsAccepted        uint32
+	win32ExitCode           uint32
+	serviceSpecificExitCode uint32
+	checkPoint              uint32
+	waitHint                uint32
+}
+
+// serviceTableEntry is a SERVICE_TABLE_ENTRYW
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+// windowsService is `ssample service run` talking to the service control manager
+var windowsService struct {
+	name    string
+	handle  uintptr
+	status  serviceStatus
+	started chan error
+	stopped chan struct{}
+	l       sync.Mutex
+}
+
+// there's a limited number of callbacks, so make them once
+var (
+	serviceMainCallback    = syscall.NewCallback(serviceMainProc)
+	serviceHandlerCallback = syscall.NewCallback(serviceHandler)
+)
+
+// serviceMain is `ssample service install|uninstall|run [-name ssample] [flags]`.
+// It returns the flags to sample with for run, once the service control manager has started it.
+func serviceMain(args []string) []string {
+	if len(args) == 0 {
+		serviceUsage()
+	}
+	verb, args := args[0], args[1:]
+	name := "ssample"
+	if len(args) >= 2 && (args[0] == "-name" || args[0] == "--name") {
+		name, args = args[1], args[2:]
+	}
+	switch verb {
+	case "install":
+		exe, err := os.Executable()
+		maybefail(err, "%v\n", err)
+		cmdline := []string{syscall.EscapeArg(exe), "service", "run", "-name", syscall.EscapeArg(name)}
+		for _, arg := range args {
+			cmdline = append(cmdline, syscall.EscapeArg(arg))
+		}
+		err = runSC("create", name, "binPath=", strings.Join(cmdline, " "), "start=", "auto", "DisplayName=", "ssample "+name)
+		maybefail(err, "service install: %v\n", err)
+		err = runSC("description", name, "Streaming sample of log lines, see the -http server for the current sample")
+		maybefail(err, "service install: %v\n", err)
+		fmt.Printf("installed service %s, start it with: sc.exe start %s\n", name, name)
+		os.Exit(0)
+	case "uninstall":
+		err := runSC("delete", name)
+		maybefail(err, "service uninstall: %v\n", err)
+		fmt.Printf("removed service %s\n", name)
+		os.Exit(0)
+	case "run":
+		err := runAsService(name)
+		maybefail(err, "service run: %v\n", err)
+		return args
+	}
+	serviceUsage()
+	return nil
+}
+
+func serviceUsage() {
+	fmt.Fprintf(os.Stderr, "usage: %s service install|uninstall|run [-name ssample] [flags]\n\ninstall registers a Windows service running `ssample service run` with the flags given, e.g.\n\n  %s service install -name applog -config C:\\ssample\\applog.toml\n\nrun is what the service control manager runs. Stopping the service is like ^C: the sample is printed, -state saved, and the -a/-teez file closed. Messages go to the Application event log.\n", os.Args[0], os.Args[0])
+	os.Exit(exitStart)
+}
+
+func runSC(args ...string) error {
+	out, err := exec.Command("sc.exe", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("sc.exe %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// runAsService starts the service control dispatcher, returning once the service is running
+func runAsService(name string) error {
+	windowsService.name = name
+	windowsService.started = make(chan error, 1)
+	windowsService.stopped = make(chan struct{})
+	go func() {
+		// the dispatcher calls back into this thread until the service stops
+		runtime.LockOSThread()
+		namep, _ := syscall.UTF16PtrFromString(name)
+		table := []serviceTableEntry{{name: namep, proc: serviceMainCallback}, {}}
+		r, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0])))
+		if r == 0 {
+			windowsService.started <- fmt.Errorf("StartServiceCtrlDispatcher: %v (ssample service run is for the service control manager to run)", err)
+		}
+	}()
+	return <-windowsService.started
+}
+
+// serviceMainProc is the ServiceMain, it returns when the service stops
+func serviceMainProc(argc, argv uintptr) uintptr {
+	namep, _ := syscall.UTF16PtrFromString(windowsService.name)
+	h, _, err := procRegisterServiceCtrlHandlerExW.Call(uintptr(unsafe.Pointer(namep)), serviceHandlerCallback, 0)
+	if h == 0 {
+		windowsService.started <- fmt.Errorf("RegisterServiceCtrlHandlerEx: %v", err)
+		return 0
+	}
+	windowsService.handle = h
+	setServiceState(stateStartPending, 0)
+	setServiceState(stateRunning, 0)
+	windowsService.started <- nil
+	<-windowsService.stopped
+	return 0
+}
+
+// serviceHandler is the HandlerEx: stop and shutdown are handled like ^C
+func serviceHandler(control, eventType, eventData, context uintptr) uintptr {
+	switch control {
+	case serviceControlStop, serviceControlShutdown:
+		setServiceState(stateStopPending, 0)
+		infof("service %s stopping", windowsService.name)
+		wake(&shouldquit)
+	case serviceControlInterrogate:
+		windowsService.l.Lock()
+		state := windowsService.status.currentState
+		windowsService.l.Unlock()
+		setServiceState(state, 0)
+	}
+	return 0
+}
+
+func setServiceState(state, exitCode uint32) {
+	windowsService.l.Lock()
+	defer windowsService.l.Unlock()
+	st := &windowsService.status
+	checkPoint := st.checkPoint
+	*st = serviceStatus{serviceType: serviceWin32OwnProcess, currentState: state, win32ExitCode: exitCode}
+	switch state {
+	case stateRunning:
+		st.controlsAccepted = serviceAcceptStop | serviceAcceptShutdown
+	case stateStartPending, stateStopPending:
+		st.checkPoint = checkPoint + 1
+		st.waitHint = 30000
+	}
+	procSetServiceStatus.Call(windowsService.handle, uintptr(unsafe.Pointer(st)))
+}
+
+// serviceStopped tells the service control manager ssample is done, if it's a service
+func serviceStopped(exitCode int) {
+	if windowsService.handle == 0 {
+		return
+	}
+	setServiceState(stateStopped, uint32(exitCode))
+	close(windowsService.stopped)
+}
+
+// serviceLogging sends log messages to the Application event log, as the service's name
+func serviceLogging() error {
+	namep, _ := syscall.UTF16PtrFromString(windowsService.name)
+	h, _, err := procRegisterEventSourceW.Call(0, uintptr(unsafe.Pointer(namep)))
+	if h == 0 {
+		return fmt.Errorf("RegisterEventSource: %v", err)
+	}
+	logger.Store(slog.New(&eventLog{h: h, level: logSettings.level}))
+	return nil
+}
+
+// eventLog is a slog.Handler reporting each message as an event
+type eventLog struct {
+	h     uintptr
+	level slog.Level
+}
+
+func (el *eventLog) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= el.level
+}
+
+func (el *eventLog) Handle(_ context.Context, r slog.Record) error {
+	msg := r.Message
+	r.Attrs(func(a slog.Attr) bool {
+		msg += fmt.Sprintf(" %s=%v", a.Key, a.Value)
+		return true
+	})
+	kind := eventlogInformation
+	switch {
+	case r.Level >= slog.LevelError:
+		kind = eventlogError
+	case r.Level >= slog.LevelWarn:
+		kind = eventlogWarning
+	}
+	msgp, err := syscall.UTF16PtrFromString(strings.ReplaceAll(msg, "\x00", ""))
+	if err != nil {
+		return err
+	}
+	strs := []*uint16{msgp}
+	ok, _, err := procReportEventW.Call(el.h, uintptr(kind), 0, 1, 0, 1, 0, uintptr(unsafe.Pointer(&strs[0])), 0)
+	if ok == 0 {
+		return errors.New("ReportEvent: " + err.Error())
+	}
+	return nil
+}
+
+// WithAttrs drops attributes, ssample's messages don't use them
+func (el *eventLog) WithAttrs(attrs []slog.Attr) slog.Handler { return el }
+
+func (el *eventLog) WithGroup(name string) slog.Handler { return el }
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// userSignals does nothing, there is no SIGUSR1 or SIGUSR2 here
+func userSignals(c *Collector, dumpPath string, resetPrint bool) {}
+
+// hupSignals does nothing, there is no SIGHUP here
+func hupSignals(reload func()) {}
@@ -0,0 +1,44 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// userSignals calls dumpSample on every SIGUSR1 and resets c on every SIGUSR2.
+// With resetPrint the sample from before each reset is printed to stdout like the exit output.
+func userSignals(c *Collector, dumpPath string, resetPrint bool) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGUSR1 {
+				err := dumpSample(c, dumpPath)
+				if err != nil {
+					errorf("dump: %v", err)
+				}
+				continue
+			}
+			lines, nos, st := c.Reset()
+			infof("reset after %d lines, %d bytes", st.LinesSeen, st.BytesSeen)
+			if resetPrint {
+				printSample(lines, nos)
+			}
+		}
+	}()
+}
+
+// hupSignals calls reload on every SIGHUP
+func hupSignals(reload func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	go func() {
+		for range sigs {
+			infof("SIGHUP, reloading")
+			reload()
+		}
+	}()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var snapshotNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// writeFileAtomic writes to a temp file in the same directory and renames it into place
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tf, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpname := tf.Name()
+	_, err = tf.Write(data)
+	if err == nil {
+		err = tf.Sync()
+	}
+	if cerr := tf.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpname, mode)
+	}
+	if err == nil {
+		err = os.Rename(tmpname, path)
+	}
+	if err != nil {
+		os.Remove(tmpname)
+	}
+	return err
+}
+
+// snapshot handles POST /snapshot?name=NAME, writing the current sample as json to NAME
+// in the -snapshot-dir. Names are plain file names, no paths.
+func (s *ssampleServer) snapshot(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	if s.snapshotDir == "" {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "snapshots not enabled, set -snapshot-dir\n")
+		return
+	}
+	name := r.FormValue("name")
+	if !snapshotNameRe.MatchString(name) {
+		badRequest(w, fmt.Errorf("bad name=%q, want [A-Za-z0-9._-]", name))
+		return
+	}
+	out, err := s.query(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	blob, err := json.Marshal(struct {
+		sampleHeader
+		*LineNoResponse
+	}{newSampleHeader(s.c.algorithm()), out})
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(s.snapshotDir, name), blob, 0644)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "snapshot: %v\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "wrote %s, %d lines\n", name, len(out.Lines))
+}
@@ -0,0 +1,66 @@
+package main
+
+import "sort"
+
+// SourceCount is lines and bytes seen from one input
+type SourceCount struct {
+	Source string `json:"source"`
+	Lines  int    `json:"lines"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// countSource adds a line of n bytes to source's count, it must be called with c.l held
+func (c *Collector) countSource(source string, n int) {
+	sc := c.lastSource
+	if sc == nil || sc.Source != source {
+		if c.sources == nil {
+			c.sources = make(map[string]*SourceCount)
+		}
+		sc = c.sources[source]
+		if sc == nil {
+			sc = &SourceCount{Source: source}
+			c.sources[source] = sc
+		}
+		// lines mostly come in batches from one source
+		c.lastSource = sc
+	}
+	sc.Lines++
+	sc.Bytes += int64(n)
+}
+
+// sourceList is the per-source counts, most lines first, it must be called with c.l held
+func (c *Collector) sourceList() []SourceCount {
+	out := make([]SourceCount, 0, len(c.sources))
+	for _, sc := range c.sources {
+		out = append(out, *sc)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Lines != out[j].Lines {
+			return out[i].Lines > out[j].Lines
+		}
+		return out[i].Source < out[j].Source
+	})
+	return out
+}
+
+// Sources returns lines and bytes seen from each input, most lines first, nil unless there's more than one
+func (c *Collector) Sources() []SourceCount {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if len(c.sources) < 2 {
+		return nil
+	}
+	return c.sourceList()
+}
+
+// restoreSources replaces the per-source counts with saved ones, it must be called with c.l held
+func (c *Collector) restoreSources(saved []SourceCount) {
+	c.sources = nil
+	c.lastSource = nil
+	for _, sc := range saved {
+		if c.sources == nil {
+			c.sources = make(map[string]*SourceCount)
+		}
+		c.sources[sc.Source] = &sc
+	}
+}
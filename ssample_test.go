@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// withSeed runs f with Collectors seeded as by -seed seed
+func withSeed(t testing.TB, seed uint64, f func()) {
+	seedCollectors(seed)
+	defer func() {
+		collectorSeeds.Lock()
+		collectorSeeds.rng = nil
+		collectorSeeds.Unlock()
+	}()
+	f()
+}
+
+func TestReservoirUniform(t *testing.T) {
+	const keep, lines, trials = 10, 100, 4000
+	counts := make([]int, lines)
+	withSeed(t, 1, func() {
+		for trial := 0; trial < trials; trial++ {
+			c := NewCollector(keep, "")
+			for i := 0; i < lines; i++ {
+				c.AddLine(strconv.Itoa(i))
+			}
+			records, stats, _ := c.Records()
+			if len(records) != keep || stats.LinesSeen != lines {
+				t.Fatalf("kept %d of %d lines, want %d of %d", len(records), stats.LinesSeen, keep, lines)
+			}
+			for _, rec := range records {
+				counts[rec.LineNumber]++
+			}
+		}
+	})
+	// each line is kept keep/lines of the time, give or take 5 standard deviations
+	want := trials * keep / lines
+	for i, n := range counts {
+		if n < want-65 || n > want+65 {
+			t.Errorf("line %d kept %d times in %d, want about %d", i, n, trials, want)
+		}
+	}
+	// the first and last lines are kept as often as each other
+	first, last := 0, 0
+	for i := 0; i < lines/2; i++ {
+		first += counts[i]
+		last += counts[lines/2+i]
+	}
+	if first < last*9/10 || last < first*9/10 {
+		t.Errorf("first half kept %d times, second half %d", first, last)
+	}
+}
+
+func TestReservoirSeed(t *testing.T) {
+	sample := func() []int {
+		var out []int
+		withSeed(t, 42, func() {
+			c := NewCollector(5, "")
+			for i := 0; i < 1000; i++ {
+				c.AddLine(strconv.Itoa(i))
+			}
+			records, _, _ := c.Records()
+			for _, rec := range records {
+				out = append(out, rec.LineNumber)
+			}
+		})
+		return out
+	}
+	a, b := sample(), sample()
+	if !slices.Equal(a, b) {
+		t.Errorf("the same -seed kept lines %v then %v", a, b)
+	}
+}
+
+func BenchmarkAddLine(b *testing.B) {
+	lines := make([][]byte, 1024)
+	for i := range lines {
+		lines[i] = []byte(fmt.Sprintf("2024-03-01T10:00:00Z host%d GET /api/v1/items/%d 200 %dms", i%7, i, i%300))
+	}
+	c := NewCollector(1000, "")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.AddBytes(lines[i%len(lines)])
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"time"
+)
+
+// collectorState is everything needed to resume a Collector, see -state
+type collectorState struct {
+	sampleHeader
+	LinesToKeep int         `json:"linesToKeep"`
+	Source      string      `json:"source,omitempty"`
+	Lines       []string    `json:"lines"`
+	LineNumbers []int       `json:"lineNumbers"`
+	LineTimes   []time.Time `json:"lineTimes"`
+	LineSources []string    `json:"lineSources,omitempty"`
+	LinesSeen   int         `json:"seen"`
+	BytesSeen   int64       `json:"bytesSeen"`
+	Evictions   int         `json:"evictions"`
+	Start       time.Time   `json:"start"`
+	// end of the window the sample covers: the latest line time with -time-regex, otherwise when it was saved
+	End time.Time `json:"end"`
+	// PCG state
+	RNG []byte `json:"rng,omitempty"`
+	// -f files and the offset after the last line added from each
+	Inputs map[string]int64 `json:"inputs,omitempty"`
+	// -unusual line priorities and weights, and the highest priority passed by; -first-by line weights
+	Priorities []float64 `json:"priorities,omitempty"`
+	Weights    []float64 `json:"weights,omitempty"`
+	Tau        float64   `json:"tau,omitempty"`
+	// lines and bytes seen from each source
+	Sources []SourceCount `json:"sources,omitempty"`
+	// -arrivals gaps of the lines after the ones before them in nanoseconds, -1 if unknown
+	LineGaps []time.Duration `json:"lineGaps,omitempty"`
+}
+
+// MarshalState encodes the sample, counters, and random generator state
+func (c *Collector) MarshalState() ([]byte, error) {
+	c.l.Lock()
+	st := collectorState{
+		sampleHeader: newSampleHeader(c.algorithm()),
+		LinesToKeep:  c.LinesToKeep,
+		Source:       c.Source,
+		Lines:        c.lines.Strings(),
+		LineNumbers:  c.lineNumbers,
+		LineTimes:    c.lineTimes,
+		LineSources:  c.lineSources,
+		LinesSeen:    c.linesSeen,
+		BytesSeen:    c.bytesSeen,
+		Evictions:    c.evictions,
+		Start:        c.start,
+		End:          c.window().End,
+		Inputs:       c.inputOffsets,
+		Sources:      c.sourceList(),
+		LineGaps:     c.lineGaps,
+	}
+	if c.unusual != nil {
+		st.Priorities = c.unusual.priorities
+		st.Weights = c.unusual.weights
+		st.Tau = c.unusual.tau
+	}
+	if c.keyed != nil {
+		st.Weights = c.keyed.counts
+	}
+	var err error
+	if c.pcg != nil {
+		st.RNG, err = c.pcg.MarshalBinary()
+	}
+	var blob []byte
+	if err == nil {
+		blob, err = json.Marshal(st)
+	}
+	c.l.Unlock()
+	return blob, err
+}
+
+// RestoreState replaces the collector's contents with a MarshalState() result.
+// The saved capacity replaces LinesToKeep because changing it part way through would bias the sample.
+func (c *Collector) RestoreState(blob []byte) error {
+	err := checkSampleHeader(blob)
+	if err != nil {
+		return err
+	}
+	var st collectorState
+	err = json.Unmarshal(blob, &st)
+	if err != nil {
+		return err
+	}
+	if run := c.algorithm(); !sameAlgorithm(st.Algorithm, run) {
+		alg := st.Algorithm
+		if alg == "" {
+			alg = algReservoir
+		}
+		return fmt.Errorf("state is a %s sample, this run makes %s samples", alg, run)
+	}
+	if len(st.LineNumbers) != len(st.Lines) || len(st.LineTimes) != len(st.Lines) {
+		return errors.New("state lines, lineNumbers, and lineTimes differ in length")
+	}
+	if st.LineSources != nil && len(st.LineSources) != len(st.Lines) {
+		return errors.New("state lines and lineSources differ in length")
+	}
+	if st.LinesToKeep <= 0 || len(st.Lines) > st.LinesToKeep {
+		return fmt.Errorf("state has bad capacity %d", st.LinesToKeep)
+	}
+	var pcg *rand.PCG
+	if len(st.RNG) != 0 {
+		pcg = new(rand.PCG)
+		err = pcg.UnmarshalBinary(st.RNG)
+		if err != nil {
+			return fmt.Errorf("state rng: %v", err)
+		}
+	}
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.LinesToKeep = st.LinesToKeep
+	c.lines.Reset()
+	for i, line := range st.Lines {
+		arenaSet(&c.lines, i, line)
+	}
+	c.lineNumbers = st.LineNumbers
+	c.lineTimes = st.LineTimes
+	c.lineSources = st.LineSources
+	c.linesSeen = st.LinesSeen
+	c.bytesSeen = st.BytesSeen
+	c.evictions = st.Evictions
+	c.start = st.Start
+	if c.eventTime != nil {
+		c.end = st.End
+	}
+	c.inputOffsets = st.Inputs
+	c.restoreSources(st.Sources)
+	if c.unusual != nil {
+		c.unusual.restore(st.Priorities, st.Weights, st.Tau, len(st.Lines))
+	}
+	if c.keyed != nil {
+		c.keyed.restore(st.Lines, st.Weights)
+	}
+	if c.arrivals != nil {
+		c.lineGaps = st.LineGaps
+		if len(c.lineGaps) != len(st.Lines) {
+			c.lineGaps = make([]time.Duration, len(st.Lines))
+			for i := range c.lineGaps {
+				c.lineGaps[i] = noGap
+			}
+		}
+	}
+	if pcg != nil {
+		c.pcg = pcg
+		c.rng = rand.New(pcg)
+	}
+	c.version++
+	// a new epoch, /v1/changes pollers start over
+	c.changes = nil
+	return nil
+}
+
+// loadState restores c from path if it exists
+func loadState(c *Collector, path string) error {
+	blob, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	requested := c.LinesToKeep
+	err = c.RestoreState(blob)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if requested != c.LinesToKeep {
+		warnf("%s: keeping saved -l %d, not %d", path, c.LinesToKeep, requested)
+	}
+	infof("%s: resumed after %d lines", path, c.Seen())
+	return nil
+}
+
+func saveState(c *Collector, path string) (err error) {
+	start := time.Now()
+	defer func() {
+		otel.span("save state", spanInternal, traceContext{}, start, []otlpKV{otlpString("file.path", path)}, err)
+	}()
+	blob, err := c.MarshalState()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, blob, 0644)
+}
+
+// startCheckpoints saves c to path every interval and/or every so many lines, whichever comes first.
+// A zero interval or lines disables that trigger. It runs until the process exits.
+func startCheckpoints(c *Collector, path string, interval time.Duration, lines int) {
+	// as loaded
+	lastVersion, lastSeen := c.Version()
+	go checkpoints(c, path, interval, lines, lastVersion, lastSeen)
+}
+
+func checkpoints(c *Collector, path string, interval time.Duration, lines int, lastVersion uint64, lastSeen int) {
+	for {
+		ctx := context.Background()
+		cancel := func() {}
+		if interval > 0 {
+			ctx, cancel = context.WithTimeout(ctx, interval)
+		}
+		target := math.MaxInt
+		if lines > 0 {
+			target = lastSeen + lines
+		}
+		c.WaitSeen(ctx, target)
+		cancel()
+		version, seen := c.Version()
+		if seen < lastSeen {
+			// Reset
+			lastSeen = 0
+		}
+		if version == lastVersion && seen == lastSeen {
+			continue
+		}
+		err := saveState(c, path)
+		if err != nil {
+			failf("%s: %v", path, err)
+		} else {
+			debugf("%s: saved after %d lines", path, seen)
+		}
+		lastVersion = version
+		lastSeen = seen
+	}
+}
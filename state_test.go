@@ -0,0 +1,107 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStateTimeParser(t *testing.T) {
+	timed := func() *Collector {
+		c := NewCollector(2, "")
+		tp, err := newTimeParser(`^\S+`, "rfc3339")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetTimeParser(tp)
+		return c
+	}
+	c := timed()
+	for _, line := range []string{"2024-03-01T10:00:00Z a", "2024-03-01T12:00:00Z b", "2024-03-01T11:00:00Z c", "no time"} {
+		c.AddLine(line)
+	}
+	end := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if got := c.Window().End; !got.Equal(end) {
+		t.Fatalf("window end %v, want %v", got, end)
+	}
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := saveState(c, path); err != nil {
+		t.Fatal(err)
+	}
+
+	// as a restart with -time-regex and -state
+	again := timed()
+	if err := loadState(again, path); err != nil {
+		t.Fatal(err)
+	}
+	if got := again.Window().End; !got.Equal(end) {
+		t.Errorf("restored window end %v, want %v", got, end)
+	}
+	// an earlier line doesn't move it back, and a line with no time is timed at it
+	again.AddLine("2024-03-01T09:00:00Z d")
+	again.AddLine("still no time")
+	records, _, window := again.Records()
+	if !window.End.Equal(end) {
+		t.Errorf("window end after more lines %v, want %v", window.End, end)
+	}
+	for _, rec := range records {
+		if rec.Line == "still no time" && !rec.Time.Equal(end) {
+			t.Errorf("line with no time at %v, want %v", rec.Time, end)
+		}
+	}
+}
+
+func TestRestoreState(t *testing.T) {
+	c := NewCollector(5, "")
+	for i := 0; i < 100; i++ {
+		c.AddLine(strconv.Itoa(i))
+	}
+	blob, err := c.MarshalState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// restored with a different -l, the saved one wins
+	again := NewCollector(50, "other")
+	if err := again.RestoreState(blob); err != nil {
+		t.Fatal(err)
+	}
+	if again.LinesToKeep != 5 {
+		t.Errorf("restored capacity %d, want 5", again.LinesToKeep)
+	}
+	want, wantStats, _ := c.Records()
+	got, gotStats, _ := again.Records()
+	if gotStats != wantStats {
+		t.Errorf("restored stats %+v, want %+v", gotStats, wantStats)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("restored %d lines, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Line != want[i].Line || got[i].LineNumber != want[i].LineNumber || !got[i].Time.Equal(want[i].Time) {
+			t.Errorf("line %d restored as %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	// the random generator was restored too, so both go on to keep the same lines
+	for i := 100; i < 1000; i++ {
+		c.AddLine(strconv.Itoa(i))
+		again.AddLine(strconv.Itoa(i))
+	}
+	want, _, _ = c.Records()
+	got, _, _ = again.Records()
+	for i := range want {
+		if got[i].LineNumber != want[i].LineNumber {
+			t.Errorf("after more lines kept line %d, want %d", got[i].LineNumber, want[i].LineNumber)
+		}
+	}
+
+	unusual := NewCollector(5, "")
+	unusual.SetUnusual(newUnusualSampler(1))
+	if err := unusual.RestoreState(blob); err == nil {
+		t.Errorf("restored a reservoir state into an -unusual collector")
+	}
+	if err := NewCollector(5, "").RestoreState([]byte(`{"linesToKeep": 0, "lines": []}`)); err == nil {
+		t.Errorf("restored a state with no capacity")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// processStart is when ssample started, for /stats uptime
+var processStart = time.Now()
+
+// V1Stats is /stats, about the ssample process, for scripts that don't read Prometheus
+type V1Stats struct {
+	Host          V1Host  `json:"host"`
+	Uptime        string  `json:"uptime"`
+	UptimeSeconds float64 `json:"uptimeSeconds"`
+	LinesSeen     int     `json:"linesSeen"`
+	BytesSeen     int64   `json:"bytesSeen"`
+	// lines in the sample, and the most it holds
+	Kept     int `json:"kept"`
+	Capacity int `json:"capacity"`
+	// estimated bytes held by the sample's lines and their metadata
+	SampleBytes int64 `json:"sampleBytes"`
+	// Go heap in use by the whole process
+	HeapBytes uint64 `json:"heapBytes"`
+	// bytes of lines waiting for a -tee-policy drop or buffer writer
+	TeeQueuedBytes int    `json:"teeQueuedBytes"`
+	TeeDropped     uint64 `json:"teeDropped"`
+	TeeWriteErrors uint64 `json:"teeWriteErrors"`
+	LinesTruncated uint64 `json:"linesTruncated"`
+	// left out by -long-lines skip
+	LinesSkippedLong uint64  `json:"linesSkippedLong"`
+	LinesFiltered    uint64  `json:"linesFiltered"`
+	LinesDuplicate   uint64  `json:"linesDuplicate"`
+	InputAttached    bool    `json:"inputAttached"`
+	Goroutines       int     `json:"goroutines"`
+	LinesPerSecond   float64 `json:"linesPerSecond"`
+	// lines seen in each minute of the last hour, oldest first
+	PerMinute []MinuteCount `json:"perMinute"`
+}
+
+// MemoryEstimate is roughly how many bytes the sample takes: the arena's chunks
+// plus each kept line's span, number, time, and source, and -intern's table
+func (c *Collector) MemoryEstimate() int64 {
+	c.l.Lock()
+	defer c.l.Unlock()
+	n := int64(0)
+	for _, ch := range c.lines.chunks {
+		n += int64(cap(ch))
+	}
+	n += int64(cap(c.lines.spans)) * int64(unsafe.Sizeof(lineSpan{}))
+	n += int64(cap(c.lineNumbers)) * int64(unsafe.Sizeof(int(0)))
+	n += int64(cap(c.lineTimes)) * int64(unsafe.Sizeof(time.Time{}))
+	n += int64(cap(c.lineSources)) * int64(unsafe.Sizeof(""))
+	// -intern's table, its map about a hash and an index an entry
+	n += int64(cap(c.lines.refs))*int64(unsafe.Sizeof(internRef{})) + int64(len(c.lines.interned))*12
+	return n
+}
+
+// statsHandler serves /stats
+func statsHandler(c *Collector, tee *teeWriter, rate *rateMeter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		st := c.Stats()
+		up := time.Since(processStart)
+		out := V1Stats{
+			Host:             thisHost(),
+			Uptime:           up.Round(time.Second).String(),
+			UptimeSeconds:    up.Seconds(),
+			LinesSeen:        st.LinesSeen,
+			BytesSeen:        st.BytesSeen,
+			Kept:             st.Kept,
+			Capacity:         st.Capacity,
+			SampleBytes:      c.MemoryEstimate(),
+			HeapBytes:        heapBytes(),
+			TeeQueuedBytes:   tee.Queued(),
+			TeeDropped:       atomic.LoadUint64(&teeDropped),
+			TeeWriteErrors:   atomic.LoadUint64(&teeWriteErrors),
+			LinesTruncated:   atomic.LoadUint64(&linesTruncated),
+			LinesSkippedLong: atomic.LoadUint64(&linesSkippedLong),
+			LinesFiltered:    atomic.LoadUint64(&linesFiltered),
+			LinesDuplicate:   atomic.LoadUint64(&linesDuplicate),
+			InputAttached:    atomic.LoadUint32(&inputAttached) != 0,
+			Goroutines:       runtime.NumGoroutine(),
+			LinesPerSecond:   rate.Rate(),
+			PerMinute:        c.PerMinute(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(out)
+	}
+}
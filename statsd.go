@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// statsdSender is -statsd: counters and gauges sent to a StatsD or DogStatsD server over UDP
+type statsdSender struct {
+	c      *Collector
+	tee    *teeWriter
+	conn   net.Conn
+	prefix string
+	// DogStatsD "|#k:v,k2:v2" suffix, or ""
+	tags string
+
+	last      CollectorStats
+	lastTee   uint64
+	lastDrop  uint64
+	lastFlush time.Time
+	l         sync.Mutex
+}
+
+func newStatsdSender(c *Collector, tee *teeWriter, addr, prefix, tags string) (*statsdSender, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	ss := &statsdSender{c: c, tee: tee, conn: conn, prefix: prefix, lastFlush: time.Now()}
+	if tags != "" {
+		ss.tags = "|#" + tags
+	}
+	ss.last = c.Stats()
+	ss.lastTee = atomic.LoadUint64(&teeBytes)
+	ss.lastDrop = atomic.LoadUint64(&teeDropped)
+	return ss, nil
+}
+
+// run sends every interval until the process exits
+func (ss *statsdSender) run(every time.Duration) {
+	for range time.Tick(every) {
+		err := ss.send()
+		if err != nil {
+			// UDP only fails like this when nothing is listening, so don't repeat it every interval at warn
+			debugf("statsd: %v", err)
+		}
+	}
+}
+
+func (ss *statsdSender) send() error {
+	ss.l.Lock()
+	defer ss.l.Unlock()
+	st := ss.c.Stats()
+	now := time.Now()
+	if st.LinesSeen < ss.last.LinesSeen {
+		// Reset
+		ss.last = CollectorStats{}
+	}
+	lines := st.LinesSeen - ss.last.LinesSeen
+	var buf bytes.Buffer
+	ss.metric(&buf, "lines", lines, "c")
+	ss.metric(&buf, "bytes", st.BytesSeen-ss.last.BytesSeen, "c")
+	ss.metric(&buf, "evictions", max(0, st.Evictions-ss.last.Evictions), "c")
+	if dt := now.Sub(ss.lastFlush).Seconds(); dt > 0 {
+		ss.metric(&buf, "lines_per_second", fmt.Sprintf("%.3f", float64(lines)/dt), "g")
+	}
+	ss.metric(&buf, "reservoir_size", st.Kept, "g")
+	if ss.tee != nil {
+		teeBytesNow := atomic.LoadUint64(&teeBytes)
+		dropped := atomic.LoadUint64(&teeDropped)
+		ss.metric(&buf, "tee_bytes", teeBytesNow-ss.lastTee, "c")
+		ss.metric(&buf, "tee_dropped", dropped-ss.lastDrop, "c")
+		ss.metric(&buf, "tee_queued_bytes", ss.tee.Queued(), "g")
+		ss.lastTee = teeBytesNow
+		ss.lastDrop = dropped
+	}
+	ss.last = st
+	ss.lastFlush = now
+	// one datagram, a metric per line, is well under a typical 1432 byte limit
+	_, err := ss.conn.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+	return err
+}
+
+// metric appends "{prefix}{name}:{value}|{kind}{tags}\n"
+func (ss *statsdSender) metric(buf *bytes.Buffer, name string, value interface{}, kind string) {
+	fmt.Fprintf(buf, "%s%s:%v|%s%s\n", ss.prefix, name, value, kind, ss.tags)
+}
+
+// checkStatsdTags wants DogStatsD tags: key:value or value, comma separated
+func checkStatsdTags(tags string) error {
+	if tags == "" {
+		return nil
+	}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag == "" || strings.ContainsAny(tag, "|#\n ") {
+			return fmt.Errorf("bad tag %q in %q, want key:value,key2:value2", tag, tags)
+		}
+	}
+	return nil
+}
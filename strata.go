@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// strata keeps a separate sample of each kind of line, besides the sample of all input,
+// so rare kinds (say 5xx responses) are represented however few they are.
+// Each stratum is a named collector "{kind}-{stratum}", e.g. status-5xx, served under /collector/.
+type strata struct {
+	kind string
+	key  func(line []byte) string
+	set  *collectorSet
+	size int
+	// -strata-size lines to keep by stratum, overriding size
+	sizes map[string]int
+}
+
+// strataKinds are the -strata values
+var strataKinds = map[string]func(line []byte) string{
+	"status":   statusClass,
+	"severity": severity,
+}
+
+func strataKindNames() string {
+	var names []string
+	for k := range strataKinds {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func newStrata(kind string, set *collectorSet, size int) (*strata, error) {
+	key := strataKinds[kind]
+	if key == nil {
+		return nil, fmt.Errorf("unknown -strata %q, want one of %s", kind, strataKindNames())
+	}
+	return &strata{kind: kind, key: key, set: set, size: size, sizes: make(map[string]int)}, nil
+}
+
+// setSize parses -strata-size stratum=N, e.g. error=1000
+func (st *strata) setSize(spec string) error {
+	key, n, ok := strings.Cut(spec, "=")
+	size, err := parseCount(n)
+	if !ok || key == "" || err != nil || size <= 0 || size > math.MaxInt32 {
+		return fmt.Errorf("bad -strata-size %q, want stratum=N", spec)
+	}
+	st.sizes[strings.ToLower(key)] = int(size)
+	return nil
+}
+
+// statusClass is "5xx" and so on for an access log line, "" if it doesn't parse
+func statusClass(line []byte) string {
+	e, ok := parseCLF(line)
+	if !ok {
+		return ""
+	}
+	return string(e.status[0]) + "xx"
+}
+
+// severityNames maps log level words to the severity strata
+var severityNames = map[string]string{
+	"trace":    "trace",
+	"debug":    "debug",
+	"dbg":      "debug",
+	"info":     "info",
+	"notice":   "info",
+	"warn":     "warn",
+	"warning":  "warn",
+	"error":    "error",
+	"err":      "error",
+	"fatal":    "fatal",
+	"crit":     "fatal",
+	"critical": "fatal",
+	"panic":    "fatal",
+	"emerg":    "fatal",
+	"alert":    "fatal",
+}
+
+// severity is the log level of a line, "" if it has none: a level or severity key in
+// logfmt or JSON, or otherwise one of the first few words like ERROR or [warn]
+func severity(line []byte) string {
+	var level []byte
+	scanLogfmt(line, func(key, value []byte) bool {
+		k := string(bytes.ToLower(key))
+		if k == "level" || k == "lvl" || k == "severity" {
+			level = value
+			return false
+		}
+		return true
+	})
+	if level == nil && len(line) != 0 && line[0] == '{' {
+		var v map[string]interface{}
+		if json.Unmarshal(line, &v) == nil {
+			for _, k := range []string{"level", "lvl", "severity", "levelname"} {
+				if s, ok := v[k].(string); ok {
+					level = []byte(s)
+					break
+				}
+			}
+		}
+	}
+	if level != nil {
+		return severityNames[string(bytes.ToLower(level))]
+	}
+	// a bare word only counts in capitals or brackets, so "connection error" isn't an error
+	fields := splitFields(nil, line, nil)
+	for _, tok := range fields[:min(len(fields), 6)] {
+		word := bytes.Trim(tok, "[]():<>|")
+		name := severityNames[string(bytes.ToLower(word))]
+		if name != "" && (len(word) != len(tok) || bytes.Equal(word, bytes.ToUpper(word))) {
+			return name
+		}
+	}
+	return ""
+}
+
+// collector returns the stratum's Collector for raw input line, nil if it has none
+func (st *strata) collector(line []byte) *Collector {
+	key := st.key(line)
+	if key == "" {
+		return nil
+	}
+	size, ok := st.sizes[key]
+	if !ok {
+		size = st.size
+	}
+	c, _ := st.set.getOrAdd(st.kind+"-"+key, size)
+	return c
+}
+
+// names returns the strata seen so far, sorted
+func (st *strata) names() []string {
+	prefix := st.kind + "-"
+	st.set.l.Lock()
+	defer st.set.l.Unlock()
+	var out []string
+	for name := range st.set.named {
+		if strings.HasPrefix(name, prefix) {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// print writes each stratum's sample to stdout after a line naming it
+func (st *strata) print() {
+	for _, name := range st.names() {
+		printCollector(name, st.set.Get(name))
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tdigest approximates the distribution of a stream of numbers in a few hundred centroids
+// (a merging t-digest, Dunning & Ertl), accurate near the tails where p99 lives.
+type tdigest struct {
+	// more centroids and accuracy with more compression
+	compression float64
+	centroids   []centroid
+	// weight of centroids
+	total float64
+	// values not yet merged into centroids
+	buf []float64
+}
+
+type centroid struct {
+	mean, weight float64
+}
+
+const tdigestCompression = 100
+
+// values added between merges
+const tdigestBuffer = 500
+
+func (t *tdigest) add(v float64) {
+	t.buf = append(t.buf, v)
+	if len(t.buf) >= tdigestBuffer {
+		t.merge()
+	}
+}
+
+func (t *tdigest) reset() {
+	t.centroids = t.centroids[:0]
+	t.total = 0
+	t.buf = t.buf[:0]
+}
+
+// k is the k1 scale function, which keeps centroids small near q=0 and q=1
+func (t *tdigest) k(q float64) float64 {
+	return t.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+func (t *tdigest) kInverse(k float64) float64 {
+	return (math.Sin(k*2*math.Pi/t.compression) + 1) / 2
+}
+
+// merge folds buf into the centroids, each centroid covering at most 1 of k
+func (t *tdigest) merge() {
+	if len(t.buf) == 0 {
+		return
+	}
+	if t.compression == 0 {
+		t.compression = tdigestCompression
+	}
+	all := make([]centroid, 0, len(t.centroids)+len(t.buf))
+	all = append(all, t.centroids...)
+	for _, v := range t.buf {
+		all = append(all, centroid{mean: v, weight: 1})
+	}
+	total := t.total + float64(len(t.buf))
+	t.buf = t.buf[:0]
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+	out := t.centroids[:0]
+	cur := all[0]
+	soFar := 0.0
+	qLimit := t.kInverse(t.k(0) + 1)
+	for _, c := range all[1:] {
+		q := (soFar + cur.weight + c.weight) / total
+		if q <= qLimit {
+			cur.weight += c.weight
+			cur.mean += (c.mean - cur.mean) * c.weight / cur.weight
+			continue
+		}
+		out = append(out, cur)
+		soFar += cur.weight
+		qLimit = t.kInverse(t.k(soFar/total) + 1)
+		cur = c
+	}
+	t.centroids = append(out, cur)
+	t.total = total
+}
+
+// quantile estimates the q quantile, interpolating between centroid centers and out to min and max
+func (t *tdigest) quantile(q, min, max float64) float64 {
+	t.merge()
+	cs := t.centroids
+	if len(cs) == 0 {
+		return 0
+	}
+	if len(cs) == 1 {
+		return cs[0].mean
+	}
+	target := q * t.total
+	first := cs[0]
+	if target < first.weight/2 {
+		return min + (first.mean-min)*target/(first.weight/2)
+	}
+	cum := 0.0
+	for i := 0; i+1 < len(cs); i++ {
+		left := cum + cs[i].weight/2
+		right := cum + cs[i].weight + cs[i+1].weight/2
+		if target <= right {
+			return cs[i].mean + (cs[i+1].mean-cs[i].mean)*(target-left)/(right-left)
+		}
+		cum += cs[i].weight
+	}
+	last := cs[len(cs)-1]
+	if target >= t.total {
+		return max
+	}
+	left := t.total - last.weight/2
+	return last.mean + (max-last.mean)*(target-left)/(last.weight/2)
+}
+
+// parseQuantiles parses a -quantiles list like "0.5,0.95,0.99"
+func parseQuantiles(spec string) ([]float64, error) {
+	var out []float64
+	for _, part := range strings.Split(spec, ",") {
+		q, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || q < 0 || q > 1 {
+			return nil, fmt.Errorf("bad quantile %q, want 0 to 1", part)
+		}
+		out = append(out, q)
+	}
+	return out, nil
+}
+
+// quantileName is "p99" for 0.99
+func quantileName(q float64) string {
+	return "p" + strconv.FormatFloat(q*100, 'g', 6, 64)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+var errTeeClosed = errors.New("tee closed")
+
+// teeDropped counts lines -tee-policy drop left out of the tee
+var teeDropped uint64
+
+// -tee-policy values, for when the tee falls behind the input
+const (
+	// Write waits for the tee's write, slowing input to the tee's pace
+	teeBlock = "block"
+	// lines are queued for a background writer; lines that don't fit in the queue are dropped
+	teeDrop = "drop"
+	// lines are queued for a background writer; Write waits for room in the queue
+	teeBuffer = "buffer"
+)
+
+// teeWriter is the -a/-teez output. main closes it at exit, even while reader is
+// blocked reading stdin, so a gzip tee gets its footer.
+type teeWriter struct {
+	l      sync.Mutex
+	w      io.Writer
+	closed bool
+	// held for all of Close, so a second Close waits for the first to finish
+	closeL sync.Mutex
+	// held by flusher while it writes, so Reopen can swap w between writes
+	writeL sync.Mutex
+
+	// for drop and buffer, lines waiting for flusher, up to queueMax bytes
+	policy   string
+	queue    []byte
+	queueMax int
+	cond     *sync.Cond
+	flushed  chan struct{}
+
+	// with markEvery > 0 a mark line follows every markEvery lines, see -tee-mark-every
+	markEvery int
+	crc       hash.Hash32
+	offset    int64
+	lines     int
+	sinceMark int
+}
+
+// Tee marks are lines starting with teeMarkPrefix, which `ssample verify` checks.
+// A start mark begins each run's output; each mark after it has the data bytes and lines
+// since the start, and the CRC-32C of the data since the previous mark.
+const (
+	teeMarkPrefix = "\x1essample-"
+	teeStartMark  = teeMarkPrefix + "start\n"
+	teeMarkFormat = teeMarkPrefix + "mark offset=%d lines=%d crc32c=%08x\n"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// newTeeWriter writes to w with policy teeBlock, teeDrop, or teeBuffer, queueing up to queueMax bytes for the latter two
+func newTeeWriter(w io.Writer, markEvery int, policy string, queueMax int) (*teeWriter, error) {
+	t := &teeWriter{w: w, markEvery: markEvery, policy: policy, queueMax: queueMax}
+	switch policy {
+	case teeBlock:
+	case teeDrop, teeBuffer:
+		t.cond = sync.NewCond(&t.l)
+		t.flushed = make(chan struct{})
+	default:
+		return nil, fmt.Errorf("unknown -tee-policy %q, want block, drop, or buffer", policy)
+	}
+	if markEvery > 0 {
+		t.crc = crc32.New(castagnoli)
+		_, err := io.WriteString(w, teeStartMark)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if t.cond != nil {
+		go t.flusher()
+	}
+	return t, nil
+}
+
+// Write takes one whole line at a time.
+// When queueing it returns len(b) once b is queued, or 0 if it was dropped.
+func (t *teeWriter) Write(b []byte) (int, error) {
+	t.l.Lock()
+	defer t.l.Unlock()
+	if t.closed {
+		return 0, errTeeClosed
+	}
+	if t.cond == nil {
+		return t.writeLine(b)
+	}
+	for len(t.queue) != 0 && len(t.queue)+len(b) > t.queueMax {
+		if t.policy == teeDrop {
+			atomic.AddUint64(&teeDropped, 1)
+			return 0, nil
+		}
+		t.cond.Wait()
+		if t.closed {
+			return 0, errTeeClosed
+		}
+	}
+	t.queue = append(t.queue, b...)
+	t.cond.Broadcast()
+	return len(b), nil
+}
+
+// flusher writes out the queue until Close
+func (t *teeWriter) flusher() {
+	defer close(t.flushed)
+	var out []byte
+	t.l.Lock()
+	for {
+		for len(t.queue) == 0 && !t.closed {
+			t.cond.Wait()
+		}
+		if len(t.queue) == 0 {
+			t.l.Unlock()
+			return
+		}
+		out, t.queue = t.queue, out[:0]
+		// room for Write again
+		t.cond.Broadcast()
+		t.l.Unlock()
+		t.writeL.Lock()
+		t.writeQueued(out)
+		t.writeL.Unlock()
+		t.l.Lock()
+	}
+}
+
+// writeQueued writes whole lines taken from the queue, one at a time if they need marks between them
+func (t *teeWriter) writeQueued(out []byte) {
+	if t.markEvery <= 0 {
+		_, err := t.w.Write(out)
+		if err != nil {
+			atomic.AddUint64(&teeWriteErrors, 1)
+		}
+		return
+	}
+	for len(out) != 0 {
+		nl := bytes.IndexByte(out, '\n')
+		_, err := t.writeLine(out[:nl+1])
+		if err != nil {
+			atomic.AddUint64(&teeWriteErrors, 1)
+		}
+		out = out[nl+1:]
+	}
+}
+
+// writeLine writes b and any mark due after it. Only one goroutine at a time may call it:
+// Write holding t.l for teeBlock, otherwise flusher.
+func (t *teeWriter) writeLine(b []byte) (int, error) {
+	n, err := t.w.Write(b)
+	if t.markEvery <= 0 {
+		return n, err
+	}
+	t.crc.Write(b[:n])
+	t.offset += int64(n)
+	t.lines++
+	t.sinceMark++
+	if err == nil && t.sinceMark >= t.markEvery {
+		err = t.mark()
+	}
+	return n, err
+}
+
+func (t *teeWriter) mark() error {
+	_, err := fmt.Fprintf(t.w, teeMarkFormat, t.offset, t.lines, t.crc.Sum32())
+	t.crc.Reset()
+	t.sinceMark = 0
+	return err
+}
+
+// Queued is how many bytes of lines are waiting for a drop or buffer tee's writer
+func (t *teeWriter) Queued() int {
+	if t == nil {
+		return 0
+	}
+	t.l.Lock()
+	defer t.l.Unlock()
+	return len(t.queue)
+}
+
+// Reopen switches output to w, as for a SIGHUP reload with a new -a path, and closes the old writer.
+// With marks the old output gets a last one and w starts afresh for `ssample verify`.
+func (t *teeWriter) Reopen(w io.Writer) error {
+	t.writeL.Lock()
+	defer t.writeL.Unlock()
+	t.l.Lock()
+	defer t.l.Unlock()
+	if t.closed {
+		return errTeeClosed
+	}
+	if t.markEvery > 0 && t.sinceMark > 0 {
+		t.mark()
+	}
+	old := t.w
+	t.w = w
+	if t.markEvery > 0 {
+		t.crc.Reset()
+		t.offset = 0
+		t.lines = 0
+		t.sinceMark = 0
+		if _, err := io.WriteString(w, teeStartMark); err != nil {
+			return err
+		}
+	}
+	if wc, ok := old.(io.WriteCloser); ok {
+		return wc.Close()
+	}
+	return nil
+}
+
+// Close closes the underlying writer if it is an io.WriteCloser. It may be called more than once, and on a nil tee.
+func (t *teeWriter) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.closeL.Lock()
+	defer t.closeL.Unlock()
+	t.l.Lock()
+	defer t.l.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	if t.cond != nil {
+		t.cond.Broadcast()
+		t.l.Unlock()
+		<-t.flushed
+		t.l.Lock()
+	}
+	if t.markEvery > 0 && t.sinceMark > 0 {
+		t.mark()
+	}
+	wc, ok := t.w.(io.WriteCloser)
+	if ok {
+		return wc.Close()
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// templateWildcard stands for the tokens that differ between lines of a template
+const templateWildcard = "<*>"
+
+// templateSimilarity is the fraction of tokens a line must share with a template to join it
+const templateSimilarity = 0.5
+
+// templateMiner groups lines into message templates, Drain style.
+// Tokens with digits in them (numbers, ids, addresses) are wildcards up front. A line is only
+// compared with templates of the same token count and first token, and joins the most similar
+// one if enough tokens match, which turns the tokens that differ into wildcards. Each template
+// keeps a count and a small reservoir of example lines.
+type templateMiner struct {
+	// examples per template
+	keep int
+	// at most this many templates, later lines that fit none are counted in other
+	max int
+
+	l      sync.Mutex
+	groups map[templateKey][]*template
+	count  int
+	other  int64
+	tokens [][]byte
+}
+
+type templateKey struct {
+	n     int
+	first string
+}
+
+type template struct {
+	tokens   []string
+	count    int64
+	examples []string
+}
+
+func newTemplateMiner(keep, max int) *templateMiner {
+	return &templateMiner{keep: keep, max: max, groups: make(map[templateKey][]*template)}
+}
+
+// hasDigit is true for tokens that are probably variable
+func hasDigit(tok []byte) bool {
+	for _, ch := range tok {
+		if ch >= '0' && ch <= '9' {
+			return true
+		}
+	}
+	return false
+}
+
+// add returns the template line went in, nil if it was past max
+func (tm *templateMiner) add(line []byte) *template {
+	tm.l.Lock()
+	defer tm.l.Unlock()
+	tm.tokens = splitFields(tm.tokens[:0], line, nil)
+	key := templateKey{n: len(tm.tokens)}
+	if key.n != 0 {
+		key.first = templateWildcard
+		if !hasDigit(tm.tokens[0]) {
+			key.first = string(tm.tokens[0])
+		}
+	}
+	var best *template
+	bestMatch := -1
+	for _, t := range tm.groups[key] {
+		match := 0
+		for i, tok := range tm.tokens {
+			if t.tokens[i] == templateWildcard || t.tokens[i] == string(tok) {
+				match++
+			}
+		}
+		if match > bestMatch {
+			best, bestMatch = t, match
+		}
+	}
+	if best != nil && float64(bestMatch) >= templateSimilarity*float64(key.n) {
+		for i, tok := range tm.tokens {
+			if best.tokens[i] != templateWildcard && best.tokens[i] != string(tok) {
+				best.tokens[i] = templateWildcard
+			}
+		}
+	} else if tm.count >= tm.max {
+		tm.other++
+		return nil
+	} else {
+		best = &template{tokens: make([]string, key.n)}
+		for i, tok := range tm.tokens {
+			if hasDigit(tok) {
+				best.tokens[i] = templateWildcard
+			} else {
+				best.tokens[i] = string(tok)
+			}
+		}
+		tm.groups[key] = append(tm.groups[key], best)
+		tm.count++
+	}
+	best.count++
+	if len(best.examples) < tm.keep {
+		best.examples = append(best.examples, string(line))
+	} else if i := rand.Int64N(best.count); i < int64(tm.keep) {
+		best.examples[i] = string(line)
+	}
+	return best
+}
+
+func (tm *templateMiner) reset() {
+	tm.l.Lock()
+	defer tm.l.Unlock()
+	tm.groups = make(map[templateKey][]*template)
+	tm.count = 0
+	tm.other = 0
+}
+
+// Template is a message shape and some of the lines that fit it
+type Template struct {
+	Template string   `json:"template"`
+	Count    int64    `json:"count"`
+	Examples []string `json:"examples"`
+}
+
+// V1Templates is the /v1/templates response. Fields are only ever added.
+type V1Templates struct {
+	// most common first
+	Templates []Template `json:"templates"`
+	// lines that fit no template after -templates-max were made
+	Other int64 `json:"other,omitempty"`
+}
+
+func (tm *templateMiner) summary() *V1Templates {
+	tm.l.Lock()
+	defer tm.l.Unlock()
+	out := &V1Templates{Templates: make([]Template, 0, tm.count), Other: tm.other}
+	for _, group := range tm.groups {
+		for _, t := range group {
+			out.Templates = append(out.Templates, Template{
+				Template: strings.Join(t.tokens, " "),
+				Count:    t.count,
+				Examples: append([]string(nil), t.examples...),
+			})
+		}
+	}
+	sort.Slice(out.Templates, func(i, j int) bool {
+		a, b := out.Templates[i], out.Templates[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Template < b.Template
+	})
+	return out
+}
+
+// print writes "{count}\t{template}\n" for each template, each followed by "\t{example}\n" lines
+func (ts *V1Templates) print(w io.Writer) {
+	for _, t := range ts.Templates {
+		fmt.Fprintf(w, "%d\t%s\n", t.Count, t.Template)
+		for _, ex := range t.Examples {
+			fmt.Fprintf(w, "\t%s\n", ex)
+		}
+	}
+	if ts.Other != 0 {
+		fmt.Fprintf(w, "%d\t(lines past -templates-max)\n", ts.Other)
+	}
+}
+
+// v1TemplatesHandler serves GET /v1/templates
+func (s *ssampleServer) v1TemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	ts := s.c.Templates()
+	if ts == nil {
+		http.Error(w, "not mining templates, see -templates", http.StatusNotFound)
+		return
+	}
+	if boolish(r.URL.Query().Get("t")) {
+		w.Header().Set("Content-Type", "text/plain")
+		ts.print(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	// keep <*> readable
+	enc.SetEscapeHTML(false)
+	enc.Encode(ts)
+}
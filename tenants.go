@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tenant is a -tenants entry: a bearer token mapped to its own named collector, with an ingest quota
+type tenant struct {
+	name  string
+	token string
+	size  int
+	// -tenants rate=, nil for no limit
+	quota *inputThrottle
+
+	c *Collector
+	h http.Handler
+}
+
+// readTenants reads "name token [l=N] [rate=R] [burst=N]" lines.
+// l is lines to keep (default 100), rate an ingest quota like 1000/s or 1e6/h, burst how far ahead of it a tenant may get.
+// Blank lines and # comments are skipped.
+func readTenants(path string) ([]*tenant, error) {
+	fin, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fin.Close()
+	var tenants []*tenant
+	names := make(map[string]bool)
+	tokens := make(map[string]bool)
+	in := bufio.NewScanner(fin)
+	lineno := 0
+	for in.Scan() {
+		lineno++
+		line := strings.TrimSpace(in.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected name token [l=N] [rate=R] [burst=N]", path, lineno)
+		}
+		t := &tenant{name: fields[0], token: fields[1], size: 100}
+		if names[t.name] {
+			return nil, fmt.Errorf("%s:%d: tenant %q again", path, lineno, t.name)
+		}
+		if tokens[t.token] {
+			return nil, fmt.Errorf("%s:%d: tenant %s has another tenant's token", path, lineno, t.name)
+		}
+		names[t.name] = true
+		tokens[t.token] = true
+		rate := ""
+		burst := 0
+		for _, opt := range fields[2:] {
+			k, v, _ := strings.Cut(opt, "=")
+			switch k {
+			case "l":
+				t.size, err = strconv.Atoi(v)
+				if err != nil || t.size <= 0 {
+					return nil, fmt.Errorf("%s:%d: bad l=%q", path, lineno, v)
+				}
+			case "rate":
+				rate = v
+			case "burst":
+				burst, err = strconv.Atoi(v)
+				if err != nil || burst <= 0 {
+					return nil, fmt.Errorf("%s:%d: bad burst=%q", path, lineno, v)
+				}
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown %q, want l=, rate=, or burst=", path, lineno, opt)
+			}
+		}
+		if rate != "" {
+			t.quota, err = newInputThrottle(rate, burst)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, lineno, err)
+			}
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, in.Err()
+}
+
+// addTenants makes each tenant's collector in cs, so -auth-token holders also see them under /collector/{name}/
+func (cs *collectorSet) addTenants(tenants []*tenant) error {
+	for _, t := range tenants {
+		c, err := cs.Add(t.name, t.size)
+		if err != nil {
+			return fmt.Errorf("tenant %s: %v", t.name, err)
+		}
+		t.c = c
+		cs.l.Lock()
+		t.h = cs.named[t.name].h
+		cs.named[t.name].fixed = true
+		cs.l.Unlock()
+	}
+	return nil
+}
+
+// tenantRouter serves requests with a tenant's bearer token from that tenant's collector:
+// POST /ingest adds lines and everything else is as for the stdin collector at /, e.g. /v1/sample.
+// Other requests go on to next.
+type tenantRouter struct {
+	next    http.Handler
+	tenants []*tenant
+	filters *inputFilters
+	// -max-line-bytes, for ingested lines
+	maxLineBytes int
+}
+
+func (tr *tenantRouter) tenant(r *http.Request) *tenant {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil
+	}
+	for _, t := range tr.tenants {
+		if ctEqual(token, t.token) {
+			return t
+		}
+	}
+	return nil
+}
+
+func (tr *tenantRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := tr.tenant(r)
+	if t == nil {
+		tr.next.ServeHTTP(w, r)
+		return
+	}
+	if r.URL.Path == "/ingest" {
+		ingest(w, r, t.c, tr.filters.live().private(), t.quota, tr.maxLineBytes)
+		return
+	}
+	t.h.ServeHTTP(w, r)
+}
+
+// overQuota answers 429 after added lines of an ingest went over its rate
+func overQuota(w http.ResponseWriter, added int, quota *inputThrottle) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(1/quota.rate)+1))
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusTooManyRequests)
+	fmt.Fprintf(w, "added %d lines, then over quota of %g lines/s\n", added, quota.rate)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// inputThrottle is -rate: a token bucket shared by all readers, refilling at rate lines per second up to burst
+type inputThrottle struct {
+	rate   float64
+	burst  float64
+	bucket tokenBucket
+	l      sync.Mutex
+}
+
+// newInputThrottle takes a rate like "10000/s", "600/m", "50/h", or lines per second.
+// burst 0 is one second's worth.
+func newInputThrottle(spec string, burst int) (*inputThrottle, error) {
+	rate, err := parseRate(spec)
+	if err != nil {
+		return nil, err
+	}
+	b := float64(burst)
+	if burst <= 0 {
+		b = max(1, rate)
+	}
+	return &inputThrottle{rate: rate, burst: b, bucket: tokenBucket{tokens: b, last: time.Now()}}, nil
+}
+
+func parseRate(spec string) (float64, error) {
+	num, unit, _ := strings.Cut(spec, "/")
+	per := time.Second
+	switch unit {
+	case "", "s":
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		if d, err := time.ParseDuration(unit); err == nil && d > 0 {
+			per = d
+		} else {
+			return 0, fmt.Errorf("bad rate %q, want lines/s, /m, /h, or /duration", spec)
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad rate %q, want a positive number of lines", spec)
+	}
+	return n / per.Seconds(), nil
+}
+
+// wait blocks until a line may be read
+func (it *inputThrottle) wait() {
+	it.l.Lock()
+	defer it.l.Unlock()
+	now := time.Now()
+	b := &it.bucket
+	b.tokens = min(it.burst, b.tokens+now.Sub(b.last).Seconds()*it.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens < 0 {
+		// holding the lock keeps the other readers waiting their turn too
+		time.Sleep(time.Duration(-b.tokens / it.rate * float64(time.Second)))
+	}
+}
+
+// allow takes a token if there is one, for callers that refuse lines over the rate rather than wait
+func (it *inputThrottle) allow() bool {
+	it.l.Lock()
+	defer it.l.Unlock()
+	now := time.Now()
+	b := &it.bucket
+	b.tokens = min(it.burst, b.tokens+now.Sub(b.last).Seconds()*it.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
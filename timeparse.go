@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// linesNoTime counts lines -time-regex found no time in, which get the latest time seen
+var linesNoTime uint64
+
+// timeFormats are -time-format names besides Go reference time layouts
+var timeFormats = map[string]string{
+	"rfc3339": time.RFC3339Nano,
+	"clf":     "02/Jan/2006:15:04:05 -0700",
+	"syslog":  time.Stamp,
+	"unix":    "",
+	"unixms":  "",
+}
+
+// timeParser reads event times from lines with -time-regex and -time-format,
+// so a sample's line times and window are when things happened, not when they were read.
+type timeParser struct {
+	re *regexp.Regexp
+	// a time.Parse layout, or "unix" or "unixms"
+	format string
+	layout string
+}
+
+func newTimeParser(expr, format string) (*timeParser, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	tp := &timeParser{re: re, format: format, layout: format}
+	if layout, ok := timeFormats[format]; ok {
+		tp.layout = layout
+	} else if !strings.ContainsAny(format, "0123456789") {
+		return nil, fmt.Errorf("bad -time-format %q, want rfc3339, clf, syslog, unix, unixms, or a Go layout like 2006-01-02 15:04:05", format)
+	}
+	return tp, nil
+}
+
+// parse returns the time in line: the regex's first group if it has one, else the whole match
+func (tp *timeParser) parse(line []byte) (time.Time, bool) {
+	m := tp.re.FindSubmatch(line)
+	if m == nil {
+		return time.Time{}, false
+	}
+	raw := m[0]
+	if len(m) > 1 {
+		raw = m[1]
+	}
+	switch tp.format {
+	case "unix", "unixms":
+		v, err := strconv.ParseFloat(string(raw), 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if tp.format == "unixms" {
+			v /= 1000
+		}
+		sec := int64(v)
+		return time.Unix(sec, int64((v-float64(sec))*1e9)), true
+	}
+	t, err := time.Parse(tp.layout, string(raw))
+	if err != nil {
+		return time.Time{}, false
+	}
+	if t.Year() == 0 {
+		// syslog style times have no year
+		now := time.Now()
+		t = t.AddDate(now.Year(), 0, 0)
+		if t.After(now.Add(24 * time.Hour)) {
+			t = t.AddDate(-1, 0, 0)
+		}
+	}
+	return t, true
+}
+
+// lineTime is the time of a line being added: its -time-regex time, or else now.
+// Lines with no time get the latest time seen. Holds c.l.
+func (c *Collector) lineTime(line string, b []byte) time.Time {
+	if c.eventTime == nil {
+		return time.Now()
+	}
+	if b == nil {
+		b = []byte(line)
+	}
+	t, ok := c.eventTime.parse(b)
+	if !ok {
+		atomic.AddUint64(&linesNoTime, 1)
+		if c.end.IsZero() {
+			return time.Now()
+		}
+		return c.end
+	}
+	if t.After(c.end) {
+		c.end = t
+	}
+	return t
+}
@@ -0,0 +1,17 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// openTTY fails, raw terminal mode is only done on linux and the BSDs
+func openTTY() (*os.File, func(), error) {
+	return nil, nil, errors.New("-tui isn't supported on this system")
+}
+
+func terminalSize(f *os.File) (width, height int) {
+	return 80, 24
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// tui is -tui: the sample, seen count, a rate sparkline, and a filter box, redrawn in place on the terminal
+type tui struct {
+	c       *Collector
+	tty     *os.File
+	restore func()
+
+	// lines per second, oldest first
+	rates    []int
+	lastSeen int
+
+	filter *regexp.Regexp
+	// editing while a filter is being typed into edit
+	editing   bool
+	edit      []rune
+	filterErr string
+	// lines up from the newest
+	scroll int
+
+	status tuiStatus
+	keys   chan []byte
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// tuiStatus keeps the last log message for the bottom line
+type tuiStatus struct {
+	last string
+	l    sync.Mutex
+}
+
+func (ts *tuiStatus) Write(p []byte) (int, error) {
+	ts.l.Lock()
+	defer ts.l.Unlock()
+	ts.last = string(bytes.TrimSpace(p))
+	return len(p), nil
+}
+
+func (ts *tuiStatus) String() string {
+	ts.l.Lock()
+	defer ts.l.Unlock()
+	return ts.last
+}
+
+const sparks = "▁▂▃▄▅▆▇█"
+
+// startTUI takes over the terminal until close, logging to its status line
+func startTUI(c *Collector) (*tui, error) {
+	tty, restore, err := openTTY()
+	if err != nil {
+		return nil, err
+	}
+	t := &tui{
+		c:        c,
+		tty:      tty,
+		restore:  restore,
+		lastSeen: c.Seen(),
+		keys:     make(chan []byte),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	setLogOutput(&t.status)
+	// alternate screen, hidden cursor
+	tty.WriteString("\x1b[?1049h\x1b[?25l")
+	go t.readKeys()
+	go t.run()
+	return t, nil
+}
+
+// close puts the terminal back and logging back on stderr
+func (t *tui) close() {
+	close(t.stop)
+	<-t.done
+	t.tty.WriteString("\x1b[?25h\x1b[?1049l")
+	t.restore()
+	setLogOutput(os.Stderr)
+}
+
+func (t *tui) readKeys() {
+	for {
+		buf := make([]byte, 64)
+		n, err := t.tty.Read(buf)
+		if err != nil {
+			return
+		}
+		select {
+		case t.keys <- buf[:n]:
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+func (t *tui) run() {
+	defer close(t.done)
+	second := time.NewTicker(time.Second)
+	defer second.Stop()
+	redraw := time.NewTicker(250 * time.Millisecond)
+	defer redraw.Stop()
+	t.draw()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-second.C:
+			seen := t.c.Seen()
+			if seen < t.lastSeen {
+				// Reset
+				t.lastSeen = 0
+			}
+			t.rates = append(t.rates, seen-t.lastSeen)
+			if len(t.rates) > 500 {
+				t.rates = t.rates[len(t.rates)-500:]
+			}
+			t.lastSeen = seen
+		case <-redraw.C:
+		case key := <-t.keys:
+			t.key(key)
+		}
+		t.draw()
+	}
+}
+
+// key handles one read from the terminal: keys, and escape sequences like arrows
+func (t *tui) key(b []byte) {
+	for len(b) > 0 {
+		if len(b) >= 3 && b[0] == 0x1b && b[1] == '[' {
+			switch b[2] {
+			case 'A':
+				t.scroll++
+			case 'B':
+				t.scroll = max(0, t.scroll-1)
+			}
+			b = b[3:]
+			continue
+		}
+		r, size := utf8.DecodeRune(b)
+		b = b[size:]
+		if t.editing {
+			t.editKey(r)
+			continue
+		}
+		switch r {
+		case 'q', 0x03:
+			wake(&shouldquit)
+		case '/':
+			t.editing = true
+			t.edit = nil
+			if t.filter != nil {
+				t.edit = []rune(t.filter.String())
+			}
+		case 0x1b:
+			t.setFilter("")
+		case 'k':
+			t.scroll++
+		case 'j':
+			t.scroll = max(0, t.scroll-1)
+		}
+	}
+}
+
+// editKey is a key typed into the filter box
+func (t *tui) editKey(r rune) {
+	switch r {
+	case '\r', '\n':
+		t.editing = false
+		t.setFilter(string(t.edit))
+	case 0x1b:
+		t.editing = false
+	case 0x7f, 0x08:
+		if len(t.edit) > 0 {
+			t.edit = t.edit[:len(t.edit)-1]
+		}
+	case 0x03:
+		wake(&shouldquit)
+	default:
+		if r >= ' ' {
+			t.edit = append(t.edit, r)
+		}
+	}
+}
+
+func (t *tui) setFilter(expr string) {
+	t.filterErr = ""
+	t.scroll = 0
+	if expr == "" {
+		t.filter = nil
+		return
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		t.filterErr = err.Error()
+		return
+	}
+	t.filter = re
+}
+
+func (t *tui) draw() {
+	width, height := terminalSize(t.tty)
+	st := t.c.Stats()
+	lines, nos := t.c.LinesAndNumbers()
+	var shown []string
+	for i, line := range lines {
+		if t.filter == nil || t.filter.MatchString(line) {
+			shown = append(shown, fmt.Sprintf("%9d %s", nos[i], line))
+		}
+	}
+	rate := 0
+	if len(t.rates) > 0 {
+		rate = t.rates[len(t.rates)-1]
+	}
+
+	var screen strings.Builder
+	// home, then each row cleared to its end
+	screen.WriteString("\x1b[H")
+	row := func(s string) {
+		screen.WriteString(tuiLine(s, width))
+		screen.WriteString("\x1b[K\r\n")
+	}
+	row(fmt.Sprintf("ssample %s  %d lines seen, %s  %d of %d kept  %d lines/s", t.c.Source, st.LinesSeen, formatBytes(st.BytesSeen), st.Kept, st.Capacity, rate))
+	row(t.sparkline(width))
+	switch {
+	case t.editing:
+		row("filter: /" + string(t.edit) + "█")
+	case t.filterErr != "":
+		row("filter: " + t.filterErr)
+	case t.filter != nil:
+		row(fmt.Sprintf("filter: /%s/  %d of %d sampled lines match", t.filter, len(shown), len(lines)))
+	default:
+		row("filter: none")
+	}
+	body := max(0, height-4)
+	end := max(0, len(shown)-t.scroll)
+	start := max(0, end-body)
+	for _, line := range shown[start:end] {
+		row(line)
+	}
+	for i := end - start; i < body; i++ {
+		row("")
+	}
+	screen.WriteString("\x1b[7m")
+	screen.WriteString(tuiLine("q quit  / filter  esc clear  ↑↓ scroll  "+t.status.String(), width))
+	screen.WriteString("\x1b[K\x1b[0m")
+	t.tty.WriteString(screen.String())
+}
+
+// sparkline is the last width seconds of lines/s
+func (t *tui) sparkline(width int) string {
+	rates := t.rates[max(0, len(t.rates)-width):]
+	peak := 1
+	for _, r := range rates {
+		peak = max(peak, r)
+	}
+	sparkRunes := []rune(sparks)
+	var sb strings.Builder
+	for _, r := range rates {
+		sb.WriteRune(sparkRunes[r*(len(sparkRunes)-1)/peak])
+	}
+	return sb.String()
+}
+
+// tuiLine is s cut to width runes, with tabs as spaces and other control characters as '.'
+func tuiLine(s string, width int) string {
+	var sb strings.Builder
+	n := 0
+	for _, r := range s {
+		if n == width {
+			break
+		}
+		switch {
+		case r == '\t':
+			r = ' '
+		case r < ' ' || r == 0x7f || (r >= 0x80 && r < 0xa0):
+			r = '.'
+		}
+		sb.WriteRune(r)
+		n++
+	}
+	return sb.String()
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed ui.html
+var uiHTML []byte
+
+// ui serves the dashboard at /ui, which polls the json sample from "/"
+func ui(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(uiHTML)
+}
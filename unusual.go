@@ -0,0 +1,183 @@
+package main
+
+import (
+	"container/heap"
+	"hash"
+	"hash/fnv"
+	"math"
+)
+
+// unusualSampler is -unusual: a priority sample (Duffield, Lund, and Thorup) weighted toward lines
+// whose tokens are uncommon in the stream so far, so the sample over-represents odd lines.
+//
+// A line's weight w is (1 + surprise)^bias, surprise being the mean of -log2 of its tokens'
+// frequencies (tokens with digits are one wildcard token). Its priority is w/u for a uniform
+// random u, and the lines with the highest priorities are kept. Given tau, the highest priority
+// passed by, a kept line was kept with probability min(1, w/tau) and so stands for max(1, tau/w)
+// input lines; those weights sum to an unbiased estimate of lines seen, or of any subset.
+type unusualSampler struct {
+	bias float64
+
+	// not seeded, so a -seed run samples the same every time
+	tokens countMin
+	hasher hash.Hash64
+
+	// parallel to the Collector's kept lines
+	priorities []float64
+	weights    []float64
+	// kept line indexes as a min-heap by priority, and where each index is in it
+	byPriority []int
+	heapIndex  []int
+	tau        float64
+}
+
+func newUnusualSampler(bias float64) *unusualSampler {
+	return &unusualSampler{bias: bias, hasher: fnv.New64a()}
+}
+
+// weight counts line's tokens and returns its weight
+func (us *unusualSampler) weight(line []byte) float64 {
+	var tokens [32][]byte
+	fields := splitFields(tokens[:0], line, nil)
+	if len(fields) == 0 {
+		return 1
+	}
+	var buf [32]uint64
+	hashes := buf[:0]
+	for _, tok := range fields {
+		us.hasher.Reset()
+		us.hasher.Write(maskToken(tok))
+		h := us.hasher.Sum64()
+		us.tokens.add(h)
+		hashes = append(hashes, h)
+	}
+	total := float64(us.tokens.total.Load())
+	surprise := 0.0
+	for _, h := range hashes {
+		surprise -= math.Log2(float64(us.tokens.estimate(h)) / total)
+	}
+	surprise /= float64(len(fields))
+	return math.Pow(1+surprise, us.bias)
+}
+
+// slot picks where c keeps a new line: c.lines.Len() to add it, the index of the line it replaces, or -1.
+// Holds c.l.
+func (us *unusualSampler) slot(c *Collector, line string, b []byte) int {
+	if b == nil {
+		b = []byte(line)
+	}
+	w := us.weight(b)
+	// 1-u is in (0, 1]
+	priority := w / (1 - c.rng.Float64())
+	if c.lines.Len() < c.LinesToKeep {
+		us.priorities = append(us.priorities, priority)
+		us.weights = append(us.weights, w)
+		heap.Push(priorityHeap{us}, len(us.priorities)-1)
+		return c.lines.Len()
+	}
+	i := us.lowestIndex()
+	if i < 0 || priority <= us.priorities[i] {
+		us.tau = max(us.tau, priority)
+		return -1
+	}
+	us.tau = max(us.tau, us.priorities[i])
+	us.priorities[i] = priority
+	us.weights[i] = w
+	heap.Fix(priorityHeap{us}, 0)
+	return i
+}
+
+// lowestIndex is the kept line with the lowest priority, -1 if there are none
+func (us *unusualSampler) lowestIndex() int {
+	if len(us.byPriority) == 0 {
+		return -1
+	}
+	return us.byPriority[0]
+}
+
+// remove drops kept line i like Shrink does, moving the last one into its place
+func (us *unusualSampler) remove(i int) {
+	us.tau = max(us.tau, us.priorities[i])
+	heap.Remove(priorityHeap{us}, us.heapIndex[i])
+	last := len(us.priorities) - 1
+	if i != last {
+		us.priorities[i] = us.priorities[last]
+		us.weights[i] = us.weights[last]
+		at := us.heapIndex[last]
+		us.byPriority[at] = i
+		us.heapIndex[i] = at
+	}
+	us.priorities = us.priorities[:last]
+	us.weights = us.weights[:last]
+	us.heapIndex = us.heapIndex[:last]
+}
+
+// priorityHeap is a container/heap of the sampler's kept line indexes, lowest priority first
+type priorityHeap struct {
+	us *unusualSampler
+}
+
+func (h priorityHeap) Len() int { return len(h.us.byPriority) }
+
+func (h priorityHeap) Less(a, b int) bool {
+	return h.us.priorities[h.us.byPriority[a]] < h.us.priorities[h.us.byPriority[b]]
+}
+
+func (h priorityHeap) Swap(a, b int) {
+	bp := h.us.byPriority
+	bp[a], bp[b] = bp[b], bp[a]
+	h.us.heapIndex[bp[a]] = a
+	h.us.heapIndex[bp[b]] = b
+}
+
+// Push adds x, the kept line just appended to priorities
+func (h priorityHeap) Push(x any) {
+	h.us.heapIndex = append(h.us.heapIndex, len(h.us.byPriority))
+	h.us.byPriority = append(h.us.byPriority, x.(int))
+}
+
+func (h priorityHeap) Pop() any {
+	last := len(h.us.byPriority) - 1
+	i := h.us.byPriority[last]
+	h.us.byPriority = h.us.byPriority[:last]
+	h.us.heapIndex[i] = -1
+	return i
+}
+
+// lineWeight is how many input lines kept line i stands for
+func (us *unusualSampler) lineWeight(i int) float64 {
+	if i >= len(us.weights) || us.tau == 0 {
+		return 1
+	}
+	return max(1, us.tau/us.weights[i])
+}
+
+func (us *unusualSampler) reset() {
+	us.priorities = nil
+	us.weights = nil
+	us.byPriority = nil
+	us.heapIndex = nil
+	us.tau = 0
+}
+
+// restore sets the priorities of lines from a saved state, n lines if there were none saved
+func (us *unusualSampler) restore(priorities, weights []float64, tau float64, n int) {
+	if len(priorities) != n || len(weights) != n {
+		// from a uniform sample: make them the first to go
+		priorities = make([]float64, n)
+		weights = make([]float64, n)
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+	us.priorities = priorities
+	us.weights = weights
+	us.tau = tau
+	us.byPriority = make([]int, n)
+	us.heapIndex = make([]int, n)
+	for i := range us.byPriority {
+		us.byPriority[i] = i
+		us.heapIndex[i] = i
+	}
+	heap.Init(priorityHeap{us})
+}
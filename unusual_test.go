@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"testing"
+)
+
+// checkPriorityHeap fails unless us's heap is a min-heap of every kept line with heapIndex its inverse
+func checkPriorityHeap(t *testing.T, us *unusualSampler, kept int) {
+	t.Helper()
+	if len(us.priorities) != kept || len(us.weights) != kept || len(us.byPriority) != kept || len(us.heapIndex) != kept {
+		t.Fatalf("%d kept, %d priorities, %d weights, heap of %d, %d heap indexes",
+			kept, len(us.priorities), len(us.weights), len(us.byPriority), len(us.heapIndex))
+	}
+	lowest := -1
+	for i, p := range us.priorities {
+		if us.byPriority[us.heapIndex[i]] != i {
+			t.Fatalf("line %d is at %d in the heap, which has %d there", i, us.heapIndex[i], us.byPriority[us.heapIndex[i]])
+		}
+		if lowest < 0 || p < us.priorities[lowest] {
+			lowest = i
+		}
+	}
+	for at := 1; at < kept; at++ {
+		if us.priorities[us.byPriority[(at-1)/2]] > us.priorities[us.byPriority[at]] {
+			t.Fatalf("heap out of order at %d", at)
+		}
+	}
+	if got := us.lowestIndex(); kept != 0 && us.priorities[got] != us.priorities[lowest] {
+		t.Fatalf("lowestIndex %d of priority %g, lowest is %g", got, us.priorities[got], us.priorities[lowest])
+	}
+}
+
+func TestUnusualHeap(t *testing.T) {
+	seedCollectors(7)
+	defer func() { collectorSeeds.rng = nil }()
+	c := NewCollector(50, "")
+	c.SetUnusual(newUnusualSampler(2))
+	us := c.unusual
+	for i := 0; i < 3000; i++ {
+		line := fmt.Sprintf("GET /index %d", i)
+		if i%97 == 0 {
+			line = fmt.Sprintf("panic: %d out of memory", i)
+		}
+		c.AddLine(line)
+		checkPriorityHeap(t, us, c.lines.Len())
+		if i == 1000 {
+			c.Shrink(20)
+			checkPriorityHeap(t, us, c.lines.Len())
+		}
+	}
+	if c.lines.Len() != 20 {
+		t.Errorf("%d lines kept, want 20", c.lines.Len())
+	}
+	// every kept line outranks every line passed by
+	for _, p := range us.priorities {
+		if p < us.tau {
+			t.Errorf("kept priority %g under tau %g", p, us.tau)
+		}
+	}
+
+	blob, err := c.MarshalState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := NewCollector(20, "")
+	again.SetUnusual(newUnusualSampler(2))
+	if err := again.RestoreState(blob); err != nil {
+		t.Fatal(err)
+	}
+	checkPriorityHeap(t, again.unusual, again.lines.Len())
+	again.Reset()
+	checkPriorityHeap(t, again.unusual, 0)
+	again.AddLine("after a reset")
+	checkPriorityHeap(t, again.unusual, 1)
+}
+
+func TestUnusualWeight(t *testing.T) {
+	us := newUnusualSampler(1)
+	for i := 0; i < 100; i++ {
+		us.weight([]byte("GET /index ok"))
+	}
+	common := us.weight([]byte("GET /index ok"))
+	odd := us.weight([]byte("panic: out of memory"))
+	if common < 1 || odd <= common {
+		t.Errorf("weights common %g, odd %g", common, odd)
+	}
+	if w := us.weight(nil); w != 1 {
+		t.Errorf("empty line weight %g", w)
+	}
+	// the reused hasher hashes as a new one would
+	fh := fnv.New64a()
+	fh.Write([]byte("GET"))
+	us.hasher.Reset()
+	us.hasher.Write([]byte("GET"))
+	if us.hasher.Sum64() != fh.Sum64() || us.tokens.estimate(fh.Sum64()) < 101 {
+		t.Errorf("GET counted %d times", us.tokens.estimate(fh.Sum64()))
+	}
+	if math.IsNaN(odd) || math.IsInf(odd, 0) {
+		t.Errorf("odd weight %g", odd)
+	}
+}
+
+func BenchmarkUnusual(b *testing.B) {
+	c := NewCollector(100000, "")
+	c.SetUnusual(newUnusualSampler(1))
+	lines := make([]string, 1000)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("GET /item/%d 200 %dms", i, i%37)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.AddLine(lines[i%len(lines)])
+	}
+}
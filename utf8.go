@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// linesInvalidUTF8 counts lines -invalid-utf8 replace or skip found invalid UTF-8 in
+var linesInvalidUTF8 uint64
+
+// -invalid-utf8 values
+const (
+	// keep lines as they are; json and gRPC output carry such lines' exact bytes separately
+	utf8Raw = "raw"
+	// replace invalid bytes with U+FFFD before sampling
+	utf8Replace = "replace"
+	// leave out lines with invalid UTF-8
+	utf8Skip = "skip"
+)
+
+func checkUTF8Policy(policy string) error {
+	switch policy {
+	case utf8Raw, utf8Replace, utf8Skip:
+		return nil
+	}
+	return fmt.Errorf("unknown -invalid-utf8 %q, want raw, replace, or skip", policy)
+}
+
+// utf8Stage is -invalid-utf8 replace or skip
+type utf8Stage struct {
+	skip bool
+	out  []byte
+}
+
+func (us *utf8Stage) apply(line []byte) ([]byte, bool) {
+	if utf8.Valid(line) {
+		return line, true
+	}
+	atomic.AddUint64(&linesInvalidUTF8, 1)
+	if us.skip {
+		return nil, false
+	}
+	us.out = append(us.out[:0], bytes.ToValidUTF8(line, []byte("\uFFFD"))...)
+	return us.out, true
+}
+
+// fixUTF8 makes rec.Line valid UTF-8 for json and protobuf, which can't carry anything else
+// as a string, keeping the original bytes in LineBase64
+func (rec *SampleRecord) fixUTF8() {
+	if utf8.ValidString(rec.Line) {
+		return
+	}
+	rec.LineBase64 = []byte(rec.Line)
+	rec.Line = strings.ToValidUTF8(rec.Line, "\uFFFD")
+}
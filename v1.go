@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// SampleRecord is one sampled line with everything known about it
+type SampleRecord struct {
+	LineNumber int    `json:"lineNumber"`
+	Line       string `json:"line"`
+	// the exact line if it isn't valid UTF-8, in which case Line has U+FFFD for invalid bytes
+	LineBase64 []byte `json:"lineBase64,omitempty"`
+	// length of the line in bytes, without the newline
+	Bytes  int       `json:"bytes"`
+	Time   time.Time `json:"time"`
+	Source string    `json:"source,omitempty"`
+	// how many input lines this sampled line stands for
+	Weight float64 `json:"weight"`
+	// the chance this line would be in the sample, 1/weight
+	Probability float64 `json:"probability"`
+	// -dedup: how many more times the line came and was left out, an estimate that's never low
+	Repeats int `json:"repeats,omitempty"`
+	// -rare: the line's pattern is under that fraction of all input
+	Rare bool `json:"rare,omitempty"`
+	// -arrivals: seconds since the line before it arrived, absent for the first
+	Gap *float64 `json:"gap,omitempty"`
+	// -records: the line as an object
+	Record interface{} `json:"record,omitempty"`
+}
+
+// Records returns the sample sorted by line number along with counters from the same moment
+func (c *Collector) Records() ([]SampleRecord, CollectorStats, V1Window) {
+	c.l.Lock()
+	out := make([]SampleRecord, c.lines.Len())
+	weight := 1.0
+	if c.lines.Len() > 0 {
+		weight = float64(c.linesSeen) / float64(c.lines.Len())
+	}
+	for i := range out {
+		out[i] = SampleRecord{
+			LineNumber: c.lineNumbers[i],
+			Line:       c.lines.Get(i),
+			Bytes:      int(c.lines.spans[i].n),
+			Time:       c.lineTimes[i],
+			Source:     c.Source,
+			Weight:     weight,
+		}
+		if c.unusual != nil {
+			out[i].Weight = c.unusual.lineWeight(i)
+		}
+		if c.keyed != nil {
+			out[i].Weight = c.keyed.lineWeight(i)
+		}
+		if c.arrivals != nil && c.lineGaps[i] != noGap {
+			gap := c.lineGaps[i].Seconds()
+			out[i].Gap = &gap
+		}
+		out[i].Probability = inclusionProbability(out[i].Weight)
+		if c.lineSources != nil {
+			out[i].Source = c.lineSources[i]
+		}
+		if c.rare != nil {
+			out[i].Rare = c.rare.rare([]byte(out[i].Line))
+		}
+		if c.dedup != nil {
+			out[i].Repeats = c.dedup.repeatsOf([]byte(out[i].Line))
+		}
+		out[i].fixUTF8()
+	}
+	stats := CollectorStats{
+		Capacity:  c.LinesToKeep,
+		LinesSeen: c.linesSeen,
+		BytesSeen: c.bytesSeen,
+		Kept:      c.lines.Len(),
+		Distinct:  c.lines.Distinct(),
+		Evictions: c.evictions,
+	}
+	window := c.window()
+	c.l.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].LineNumber < out[j].LineNumber })
+	return out, stats, window
+}
+
+// Window is the span of input the sample covers
+func (c *Collector) Window() V1Window {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.window()
+}
+
+// window holds c.l
+func (c *Collector) window() V1Window {
+	window := V1Window{Start: c.start, End: time.Now()}
+	if c.eventTime != nil && !c.end.IsZero() {
+		window.End = c.end
+	}
+	return window
+}
+
+// V1Sample is the /v1/sample response. Fields are only ever added.
+type V1Sample struct {
+	sampleHeader
+	Version   int    `json:"version"`
+	Host      V1Host `json:"host"`
+	Source    string `json:"source,omitempty"`
+	Capacity  int    `json:"capacity"`
+	LinesSeen int    `json:"seen"`
+	BytesSeen int64  `json:"bytesSeen"`
+	// lines in the sample over lines seen, 1 until it's full
+	SamplingRate float64  `json:"samplingRate"`
+	Window       V1Window `json:"window"`
+	// -stat fields over all input
+	Stats []FieldStats `json:"stats,omitempty"`
+	// -histogram over all input
+	Histogram *Histogram `json:"histogram,omitempty"`
+	// -count regexes over all input
+	Counts []PatternCount `json:"counts,omitempty"`
+	// lines seen in each minute of the last hour, oldest first
+	PerMinute []MinuteCount `json:"perMinute,omitempty"`
+	// lines and bytes seen from each input, most first, when there's more than one
+	Sources []SourceCount `json:"sources,omitempty"`
+	// -arrivals gaps between input lines
+	Arrivals *ArrivalStats `json:"arrivals,omitempty"`
+	// -first-by or -last-by keys, for those collectors
+	Keys  *KeyStats      `json:"keys,omitempty"`
+	Lines []SampleRecord `json:"lines"`
+}
+
+// V1Host is the process that made a sample; hostname, pid, and started together tell runs apart
+type V1Host struct {
+	Hostname string `json:"hostname"`
+	Pid      int    `json:"pid"`
+	// when the process started, which may be before the window with -state
+	Started time.Time `json:"started"`
+}
+
+// samplingRate is kept/seen, 1 before any lines
+func samplingRate(kept, seen int) float64 {
+	if seen == 0 {
+		return 1
+	}
+	return float64(kept) / float64(seen)
+}
+
+// inclusionProbability is the chance a line of this weight is sampled
+func inclusionProbability(weight float64) float64 {
+	if weight <= 0 {
+		return 0
+	}
+	return min(1, 1/weight)
+}
+
+func thisHost() V1Host {
+	hostname, _ := os.Hostname()
+	return V1Host{Hostname: hostname, Pid: os.Getpid(), Started: processStart}
+}
+
+// V1Window is the span of input the sample covers. Start is zero before any input.
+// With -time-regex it is from the earliest to the latest line time, otherwise from the first line to now.
+type V1Window struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+func (s *ssampleServer) v1Sample() *V1Sample {
+	records, st, window := s.c.Records()
+	if recordFormat != "" {
+		for i := range records {
+			records[i].Record = recordValue(records[i].Line)
+		}
+	}
+	return &V1Sample{
+		sampleHeader: newSampleHeader(s.c.algorithm()),
+		Version:      1,
+		Host:         thisHost(),
+		Source:       s.c.Source,
+		Capacity:     st.Capacity,
+		LinesSeen:    st.LinesSeen,
+		BytesSeen:    st.BytesSeen,
+		SamplingRate: samplingRate(len(records), st.LinesSeen),
+		Window:       window,
+		Stats:        s.c.FieldStats(),
+		Histogram:    s.c.Histogram(),
+		Counts:       s.c.PatternCounts(),
+		PerMinute:    s.c.PerMinute(),
+		Sources:      s.c.Sources(),
+		Arrivals:     s.c.Arrivals(),
+		Keys:         s.c.KeyStats(),
+		Lines:        records,
+	}
+}
+
+// v1SampleHandler serves GET /v1/sample
+func (s *ssampleServer) v1SampleHandler(w http.ResponseWriter, r *http.Request) {
+	if notModified(w, r, s.c, fmtJSON) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeV1JSON(w, s.v1Sample())
+}
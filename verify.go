@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// teeCheck is the result of verifying one tee file
+type teeCheck struct {
+	runs  int
+	marks int
+	lines int
+	// lines after the last mark, not covered by any checksum
+	unmarked int
+}
+
+// gunzipMaybe reads r through gzip if it starts with the gzip magic number
+func gunzipMaybe(r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	magic, _ := br.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReaderSize(readAhead(zr), 64*1024)
+	}
+	return br, nil
+}
+
+// verifyTee checks the marks written by -tee-mark-every, gunzipping a -teez file
+func verifyTee(r io.Reader) (teeCheck, error) {
+	var tc teeCheck
+	br, err := gunzipMaybe(r)
+	if err != nil {
+		return tc, err
+	}
+	prefix := []byte(teeMarkPrefix)
+	crc := crc32.New(castagnoli)
+	var offset int64
+	lines := 0
+	lineno := 0
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) != 0 {
+			lineno++
+		}
+		if len(line) != 0 && !bytes.HasPrefix(line, prefix) {
+			if tc.runs == 0 {
+				return tc, fmt.Errorf("line %d: data before any start mark, was it written with -tee-mark-every?", lineno)
+			}
+			crc.Write(line)
+			offset += int64(len(line))
+			lines++
+			tc.lines++
+			tc.unmarked++
+		} else if string(line) == teeStartMark {
+			if tc.unmarked != 0 {
+				// a run that stopped without its final mark, e.g. killed
+				return tc, fmt.Errorf("line %d: %d lines before this run's start are not covered by a mark", lineno, tc.unmarked)
+			}
+			tc.runs++
+			crc.Reset()
+			offset = 0
+			lines = 0
+		} else if len(line) != 0 {
+			var mOffset int64
+			var mLines int
+			var mCRC uint32
+			_, serr := fmt.Sscanf(string(line), teeMarkFormat, &mOffset, &mLines, &mCRC)
+			if serr != nil {
+				return tc, fmt.Errorf("line %d: bad mark: %v", lineno, serr)
+			}
+			if mOffset != offset || mLines != lines {
+				return tc, fmt.Errorf("line %d: mark says offset %d lines %d, but read %d bytes %d lines (data lost or added)", lineno, mOffset, mLines, offset, lines)
+			}
+			if mCRC != crc.Sum32() {
+				return tc, fmt.Errorf("line %d: crc32c mismatch, data before this mark is corrupt", lineno)
+			}
+			crc.Reset()
+			tc.marks++
+			tc.unmarked = 0
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return tc, err
+		}
+	}
+	if tc.runs == 0 {
+		return tc, fmt.Errorf("no start mark, was it written with -tee-mark-every?")
+	}
+	return tc, nil
+}
+
+// verifyMain is `ssample verify tee-file ...`
+func verifyMain(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s verify tee-file ...\n\nCheck the marks written into -a or -teez files by -tee-mark-every.\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(exitStart)
+	}
+	failed := false
+	for _, path := range fs.Args() {
+		fin, err := os.Open(path)
+		if err != nil {
+			errorf("%v", err)
+			failed = true
+			continue
+		}
+		tc, err := verifyTee(fin)
+		fin.Close()
+		if err != nil {
+			errorf("%s: %v", path, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: ok, %d lines in %d runs, %d marks", path, tc.lines, tc.runs, tc.marks)
+		if tc.unmarked != 0 {
+			fmt.Printf(", last %d lines unmarked", tc.unmarked)
+		}
+		fmt.Printf("\n")
+	}
+	if failed {
+		os.Exit(exitError)
+	}
+}
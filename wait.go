@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type seenWaiter struct {
+	target int
+	ch     chan struct{}
+}
+
+// WaitSeen blocks until the collector has seen target lines, or is Reset, or ctx is done.
+// It returns false if ctx ended the wait.
+func (c *Collector) WaitSeen(ctx context.Context, target int) bool {
+	c.l.Lock()
+	if c.linesSeen >= target {
+		c.l.Unlock()
+		return true
+	}
+	sw := &seenWaiter{target: target, ch: make(chan struct{})}
+	c.waiters = append(c.waiters, sw)
+	c.l.Unlock()
+	select {
+	case <-sw.ch:
+		return true
+	case <-ctx.Done():
+		c.l.Lock()
+		for i, w := range c.waiters {
+			if w == sw {
+				c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+				break
+			}
+		}
+		c.l.Unlock()
+		return false
+	}
+}
+
+// wakeWaiters must be called with c.l held. all wakes everyone, as after a Reset.
+func (c *Collector) wakeWaiters(all bool) {
+	keep := c.waiters[:0]
+	for _, w := range c.waiters {
+		if all || c.linesSeen >= w.target {
+			close(w.ch)
+		} else {
+			keep = append(keep, w)
+		}
+	}
+	for i := len(keep); i < len(c.waiters); i++ {
+		c.waiters[i] = nil
+	}
+	c.waiters = keep
+}
+
+const maxWait = 5 * time.Minute
+
+// wait serves /wait?lines=N&timeout=30s, responding like / once N more lines have been seen.
+// If the timeout comes first the current sample is returned with "X-Wait-Timeout: 1".
+func (s *ssampleServer) wait(w http.ResponseWriter, r *http.Request) {
+	lines := 1
+	if lstr := r.FormValue("lines"); lstr != "" {
+		var err error
+		lines, err = strconv.Atoi(lstr)
+		if err != nil || lines < 0 {
+			badRequest(w, fmt.Errorf("bad lines=%q", lstr))
+			return
+		}
+	}
+	timeout := 30 * time.Second
+	if tstr := r.FormValue("timeout"); tstr != "" {
+		var err error
+		timeout, err = time.ParseDuration(tstr)
+		if err != nil || timeout < 0 {
+			badRequest(w, fmt.Errorf("bad timeout=%q", tstr))
+			return
+		}
+	}
+	if timeout > maxWait {
+		timeout = maxWait
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	if !s.c.WaitSeen(ctx, s.c.Seen()+lines) {
+		if r.Context().Err() != nil {
+			// client went away
+			return
+		}
+		w.Header().Set("X-Wait-Timeout", "1")
+	}
+	out, err := s.query(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	writeSample(w, negotiateFormat(r), out)
+}
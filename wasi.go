@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// WASI preview 1 for -wasm modules built for it, as Go and TinyGo's wasip1 and Rust's wasm32-wasip1 are,
+// with nothing of the world outside the module: no files, args, or environment, stdin at its end, and
+// stdout and stderr going to ssample's stderr. The clocks and random numbers are real.
+// Calls for anything else fail with ENOTCAPABLE.
+
+// WASI errno values
+const (
+	wasiSuccess     = 0
+	wasiEBADF       = 8
+	wasiEFAULT      = 21
+	wasiEINVAL      = 28
+	wasiESPIPE      = 70
+	wasiENOTCAPABLE = 76
+)
+
+// wasiStart is when the monotonic clock started
+var wasiStart = time.Now()
+
+// wasmHosts returns the functions for m's imports, which can be WASI's and AssemblyScript's env.abort,
+// with a module's output written to out
+func wasmHosts(m *wasmModule, out io.Writer) ([]wasmHostFunc, error) {
+	var hosts []wasmHostFunc
+	for _, imp := range m.imports {
+		ft := m.types[imp.typ]
+		var f wasmHostFunc
+		switch imp.module {
+		case "wasi_snapshot_preview1", "wasi_unstable":
+			f = wasiFuncs(imp.name, out)
+			if f == nil && len(ft.results) == 1 && ft.results[0] == wasmI32 {
+				f = func(in *wasmInstance, args []uint64) { args[0] = wasiENOTCAPABLE }
+			}
+		case "env":
+			if imp.name == "abort" {
+				f = func(in *wasmInstance, args []uint64) { wasmTrapf("aborted") }
+			}
+		}
+		if f == nil {
+			return nil, fmt.Errorf("import %s.%s isn't provided, a -wasm module can import only WASI", imp.module, imp.name)
+		}
+		hosts = append(hosts, f)
+	}
+	return hosts, nil
+}
+
+// wasiPut32 and wasiPut64 write v at ptr, false if it's outside memory
+func wasiPut32(in *wasmInstance, ptr uint64, v uint32) bool {
+	b, ok := in.memRange(uint32(ptr), 4)
+	if ok {
+		binary.LittleEndian.PutUint32(b, v)
+	}
+	return ok
+}
+
+func wasiPut64(in *wasmInstance, ptr uint64, v uint64) bool {
+	b, ok := in.memRange(uint32(ptr), 8)
+	if ok {
+		binary.LittleEndian.PutUint64(b, v)
+	}
+	return ok
+}
+
+func wasiErrno(ok bool) uint64 {
+	if ok {
+		return wasiSuccess
+	}
+	return wasiEFAULT
+}
+
+// wasiFuncs returns the WASI function name, nil if it isn't one ssample provides
+func wasiFuncs(name string, out io.Writer) wasmHostFunc {
+	switch name {
+	case "args_sizes_get", "environ_sizes_get":
+		return func(in *wasmInstance, args []uint64) {
+			args[0] = wasiErrno(wasiPut32(in, args[0], 0) && wasiPut32(in, args[1], 0))
+		}
+	case "args_get", "environ_get", "sched_yield":
+		return func(in *wasmInstance, args []uint64) { args[0] = wasiSuccess }
+	case "clock_res_get":
+		return func(in *wasmInstance, args []uint64) {
+			args[0] = wasiErrno(wasiPut64(in, args[1], 1000))
+		}
+	case "clock_time_get":
+		return func(in *wasmInstance, args []uint64) {
+			var t uint64
+			switch uint32(args[0]) {
+			case 0:
+				t = uint64(time.Now().UnixNano())
+			default:
+				// monotonic, and process and thread CPU time
+				t = uint64(time.Since(wasiStart))
+			}
+			args[0] = wasiErrno(wasiPut64(in, args[2], t))
+		}
+	case "random_get":
+		return func(in *wasmInstance, args []uint64) {
+			b, ok := in.memRange(uint32(args[0]), uint32(args[1]))
+			if ok {
+				rand.Read(b)
+			}
+			args[0] = wasiErrno(ok)
+		}
+	case "fd_write":
+		return func(in *wasmInstance, args []uint64) {
+			fd := uint32(args[0])
+			if fd != 1 && fd != 2 {
+				args[0] = wasiEBADF
+				return
+			}
+			n, ok := wasiIovecs(in, uint32(args[1]), uint32(args[2]), func(b []byte) { out.Write(b) })
+			args[0] = wasiErrno(ok && wasiPut32(in, args[3], n))
+		}
+	case "fd_read":
+		return func(in *wasmInstance, args []uint64) {
+			if uint32(args[0]) != 0 {
+				args[0] = wasiEBADF
+				return
+			}
+			// stdin is empty
+			args[0] = wasiErrno(wasiPut32(in, args[3], 0))
+		}
+	case "fd_fdstat_get":
+		return func(in *wasmInstance, args []uint64) {
+			if uint32(args[0]) > 2 {
+				args[0] = wasiEBADF
+				return
+			}
+			b, ok := in.memRange(uint32(args[1]), 24)
+			if ok {
+				clear(b)
+				// a character device, with rights to read and write
+				b[0] = 2
+				binary.LittleEndian.PutUint64(b[8:], 1<<1|1<<6)
+			}
+			args[0] = wasiErrno(ok)
+		}
+	case "fd_seek", "fd_tell":
+		return func(in *wasmInstance, args []uint64) {
+			if uint32(args[0]) > 2 {
+				args[0] = wasiEBADF
+				return
+			}
+			args[0] = wasiESPIPE
+		}
+	case "fd_close", "fd_prestat_get", "fd_prestat_dir_name":
+		// there are no preopened directories
+		return func(in *wasmInstance, args []uint64) { args[0] = wasiEBADF }
+	case "poll_oneoff":
+		return wasiPollOneoff
+	case "proc_exit":
+		return func(in *wasmInstance, args []uint64) { wasmTrapf("exited with status %d", uint32(args[0])) }
+	}
+	return nil
+}
+
+// wasiIovecs calls f with each of the n buffers of the iovec array at iovs, returning their total length
+func wasiIovecs(in *wasmInstance, iovs, n uint32, f func([]byte)) (uint32, bool) {
+	if n > 1<<20 {
+		return 0, false
+	}
+	vecs, ok := in.memRange(iovs, n*8)
+	if !ok {
+		return 0, false
+	}
+	var total uint32
+	for i := uint32(0); i < n; i++ {
+		b, ok := in.memRange(binary.LittleEndian.Uint32(vecs[i*8:]), binary.LittleEndian.Uint32(vecs[i*8+4:]))
+		if !ok {
+			return total, false
+		}
+		f(b)
+		total += uint32(len(b))
+	}
+	return total, true
+}
+
+// wasiPollOneoff has every subscription happen at once, without waiting: a filter that sleeps
+// would hold up its reader
+func wasiPollOneoff(in *wasmInstance, args []uint64) {
+	subs, events, n := uint32(args[0]), uint32(args[1]), uint32(args[2])
+	if n == 0 || n > 1<<16 {
+		args[0] = wasiEINVAL
+		return
+	}
+	sb, ok := in.memRange(subs, n*48)
+	eb, ok2 := in.memRange(events, n*32)
+	if !ok || !ok2 {
+		args[0] = wasiEFAULT
+		return
+	}
+	for i := uint32(0); i < n; i++ {
+		sub, ev := sb[i*48:], eb[i*32:i*32+32]
+		clear(ev)
+		// userdata, then the subscription's type
+		copy(ev, sub[:8])
+		ev[10] = sub[8]
+	}
+	args[0] = wasiErrno(wasiPut32(in, args[3], n))
+}
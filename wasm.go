@@ -0,0 +1,1066 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// A small WebAssembly interpreter for -wasm, so line filters can be plugged in without a runtime
+// outside the standard library. It runs the 1.0 instruction set with the later sign-extension,
+// saturating conversion, bulk memory, reference type, multi-value, and extended constant additions,
+// which is what Go, TinyGo, Rust, and AssemblyScript emit by default; SIMD, threads, tail calls,
+// exceptions, and memory64 modules are refused when they load. Modules are validated as they're
+// decoded, see wasmvalid.go.
+//
+// ssample has no dependencies outside the standard library, so this is here rather than wazero,
+// which would be the first. wasm_test.go checks it against a subset of the spec tests' execution,
+// trap, and validation cases, and fuzzes the decoder, validator, and interpreter together.
+
+// value types
+const (
+	wasmI32       = 0x7f
+	wasmI64       = 0x7e
+	wasmF32       = 0x7d
+	wasmF64       = 0x7c
+	wasmFuncref   = 0x70
+	wasmExternref = 0x6f
+)
+
+// import and export kinds
+const (
+	wasmKindFunc   = 0
+	wasmKindTable  = 1
+	wasmKindMemory = 2
+	wasmKindGlobal = 3
+)
+
+const (
+	wasmPageSize = 64 << 10
+	// most locals, including params, a function can have
+	wasmMaxLocals = 50000
+	// most entries in a table
+	wasmMaxTable = 10 << 20
+	// most params or results a function type can have
+	wasmMaxParams = 1000
+)
+
+type wasmFuncType struct {
+	params, results []byte
+}
+
+func (ft wasmFuncType) String() string {
+	return fmt.Sprintf("%x->%x", ft.params, ft.results)
+}
+
+type wasmLimits struct {
+	min, max uint32
+	hasMax   bool
+}
+
+type wasmImport struct {
+	module, name string
+	kind         byte
+	// type index of an imported function
+	typ uint32
+}
+
+type wasmGlobalDef struct {
+	typ  byte
+	mut  bool
+	init []byte
+}
+
+type wasmTableDef struct {
+	elem byte
+	wasmLimits
+}
+
+type wasmExport struct {
+	kind  byte
+	index uint32
+}
+
+// wasmElemSegment is a table initializer, each entry a constant expression
+type wasmElemSegment struct {
+	// 0 active, 1 passive, 2 declarative
+	mode   byte
+	table  uint32
+	offset []byte
+	elem   byte
+	inits  [][]byte
+}
+
+type wasmDataSegment struct {
+	active bool
+	offset []byte
+	data   []byte
+}
+
+// wasmInstr is one decoded instruction. Opcodes after 0xfc are 0x100 plus their sub-opcode;
+// a and b are its immediates, or for blocks where they end.
+type wasmInstr struct {
+	op uint16
+	a  uint32
+	b  uint64
+}
+
+// 0xfc prefixed instructions, as wasmInstr ops
+const wasmOpFC = 0x100
+
+type wasmCode struct {
+	// param and local value types
+	locals []byte
+	body   []wasmInstr
+	// br_table labels, each table's default last
+	brTables []uint32
+	// at most how many values the body pushes, to check there's room on the stack when it's called
+	maxPush int
+}
+
+// wasmModule is a decoded module, ready to instantiate
+type wasmModule struct {
+	types []wasmFuncType
+	// types by their params and results, so call_indirect can compare equal types with different indexes
+	typeIDs []int
+	imports []wasmImport
+	// every function's type index, imported ones first
+	funcs        []uint32
+	importFuncs  int
+	tables       []wasmTableDef
+	memory       *wasmLimits
+	globals      []wasmGlobalDef
+	exports      map[string]wasmExport
+	start        int
+	elems        []wasmElemSegment
+	datas        []wasmDataSegment
+	codes        []wasmCode
+	dataCount    int
+	hasDataCount bool
+}
+
+// wasmReader reads a module's binary encoding, remembering the first error
+type wasmReader struct {
+	b   []byte
+	off int
+	err error
+}
+
+var errWasmEOF = errors.New("unexpected end")
+
+func (r *wasmReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.off = len(r.b)
+}
+
+func (r *wasmReader) byte() byte {
+	if r.off >= len(r.b) {
+		r.fail(errWasmEOF)
+		return 0
+	}
+	c := r.b[r.off]
+	r.off++
+	return c
+}
+
+func (r *wasmReader) bytes(n uint32) []byte {
+	if uint64(n) > uint64(len(r.b)-r.off) {
+		r.fail(errWasmEOF)
+		return nil
+	}
+	out := r.b[r.off : r.off+int(n)]
+	r.off += int(n)
+	return out
+}
+
+// leb reads an LEB128 number of at most bits, sign extending it if signed
+func (r *wasmReader) leb(bits uint, signed bool) uint64 {
+	var v uint64
+	var shift uint
+	for n := uint(0); ; n++ {
+		c := r.byte()
+		if r.err != nil {
+			return 0
+		}
+		if n >= (bits+6)/7 {
+			r.fail(errors.New("integer too long"))
+			return 0
+		}
+		if used := bits - 7*n; used < 7 {
+			// the last byte's bits past the integer's must be zero, or for a signed one copies of its sign
+			if signed {
+				used--
+			}
+			if rest := c & 0x7f >> used; rest != 0 && !(signed && rest == 0x7f>>used) {
+				r.fail(errors.New("integer too long"))
+				return 0
+			}
+		}
+		v |= uint64(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			if signed && shift < 64 && c&0x40 != 0 {
+				v |= ^uint64(0) << shift
+			}
+			return v
+		}
+	}
+}
+
+// fixed32 and fixed64 read a float's little-endian bits
+func (r *wasmReader) fixed32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *wasmReader) fixed64() uint64 {
+	if b := r.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *wasmReader) u32() uint32 {
+	return uint32(r.leb(32, false))
+}
+
+func (r *wasmReader) name() string {
+	b := r.bytes(r.u32())
+	if !utf8.Valid(b) {
+		r.fail(errors.New("name isn't UTF-8"))
+	}
+	return string(b)
+}
+
+func (r *wasmReader) limits() wasmLimits {
+	var l wasmLimits
+	switch flags := r.byte(); flags {
+	case 0:
+	case 1:
+		l.hasMax = true
+	default:
+		r.fail(fmt.Errorf("limits flags %#x: shared or 64 bit memory isn't supported", flags))
+	}
+	l.min = r.u32()
+	if l.hasMax {
+		l.max = r.u32()
+		if l.max < l.min {
+			r.fail(errors.New("limits maximum under minimum"))
+		}
+	}
+	return l
+}
+
+func (r *wasmReader) valType() byte {
+	t := r.byte()
+	switch t {
+	case wasmI32, wasmI64, wasmF32, wasmF64, wasmFuncref, wasmExternref:
+	case 0x7b:
+		r.fail(errors.New("SIMD isn't supported"))
+	default:
+		r.fail(fmt.Errorf("bad value type %#x", t))
+	}
+	return t
+}
+
+func (r *wasmReader) refType() byte {
+	t := r.byte()
+	if t != wasmFuncref && t != wasmExternref {
+		r.fail(fmt.Errorf("bad reference type %#x", t))
+	}
+	return t
+}
+
+// constExpr reads an initializer expression through its end, to be evaluated when instantiating
+func (r *wasmReader) constExpr() []byte {
+	start := r.off
+	for r.err == nil {
+		switch op := r.byte(); op {
+		case 0x0b:
+			return r.b[start:r.off]
+		case 0x41:
+			r.leb(32, true)
+		case 0x42:
+			r.leb(64, true)
+		case 0x43:
+			r.bytes(4)
+		case 0x44:
+			r.bytes(8)
+		case 0x23, 0xd2:
+			r.u32()
+		case 0xd0:
+			r.refType()
+		case 0x6a, 0x6b, 0x6c, 0x7c, 0x7d, 0x7e:
+			// extended constant expressions' add, sub, and mul
+		default:
+			r.fail(fmt.Errorf("instruction %#x in a constant expression", op))
+		}
+	}
+	return nil
+}
+
+// parseWasm decodes and validates a module
+func parseWasm(b []byte) (*wasmModule, error) {
+	if len(b) < 8 || string(b[:4]) != "\x00asm" {
+		return nil, errors.New("not a WebAssembly module")
+	}
+	if v := binary.LittleEndian.Uint32(b[4:8]); v != 1 {
+		return nil, fmt.Errorf("WebAssembly version %d, only 1 is supported", v)
+	}
+	m := &wasmModule{exports: make(map[string]wasmExport), start: -1}
+	r := &wasmReader{b: b, off: 8}
+	var funcTypes []uint32
+	// functions exported or in element segments, which ref.func can refer to
+	refs := make(map[uint32]bool)
+	last := byte(0)
+	for r.off < len(r.b) && r.err == nil {
+		id := r.byte()
+		size := r.u32()
+		sec := &wasmReader{b: r.bytes(size)}
+		if r.err != nil {
+			break
+		}
+		// custom sections go anywhere, the others in order, data count between element and code
+		if id != 0 {
+			order := id * 2
+			if id == 12 {
+				order = 9*2 + 1
+			}
+			if order <= last {
+				return nil, fmt.Errorf("section %d out of order", id)
+			}
+			last = order
+		}
+		switch id {
+		case 0:
+			// custom, names and debug info
+			sec.name()
+			sec.off = len(sec.b)
+		case 1:
+			for n := sec.u32(); n > 0 && sec.err == nil; n-- {
+				if f := sec.byte(); f != 0x60 {
+					sec.fail(fmt.Errorf("bad function type %#x", f))
+				}
+				var ft wasmFuncType
+				for p := sec.u32(); p > 0 && sec.err == nil; p-- {
+					ft.params = append(ft.params, sec.valType())
+				}
+				for p := sec.u32(); p > 0 && sec.err == nil; p-- {
+					ft.results = append(ft.results, sec.valType())
+				}
+				if len(ft.params) > wasmMaxParams || len(ft.results) > wasmMaxParams {
+					sec.fail(errors.New("function type with too many params or results"))
+				}
+				m.types = append(m.types, ft)
+			}
+		case 2:
+			for n := sec.u32(); n > 0 && sec.err == nil; n-- {
+				imp := wasmImport{module: sec.name(), name: sec.name(), kind: sec.byte()}
+				switch imp.kind {
+				case wasmKindFunc:
+					imp.typ = sec.u32()
+					if int(imp.typ) >= len(m.types) {
+						sec.fail(fmt.Errorf("import %s.%s: no type %d", imp.module, imp.name, imp.typ))
+					}
+					m.funcs = append(m.funcs, imp.typ)
+					m.importFuncs++
+				default:
+					// only functions can be provided, say which import it is rather than failing later
+					sec.fail(fmt.Errorf("import %s.%s: only function imports are supported", imp.module, imp.name))
+				}
+				m.imports = append(m.imports, imp)
+			}
+		case 3:
+			for n := sec.u32(); n > 0 && sec.err == nil; n-- {
+				t := sec.u32()
+				if int(t) >= len(m.types) {
+					sec.fail(fmt.Errorf("no function type %d", t))
+				}
+				funcTypes = append(funcTypes, t)
+			}
+			m.funcs = append(m.funcs, funcTypes...)
+		case 4:
+			for n := sec.u32(); n > 0 && sec.err == nil; n-- {
+				t := wasmTableDef{elem: sec.refType()}
+				t.wasmLimits = sec.limits()
+				if t.min > wasmMaxTable {
+					sec.fail(fmt.Errorf("table of %d entries is too big", t.min))
+				}
+				m.tables = append(m.tables, t)
+			}
+		case 5:
+			n := sec.u32()
+			if n > 1 {
+				sec.fail(errors.New("more than one memory"))
+			}
+			if n == 1 {
+				l := sec.limits()
+				if l.min > 1<<16 || l.max > 1<<16 {
+					sec.fail(errors.New("memory over 4GiB"))
+				}
+				m.memory = &l
+			}
+		case 6:
+			for n := sec.u32(); n > 0 && sec.err == nil; n-- {
+				g := wasmGlobalDef{typ: sec.valType()}
+				switch mut := sec.byte(); mut {
+				case 0:
+				case 1:
+					g.mut = true
+				default:
+					sec.fail(fmt.Errorf("bad global mutability %#x", mut))
+				}
+				g.init = sec.constExpr()
+				if sec.err == nil {
+					if err := m.checkConst(g.init, g.typ, len(m.globals), refs); err != nil {
+						sec.fail(fmt.Errorf("global %d: %v", len(m.globals), err))
+					}
+				}
+				m.globals = append(m.globals, g)
+			}
+		case 7:
+			for n := sec.u32(); n > 0 && sec.err == nil; n-- {
+				name := sec.name()
+				e := wasmExport{kind: sec.byte(), index: sec.u32()}
+				if _, dup := m.exports[name]; dup {
+					sec.fail(fmt.Errorf("export %q twice", name))
+				}
+				if e.kind == wasmKindFunc {
+					refs[e.index] = true
+				}
+				m.exports[name] = e
+			}
+		case 8:
+			m.start = int(sec.u32())
+		case 9:
+			for n := sec.u32(); n > 0 && sec.err == nil; n-- {
+				seg := sec.elemSegment()
+				if sec.err == nil {
+					if err := m.checkElemSegment(&seg, refs); err != nil {
+						sec.fail(fmt.Errorf("element segment %d: %v", len(m.elems), err))
+					}
+				}
+				m.elems = append(m.elems, seg)
+			}
+		case 10:
+			n := sec.u32()
+			if int(n) != len(funcTypes) {
+				sec.fail(fmt.Errorf("%d function bodies for %d functions", n, len(funcTypes)))
+			}
+			for i := 0; i < int(n) && sec.err == nil; i++ {
+				body := sec.bytes(sec.u32())
+				if sec.err != nil {
+					break
+				}
+				code, err := m.compile(m.types[funcTypes[i]], body, refs)
+				if err != nil {
+					return nil, fmt.Errorf("function %d: %v", m.importFuncs+i, err)
+				}
+				m.codes = append(m.codes, code)
+			}
+		case 11:
+			n := sec.u32()
+			if m.hasDataCount && int(n) != m.dataCount {
+				sec.fail(fmt.Errorf("%d data segments, data count said %d", n, m.dataCount))
+			}
+			for ; n > 0 && sec.err == nil; n-- {
+				var d wasmDataSegment
+				switch kind := sec.u32(); kind {
+				case 0:
+					d.active = true
+					d.offset = sec.constExpr()
+				case 1:
+				case 2:
+					if sec.u32() != 0 {
+						sec.fail(errors.New("data segment for a memory other than 0"))
+					}
+					d.active = true
+					d.offset = sec.constExpr()
+				default:
+					sec.fail(fmt.Errorf("bad data segment kind %d", kind))
+				}
+				d.data = sec.bytes(sec.u32())
+				if d.active && sec.err == nil {
+					err := errors.New("no memory")
+					if m.memory != nil {
+						err = m.checkConst(d.offset, wasmI32, len(m.globals), refs)
+					}
+					if err != nil {
+						sec.fail(fmt.Errorf("data segment %d: %v", len(m.datas), err))
+					}
+				}
+				m.datas = append(m.datas, d)
+			}
+		case 12:
+			m.dataCount = int(sec.u32())
+			m.hasDataCount = true
+		case 13:
+			return nil, errors.New("exception handling isn't supported")
+		default:
+			return nil, fmt.Errorf("unknown section %d", id)
+		}
+		if sec.err == nil && sec.off != len(sec.b) {
+			sec.fail(errors.New("section longer than its contents"))
+		}
+		if sec.err != nil {
+			return nil, fmt.Errorf("section %d: %v", id, sec.err)
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(m.codes) != len(funcTypes) {
+		return nil, fmt.Errorf("%d function bodies for %d functions", len(m.codes), len(funcTypes))
+	}
+	if m.start >= len(m.funcs) {
+		return nil, fmt.Errorf("no start function %d", m.start)
+	}
+	if m.start >= 0 && m.funcType(m.start).String() != "->" {
+		return nil, fmt.Errorf("start function's type isn't ->, it's %s", m.funcType(m.start))
+	}
+	if m.hasDataCount && len(m.datas) != m.dataCount {
+		return nil, fmt.Errorf("%d data segments, data count said %d", len(m.datas), m.dataCount)
+	}
+	for name, e := range m.exports {
+		var n int
+		switch e.kind {
+		case wasmKindFunc:
+			n = len(m.funcs)
+		case wasmKindTable:
+			n = len(m.tables)
+		case wasmKindMemory:
+			n = 0
+			if m.memory != nil {
+				n = 1
+			}
+		case wasmKindGlobal:
+			n = len(m.globals)
+		default:
+			return nil, fmt.Errorf("export %q: bad kind %d", name, e.kind)
+		}
+		if int(e.index) >= n {
+			return nil, fmt.Errorf("export %q: no such %s", name, wasmKindName(e.kind))
+		}
+	}
+	ids := make(map[string]int)
+	for _, ft := range m.types {
+		id, ok := ids[ft.String()]
+		if !ok {
+			id = len(ids)
+			ids[ft.String()] = id
+		}
+		m.typeIDs = append(m.typeIDs, id)
+	}
+	return m, nil
+}
+
+func wasmKindName(kind byte) string {
+	switch kind {
+	case wasmKindFunc:
+		return "function"
+	case wasmKindTable:
+		return "table"
+	case wasmKindMemory:
+		return "memory"
+	case wasmKindGlobal:
+		return "global"
+	}
+	return fmt.Sprintf("kind %d", kind)
+}
+
+func (r *wasmReader) elemSegment() wasmElemSegment {
+	var seg wasmElemSegment
+	flags := r.u32()
+	if flags > 7 {
+		r.fail(fmt.Errorf("bad element segment flags %d", flags))
+		return seg
+	}
+	switch {
+	case flags&1 == 0:
+		// active
+		if flags&2 != 0 {
+			seg.table = r.u32()
+		}
+		seg.offset = r.constExpr()
+	case flags&2 == 0:
+		seg.mode = 1
+	default:
+		seg.mode = 2
+	}
+	seg.elem = wasmFuncref
+	exprs := flags&4 != 0
+	if flags&3 != 0 {
+		// an element kind or type, 0 or 8 say which
+		if exprs {
+			seg.elem = r.refType()
+		} else if k := r.byte(); k != 0 {
+			r.fail(fmt.Errorf("bad element kind %#x", k))
+		}
+	}
+	for n := r.u32(); n > 0 && r.err == nil; n-- {
+		if exprs {
+			seg.inits = append(seg.inits, r.constExpr())
+		} else {
+			// a function index, as the expression ref.func index
+			start := r.off
+			r.u32()
+			seg.inits = append(seg.inits, append(append([]byte{0xd2}, r.b[start:r.off]...), 0x0b))
+		}
+	}
+	return seg
+}
+
+// blockType reads a block's type, returning its params and results
+func (m *wasmModule) blockType(r *wasmReader) (params, results []byte) {
+	if r.off < len(r.b) {
+		switch t := r.b[r.off]; t {
+		case 0x40:
+			r.off++
+			return nil, nil
+		case wasmI32, wasmI64, wasmF32, wasmF64, wasmFuncref, wasmExternref:
+			r.off++
+			return nil, []byte{t}
+		}
+	}
+	i := int64(r.leb(33, true))
+	if i < 0 || i >= int64(len(m.types)) {
+		r.fail(fmt.Errorf("bad block type %d", i))
+		return nil, nil
+	}
+	return m.types[i].params, m.types[i].results
+}
+
+// compile decodes a function body of type ft into instructions, validating it as it goes and matching
+// each block with its end. refs are the functions ref.func can refer to.
+func (m *wasmModule) compile(ft wasmFuncType, body []byte, refs map[uint32]bool) (wasmCode, error) {
+	var code wasmCode
+	r := &wasmReader{b: body}
+	code.locals = append(code.locals, ft.params...)
+	for n := r.u32(); n > 0 && r.err == nil; n-- {
+		count := r.u32()
+		t := r.valType()
+		if uint64(len(code.locals))+uint64(count) > wasmMaxLocals {
+			return code, errors.New("too many locals")
+		}
+		for i := uint32(0); i < count && r.err == nil; i++ {
+			code.locals = append(code.locals, t)
+		}
+	}
+	v := &wasmValidator{frames: []wasmFrame{{results: ft.results}}}
+	for r.err == nil && v.err == nil {
+		if r.off >= len(r.b) {
+			return code, errors.New("body has no end")
+		}
+		op := uint16(r.byte())
+		in := wasmInstr{op: op}
+		switch {
+		case op == 0x00:
+			v.unreachable()
+		case op == 0x01:
+		case op == 0x02 || op == 0x03 || op == 0x04:
+			params, results := m.blockType(r)
+			in.b = uint64(len(params)) | uint64(len(results))<<16
+			if op == 0x04 {
+				v.popT(wasmI32)
+			}
+			v.popAll(params)
+			v.pushFrame(byte(op), params, results, len(code.body))
+		case op == 0x05:
+			f := &v.frames[len(v.frames)-1]
+			if f.op != 0x04 {
+				return code, errors.New("else without if")
+			}
+			v.endFrame()
+			code.body[f.at].b |= uint64(len(code.body)) << 32
+			// the end of the if is patched in when it's reached
+			f.op, f.elseAt = 0x05, len(code.body)
+			v.vals = v.vals[:f.height]
+			f.unreachable = false
+			v.push(f.params...)
+		case op == 0x0b:
+			f := *v.endFrame()
+			v.frames = v.frames[:len(v.frames)-1]
+			if v.err != nil {
+				break
+			}
+			if len(v.frames) == 0 {
+				// the function's end
+				in.op = 0x0f
+				code.body = append(code.body, in)
+				if r.off != len(r.b) {
+					return code, errors.New("code after the function's end")
+				}
+				code.maxPush = v.most
+				return code, nil
+			}
+			if err := checkBlockEnd(&f); err != nil {
+				return code, err
+			}
+			if f.op == 0x05 {
+				code.body[f.elseAt].a = uint32(len(code.body))
+			}
+			code.body[f.at].a = uint32(len(code.body))
+			v.push(f.results...)
+		case op == 0x0c:
+			in.a = r.u32()
+			v.popAll(v.label(in.a))
+			v.unreachable()
+		case op == 0x0d:
+			in.a = r.u32()
+			v.popT(wasmI32)
+			ts := v.label(in.a)
+			v.popAll(ts)
+			v.push(ts...)
+		case op == 0x0e:
+			n := r.u32()
+			if n > uint32(len(r.b)) {
+				return code, errors.New("br_table too long")
+			}
+			in.a = uint32(len(code.brTables))
+			in.b = uint64(n)
+			for i := uint32(0); i <= n && r.err == nil; i++ {
+				code.brTables = append(code.brTables, r.u32())
+			}
+			if r.err != nil {
+				break
+			}
+			v.popT(wasmI32)
+			labels := code.brTables[in.a:]
+			arity := len(v.label(labels[n]))
+			for _, l := range labels[:n] {
+				ts := v.label(l)
+				if len(ts) != arity && v.err == nil {
+					return code, errors.New("type mismatch: br_table labels take different numbers of values")
+				}
+				v.push(v.popAll(ts)...)
+			}
+			v.popAll(v.label(labels[n]))
+			v.unreachable()
+		case op == 0x0f:
+			v.popAll(ft.results)
+			v.unreachable()
+		case op == 0x10:
+			in.a = r.u32()
+			if uint64(in.a) >= uint64(len(m.funcs)) {
+				return code, fmt.Errorf("call to no function %d", in.a)
+			}
+			callee := m.types[m.funcs[in.a]]
+			v.popAll(callee.params)
+			v.push(callee.results...)
+		case op == 0x11:
+			in.a = r.u32()
+			in.b = uint64(r.u32())
+			if uint64(in.a) >= uint64(len(m.types)) {
+				return code, fmt.Errorf("call_indirect of no type %d", in.a)
+			}
+			if t := m.table(v, uint32(in.b)); t != wasmFuncref && v.err == nil {
+				return code, fmt.Errorf("call_indirect through table %d of %s", in.b, wasmTypeName(t))
+			}
+			v.popT(wasmI32)
+			v.popAll(m.types[in.a].params)
+			v.push(m.types[in.a].results...)
+		case op == 0x12 || op == 0x13:
+			return code, errors.New("tail calls aren't supported")
+		case op == 0x1a:
+			v.pop()
+		case op == 0x1b:
+			v.popT(wasmI32)
+			t1, t2 := v.pop(), v.pop()
+			if !wasmIsNum(t1) || !wasmIsNum(t2) {
+				return code, errors.New("type mismatch: select of references needs their type")
+			}
+			if t1 != t2 && t1 != wasmUnknown && t2 != wasmUnknown {
+				return code, fmt.Errorf("type mismatch: select of %s and %s", wasmTypeName(t2), wasmTypeName(t1))
+			}
+			if t1 == wasmUnknown {
+				t1 = t2
+			}
+			v.push(t1)
+		case op == 0x1c:
+			if n := r.u32(); n != 1 {
+				return code, fmt.Errorf("select with %d types", n)
+			}
+			t := r.valType()
+			v.popT(wasmI32)
+			v.popT(t)
+			v.popT(t)
+			v.push(t)
+			in.op = 0x1b
+		case op >= 0x20 && op <= 0x22:
+			in.a = r.u32()
+			if uint64(in.a) >= uint64(len(code.locals)) {
+				return code, fmt.Errorf("no local %d", in.a)
+			}
+			t := code.locals[in.a]
+			if op != 0x20 {
+				v.popT(t)
+			}
+			if op != 0x21 {
+				v.push(t)
+			}
+		case op == 0x23 || op == 0x24:
+			in.a = r.u32()
+			if uint64(in.a) >= uint64(len(m.globals)) {
+				return code, fmt.Errorf("no global %d", in.a)
+			}
+			g := m.globals[in.a]
+			if op == 0x23 {
+				v.push(g.typ)
+			} else if !g.mut {
+				return code, fmt.Errorf("global.set of immutable global %d", in.a)
+			} else {
+				v.popT(g.typ)
+			}
+		case op == 0x25 || op == 0x26:
+			in.a = r.u32()
+			t := m.table(v, in.a)
+			if op == 0x25 {
+				v.popT(wasmI32)
+				v.push(t)
+			} else {
+				v.popT(t)
+				v.popT(wasmI32)
+			}
+		case op >= 0x28 && op <= 0x3e:
+			if m.memory == nil {
+				return code, errWasmNoMemory
+			}
+			align := r.u32()
+			if align&0x40 != 0 {
+				return code, errors.New("multiple memories aren't supported")
+			}
+			mo := wasmMemOps[op-0x28]
+			if align > mo.align {
+				return code, errors.New("alignment larger than the access")
+			}
+			in.b = uint64(r.u32())
+			if op >= 0x36 {
+				v.popT(mo.typ)
+				v.popT(wasmI32)
+			} else {
+				v.popT(wasmI32)
+				v.push(mo.typ)
+			}
+		case op == 0x3f || op == 0x40:
+			if m.memory == nil {
+				return code, errWasmNoMemory
+			}
+			if r.byte() != 0 {
+				return code, errors.New("multiple memories aren't supported")
+			}
+			if op == 0x40 {
+				v.popT(wasmI32)
+			}
+			v.push(wasmI32)
+		case op == 0x41:
+			in.b = uint64(uint32(r.leb(32, true)))
+			v.push(wasmI32)
+		case op == 0x42:
+			in.b = r.leb(64, true)
+			v.push(wasmI64)
+		case op == 0x43:
+			in.b = uint64(r.fixed32())
+			v.push(wasmF32)
+		case op == 0x44:
+			in.b = r.fixed64()
+			v.push(wasmF64)
+		case op >= 0x45 && op <= 0xc4:
+			v.numeric(op)
+		case op == 0xd0:
+			v.push(r.refType())
+		case op == 0xd1:
+			if t := v.pop(); !wasmIsRef(t) {
+				return code, fmt.Errorf("type mismatch: ref.is_null of %s", wasmTypeName(t))
+			}
+			v.push(wasmI32)
+		case op == 0xd2:
+			in.a = r.u32()
+			if uint64(in.a) >= uint64(len(m.funcs)) {
+				return code, fmt.Errorf("ref.func of no function %d", in.a)
+			}
+			if !refs[in.a] {
+				return code, fmt.Errorf("ref.func of function %d, which isn't exported or in an element segment", in.a)
+			}
+			v.push(wasmFuncref)
+		case op == 0xfc:
+			sub := r.u32()
+			if sub > 17 {
+				return code, fmt.Errorf("unsupported instruction 0xfc %d", sub)
+			}
+			in.op = wasmOpFC + uint16(sub)
+			if sub >= 8 && sub <= 11 && m.memory == nil {
+				return code, errWasmNoMemory
+			}
+			switch sub {
+			case 0, 1, 2, 3, 4, 5, 6, 7:
+				v.numeric(in.op)
+			case 8, 9:
+				in.a = r.u32()
+				if sub == 8 && r.byte() != 0 {
+					return code, errors.New("multiple memories aren't supported")
+				}
+				if !m.hasDataCount {
+					return code, errors.New("memory.init or data.drop without a data count section")
+				}
+				if uint64(in.a) >= uint64(m.dataCount) {
+					return code, fmt.Errorf("no data segment %d", in.a)
+				}
+				if sub == 8 {
+					v.popAll([]byte{wasmI32, wasmI32, wasmI32})
+				}
+			case 10:
+				if r.byte() != 0 || r.byte() != 0 {
+					return code, errors.New("multiple memories aren't supported")
+				}
+				v.popAll([]byte{wasmI32, wasmI32, wasmI32})
+			case 11:
+				if r.byte() != 0 {
+					return code, errors.New("multiple memories aren't supported")
+				}
+				v.popAll([]byte{wasmI32, wasmI32, wasmI32})
+			case 12, 13:
+				in.a = r.u32()
+				if uint64(in.a) >= uint64(len(m.elems)) {
+					return code, fmt.Errorf("no element segment %d", in.a)
+				}
+				if sub == 13 {
+					break
+				}
+				in.b = uint64(r.u32())
+				if t := m.table(v, uint32(in.b)); t != m.elems[in.a].elem && v.err == nil {
+					return code, fmt.Errorf("table.init of %s into a table of %s", wasmTypeName(m.elems[in.a].elem), wasmTypeName(t))
+				}
+				v.popAll([]byte{wasmI32, wasmI32, wasmI32})
+			case 14:
+				in.a = r.u32()
+				in.b = uint64(r.u32())
+				if m.table(v, in.a) != m.table(v, uint32(in.b)) && v.err == nil {
+					return code, errors.New("table.copy between tables of different types")
+				}
+				v.popAll([]byte{wasmI32, wasmI32, wasmI32})
+			case 15:
+				in.a = r.u32()
+				v.popT(wasmI32)
+				v.popT(m.table(v, in.a))
+				v.push(wasmI32)
+			case 16:
+				in.a = r.u32()
+				m.table(v, in.a)
+				v.push(wasmI32)
+			case 17:
+				in.a = r.u32()
+				v.popAll([]byte{wasmI32, m.table(v, in.a), wasmI32})
+			}
+		case op == 0xfd:
+			return code, errors.New("SIMD isn't supported")
+		case op == 0xfe:
+			return code, errors.New("threads aren't supported")
+		default:
+			return code, fmt.Errorf("unsupported instruction %#x", op)
+		}
+		code.body = append(code.body, in)
+	}
+	if r.err != nil {
+		return code, r.err
+	}
+	return code, v.err
+}
+
+// evalConst evaluates a constant expression, with globals so far for global.get
+func evalConst(expr []byte, globals []uint64) (uint64, error) {
+	r := &wasmReader{b: expr}
+	var st []uint64
+	pop2 := func() (uint64, uint64, bool) {
+		if len(st) < 2 {
+			return 0, 0, false
+		}
+		a, b := st[len(st)-2], st[len(st)-1]
+		st = st[:len(st)-2]
+		return a, b, true
+	}
+	for r.err == nil {
+		switch op := r.byte(); op {
+		case 0x0b:
+			if len(st) != 1 {
+				return 0, errors.New("constant expression doesn't leave one value")
+			}
+			return st[0], nil
+		case 0x41:
+			st = append(st, uint64(uint32(r.leb(32, true))))
+		case 0x42:
+			st = append(st, r.leb(64, true))
+		case 0x43:
+			st = append(st, uint64(r.fixed32()))
+		case 0x44:
+			st = append(st, r.fixed64())
+		case 0x23:
+			i := r.u32()
+			if int(i) >= len(globals) {
+				return 0, fmt.Errorf("constant expression gets global %d before it's set", i)
+			}
+			st = append(st, globals[i])
+		case 0xd0:
+			r.refType()
+			st = append(st, 0)
+		case 0xd2:
+			st = append(st, uint64(r.u32())+1)
+		case 0x6a, 0x6b, 0x6c, 0x7c, 0x7d, 0x7e:
+			a, b, ok := pop2()
+			if !ok {
+				return 0, errors.New("constant expression's stack is empty")
+			}
+			var v uint64
+			switch op {
+			case 0x6a:
+				v = uint64(uint32(a) + uint32(b))
+			case 0x6b:
+				v = uint64(uint32(a) - uint32(b))
+			case 0x6c:
+				v = uint64(uint32(a) * uint32(b))
+			case 0x7c:
+				v = a + b
+			case 0x7d:
+				v = a - b
+			case 0x7e:
+				v = a * b
+			}
+			st = append(st, v)
+		default:
+			return 0, fmt.Errorf("instruction %#x in a constant expression", op)
+		}
+	}
+	return 0, r.err
+}
+
+// funcName names function i for errors, by its import or export if it has one
+func (m *wasmModule) funcName(i uint32) string {
+	if int(i) < m.importFuncs {
+		// only functions are imported
+		return m.imports[i].module + "." + m.imports[i].name
+	}
+	var names []string
+	for name, e := range m.exports {
+		if e.kind == wasmKindFunc && e.index == i {
+			names = append(names, name)
+		}
+	}
+	if len(names) != 0 {
+		sort.Strings(names)
+		return strings.Join(names, "/")
+	}
+	return fmt.Sprintf("function %d", i)
+}
+
+// float32 and float64 values are kept as their bits
+func f32(v uint64) float32  { return math.Float32frombits(uint32(v)) }
+func f64(v uint64) float64  { return math.Float64frombits(v) }
+func f32v(f float32) uint64 { return uint64(math.Float32bits(f)) }
+func f64v(f float64) uint64 { return math.Float64bits(f) }
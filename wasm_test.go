@@ -0,0 +1,644 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Modules for these tests are put together from their binary encoding, see
+// https://webassembly.github.io/spec/core/binary/ for the layout.
+
+func wasmULEB(v uint64) []byte {
+	var out []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		out = append(out, c)
+		if v == 0 {
+			return out
+		}
+	}
+}
+
+func wasmSLEB(v int64) []byte {
+	var out []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		done := (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0)
+		if !done {
+			c |= 0x80
+		}
+		out = append(out, c)
+		if done {
+			return out
+		}
+	}
+}
+
+// wasmVec is a count and then items
+func wasmVec(items ...[]byte) []byte {
+	out := wasmULEB(uint64(len(items)))
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+func wasmName(s string) []byte {
+	return append(wasmULEB(uint64(len(s))), s...)
+}
+
+func wasmCat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// i32c and i64c are i32.const and i64.const instructions
+func i32c(v int32) []byte { return append([]byte{0x41}, wasmSLEB(int64(v))...) }
+func i64c(v int64) []byte { return append([]byte{0x42}, wasmSLEB(v)...) }
+
+// wasmTestModule builds a module's sections
+type wasmTestModule struct {
+	types   [][]byte
+	imports [][]byte
+	funcs   [][]byte
+	tables  [][]byte
+	// encoded limits, nil for no memory
+	memory  []byte
+	globals [][]byte
+	exports [][]byte
+	start   []byte
+	elems   [][]byte
+	codes   [][]byte
+	datas   [][]byte
+	// a data count section, for memory.init and data.drop
+	dataCount bool
+}
+
+// typ adds a function type, returning its index
+func (tm *wasmTestModule) typ(params, results []byte) uint32 {
+	tm.types = append(tm.types, wasmCat([]byte{0x60}, wasmVec(wasmSplit(params)...), wasmVec(wasmSplit(results)...)))
+	return uint32(len(tm.types) - 1)
+}
+
+func wasmSplit(b []byte) [][]byte {
+	out := make([][]byte, len(b))
+	for i := range b {
+		out[i] = b[i : i+1]
+	}
+	return out
+}
+
+// fn adds a function of the given type with locals of the given types, exported as name unless it's "",
+// returning its index. body is its instructions without the final end.
+func (tm *wasmTestModule) fn(name string, params, results, locals []byte, body ...[]byte) uint32 {
+	t := tm.typ(params, results)
+	tm.funcs = append(tm.funcs, wasmULEB(uint64(t)))
+	var groups [][]byte
+	for _, l := range locals {
+		groups = append(groups, []byte{1, l})
+	}
+	code := wasmCat(wasmVec(groups...), wasmCat(body...), []byte{0x0b})
+	tm.codes = append(tm.codes, append(wasmULEB(uint64(len(code))), code...))
+	idx := uint32(len(tm.imports) + len(tm.funcs) - 1)
+	if name != "" {
+		tm.export(name, wasmKindFunc, idx)
+	}
+	return idx
+}
+
+func (tm *wasmTestModule) export(name string, kind byte, idx uint32) {
+	tm.exports = append(tm.exports, wasmCat(wasmName(name), []byte{kind}, wasmULEB(uint64(idx))))
+}
+
+// mem adds a memory of min pages, and max if it's not negative, exported as "memory"
+func (tm *wasmTestModule) mem(min, max int) {
+	if max < 0 {
+		tm.memory = wasmCat([]byte{0}, wasmULEB(uint64(min)))
+	} else {
+		tm.memory = wasmCat([]byte{1}, wasmULEB(uint64(min)), wasmULEB(uint64(max)))
+	}
+	tm.export("memory", wasmKindMemory, 0)
+}
+
+func wasmSectionBytes(id byte, payload []byte) []byte {
+	return wasmCat([]byte{id}, wasmULEB(uint64(len(payload))), payload)
+}
+
+func (tm *wasmTestModule) bytes() []byte {
+	out := []byte("\x00asm\x01\x00\x00\x00")
+	add := func(id byte, items [][]byte) {
+		if len(items) != 0 {
+			out = append(out, wasmSectionBytes(id, wasmVec(items...))...)
+		}
+	}
+	add(1, tm.types)
+	add(2, tm.imports)
+	add(3, tm.funcs)
+	add(4, tm.tables)
+	if tm.memory != nil {
+		add(5, [][]byte{tm.memory})
+	}
+	add(6, tm.globals)
+	add(7, tm.exports)
+	if tm.start != nil {
+		out = append(out, wasmSectionBytes(8, tm.start)...)
+	}
+	add(9, tm.elems)
+	if tm.dataCount {
+		out = append(out, wasmSectionBytes(12, wasmULEB(uint64(len(tm.datas))))...)
+	}
+	add(10, tm.codes)
+	add(11, tm.datas)
+	return out
+}
+
+// instantiate parses and instantiates tm with memory held to 2 pages
+func (tm *wasmTestModule) instantiate(t *testing.T) *wasmInstance {
+	t.Helper()
+	m, err := parseWasm(tm.bytes())
+	if err != nil {
+		t.Fatalf("parseWasm: %v", err)
+	}
+	in, err := m.instantiate(nil, 2, 1000)
+	if err != nil {
+		t.Fatalf("instantiate: %v", err)
+	}
+	return in
+}
+
+var (
+	vI32 = []byte{wasmI32}
+	vI64 = []byte{wasmI64}
+	vF64 = []byte{wasmF64}
+)
+
+func localGet(i byte) []byte { return []byte{0x20, i} }
+
+func TestWasmExec(t *testing.T) {
+	f64bits := func(f float64) uint64 { return math.Float64bits(f) }
+	for _, tc := range []struct {
+		name            string
+		params, results []byte
+		locals          []byte
+		body            [][]byte
+		args            []uint64
+		want            []uint64
+	}{
+		{"i32.add", []byte{wasmI32, wasmI32}, vI32, nil,
+			[][]byte{localGet(0), localGet(1), {0x6a}}, []uint64{2, 3}, []uint64{5}},
+		{"i32.sub wraps", nil, vI32, nil,
+			[][]byte{i32c(0), i32c(1), {0x6b}}, nil, []uint64{0xffffffff}},
+		{"i32.div_s", nil, vI32, nil,
+			[][]byte{i32c(-7), i32c(2), {0x6d}}, nil, []uint64{uint64(uint32(0xfffffffd))}},
+		{"i32.rem_s", nil, vI32, nil,
+			[][]byte{i32c(-7), i32c(2), {0x6f}}, nil, []uint64{0xffffffff}},
+		{"i32.rem_s of INT_MIN by -1", nil, vI32, nil,
+			[][]byte{i32c(math.MinInt32), i32c(-1), {0x6f}}, nil, []uint64{0}},
+		{"i32.div_u", nil, vI32, nil,
+			[][]byte{i32c(-1), i32c(2), {0x6e}}, nil, []uint64{0x7fffffff}},
+		{"i32.clz and popcnt", nil, vI32, nil,
+			[][]byte{i32c(1), {0x67}, i32c(0xff), {0x69}, {0x6a}}, nil, []uint64{31 + 8}},
+		{"i32.rotl", nil, vI32, nil,
+			[][]byte{i32c(math.MinInt32), i32c(1), {0x77}}, nil, []uint64{1}},
+		{"i32.shr_s masks the count", nil, vI32, nil,
+			[][]byte{i32c(-8), i32c(33), {0x75}}, nil, []uint64{0xfffffffc}},
+		{"i64.mul", nil, vI64, nil,
+			[][]byte{i64c(1 << 40), i64c(3), {0x7e}}, nil, []uint64{3 << 40}},
+		{"i64.extend_i32_s", nil, vI64, nil,
+			[][]byte{i32c(-2), {0xac}}, nil, []uint64{math.MaxUint64 - 1}},
+		{"i32.wrap_i64", nil, vI32, nil,
+			[][]byte{i64c(1<<32 + 5), {0xa7}}, nil, []uint64{5}},
+		{"i32.extend8_s", nil, vI32, nil,
+			[][]byte{i32c(0x80), {0xc0}}, nil, []uint64{0xffffff80}},
+		{"f64.sqrt", nil, vF64, nil,
+			[][]byte{{0x44}, wasmCat(f64le(2.25)), {0x9f}}, nil, []uint64{f64bits(1.5)}},
+		{"f64.min of -0 and 0", nil, vF64, nil,
+			[][]byte{{0x44}, f64le(0), {0x44}, f64le(math.Copysign(0, -1)), {0xa4}}, nil, []uint64{f64bits(math.Copysign(0, -1))}},
+		{"i32.trunc_sat_f64_s of NaN", nil, vI32, nil,
+			[][]byte{{0x44}, f64le(math.NaN()), {0xfc, 2}}, nil, []uint64{0}},
+		{"i32.trunc_sat_f64_u of a big number", nil, vI32, nil,
+			[][]byte{{0x44}, f64le(1e20), {0xfc, 3}}, nil, []uint64{0xffffffff}},
+		{"select", []byte{wasmI32}, vI32, nil,
+			[][]byte{i32c(10), i32c(20), localGet(0), {0x1b}}, []uint64{0}, []uint64{20}},
+		{"local.tee", nil, vI32, vI32,
+			[][]byte{i32c(7), {0x22, 0}, localGet(0), {0x6a}}, nil, []uint64{14}},
+		{"loop sums 1 to n", []byte{wasmI32}, vI32, vI32,
+			[][]byte{
+				{0x03, 0x40},                     // loop
+				localGet(1), localGet(0), {0x6a}, // sum + n
+				{0x21, 1},                    // local.set sum
+				localGet(0), i32c(1), {0x6b}, // n - 1
+				{0x22, 0}, // local.tee n
+				{0x0d, 0}, // br_if 0
+				{0x0b},    // end
+				localGet(1),
+			}, []uint64{100}, []uint64{5050}},
+		{"br_table", []byte{wasmI32}, vI32, nil,
+			[][]byte{
+				{0x02, 0x40}, {0x02, 0x40}, {0x02, 0x40},
+				localGet(0), {0x0e, 2, 0, 1, 2},
+				{0x0b}, i32c(100), {0x0f},
+				{0x0b}, i32c(101), {0x0f},
+				{0x0b}, i32c(102),
+			}, []uint64{1}, []uint64{101}},
+		{"br_table default", []byte{wasmI32}, vI32, nil,
+			[][]byte{
+				{0x02, 0x40}, {0x02, 0x40},
+				localGet(0), {0x0e, 1, 0, 1},
+				{0x0b}, i32c(100), {0x0f},
+				{0x0b}, i32c(101),
+			}, []uint64{9}, []uint64{101}},
+		{"if else", []byte{wasmI32}, vI32, nil,
+			[][]byte{localGet(0), {0x04, wasmI32}, i32c(1), {0x05}, i32c(2), {0x0b}}, []uint64{0}, []uint64{2}},
+		{"block with a result", nil, vI32, nil,
+			[][]byte{{0x02, wasmI32}, i32c(5), i32c(6), {0x0d, 0}, {0x1a}, i32c(7), {0x0b}}, nil, []uint64{5}},
+		{"multi-value results", nil, []byte{wasmI32, wasmI64}, nil,
+			[][]byte{i32c(1), i64c(2)}, nil, []uint64{1, 2}},
+		{"memory store and load", nil, vI32, nil,
+			[][]byte{i32c(8), i32c(0x01020304), {0x36, 2, 0}, i32c(0), {0x2d, 0, 9}}, nil, []uint64{3}},
+		{"memory.size and grow", nil, vI32, nil,
+			[][]byte{i32c(1), {0x40, 0}, {0x3f, 0}, {0x6a}}, nil, []uint64{1 + 2}},
+		{"memory.grow past the most", nil, vI32, nil,
+			[][]byte{i32c(2), {0x40, 0}}, nil, []uint64{0xffffffff}},
+		{"memory.fill and load", nil, vI32, nil,
+			[][]byte{i32c(100), i32c(0xab), i32c(4), {0xfc, 11, 0}, i32c(100), {0x28, 2, 0}}, nil, []uint64{0xabababab}},
+	} {
+		tm := &wasmTestModule{}
+		tm.mem(1, 3)
+		tm.fn("f", tc.params, tc.results, tc.locals, tc.body...)
+		in := tm.instantiate(t)
+		got, err := in.invoke(0, 10000, tc.args...)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s = %x, want %x", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s = %x, want %x", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func f64le(f float64) []byte {
+	v := math.Float64bits(f)
+	out := make([]byte, 8)
+	for i := range out {
+		out[i] = byte(v >> (8 * i))
+	}
+	return out
+}
+
+func TestWasmCalls(t *testing.T) {
+	tm := &wasmTestModule{}
+	// fact(n) = n <= 1 ? 1 : n * fact(n-1)
+	fact := tm.fn("fact", vI64, vI64, nil,
+		localGet(0), i64c(1), []byte{0x57}, // i64.le_s
+		[]byte{0x04, wasmI64}, i64c(1),
+		[]byte{0x05}, localGet(0), localGet(0), i64c(1), []byte{0x7d}, []byte{0x10, 0}, []byte{0x7e},
+		[]byte{0x0b})
+	double := tm.fn("", vI32, vI32, nil, localGet(0), i32c(2), []byte{0x6c})
+	// call_indirect through a table of [fact, double]
+	tm.tables = append(tm.tables, []byte{wasmFuncref, 0, 2})
+	tm.elems = append(tm.elems, wasmCat([]byte{0}, i32c(0), []byte{0x0b}, wasmVec(wasmULEB(uint64(fact)), wasmULEB(uint64(double)))))
+	dtype := tm.typ(vI32, vI32)
+	tm.fn("indirect", []byte{wasmI32, wasmI32}, vI32, nil, localGet(1), localGet(0), []byte{0x11, byte(dtype), 0})
+	m, err := parseWasm(tm.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := m.instantiate(nil, 1, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := in.invoke(uint32(m.export("fact")), 10000, 20)
+	if err != nil || got[0] != 2432902008176640000 {
+		t.Errorf("fact(20) = %v, %v", got, err)
+	}
+	indirect := uint32(m.export("indirect"))
+	got, err = in.invoke(indirect, 10000, 1, 21)
+	if err != nil || got[0] != 42 {
+		t.Errorf("indirect(double, 21) = %v, %v", got, err)
+	}
+	if _, err = in.invoke(indirect, 10000, 0, 21); err == nil || !strings.Contains(err.Error(), "indirect call type mismatch") {
+		t.Errorf("indirect(fact) = %v, want a type mismatch", err)
+	}
+	if _, err = in.invoke(indirect, 10000, 2, 21); err == nil || !strings.Contains(err.Error(), "undefined element") {
+		t.Errorf("indirect(2) = %v, want undefined element", err)
+	}
+	// the instance is still good after traps
+	got, err = in.invoke(indirect, 10000, 1, 5)
+	if err != nil || got[0] != 10 {
+		t.Errorf("indirect(double, 5) after traps = %v, %v", got, err)
+	}
+}
+
+func TestWasmTraps(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body [][]byte
+		want string
+	}{
+		{"unreachable", [][]byte{{0x00}}, "unreachable"},
+		{"i32.div_s by zero", [][]byte{i32c(1), i32c(0), {0x6d}}, "divide by zero"},
+		{"i32.div_s overflow", [][]byte{i32c(math.MinInt32), i32c(-1), {0x6d}}, "overflow"},
+		{"i64.rem_u by zero", [][]byte{i64c(1), i64c(0), {0x82}, {0xa7}}, "divide by zero"},
+		{"i32.trunc_f64_s of NaN", [][]byte{{0x44}, f64le(math.NaN()), {0xaa}}, "invalid conversion"},
+		{"i32.trunc_f64_s out of range", [][]byte{{0x44}, f64le(3e9), {0xaa}}, "overflow"},
+		{"load past memory", [][]byte{i32c(wasmPageSize - 2), {0x28, 2, 0}}, "out of bounds"},
+		{"load past memory by offset", [][]byte{i32c(0), {0x28, 2}, wasmULEB(wasmPageSize)}, "out of bounds"},
+		{"load at a negative address", [][]byte{i32c(-1), {0x2d, 0, 0}}, "out of bounds"},
+		{"store past memory", [][]byte{i32c(wasmPageSize - 1), i32c(1), {0x3b, 1, 0}, i32c(0)}, "out of bounds"},
+		{"memory.fill past memory", [][]byte{i32c(wasmPageSize - 4), i32c(0), i32c(5), {0xfc, 11, 0}, i32c(0)}, "out of bounds"},
+		{"memory.copy past memory", [][]byte{i32c(0), i32c(wasmPageSize - 4), i32c(5), {0xfc, 10, 0, 0}, i32c(0)}, "out of bounds"},
+		{"infinite loop", [][]byte{{0x03, 0x40}, {0x0c, 0}, {0x0b}, i32c(0)}, "ran too long"},
+		{"infinite recursion", [][]byte{{0x10, 0}}, "call stack exhausted"},
+	} {
+		tm := &wasmTestModule{}
+		tm.mem(1, 1)
+		tm.fn("f", nil, vI32, nil, tc.body...)
+		in := tm.instantiate(t)
+		_, err := in.invoke(0, 1_000_000)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestParseWasmInvalid(t *testing.T) {
+	valid := func() *wasmTestModule {
+		tm := &wasmTestModule{}
+		tm.mem(1, -1)
+		return tm
+	}
+	for _, tc := range []struct {
+		name string
+		b    []byte
+		want string
+	}{
+		{"empty", nil, "not a WebAssembly module"},
+		{"bad magic", []byte("\x00asn\x01\x00\x00\x00"), "not a WebAssembly module"},
+		{"version 2", []byte("\x00asm\x02\x00\x00\x00"), "version"},
+		{"truncated section", []byte("\x00asm\x01\x00\x00\x00\x01\x05\x01"), "unexpected end"},
+		{"sections out of order", wasmCat([]byte("\x00asm\x01\x00\x00\x00"), wasmSectionBytes(3, wasmVec()), wasmSectionBytes(1, wasmVec())), "order"},
+		{"long LEB", wasmCat([]byte("\x00asm\x01\x00\x00\x00"), []byte{1, 0x81, 0x80, 0x80, 0x80, 0x80, 0x00}), "integer too long"},
+		{"LEB with bits past 32", wasmCat([]byte("\x00asm\x01\x00\x00\x00"), []byte{1, 0x81, 0x80, 0x80, 0x80, 0x70}), "integer too long"},
+		{"custom section name not UTF-8", wasmCat([]byte("\x00asm\x01\x00\x00\x00"), wasmSectionBytes(0, []byte{2, 0xff, 0xfe})), "UTF-8"},
+	} {
+		_, err := parseWasm(tc.b)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want %q", tc.name, err, tc.want)
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		make func(tm *wasmTestModule)
+		want string
+	}{
+		{"i32.add of i64s", func(tm *wasmTestModule) {
+			tm.fn("f", nil, vI32, nil, i64c(1), i64c(2), []byte{0x6a})
+		}, "type mismatch"},
+		{"empty stack", func(tm *wasmTestModule) {
+			tm.fn("f", nil, vI32, nil, []byte{0x6a})
+		}, "type mismatch"},
+		{"wrong result", func(tm *wasmTestModule) {
+			tm.fn("f", nil, vI32, nil, i64c(1))
+		}, "type mismatch"},
+		{"values left over", func(tm *wasmTestModule) {
+			tm.fn("f", nil, nil, nil, i32c(1))
+		}, "type mismatch"},
+		{"no local", func(tm *wasmTestModule) {
+			tm.fn("f", vI32, vI32, nil, localGet(1))
+		}, "no local 1"},
+		{"branch past the function", func(tm *wasmTestModule) {
+			tm.fn("f", nil, nil, nil, []byte{0x0c, 1})
+		}, "label"},
+		{"br_table arities differ", func(tm *wasmTestModule) {
+			tm.fn("f", nil, vI32, nil, []byte{0x02, 0x40}, i32c(0), i32c(0), []byte{0x0e, 1, 0, 1, 0x0b}, i32c(0))
+		}, "type mismatch"},
+		{"call of no function", func(tm *wasmTestModule) {
+			tm.fn("f", nil, nil, nil, []byte{0x10, 5})
+		}, "call to no function 5"},
+		{"global.set of an immutable global", func(tm *wasmTestModule) {
+			tm.globals = append(tm.globals, wasmCat([]byte{wasmI32, 0}, i32c(0), []byte{0x0b}))
+			tm.fn("f", nil, nil, nil, i32c(1), []byte{0x24, 0})
+		}, "immutable"},
+		{"global of the wrong type", func(tm *wasmTestModule) {
+			tm.globals = append(tm.globals, wasmCat([]byte{wasmI32, 0}, i64c(0), []byte{0x0b}))
+		}, "global 0"},
+		{"alignment past the access", func(tm *wasmTestModule) {
+			tm.fn("f", nil, vI32, nil, i32c(0), []byte{0x28, 3, 0})
+		}, "alignment"},
+		{"unknown instruction", func(tm *wasmTestModule) {
+			tm.fn("f", nil, nil, nil, []byte{0xff})
+		}, "instruction"},
+		{"SIMD", func(tm *wasmTestModule) {
+			tm.fn("f", nil, nil, nil, []byte{0xfd, 0})
+		}, "SIMD"},
+		{"body without an end", func(tm *wasmTestModule) {
+			tm.fn("f", nil, nil, nil)
+			tm.codes[0] = []byte{1, 0}
+		}, "no end"},
+		{"start with params", func(tm *wasmTestModule) {
+			tm.fn("", vI32, nil, nil)
+			tm.start = []byte{0}
+		}, "start function"},
+		{"export twice", func(tm *wasmTestModule) {
+			tm.fn("f", nil, nil, nil)
+			tm.export("f", wasmKindFunc, 0)
+		}, "twice"},
+		{"export of no function", func(tm *wasmTestModule) {
+			tm.export("g", wasmKindFunc, 3)
+		}, "g"},
+		{"undeclared ref.func", func(tm *wasmTestModule) {
+			tm.fn("", nil, nil, nil)
+			tm.fn("f", nil, []byte{wasmFuncref}, nil, []byte{0xd2, 0})
+		}, "ref.func"},
+		{"data segment without memory", func(tm *wasmTestModule) {
+			tm.memory = nil
+			tm.exports = nil
+			tm.datas = append(tm.datas, wasmCat([]byte{0}, i32c(0), []byte{0x0b}, wasmName("x")))
+		}, "no memory"},
+		{"memory.init without a data count", func(tm *wasmTestModule) {
+			tm.datas = append(tm.datas, wasmCat([]byte{1}, wasmName("x")))
+			tm.fn("f", nil, nil, nil, i32c(0), i32c(0), i32c(1), []byte{0xfc, 8, 0, 0})
+		}, "data count"},
+		{"function and code counts differ", func(tm *wasmTestModule) {
+			tm.fn("f", nil, nil, nil)
+			tm.codes = nil
+		}, "function bodies"},
+		{"too many locals", func(tm *wasmTestModule) {
+			tm.fn("f", nil, nil, nil)
+			tm.codes[0] = wasmCat([]byte{6}, wasmVec(wasmCat(wasmULEB(wasmMaxLocals+1), vI32)), []byte{0x0b})
+		}, "too many locals"},
+		{"memory without limits under minimum", func(tm *wasmTestModule) {
+			tm.memory = []byte{1, 2, 1}
+		}, "under minimum"},
+	} {
+		tm := valid()
+		tc.make(tm)
+		_, err := parseWasm(tm.bytes())
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+// wasmFilterModule is a -wasm plugin: alloc returns 1024, filter keeps lines longer than 3 bytes,
+// and transform drops the last byte
+func wasmFilterModule() *wasmTestModule {
+	tm := &wasmTestModule{}
+	tm.mem(1, -1)
+	tm.fn("alloc", vI32, vI32, nil, i32c(1024))
+	tm.fn("filter", []byte{wasmI32, wasmI32}, vI32, nil, localGet(1), i32c(3), []byte{0x4b})
+	tm.fn("transform", []byte{wasmI32, wasmI32}, vI64, nil,
+		localGet(0), []byte{0xad}, i64c(32), []byte{0x86}, // i64.extend_i32_u(ptr) << 32
+		localGet(1), i32c(1), []byte{0x6b}, []byte{0xad}, []byte{0x84}) // | i64.extend_i32_u(len - 1)
+	return tm
+}
+
+func writeWasm(t *testing.T, tm *wasmTestModule) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "m.wasm")
+	if err := os.WriteFile(path, tm.bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWasmPlugin(t *testing.T) {
+	p, err := loadWasmPlugin(writeWasm(t, wasmFilterModule()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := &wasmStage{p: p}
+	for _, tc := range []struct {
+		line, want string
+		keep       bool
+	}{
+		{"abc", "", false},
+		{"abcd", "abc", true},
+		{strings.Repeat("x", 5000), strings.Repeat("x", 4999), true},
+		{"hello", "hell", true},
+	} {
+		out, keep := ws.apply([]byte(tc.line))
+		if keep != tc.keep || (keep && string(out) != tc.want) {
+			t.Errorf("apply(%.10q) = %.10q, %v, want %.10q, %v", tc.line, out, keep, tc.want, tc.keep)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		make func(tm *wasmTestModule)
+		want string
+	}{
+		{"no memory export", func(tm *wasmTestModule) { tm.exports = tm.exports[1:] }, "no memory export"},
+		{"no alloc", func(tm *wasmTestModule) { tm.exports = append(tm.exports[:1], tm.exports[2:]...) }, "no alloc export"},
+		{"filter of the wrong type", func(tm *wasmTestModule) {
+			tm.exports = tm.exports[:2]
+			tm.fn("filter", vI32, vI32, nil, i32c(1))
+		}, "filter's type"},
+		{"an import that isn't WASI", func(tm *wasmTestModule) {
+			*tm = wasmTestModule{}
+			tm.imports = append(tm.imports, wasmCat(wasmName("env"), wasmName("open"), []byte{wasmKindFunc}, wasmULEB(uint64(tm.typ(nil, nil)))))
+			tm.mem(1, -1)
+			tm.fn("alloc", vI32, vI32, nil, i32c(0))
+			tm.fn("filter", []byte{wasmI32, wasmI32}, vI32, nil, i32c(1))
+		}, "env.open"},
+	} {
+		tm := wasmFilterModule()
+		tc.make(tm)
+		_, err := loadWasmPlugin(writeWasm(t, tm))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestWasmPluginFailures(t *testing.T) {
+	tm := &wasmTestModule{}
+	tm.mem(1, -1)
+	tm.fn("alloc", vI32, vI32, nil, i32c(0))
+	// traps on lines starting with '!', and keeps the others
+	tm.fn("filter", []byte{wasmI32, wasmI32}, vI32, nil,
+		localGet(0), []byte{0x2d, 0, 0}, i32c('!'), []byte{0x46}, []byte{0x04, 0x40, 0x00, 0x0b}, i32c(1))
+	p, err := loadWasmPlugin(writeWasm(t, tm))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.warned.Store(true)
+	ws := &wasmStage{p: p}
+	errors0, skipped0 := atomic.LoadUint64(&linesWasmErrors), atomic.LoadUint64(&linesWasmSkipped)
+	// a failure keeps the line and gets a fresh instance
+	if out, keep := ws.apply([]byte("!a")); !keep || string(out) != "!a" {
+		t.Errorf("failed apply = %q, %v", out, keep)
+	}
+	if out, keep := ws.apply([]byte("b")); !keep || string(out) != "b" {
+		t.Errorf("apply after a failure = %q, %v", out, keep)
+	}
+	for i := 0; i < wasmMaxFails; i++ {
+		ws.apply([]byte("!"))
+	}
+	// then it gives up and passes lines by
+	ws.apply([]byte("!c"))
+	ws.apply([]byte("d"))
+	if n := atomic.LoadUint64(&linesWasmErrors) - errors0; n != wasmMaxFails+1 {
+		t.Errorf("%d errors, want %d", n, wasmMaxFails+1)
+	}
+	if n := atomic.LoadUint64(&linesWasmSkipped) - skipped0; n != 2 {
+		t.Errorf("%d skipped, want 2", n)
+	}
+}
+
+func FuzzParseWasm(f *testing.F) {
+	f.Add(wasmFilterModule().bytes())
+	tm := &wasmTestModule{}
+	tm.mem(1, 2)
+	tm.fn("f", nil, vI32, vI32,
+		[]byte{0x03, 0x40}, localGet(0), i32c(1), []byte{0x6a, 0x22, 0}, i32c(10), []byte{0x48, 0x0d, 0, 0x0b},
+		localGet(0), i32c(0), []byte{0x36, 2, 0}, i32c(0), []byte{0x28, 2, 0})
+	tm.tables = append(tm.tables, []byte{wasmFuncref, 0, 1})
+	tm.elems = append(tm.elems, wasmCat([]byte{0}, i32c(0), []byte{0x0b}, wasmVec([]byte{0})))
+	tm.globals = append(tm.globals, wasmCat([]byte{wasmI64, 1}, i64c(3), []byte{0x0b}))
+	tm.datas = append(tm.datas, wasmCat([]byte{0}, i32c(16), []byte{0x0b}, wasmName("hello")))
+	f.Add(tm.bytes())
+	f.Fuzz(func(t *testing.T, b []byte) {
+		m, err := parseWasm(b)
+		if err != nil {
+			return
+		}
+		hosts, err := wasmHosts(m, nil)
+		if err != nil {
+			return
+		}
+		in, err := m.instantiate(hosts, 2, 10000)
+		if err != nil {
+			return
+		}
+		// a module that validated only ever traps, any other panic fails the test
+		for _, e := range m.exports {
+			if e.kind != wasmKindFunc {
+				continue
+			}
+			args := make([]uint64, len(m.funcType(int(e.index)).params))
+			in.clone().invoke(e.index, 10000, args...)
+		}
+	})
+}
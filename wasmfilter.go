@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// counts of lines left out by -wasm, lines it failed on, and lines passed by after it gave up
+var linesWasmDropped uint64
+var linesWasmErrors uint64
+var linesWasmSkipped uint64
+
+const (
+	// most memory a -wasm module can have, in 64KiB pages
+	wasmMaxPages = 4096
+	// instructions one alloc, filter, or transform call can run, about a second's worth;
+	// loading the module gets 10 times as many
+	wasmFuel = 250_000_000
+	// failures in a row after which a reader stops running the module, rather than copying
+	// its memory again for every line
+	wasmMaxFails = 10
+)
+
+// wasmPlugin is -wasm: a WebAssembly module that filters or changes lines, run by ssample's own
+// interpreter, so it can't touch files, the network, or ssample's memory. The module exports
+//
+//	memory
+//	alloc(size i32) i32              a buffer of size bytes a line can be written to
+//	filter(ptr, len i32) i32         nonzero to keep the line at ptr
+//	transform(ptr, len i32) i64      the line to keep in its place as ptr<<32 | len, or negative to drop it
+//
+// with filter, transform, or both, filter first. ssample reuses the alloc buffer for every line
+// no longer than it, and the line transform returns only has to last until the next call.
+// A WASI reactor's _initialize is called when the module loads.
+// Each reader gets its own copy of the loaded instance, and a copy that fails is replaced,
+// until wasmMaxFails lines in a row fail and the reader passes lines by without it.
+type wasmPlugin struct {
+	path string
+	m    *wasmModule
+	// the instance after loading, which readers' instances are copied from
+	loaded *wasmInstance
+
+	alloc, filter, transform int
+	// set once a failure has been warned about, later ones are debug messages
+	warned atomic.Bool
+	// set once a reader has given up on the module
+	gaveUp atomic.Bool
+}
+
+// loadWasmPlugin reads, checks, and instantiates a -wasm module
+func loadWasmPlugin(path string) (*wasmPlugin, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := parseWasm(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	p := &wasmPlugin{path: path, m: m, alloc: m.export("alloc"), filter: m.export("filter"), transform: m.export("transform")}
+	if e, ok := m.exports["memory"]; !ok || e.kind != wasmKindMemory {
+		return nil, fmt.Errorf("%s: no memory export", path)
+	}
+	check := func(name string, fn int, sig string) error {
+		if fn >= 0 && m.funcType(fn).String() != sig {
+			return fmt.Errorf("%s: %s's type isn't %s", path, name, wasmABI[name])
+		}
+		return nil
+	}
+	switch {
+	case p.alloc < 0:
+		return nil, fmt.Errorf("%s: no alloc export", path)
+	case p.filter < 0 && p.transform < 0:
+		return nil, fmt.Errorf("%s: no filter or transform export", path)
+	}
+	for _, err := range []error{
+		check("alloc", p.alloc, "7f->7f"),
+		check("filter", p.filter, "7f7f->7f"),
+		check("transform", p.transform, "7f7f->7e"),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+	hosts, err := wasmHosts(m, os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	p.loaded, err = m.instantiate(hosts, wasmMaxPages, 10*wasmFuel)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if init := m.export("_initialize"); init >= 0 {
+		if _, err := p.loaded.invoke(uint32(init), 10*wasmFuel); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return p, nil
+}
+
+// wasmABI is each export and its type, for errors
+var wasmABI = map[string]string{
+	"alloc":     "alloc(size i32) i32",
+	"filter":    "filter(ptr, len i32) i32",
+	"transform": "transform(ptr, len i32) i64",
+}
+
+// wasmStage is a reader's -wasm instance
+type wasmStage struct {
+	p  *wasmPlugin
+	in *wasmInstance
+	// the alloc buffer and its size
+	buf, size uint32
+	// failures since the last line that worked, wasmMaxFails to stop running the module
+	fails int
+}
+
+func (ws *wasmStage) apply(line []byte) ([]byte, bool) {
+	if ws.fails >= wasmMaxFails {
+		atomic.AddUint64(&linesWasmSkipped, 1)
+		return line, true
+	}
+	out, keep, err := ws.run(line)
+	if err != nil {
+		atomic.AddUint64(&linesWasmErrors, 1)
+		if ws.p.warned.CompareAndSwap(false, true) {
+			warnf("-wasm %s: %v, keeping lines it fails on as they are", ws.p.path, err)
+		} else {
+			debugf("-wasm %s: %v", ws.p.path, err)
+		}
+		// it may be left half way through changing its memory
+		ws.in = nil
+		if ws.fails++; ws.fails == wasmMaxFails && ws.p.gaveUp.CompareAndSwap(false, true) {
+			warnf("-wasm %s failed on %d lines in a row, passing lines by without it until it's reloaded", ws.p.path, wasmMaxFails)
+		}
+		return line, true
+	}
+	ws.fails = 0
+	if !keep {
+		atomic.AddUint64(&linesWasmDropped, 1)
+	}
+	return out, keep
+}
+
+func (ws *wasmStage) run(line []byte) ([]byte, bool, error) {
+	if ws.in == nil {
+		ws.in = ws.p.loaded.clone()
+		ws.buf, ws.size = 0, 0
+	}
+	in := ws.in
+	if ws.size == 0 || len(line) > int(ws.size) {
+		// room to grow, so longer lines don't each need a new buffer
+		n := uint32(max(len(line), 2*int(ws.size), 64))
+		r, err := in.invoke(uint32(ws.p.alloc), wasmFuel, uint64(n))
+		if err != nil {
+			return nil, false, err
+		}
+		ws.buf, ws.size = uint32(r[0]), n
+		if _, ok := in.memRange(ws.buf, n); !ok {
+			ws.size = 0
+			return nil, false, fmt.Errorf("alloc(%d) returned %#x, past the end of memory", n, ws.buf)
+		}
+	}
+	dst, _ := in.memRange(ws.buf, uint32(len(line)))
+	copy(dst, line)
+	if ws.p.filter >= 0 {
+		r, err := in.invoke(uint32(ws.p.filter), wasmFuel, uint64(ws.buf), uint64(len(line)))
+		if err != nil {
+			return nil, false, err
+		}
+		if uint32(r[0]) == 0 {
+			return nil, false, nil
+		}
+	}
+	if ws.p.transform < 0 {
+		return line, true, nil
+	}
+	r, err := in.invoke(uint32(ws.p.transform), wasmFuel, uint64(ws.buf), uint64(len(line)))
+	if err != nil {
+		return nil, false, err
+	}
+	v := int64(r[0])
+	if v < 0 {
+		return nil, false, nil
+	}
+	out, ok := in.memRange(uint32(v>>32), uint32(v))
+	if !ok {
+		return nil, false, errors.New("transform returned a line past the end of memory")
+	}
+	return out, true, nil
+}
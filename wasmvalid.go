@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Validation of -wasm modules, as the WebAssembly spec's appendix has it: each function body's
+// instructions are type checked against a stack of value types as they're decoded, so by the time
+// a module is instantiated every index, label, and stack access in it is known to be good and the
+// interpreter only checks what depends on values, like memory and table bounds.
+
+// wasmUnknown is a value of any type, on the stack after an unconditional branch
+const wasmUnknown = 0
+
+func wasmTypeName(t byte) string {
+	switch t {
+	case wasmI32:
+		return "i32"
+	case wasmI64:
+		return "i64"
+	case wasmF32:
+		return "f32"
+	case wasmF64:
+		return "f64"
+	case wasmFuncref:
+		return "funcref"
+	case wasmExternref:
+		return "externref"
+	case wasmUnknown:
+		return "any"
+	}
+	return fmt.Sprintf("type %#x", t)
+}
+
+func wasmIsNum(t byte) bool {
+	return t == wasmI32 || t == wasmI64 || t == wasmF32 || t == wasmF64 || t == wasmUnknown
+}
+
+func wasmIsRef(t byte) bool {
+	return t == wasmFuncref || t == wasmExternref || t == wasmUnknown
+}
+
+// wasmFrame is a block, loop, if, or the function itself while it's validated
+type wasmFrame struct {
+	// the opcode that started it, 0x05 once an if reaches its else, 0 for the function
+	op              byte
+	params, results []byte
+	// the stack height the block started at
+	height int
+	// the rest of the block follows a branch, return, or unreachable and is never run
+	unreachable bool
+	// indexes of the block's instruction and its else, to be told where the block ends
+	at, elseAt int
+}
+
+// wasmValidator is the state of validating one function body
+type wasmValidator struct {
+	vals   []byte
+	frames []wasmFrame
+	// the most values on the stack at once, how much room a call needs
+	most int
+	err  error
+}
+
+func (v *wasmValidator) failf(format string, args ...interface{}) {
+	if v.err == nil {
+		v.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (v *wasmValidator) push(ts ...byte) {
+	v.vals = append(v.vals, ts...)
+	v.most = max(v.most, len(v.vals))
+}
+
+// pop takes a value of any type off the stack
+func (v *wasmValidator) pop() byte {
+	f := &v.frames[len(v.frames)-1]
+	if len(v.vals) == f.height {
+		if !f.unreachable {
+			v.failf("type mismatch: a value is needed and the stack is empty")
+		}
+		return wasmUnknown
+	}
+	t := v.vals[len(v.vals)-1]
+	v.vals = v.vals[:len(v.vals)-1]
+	return t
+}
+
+// popT takes a value of type want off the stack
+func (v *wasmValidator) popT(want byte) byte {
+	t := v.pop()
+	if t != want && t != wasmUnknown && want != wasmUnknown {
+		v.failf("type mismatch: %s where %s is needed", wasmTypeName(t), wasmTypeName(want))
+	}
+	return t
+}
+
+// popAll takes values of types ts off the stack, the last first, returning what they were
+func (v *wasmValidator) popAll(ts []byte) []byte {
+	out := make([]byte, len(ts))
+	for i := len(ts) - 1; i >= 0; i-- {
+		out[i] = v.popT(ts[i])
+	}
+	return out
+}
+
+func (v *wasmValidator) pushFrame(op byte, params, results []byte, at int) {
+	v.frames = append(v.frames, wasmFrame{op: op, params: params, results: results, height: len(v.vals), at: at})
+	v.push(params...)
+}
+
+// endFrame checks the innermost block leaves exactly its results
+func (v *wasmValidator) endFrame() *wasmFrame {
+	f := &v.frames[len(v.frames)-1]
+	v.popAll(f.results)
+	if len(v.vals) != f.height {
+		v.failf("type mismatch: %d values left at the end of a block", len(v.vals)-f.height)
+	}
+	return f
+}
+
+// label returns the types a branch to label l takes, the params of a loop and the results of the rest
+func (v *wasmValidator) label(l uint32) []byte {
+	if uint64(l) >= uint64(len(v.frames)) {
+		v.failf("branch to no label %d", l)
+		return nil
+	}
+	f := &v.frames[len(v.frames)-1-int(l)]
+	if f.op == 0x03 {
+		return f.params
+	}
+	return f.results
+}
+
+// unreachable makes the rest of the innermost block unreachable
+func (v *wasmValidator) unreachable() {
+	f := &v.frames[len(v.frames)-1]
+	v.vals = v.vals[:f.height]
+	f.unreachable = true
+}
+
+// wasmMemOps are the load and store instructions from 0x28: the value's type,
+// and log2 of the bytes they access, the most their alignment can be
+var wasmMemOps = [...]struct {
+	typ   byte
+	align uint32
+}{
+	{wasmI32, 2}, {wasmI64, 3}, {wasmF32, 2}, {wasmF64, 3},
+	{wasmI32, 0}, {wasmI32, 0}, {wasmI32, 1}, {wasmI32, 1},
+	{wasmI64, 0}, {wasmI64, 0}, {wasmI64, 1}, {wasmI64, 1}, {wasmI64, 2}, {wasmI64, 2},
+	// stores, from 0x36
+	{wasmI32, 2}, {wasmI64, 3}, {wasmF32, 2}, {wasmF64, 3},
+	{wasmI32, 0}, {wasmI32, 1}, {wasmI64, 0}, {wasmI64, 1}, {wasmI64, 2},
+}
+
+// wasmNumericOps are the types of the numeric instructions from 0x45 to 0xc4 and the saturating
+// conversions after 0xfc: each range of opcodes takes args and leaves one result
+var wasmNumericOps = []struct {
+	from, to uint16
+	args     string
+	result   byte
+}{
+	{0x45, 0x45, "\x7f", wasmI32},
+	{0x46, 0x4f, "\x7f\x7f", wasmI32},
+	{0x50, 0x50, "\x7e", wasmI32},
+	{0x51, 0x5a, "\x7e\x7e", wasmI32},
+	{0x5b, 0x60, "\x7d\x7d", wasmI32},
+	{0x61, 0x66, "\x7c\x7c", wasmI32},
+	{0x67, 0x69, "\x7f", wasmI32},
+	{0x6a, 0x78, "\x7f\x7f", wasmI32},
+	{0x79, 0x7b, "\x7e", wasmI64},
+	{0x7c, 0x8a, "\x7e\x7e", wasmI64},
+	{0x8b, 0x91, "\x7d", wasmF32},
+	{0x92, 0x98, "\x7d\x7d", wasmF32},
+	{0x99, 0x9f, "\x7c", wasmF64},
+	{0xa0, 0xa6, "\x7c\x7c", wasmF64},
+	{0xa7, 0xa7, "\x7e", wasmI32},
+	{0xa8, 0xa9, "\x7d", wasmI32},
+	{0xaa, 0xab, "\x7c", wasmI32},
+	{0xac, 0xad, "\x7f", wasmI64},
+	{0xae, 0xaf, "\x7d", wasmI64},
+	{0xb0, 0xb1, "\x7c", wasmI64},
+	{0xb2, 0xb3, "\x7f", wasmF32},
+	{0xb4, 0xb5, "\x7e", wasmF32},
+	{0xb6, 0xb6, "\x7c", wasmF32},
+	{0xb7, 0xb8, "\x7f", wasmF64},
+	{0xb9, 0xba, "\x7e", wasmF64},
+	{0xbb, 0xbb, "\x7d", wasmF64},
+	{0xbc, 0xbc, "\x7d", wasmI32},
+	{0xbd, 0xbd, "\x7c", wasmI64},
+	{0xbe, 0xbe, "\x7f", wasmF32},
+	{0xbf, 0xbf, "\x7e", wasmF64},
+	{0xc0, 0xc1, "\x7f", wasmI32},
+	{0xc2, 0xc4, "\x7e", wasmI64},
+	{wasmOpFC + 0, wasmOpFC + 1, "\x7d", wasmI32},
+	{wasmOpFC + 2, wasmOpFC + 3, "\x7c", wasmI32},
+	{wasmOpFC + 4, wasmOpFC + 5, "\x7d", wasmI64},
+	{wasmOpFC + 6, wasmOpFC + 7, "\x7c", wasmI64},
+}
+
+// numeric checks numeric instruction op's args and pushes its result, false if op isn't one
+func (v *wasmValidator) numeric(op uint16) bool {
+	for _, n := range wasmNumericOps {
+		if op >= n.from && op <= n.to {
+			v.popAll([]byte(n.args))
+			v.push(n.result)
+			return true
+		}
+	}
+	return false
+}
+
+// constType checks a constant expression that can get the first n globals, returning the type of its value
+// and adding the functions it refers to to refs
+func (m *wasmModule) constType(expr []byte, n int, refs map[uint32]bool) (byte, error) {
+	r := &wasmReader{b: expr}
+	var st []byte
+	for r.err == nil {
+		op := r.byte()
+		switch op {
+		case 0x0b:
+			if len(st) != 1 {
+				return 0, fmt.Errorf("constant expression leaves %d values, not 1", len(st))
+			}
+			return st[0], nil
+		case 0x41:
+			r.leb(32, true)
+			st = append(st, wasmI32)
+		case 0x42:
+			r.leb(64, true)
+			st = append(st, wasmI64)
+		case 0x43:
+			r.bytes(4)
+			st = append(st, wasmF32)
+		case 0x44:
+			r.bytes(8)
+			st = append(st, wasmF64)
+		case 0x23:
+			i := r.u32()
+			if uint64(i) >= uint64(n) {
+				return 0, fmt.Errorf("constant expression gets global %d, which isn't defined before it", i)
+			}
+			if m.globals[i].mut {
+				return 0, fmt.Errorf("constant expression gets mutable global %d", i)
+			}
+			st = append(st, m.globals[i].typ)
+		case 0xd0:
+			st = append(st, r.refType())
+		case 0xd2:
+			i := r.u32()
+			if uint64(i) >= uint64(len(m.funcs)) {
+				return 0, fmt.Errorf("constant expression refers to no function %d", i)
+			}
+			refs[i] = true
+			st = append(st, wasmFuncref)
+		case 0x6a, 0x6b, 0x6c, 0x7c, 0x7d, 0x7e:
+			t := byte(wasmI32)
+			if op >= 0x7c {
+				t = wasmI64
+			}
+			if len(st) < 2 || st[len(st)-1] != t || st[len(st)-2] != t {
+				return 0, fmt.Errorf("type mismatch in constant expression: %#x needs two %s", op, wasmTypeName(t))
+			}
+			st = st[:len(st)-1]
+		default:
+			return 0, fmt.Errorf("instruction %#x in a constant expression", op)
+		}
+	}
+	return 0, r.err
+}
+
+// checkConst checks a constant expression's value is of type want
+func (m *wasmModule) checkConst(expr []byte, want byte, n int, refs map[uint32]bool) error {
+	t, err := m.constType(expr, n, refs)
+	if err == nil && t != want {
+		err = fmt.Errorf("constant expression is %s, not %s", wasmTypeName(t), wasmTypeName(want))
+	}
+	return err
+}
+
+// checkElemSegment checks an element segment's table, offset, and entries
+func (m *wasmModule) checkElemSegment(seg *wasmElemSegment, refs map[uint32]bool) error {
+	if seg.mode == 0 {
+		if uint64(seg.table) >= uint64(len(m.tables)) {
+			return fmt.Errorf("no table %d", seg.table)
+		}
+		if t := m.tables[seg.table].elem; t != seg.elem {
+			return fmt.Errorf("%s entries for a table of %s", wasmTypeName(seg.elem), wasmTypeName(t))
+		}
+		if err := m.checkConst(seg.offset, wasmI32, len(m.globals), refs); err != nil {
+			return err
+		}
+	}
+	for _, init := range seg.inits {
+		if err := m.checkConst(init, seg.elem, len(m.globals), refs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// table returns table i's element type, or fails v if there's no such table
+func (m *wasmModule) table(v *wasmValidator, i uint32) byte {
+	if uint64(i) >= uint64(len(m.tables)) {
+		v.failf("no table %d", i)
+		return wasmUnknown
+	}
+	return m.tables[i].elem
+}
+
+var errWasmNoMemory = errors.New("memory instruction without a memory")
+
+// checkBlockEnd checks what an if without an else would pass through, its params, matches its results
+func checkBlockEnd(f *wasmFrame) error {
+	if f.op == 0x04 && !bytes.Equal(f.params, f.results) {
+		return errors.New("type mismatch: an if without an else must leave its params as its results")
+	}
+	return nil
+}
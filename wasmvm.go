@@ -0,0 +1,1037 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+const (
+	// most values on an instance's stack, locals included
+	wasmMaxStack = 4 << 20
+	// most nested calls
+	wasmMaxDepth = 20000
+)
+
+// wasmTrap is a module failing while it runs, like dividing by zero or reading past its memory
+type wasmTrap struct {
+	msg string
+}
+
+func (t wasmTrap) Error() string {
+	return t.msg
+}
+
+func wasmTrapf(format string, args ...interface{}) {
+	panic(wasmTrap{fmt.Sprintf(format, args...)})
+}
+
+// wasmHostFunc is an imported function. args holds its params, and it writes its results over them;
+// it's long enough for whichever there are more of.
+type wasmHostFunc func(in *wasmInstance, args []uint64)
+
+type wasmLabel struct {
+	// where the block's values start on the stack, how many a branch to it keeps, and where it goes on
+	height, arity, cont int
+}
+
+// wasmInstance is a module's memory, tables, and globals, and the stack running its functions.
+// parseWasm has validated the module, so its indexes, labels, and stack use are known to be good;
+// what's checked as it runs is what depends on values, like memory and table bounds.
+// It isn't safe for use from more than one goroutine.
+type wasmInstance struct {
+	m     *wasmModule
+	hosts []wasmHostFunc
+
+	mem []byte
+	// most pages mem can grow to
+	maxPages uint32
+	globals  []uint64
+	// funcref and externref values, 0 for null and a function's index plus 1 for a function
+	tables [][]uint64
+	// passive segments, nil once dropped
+	elems [][]uint64
+	datas [][]byte
+
+	stack  []uint64
+	sp     int
+	labels []wasmLabel
+	depth  int
+	// instructions left to run before a call fails, so a module can't hang its caller
+	fuel int64
+}
+
+// instantiate makes an instance of m with hosts for its imported functions, and runs its start function.
+// Its memory is held to maxPages of 64KiB.
+func (m *wasmModule) instantiate(hosts []wasmHostFunc, maxPages uint32, fuel int64) (*wasmInstance, error) {
+	if len(hosts) != m.importFuncs {
+		return nil, fmt.Errorf("%d imports for %d imported functions", len(hosts), m.importFuncs)
+	}
+	in := &wasmInstance{m: m, hosts: hosts}
+	for i, g := range m.globals {
+		v, err := evalConst(g.init, in.globals)
+		if err != nil {
+			return nil, fmt.Errorf("global %d: %v", i, err)
+		}
+		in.globals = append(in.globals, v)
+	}
+	for _, t := range m.tables {
+		in.tables = append(in.tables, make([]uint64, t.min))
+	}
+	if m.memory != nil {
+		in.maxPages = maxPages
+		if m.memory.hasMax && m.memory.max < maxPages {
+			in.maxPages = m.memory.max
+		}
+		if m.memory.min > in.maxPages {
+			return nil, fmt.Errorf("memory of %d pages is over the most allowed, %d", m.memory.min, maxPages)
+		}
+		in.mem = make([]byte, int(m.memory.min)*wasmPageSize)
+	}
+	for i, seg := range m.elems {
+		refs := make([]uint64, len(seg.inits))
+		for j, init := range seg.inits {
+			v, err := evalConst(init, in.globals)
+			if err != nil {
+				return nil, fmt.Errorf("element segment %d: %v", i, err)
+			}
+			refs[j] = v
+		}
+		switch seg.mode {
+		case 0:
+			off, err := evalConst(seg.offset, in.globals)
+			if err != nil {
+				return nil, fmt.Errorf("element segment %d: %v", i, err)
+			}
+			if int(seg.table) >= len(in.tables) {
+				return nil, fmt.Errorf("element segment %d: no table %d", i, seg.table)
+			}
+			t := in.tables[seg.table]
+			if uint64(uint32(off))+uint64(len(refs)) > uint64(len(t)) {
+				return nil, fmt.Errorf("element segment %d doesn't fit its table", i)
+			}
+			copy(t[uint32(off):], refs)
+			in.elems = append(in.elems, nil)
+		case 1:
+			in.elems = append(in.elems, refs)
+		default:
+			in.elems = append(in.elems, nil)
+		}
+	}
+	for i, d := range m.datas {
+		if !d.active {
+			in.datas = append(in.datas, d.data)
+			continue
+		}
+		off, err := evalConst(d.offset, in.globals)
+		if err != nil {
+			return nil, fmt.Errorf("data segment %d: %v", i, err)
+		}
+		if uint64(uint32(off))+uint64(len(d.data)) > uint64(len(in.mem)) {
+			return nil, fmt.Errorf("data segment %d doesn't fit in memory", i)
+		}
+		copy(in.mem[uint32(off):], d.data)
+		in.datas = append(in.datas, nil)
+	}
+	if m.start >= 0 {
+		if _, err := in.invoke(uint32(m.start), fuel); err != nil {
+			return nil, fmt.Errorf("start function: %v", err)
+		}
+	}
+	return in, nil
+}
+
+// clone copies in's memory, tables, and globals into a new instance, as if it had run the same calls
+func (in *wasmInstance) clone() *wasmInstance {
+	out := &wasmInstance{
+		m:        in.m,
+		hosts:    in.hosts,
+		mem:      append([]byte(nil), in.mem...),
+		maxPages: in.maxPages,
+		globals:  append([]uint64(nil), in.globals...),
+		elems:    append([][]uint64(nil), in.elems...),
+		datas:    append([][]byte(nil), in.datas...),
+	}
+	for _, t := range in.tables {
+		out.tables = append(out.tables, append([]uint64(nil), t...))
+	}
+	return out
+}
+
+// export returns the index of the exported function name, -1 if there isn't one
+func (m *wasmModule) export(name string) int {
+	e, ok := m.exports[name]
+	if !ok || e.kind != wasmKindFunc {
+		return -1
+	}
+	return int(e.index)
+}
+
+// funcType returns function i's type
+func (m *wasmModule) funcType(i int) wasmFuncType {
+	return m.types[m.funcs[i]]
+}
+
+// invoke calls function fn with args, and returns its results, or how it failed.
+// fuel is how many instructions it can run.
+func (in *wasmInstance) invoke(fn uint32, fuel int64, args ...uint64) (results []uint64, err error) {
+	ft := in.m.types[in.m.funcs[fn]]
+	if len(args) != len(ft.params) {
+		return nil, fmt.Errorf("%s takes %d args, not %d", in.m.funcName(fn), len(ft.params), len(args))
+	}
+	in.sp = 0
+	in.labels = in.labels[:0]
+	in.depth = 0
+	in.fuel = fuel
+	defer func() {
+		if r := recover(); r != nil {
+			t, ok := r.(wasmTrap)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%s: %v", in.m.funcName(fn), t)
+		}
+	}()
+	in.ensure(len(args))
+	copy(in.stack, args)
+	in.sp = len(args)
+	in.call(fn)
+	return append([]uint64(nil), in.stack[:len(ft.results)]...), nil
+}
+
+// ensure makes room for n more values on the stack
+func (in *wasmInstance) ensure(n int) {
+	need := in.sp + n
+	if need <= len(in.stack) {
+		return
+	}
+	if need > wasmMaxStack {
+		wasmTrapf("call stack exhausted")
+	}
+	grown := make([]uint64, min(wasmMaxStack, max(need, 2*len(in.stack), 1024)))
+	copy(grown, in.stack[:in.sp])
+	in.stack = grown
+}
+
+// call runs function fn with its params on top of the stack, leaving its results in their place
+func (in *wasmInstance) call(fn uint32) {
+	m := in.m
+	ft := &m.types[m.funcs[fn]]
+	np, nr := len(ft.params), len(ft.results)
+	if int(fn) < m.importFuncs {
+		in.ensure(max(0, nr-np))
+		base := in.sp - np
+		in.hosts[fn](in, in.stack[base:base+max(np, nr)])
+		in.sp = base + nr
+		return
+	}
+	in.depth++
+	if in.depth > wasmMaxDepth {
+		wasmTrapf("call stack exhausted")
+	}
+	in.exec(&m.codes[int(fn)-m.importFuncs], np, nr)
+	in.depth--
+}
+
+// ea returns the n bytes of memory at address v plus off
+func (in *wasmInstance) ea(v, off, n uint64) []byte {
+	a := uint64(uint32(v)) + off
+	if a+n > uint64(len(in.mem)) {
+		wasmTrapf("out of bounds memory access")
+	}
+	return in.mem[a : a+n]
+}
+
+// memRange returns n bytes of memory at ptr, false if that's out of bounds
+func (in *wasmInstance) memRange(ptr, n uint32) ([]byte, bool) {
+	end := uint64(ptr) + uint64(n)
+	if end > uint64(len(in.mem)) {
+		return nil, false
+	}
+	return in.mem[ptr:end:end], true
+}
+
+// region checks n entries from at fit in a table, a segment, or memory of size
+func wasmRegion(at, n uint32, size int) {
+	if uint64(at)+uint64(n) > uint64(size) {
+		wasmTrapf("out of bounds access")
+	}
+}
+
+func b2u(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ret returns from a frame at fp with nr results, which are on top of the stack
+func (in *wasmInstance) ret(st []uint64, sp, fp, nr, lbase int) {
+	copy(st[fp:], st[sp-nr:sp])
+	in.sp = fp + nr
+	in.labels = in.labels[:lbase]
+}
+
+// branch goes to the label d out from the innermost, returning where to go on and the stack top then,
+// or true if it's the function's own and it should return
+func (in *wasmInstance) branch(st []uint64, sp, lbase int, d uint32) (int, int, bool) {
+	top := len(in.labels) - 1 - int(d)
+	if top < lbase {
+		return 0, sp, true
+	}
+	l := in.labels[top]
+	in.labels = in.labels[:top]
+	copy(st[l.height:], st[sp-l.arity:sp])
+	return l.cont, l.height + l.arity, false
+}
+
+// exec runs a function body, with its np params on top of the stack, leaving its nr results in their place
+func (in *wasmInstance) exec(code *wasmCode, np, nr int) {
+	fp := in.sp - np
+	in.ensure(len(code.locals) - np + code.maxPush)
+	st := in.stack
+	sp := fp + len(code.locals)
+	clear(st[in.sp:sp])
+	lbase := len(in.labels)
+	body := code.body
+	pc := 0
+	// in.fuel is kept up to date only around calls and returns
+	fuel := in.fuel
+	for {
+		ins := &body[pc]
+		pc++
+		fuel--
+		if fuel < 0 {
+			wasmTrapf("ran too long")
+		}
+		switch ins.op {
+		case 0x00:
+			wasmTrapf("unreachable")
+		case 0x01:
+		case 0x02:
+			in.labels = append(in.labels, wasmLabel{height: sp - int(ins.b&0xffff), arity: int(ins.b >> 16 & 0xffff), cont: int(ins.a) + 1})
+		case 0x03:
+			p := int(ins.b & 0xffff)
+			in.labels = append(in.labels, wasmLabel{height: sp - p, arity: p, cont: pc - 1})
+		case 0x04:
+			sp--
+			l := wasmLabel{height: sp - int(ins.b&0xffff), arity: int(ins.b >> 16 & 0xffff), cont: int(ins.a) + 1}
+			switch els := int(ins.b >> 32); {
+			case uint32(st[sp]) != 0:
+				in.labels = append(in.labels, l)
+			case els != 0:
+				in.labels = append(in.labels, l)
+				pc = els + 1
+			default:
+				pc = l.cont
+			}
+		case 0x05:
+			// the end of an if's then
+			in.labels = in.labels[:len(in.labels)-1]
+			pc = int(ins.a) + 1
+		case 0x0b:
+			in.labels = in.labels[:len(in.labels)-1]
+		case 0x0c:
+			var done bool
+			if pc, sp, done = in.branch(st, sp, lbase, ins.a); done {
+				in.fuel = fuel
+				in.ret(st, sp, fp, nr, lbase)
+				return
+			}
+		case 0x0d:
+			sp--
+			if uint32(st[sp]) != 0 {
+				var done bool
+				if pc, sp, done = in.branch(st, sp, lbase, ins.a); done {
+					in.fuel = fuel
+					in.ret(st, sp, fp, nr, lbase)
+					return
+				}
+			}
+		case 0x0e:
+			sp--
+			i := min(uint64(uint32(st[sp])), ins.b)
+			var done bool
+			if pc, sp, done = in.branch(st, sp, lbase, code.brTables[uint64(ins.a)+i]); done {
+				in.fuel = fuel
+				in.ret(st, sp, fp, nr, lbase)
+				return
+			}
+		case 0x0f:
+			in.fuel = fuel
+			in.ret(st, sp, fp, nr, lbase)
+			return
+		case 0x10:
+			in.sp, in.fuel = sp, fuel
+			in.call(ins.a)
+			st, sp, fuel = in.stack, in.sp, in.fuel
+		case 0x11:
+			sp--
+			t := in.tables[ins.b]
+			i := uint32(st[sp])
+			if uint64(i) >= uint64(len(t)) {
+				wasmTrapf("undefined element")
+			}
+			if t[i] == 0 {
+				wasmTrapf("uninitialized element")
+			}
+			fn := uint32(t[i] - 1)
+			if in.m.typeIDs[in.m.funcs[fn]] != in.m.typeIDs[ins.a] {
+				wasmTrapf("indirect call type mismatch")
+			}
+			in.sp, in.fuel = sp, fuel
+			in.call(fn)
+			st, sp, fuel = in.stack, in.sp, in.fuel
+		case 0x1a:
+			sp--
+		case 0x1b:
+			sp -= 2
+			if uint32(st[sp+1]) == 0 {
+				st[sp-1] = st[sp]
+			}
+
+		case 0x20:
+			st[sp] = st[fp+int(ins.a)]
+			sp++
+		case 0x21:
+			sp--
+			st[fp+int(ins.a)] = st[sp]
+		case 0x22:
+			st[fp+int(ins.a)] = st[sp-1]
+		case 0x23:
+			st[sp] = in.globals[ins.a]
+			sp++
+		case 0x24:
+			sp--
+			in.globals[ins.a] = st[sp]
+		case 0x25:
+			t := in.tables[ins.a]
+			i := uint32(st[sp-1])
+			wasmRegion(i, 1, len(t))
+			st[sp-1] = t[i]
+		case 0x26:
+			sp -= 2
+			t := in.tables[ins.a]
+			i := uint32(st[sp])
+			wasmRegion(i, 1, len(t))
+			t[i] = st[sp+1]
+
+		case 0x28:
+			st[sp-1] = uint64(binary.LittleEndian.Uint32(in.ea(st[sp-1], ins.b, 4)))
+		case 0x29:
+			st[sp-1] = binary.LittleEndian.Uint64(in.ea(st[sp-1], ins.b, 8))
+		case 0x2a:
+			st[sp-1] = uint64(binary.LittleEndian.Uint32(in.ea(st[sp-1], ins.b, 4)))
+		case 0x2b:
+			st[sp-1] = binary.LittleEndian.Uint64(in.ea(st[sp-1], ins.b, 8))
+		case 0x2c:
+			st[sp-1] = uint64(uint32(int8(in.ea(st[sp-1], ins.b, 1)[0])))
+		case 0x2d:
+			st[sp-1] = uint64(in.ea(st[sp-1], ins.b, 1)[0])
+		case 0x2e:
+			st[sp-1] = uint64(uint32(int16(binary.LittleEndian.Uint16(in.ea(st[sp-1], ins.b, 2)))))
+		case 0x2f:
+			st[sp-1] = uint64(binary.LittleEndian.Uint16(in.ea(st[sp-1], ins.b, 2)))
+		case 0x30:
+			st[sp-1] = uint64(int8(in.ea(st[sp-1], ins.b, 1)[0]))
+		case 0x31:
+			st[sp-1] = uint64(in.ea(st[sp-1], ins.b, 1)[0])
+		case 0x32:
+			st[sp-1] = uint64(int16(binary.LittleEndian.Uint16(in.ea(st[sp-1], ins.b, 2))))
+		case 0x33:
+			st[sp-1] = uint64(binary.LittleEndian.Uint16(in.ea(st[sp-1], ins.b, 2)))
+		case 0x34:
+			st[sp-1] = uint64(int32(binary.LittleEndian.Uint32(in.ea(st[sp-1], ins.b, 4))))
+		case 0x35:
+			st[sp-1] = uint64(binary.LittleEndian.Uint32(in.ea(st[sp-1], ins.b, 4)))
+		case 0x36, 0x38, 0x3e:
+			sp -= 2
+			binary.LittleEndian.PutUint32(in.ea(st[sp], ins.b, 4), uint32(st[sp+1]))
+		case 0x37, 0x39:
+			sp -= 2
+			binary.LittleEndian.PutUint64(in.ea(st[sp], ins.b, 8), st[sp+1])
+		case 0x3a, 0x3c:
+			sp -= 2
+			in.ea(st[sp], ins.b, 1)[0] = byte(st[sp+1])
+		case 0x3b, 0x3d:
+			sp -= 2
+			binary.LittleEndian.PutUint16(in.ea(st[sp], ins.b, 2), uint16(st[sp+1]))
+		case 0x3f:
+			st[sp] = uint64(len(in.mem) / wasmPageSize)
+			sp++
+		case 0x40:
+			n := uint32(st[sp-1])
+			old := uint32(len(in.mem) / wasmPageSize)
+			if uint64(old)+uint64(n) > uint64(in.maxPages) {
+				st[sp-1] = uint64(math.MaxUint32)
+			} else {
+				in.mem = append(in.mem, make([]byte, int(n)*wasmPageSize)...)
+				st[sp-1] = uint64(old)
+			}
+
+		case 0x41, 0x42, 0x43, 0x44:
+			st[sp] = ins.b
+			sp++
+
+		case 0x45:
+			st[sp-1] = b2u(uint32(st[sp-1]) == 0)
+		case 0x46:
+			sp--
+			st[sp-1] = b2u(uint32(st[sp-1]) == uint32(st[sp]))
+		case 0x47:
+			sp--
+			st[sp-1] = b2u(uint32(st[sp-1]) != uint32(st[sp]))
+		case 0x48:
+			sp--
+			st[sp-1] = b2u(int32(st[sp-1]) < int32(st[sp]))
+		case 0x49:
+			sp--
+			st[sp-1] = b2u(uint32(st[sp-1]) < uint32(st[sp]))
+		case 0x4a:
+			sp--
+			st[sp-1] = b2u(int32(st[sp-1]) > int32(st[sp]))
+		case 0x4b:
+			sp--
+			st[sp-1] = b2u(uint32(st[sp-1]) > uint32(st[sp]))
+		case 0x4c:
+			sp--
+			st[sp-1] = b2u(int32(st[sp-1]) <= int32(st[sp]))
+		case 0x4d:
+			sp--
+			st[sp-1] = b2u(uint32(st[sp-1]) <= uint32(st[sp]))
+		case 0x4e:
+			sp--
+			st[sp-1] = b2u(int32(st[sp-1]) >= int32(st[sp]))
+		case 0x4f:
+			sp--
+			st[sp-1] = b2u(uint32(st[sp-1]) >= uint32(st[sp]))
+
+		case 0x50:
+			st[sp-1] = b2u(st[sp-1] == 0)
+		case 0x51:
+			sp--
+			st[sp-1] = b2u(st[sp-1] == st[sp])
+		case 0x52:
+			sp--
+			st[sp-1] = b2u(st[sp-1] != st[sp])
+		case 0x53:
+			sp--
+			st[sp-1] = b2u(int64(st[sp-1]) < int64(st[sp]))
+		case 0x54:
+			sp--
+			st[sp-1] = b2u(st[sp-1] < st[sp])
+		case 0x55:
+			sp--
+			st[sp-1] = b2u(int64(st[sp-1]) > int64(st[sp]))
+		case 0x56:
+			sp--
+			st[sp-1] = b2u(st[sp-1] > st[sp])
+		case 0x57:
+			sp--
+			st[sp-1] = b2u(int64(st[sp-1]) <= int64(st[sp]))
+		case 0x58:
+			sp--
+			st[sp-1] = b2u(st[sp-1] <= st[sp])
+		case 0x59:
+			sp--
+			st[sp-1] = b2u(int64(st[sp-1]) >= int64(st[sp]))
+		case 0x5a:
+			sp--
+			st[sp-1] = b2u(st[sp-1] >= st[sp])
+
+		case 0x5b:
+			sp--
+			st[sp-1] = b2u(f32(st[sp-1]) == f32(st[sp]))
+		case 0x5c:
+			sp--
+			st[sp-1] = b2u(f32(st[sp-1]) != f32(st[sp]))
+		case 0x5d:
+			sp--
+			st[sp-1] = b2u(f32(st[sp-1]) < f32(st[sp]))
+		case 0x5e:
+			sp--
+			st[sp-1] = b2u(f32(st[sp-1]) > f32(st[sp]))
+		case 0x5f:
+			sp--
+			st[sp-1] = b2u(f32(st[sp-1]) <= f32(st[sp]))
+		case 0x60:
+			sp--
+			st[sp-1] = b2u(f32(st[sp-1]) >= f32(st[sp]))
+		case 0x61:
+			sp--
+			st[sp-1] = b2u(f64(st[sp-1]) == f64(st[sp]))
+		case 0x62:
+			sp--
+			st[sp-1] = b2u(f64(st[sp-1]) != f64(st[sp]))
+		case 0x63:
+			sp--
+			st[sp-1] = b2u(f64(st[sp-1]) < f64(st[sp]))
+		case 0x64:
+			sp--
+			st[sp-1] = b2u(f64(st[sp-1]) > f64(st[sp]))
+		case 0x65:
+			sp--
+			st[sp-1] = b2u(f64(st[sp-1]) <= f64(st[sp]))
+		case 0x66:
+			sp--
+			st[sp-1] = b2u(f64(st[sp-1]) >= f64(st[sp]))
+
+		case 0x67:
+			st[sp-1] = uint64(bits.LeadingZeros32(uint32(st[sp-1])))
+		case 0x68:
+			st[sp-1] = uint64(bits.TrailingZeros32(uint32(st[sp-1])))
+		case 0x69:
+			st[sp-1] = uint64(bits.OnesCount32(uint32(st[sp-1])))
+		case 0x6a:
+			sp--
+			st[sp-1] = uint64(uint32(st[sp-1]) + uint32(st[sp]))
+		case 0x6b:
+			sp--
+			st[sp-1] = uint64(uint32(st[sp-1]) - uint32(st[sp]))
+		case 0x6c:
+			sp--
+			st[sp-1] = uint64(uint32(st[sp-1]) * uint32(st[sp]))
+		case 0x6d:
+			sp--
+			a, b := int32(st[sp-1]), int32(st[sp])
+			if b == 0 {
+				wasmTrapf("integer divide by zero")
+			}
+			if a == math.MinInt32 && b == -1 {
+				wasmTrapf("integer overflow")
+			}
+			st[sp-1] = uint64(uint32(a / b))
+		case 0x6e:
+			sp--
+			a, b := uint32(st[sp-1]), uint32(st[sp])
+			if b == 0 {
+				wasmTrapf("integer divide by zero")
+			}
+			st[sp-1] = uint64(a / b)
+		case 0x6f:
+			sp--
+			a, b := int32(st[sp-1]), int32(st[sp])
+			if b == 0 {
+				wasmTrapf("integer divide by zero")
+			}
+			st[sp-1] = uint64(uint32(a % b))
+		case 0x70:
+			sp--
+			a, b := uint32(st[sp-1]), uint32(st[sp])
+			if b == 0 {
+				wasmTrapf("integer divide by zero")
+			}
+			st[sp-1] = uint64(a % b)
+		case 0x71:
+			sp--
+			st[sp-1] &= st[sp]
+		case 0x72:
+			sp--
+			st[sp-1] = uint64(uint32(st[sp-1] | st[sp]))
+		case 0x73:
+			sp--
+			st[sp-1] = uint64(uint32(st[sp-1] ^ st[sp]))
+		case 0x74:
+			sp--
+			st[sp-1] = uint64(uint32(st[sp-1]) << (st[sp] & 31))
+		case 0x75:
+			sp--
+			st[sp-1] = uint64(uint32(int32(st[sp-1]) >> (st[sp] & 31)))
+		case 0x76:
+			sp--
+			st[sp-1] = uint64(uint32(st[sp-1]) >> (st[sp] & 31))
+		case 0x77:
+			sp--
+			st[sp-1] = uint64(bits.RotateLeft32(uint32(st[sp-1]), int(st[sp]&31)))
+		case 0x78:
+			sp--
+			st[sp-1] = uint64(bits.RotateLeft32(uint32(st[sp-1]), -int(st[sp]&31)))
+
+		case 0x79:
+			st[sp-1] = uint64(bits.LeadingZeros64(st[sp-1]))
+		case 0x7a:
+			st[sp-1] = uint64(bits.TrailingZeros64(st[sp-1]))
+		case 0x7b:
+			st[sp-1] = uint64(bits.OnesCount64(st[sp-1]))
+		case 0x7c:
+			sp--
+			st[sp-1] += st[sp]
+		case 0x7d:
+			sp--
+			st[sp-1] -= st[sp]
+		case 0x7e:
+			sp--
+			st[sp-1] *= st[sp]
+		case 0x7f:
+			sp--
+			a, b := int64(st[sp-1]), int64(st[sp])
+			if b == 0 {
+				wasmTrapf("integer divide by zero")
+			}
+			if a == math.MinInt64 && b == -1 {
+				wasmTrapf("integer overflow")
+			}
+			st[sp-1] = uint64(a / b)
+		case 0x80:
+			sp--
+			if st[sp] == 0 {
+				wasmTrapf("integer divide by zero")
+			}
+			st[sp-1] /= st[sp]
+		case 0x81:
+			sp--
+			a, b := int64(st[sp-1]), int64(st[sp])
+			if b == 0 {
+				wasmTrapf("integer divide by zero")
+			}
+			st[sp-1] = uint64(a % b)
+		case 0x82:
+			sp--
+			if st[sp] == 0 {
+				wasmTrapf("integer divide by zero")
+			}
+			st[sp-1] %= st[sp]
+		case 0x83:
+			sp--
+			st[sp-1] &= st[sp]
+		case 0x84:
+			sp--
+			st[sp-1] |= st[sp]
+		case 0x85:
+			sp--
+			st[sp-1] ^= st[sp]
+		case 0x86:
+			sp--
+			st[sp-1] <<= st[sp] & 63
+		case 0x87:
+			sp--
+			st[sp-1] = uint64(int64(st[sp-1]) >> (st[sp] & 63))
+		case 0x88:
+			sp--
+			st[sp-1] >>= st[sp] & 63
+		case 0x89:
+			sp--
+			st[sp-1] = bits.RotateLeft64(st[sp-1], int(st[sp]&63))
+		case 0x8a:
+			sp--
+			st[sp-1] = bits.RotateLeft64(st[sp-1], -int(st[sp]&63))
+
+		case 0x8b:
+			st[sp-1] &^= 1 << 31
+		case 0x8c:
+			st[sp-1] ^= 1 << 31
+		case 0x8d:
+			st[sp-1] = f32v(float32(math.Ceil(float64(f32(st[sp-1])))))
+		case 0x8e:
+			st[sp-1] = f32v(float32(math.Floor(float64(f32(st[sp-1])))))
+		case 0x8f:
+			st[sp-1] = f32v(float32(math.Trunc(float64(f32(st[sp-1])))))
+		case 0x90:
+			st[sp-1] = f32v(float32(math.RoundToEven(float64(f32(st[sp-1])))))
+		case 0x91:
+			st[sp-1] = f32v(float32(math.Sqrt(float64(f32(st[sp-1])))))
+		case 0x92:
+			sp--
+			st[sp-1] = f32v(f32(st[sp-1]) + f32(st[sp]))
+		case 0x93:
+			sp--
+			st[sp-1] = f32v(f32(st[sp-1]) - f32(st[sp]))
+		case 0x94:
+			sp--
+			st[sp-1] = f32v(f32(st[sp-1]) * f32(st[sp]))
+		case 0x95:
+			sp--
+			st[sp-1] = f32v(f32(st[sp-1]) / f32(st[sp]))
+		case 0x96:
+			sp--
+			st[sp-1] = f32v(float32(wasmMin(float64(f32(st[sp-1])), float64(f32(st[sp])))))
+		case 0x97:
+			sp--
+			st[sp-1] = f32v(float32(wasmMax(float64(f32(st[sp-1])), float64(f32(st[sp])))))
+		case 0x98:
+			sp--
+			st[sp-1] = st[sp-1]&^(1<<31) | st[sp]&(1<<31)
+
+		case 0x99:
+			st[sp-1] &^= 1 << 63
+		case 0x9a:
+			st[sp-1] ^= 1 << 63
+		case 0x9b:
+			st[sp-1] = f64v(math.Ceil(f64(st[sp-1])))
+		case 0x9c:
+			st[sp-1] = f64v(math.Floor(f64(st[sp-1])))
+		case 0x9d:
+			st[sp-1] = f64v(math.Trunc(f64(st[sp-1])))
+		case 0x9e:
+			st[sp-1] = f64v(math.RoundToEven(f64(st[sp-1])))
+		case 0x9f:
+			st[sp-1] = f64v(math.Sqrt(f64(st[sp-1])))
+		case 0xa0:
+			sp--
+			st[sp-1] = f64v(f64(st[sp-1]) + f64(st[sp]))
+		case 0xa1:
+			sp--
+			st[sp-1] = f64v(f64(st[sp-1]) - f64(st[sp]))
+		case 0xa2:
+			sp--
+			st[sp-1] = f64v(f64(st[sp-1]) * f64(st[sp]))
+		case 0xa3:
+			sp--
+			st[sp-1] = f64v(f64(st[sp-1]) / f64(st[sp]))
+		case 0xa4:
+			sp--
+			st[sp-1] = f64v(wasmMin(f64(st[sp-1]), f64(st[sp])))
+		case 0xa5:
+			sp--
+			st[sp-1] = f64v(wasmMax(f64(st[sp-1]), f64(st[sp])))
+		case 0xa6:
+			sp--
+			st[sp-1] = st[sp-1]&^(1<<63) | st[sp]&(1<<63)
+
+		case 0xa7:
+			st[sp-1] = uint64(uint32(st[sp-1]))
+		case 0xa8:
+			st[sp-1] = uint64(uint32(int32(wasmTrunc(float64(f32(st[sp-1])), math.MinInt32, math.MaxInt32))))
+		case 0xa9:
+			st[sp-1] = uint64(uint32(wasmTrunc(float64(f32(st[sp-1])), 0, math.MaxUint32)))
+		case 0xaa:
+			st[sp-1] = uint64(uint32(int32(wasmTrunc(f64(st[sp-1]), math.MinInt32, math.MaxInt32))))
+		case 0xab:
+			st[sp-1] = uint64(uint32(wasmTrunc(f64(st[sp-1]), 0, math.MaxUint32)))
+		case 0xac:
+			st[sp-1] = uint64(int32(st[sp-1]))
+		case 0xad:
+			st[sp-1] = uint64(uint32(st[sp-1]))
+		case 0xae:
+			st[sp-1] = wasmTruncI64(float64(f32(st[sp-1])))
+		case 0xaf:
+			st[sp-1] = wasmTruncU64(float64(f32(st[sp-1])))
+		case 0xb0:
+			st[sp-1] = wasmTruncI64(f64(st[sp-1]))
+		case 0xb1:
+			st[sp-1] = wasmTruncU64(f64(st[sp-1]))
+		case 0xb2:
+			st[sp-1] = f32v(float32(int32(st[sp-1])))
+		case 0xb3:
+			st[sp-1] = f32v(float32(uint32(st[sp-1])))
+		case 0xb4:
+			st[sp-1] = f32v(float32(int64(st[sp-1])))
+		case 0xb5:
+			st[sp-1] = f32v(float32(st[sp-1]))
+		case 0xb6:
+			st[sp-1] = f32v(float32(f64(st[sp-1])))
+		case 0xb7:
+			st[sp-1] = f64v(float64(int32(st[sp-1])))
+		case 0xb8:
+			st[sp-1] = f64v(float64(uint32(st[sp-1])))
+		case 0xb9:
+			st[sp-1] = f64v(float64(int64(st[sp-1])))
+		case 0xba:
+			st[sp-1] = f64v(float64(st[sp-1]))
+		case 0xbb:
+			st[sp-1] = f64v(float64(f32(st[sp-1])))
+		case 0xbc, 0xbd, 0xbe, 0xbf:
+			// values are kept as their bits already
+
+		case 0xc0:
+			st[sp-1] = uint64(uint32(int8(st[sp-1])))
+		case 0xc1:
+			st[sp-1] = uint64(uint32(int16(st[sp-1])))
+		case 0xc2:
+			st[sp-1] = uint64(int8(st[sp-1]))
+		case 0xc3:
+			st[sp-1] = uint64(int16(st[sp-1]))
+		case 0xc4:
+			st[sp-1] = uint64(int32(st[sp-1]))
+
+		case 0xd0:
+			st[sp] = 0
+			sp++
+		case 0xd1:
+			st[sp-1] = b2u(st[sp-1] == 0)
+		case 0xd2:
+			st[sp] = uint64(ins.a) + 1
+			sp++
+
+		case wasmOpFC + 0:
+			st[sp-1] = uint64(uint32(int32(wasmTruncSat(float64(f32(st[sp-1])), math.MinInt32, math.MaxInt32))))
+		case wasmOpFC + 1:
+			st[sp-1] = uint64(uint32(wasmTruncSat(float64(f32(st[sp-1])), 0, math.MaxUint32)))
+		case wasmOpFC + 2:
+			st[sp-1] = uint64(uint32(int32(wasmTruncSat(f64(st[sp-1]), math.MinInt32, math.MaxInt32))))
+		case wasmOpFC + 3:
+			st[sp-1] = uint64(uint32(wasmTruncSat(f64(st[sp-1]), 0, math.MaxUint32)))
+		case wasmOpFC + 4:
+			st[sp-1] = wasmTruncSatI64(float64(f32(st[sp-1])))
+		case wasmOpFC + 5:
+			st[sp-1] = wasmTruncSatU64(float64(f32(st[sp-1])))
+		case wasmOpFC + 6:
+			st[sp-1] = wasmTruncSatI64(f64(st[sp-1]))
+		case wasmOpFC + 7:
+			st[sp-1] = wasmTruncSatU64(f64(st[sp-1]))
+		case wasmOpFC + 8:
+			sp -= 3
+			d, s, n := uint32(st[sp]), uint32(st[sp+1]), uint32(st[sp+2])
+			data := in.datas[ins.a]
+			wasmRegion(s, n, len(data))
+			wasmRegion(d, n, len(in.mem))
+			copy(in.mem[d:], data[s:s+n])
+		case wasmOpFC + 9:
+			in.datas[ins.a] = nil
+		case wasmOpFC + 10:
+			sp -= 3
+			d, s, n := uint32(st[sp]), uint32(st[sp+1]), uint32(st[sp+2])
+			wasmRegion(s, n, len(in.mem))
+			wasmRegion(d, n, len(in.mem))
+			copy(in.mem[d:], in.mem[s:s+n])
+		case wasmOpFC + 11:
+			sp -= 3
+			d, v, n := uint32(st[sp]), byte(st[sp+1]), uint32(st[sp+2])
+			wasmRegion(d, n, len(in.mem))
+			fill := in.mem[d : d+n]
+			for i := range fill {
+				fill[i] = v
+			}
+		case wasmOpFC + 12:
+			sp -= 3
+			d, s, n := uint32(st[sp]), uint32(st[sp+1]), uint32(st[sp+2])
+			seg, t := in.elems[ins.a], in.tables[ins.b]
+			wasmRegion(s, n, len(seg))
+			wasmRegion(d, n, len(t))
+			copy(t[d:], seg[s:s+n])
+		case wasmOpFC + 13:
+			in.elems[ins.a] = nil
+		case wasmOpFC + 14:
+			sp -= 3
+			d, s, n := uint32(st[sp]), uint32(st[sp+1]), uint32(st[sp+2])
+			dt, stab := in.tables[ins.a], in.tables[ins.b]
+			wasmRegion(s, n, len(stab))
+			wasmRegion(d, n, len(dt))
+			copy(dt[d:], stab[s:s+n])
+		case wasmOpFC + 15:
+			sp--
+			n := uint32(st[sp])
+			t := in.tables[ins.a]
+			most := uint64(wasmMaxTable)
+			if def := in.m.tables[ins.a]; def.hasMax {
+				most = min(most, uint64(def.max))
+			}
+			if uint64(len(t))+uint64(n) > most {
+				st[sp-1] = uint64(math.MaxUint32)
+			} else {
+				old := len(t)
+				for i := uint32(0); i < n; i++ {
+					t = append(t, st[sp-1])
+				}
+				in.tables[ins.a] = t
+				st[sp-1] = uint64(old)
+			}
+		case wasmOpFC + 16:
+			st[sp] = uint64(len(in.tables[ins.a]))
+			sp++
+		case wasmOpFC + 17:
+			sp -= 3
+			i, v, n := uint32(st[sp]), st[sp+1], uint32(st[sp+2])
+			t := in.tables[ins.a]
+			wasmRegion(i, n, len(t))
+			for j := range t[i : i+n] {
+				t[i+uint32(j)] = v
+			}
+		default:
+			wasmTrapf("unknown instruction %#x", ins.op)
+		}
+	}
+}
+
+// wasmMin and wasmMax are NaN if either value is, where math.Min and math.Max favor infinities
+func wasmMin(a, b float64) float64 {
+	if a != a || b != b {
+		return math.NaN()
+	}
+	return math.Min(a, b)
+}
+
+func wasmMax(a, b float64) float64 {
+	if a != a || b != b {
+		return math.NaN()
+	}
+	return math.Max(a, b)
+}
+
+// wasmTrunc truncates f toward zero, which must be an integer from lo to hi
+func wasmTrunc(f, lo, hi float64) int64 {
+	if f != f {
+		wasmTrapf("invalid conversion to integer")
+	}
+	t := math.Trunc(f)
+	if t < lo || t > hi {
+		wasmTrapf("integer overflow")
+	}
+	return int64(t)
+}
+
+func wasmTruncI64(f float64) uint64 {
+	if f != f {
+		wasmTrapf("invalid conversion to integer")
+	}
+	t := math.Trunc(f)
+	if t < -(1<<63) || t >= 1<<63 {
+		wasmTrapf("integer overflow")
+	}
+	return uint64(int64(t))
+}
+
+func wasmTruncU64(f float64) uint64 {
+	if f != f {
+		wasmTrapf("invalid conversion to integer")
+	}
+	t := math.Trunc(f)
+	if t < 0 || t >= 1<<64 {
+		wasmTrapf("integer overflow")
+	}
+	return wasmU64(t)
+}
+
+// wasmU64 converts t, a whole number from 0 to 2^64, without relying on how Go converts values over 2^63
+func wasmU64(t float64) uint64 {
+	if t >= 1<<63 {
+		return uint64(int64(t-(1<<63))) | 1<<63
+	}
+	return uint64(int64(t))
+}
+
+// wasmTruncSat truncates f toward zero, clamped to lo to hi, 0 for NaN
+func wasmTruncSat(f, lo, hi float64) int64 {
+	switch {
+	case f != f:
+		return 0
+	case f <= lo:
+		return int64(lo)
+	case f >= hi:
+		return int64(hi)
+	}
+	return int64(math.Trunc(f))
+}
+
+func wasmTruncSatI64(f float64) uint64 {
+	switch {
+	case f != f:
+		return 0
+	case f <= -(1 << 63):
+		return 1 << 63
+	case f >= 1<<63:
+		return math.MaxInt64
+	}
+	return uint64(int64(f))
+}
+
+func wasmTruncSatU64(f float64) uint64 {
+	switch {
+	case f != f || f <= 0:
+		return 0
+	case f >= 1<<64:
+		return math.MaxUint64
+	}
+	return wasmU64(math.Trunc(f))
+}
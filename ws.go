@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Just enough RFC 6455 to push text messages to a browser or websocket client.
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+)
+
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+
+	wl sync.Mutex
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsOriginAllowed is true for requests without an Origin, which don't come from a browser,
+// from a page served by this host, or from one of the -cors-origin origins.
+// Browsers let any page open a websocket anywhere, so without this any site a user visits could read their input.
+func wsOriginAllowed(r *http.Request, origins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return originListed(origins, origin)
+}
+
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" || !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		return nil, errors.New("not a websocket request")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("connection can't be hijacked")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	err = rw.Flush()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+func (ws *wsConn) writeFrame(op byte, payload []byte) error {
+	ws.wl.Lock()
+	defer ws.wl.Unlock()
+	var hdr [10]byte
+	hdr[0] = 0x80 | op
+	hlen := 2
+	switch {
+	case len(payload) < 126:
+		hdr[1] = byte(len(payload))
+	case len(payload) <= 0xffff:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(len(payload)))
+		hlen = 4
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(len(payload)))
+		hlen = 10
+	}
+	ws.rw.Write(hdr[:hlen])
+	ws.rw.Write(payload)
+	return ws.rw.Flush()
+}
+
+// readLoop answers pings and returns when the client closes or the connection fails.
+// Client messages are otherwise ignored.
+func (ws *wsConn) readLoop() {
+	var hdr [8]byte
+	for {
+		_, err := io.ReadFull(ws.rw, hdr[:2])
+		if err != nil {
+			return
+		}
+		op := hdr[0] & 0x0f
+		masked := hdr[1]&0x80 != 0
+		plen := uint64(hdr[1] & 0x7f)
+		if plen == 126 {
+			if _, err = io.ReadFull(ws.rw, hdr[:2]); err != nil {
+				return
+			}
+			plen = uint64(binary.BigEndian.Uint16(hdr[:2]))
+		} else if plen == 127 {
+			if _, err = io.ReadFull(ws.rw, hdr[:8]); err != nil {
+				return
+			}
+			plen = binary.BigEndian.Uint64(hdr[:8])
+		}
+		if plen > 1<<20 {
+			return
+		}
+		var mask [4]byte
+		if masked {
+			if _, err = io.ReadFull(ws.rw, mask[:]); err != nil {
+				return
+			}
+		}
+		payload := make([]byte, plen)
+		if _, err = io.ReadFull(ws.rw, payload); err != nil {
+			return
+		}
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+		}
+		switch op {
+		case wsOpClose:
+			ws.writeFrame(wsOpClose, payload)
+			return
+		case wsOpPing:
+			ws.writeFrame(wsOpPong, payload)
+		}
+	}
+}
+
+// TappedLine is an input line as it arrives
+type TappedLine struct {
+	LineNumber int    `json:"lineNumber"`
+	Line       string `json:"line"`
+}
+
+type lineTap struct {
+	ch chan TappedLine
+	// fraction of lines to pass on
+	rate float64
+	// its own, to leave the Collector's for sampling
+	rng  *rand.Rand
+	lost uint32
+}
+
+// Tap gets a random fraction rate of all input lines until cancel is called.
+// Lines that don't fit in the buffer are dropped, lost() reports if that happened since the last call.
+func (c *Collector) Tap(buffer int, rate float64) (lines <-chan TappedLine, lost func() bool, cancel func()) {
+	tap := &lineTap{ch: make(chan TappedLine, buffer), rate: rate, rng: rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))}
+	c.l.Lock()
+	c.taps = append(c.taps, tap)
+	c.l.Unlock()
+	lost = func() bool {
+		return atomic.SwapUint32(&tap.lost, 0) != 0
+	}
+	cancel = func() {
+		c.l.Lock()
+		defer c.l.Unlock()
+		for i, t := range c.taps {
+			if t == tap {
+				c.taps = append(c.taps[:i], c.taps[i+1:]...)
+				return
+			}
+		}
+	}
+	return tap.ch, lost, cancel
+}
+
+// tapLine must be called with c.l held
+func (c *Collector) tapLine(line string) {
+	for _, tap := range c.taps {
+		if tap.rate < 1 && tap.rng.Float64() >= tap.rate {
+			continue
+		}
+		select {
+		case tap.ch <- TappedLine{c.linesSeen, line}:
+		default:
+			atomic.StoreUint32(&tap.lost, 1)
+		}
+	}
+}
+
+// wsTail serves /ws?rate=R, a websocket of json {"lineNumber":N,"line":"..."} messages
+// for every input line, or a random fraction R of them.
+// A {"lost":true} message means lines were dropped because the client wasn't keeping up.
+func (s *ssampleServer) wsTail(w http.ResponseWriter, r *http.Request) {
+	rate := 1.0
+	if rstr := r.FormValue("rate"); rstr != "" {
+		var err error
+		rate, err = strconv.ParseFloat(rstr, 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			badRequest(w, fmt.Errorf("bad rate=%q, want (0,1]", rstr))
+			return
+		}
+	}
+	if !wsOriginAllowed(r, s.wsOrigins) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	ws, err := wsUpgrade(w, r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	defer ws.conn.Close()
+	lines, lost, cancel := s.c.Tap(1000, rate)
+	defer cancel()
+	closed := make(chan struct{})
+	go func() {
+		ws.readLoop()
+		close(closed)
+	}()
+	for {
+		select {
+		case <-closed:
+			return
+		case tl := <-lines:
+			if lost() {
+				if ws.writeFrame(wsOpText, []byte(`{"lost":true}`)) != nil {
+					return
+				}
+			}
+			blob, _ := json.Marshal(tl)
+			if ws.writeFrame(wsOpText, blob) != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// wsDial sends a websocket handshake with origin, "" for none, and returns the response status
+func wsDial(t *testing.T, hs *httptest.Server, origin string) int {
+	conn, err := net.Dial("tcp", hs.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req := "GET /ws HTTP/1.1\r\nHost: " + hs.Listener.Addr().String() + "\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	if origin != "" {
+		req += "Origin: " + origin + "\r\n"
+	}
+	fmt.Fprint(conn, req+"\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestWSOrigin(t *testing.T) {
+	s := &ssampleServer{c: NewCollector(10, ""), wsOrigins: []string{"https://dash.example.com"}}
+	hs := httptest.NewServer(s.routes())
+	defer hs.Close()
+	for _, tc := range []struct {
+		origin string
+		status int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"http://" + hs.Listener.Addr().String(), http.StatusSwitchingProtocols},
+		{"https://dash.example.com", http.StatusSwitchingProtocols},
+		{"https://evil.example.com", http.StatusForbidden},
+		{"https://dash.example.com.evil.example.com", http.StatusForbidden},
+		{"null", http.StatusForbidden},
+	} {
+		if got := wsDial(t, hs, tc.origin); got != tc.status {
+			t.Errorf("Origin %q: %d, want %d", tc.origin, got, tc.status)
+		}
+	}
+
+	s.wsOrigins = []string{"*"}
+	if got := wsDial(t, hs, "https://evil.example.com"); got != http.StatusSwitchingProtocols {
+		t.Errorf("-cors-origin *: %d", got)
+	}
+}
+
+func TestWSOriginAllowed(t *testing.T) {
+	for _, tc := range []struct {
+		host, origin string
+		want         bool
+	}{
+		{"localhost:4422", "", true},
+		{"localhost:4422", "http://localhost:4422", true},
+		{"LOCALHOST:4422", "http://localhost:4422", true},
+		{"localhost:4422", "http://localhost:4423", false},
+		{"localhost:4422", "http://localhost", false},
+		{"localhost:4422", "localhost:4422", false},
+	} {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		r.Host = tc.host
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if got := wsOriginAllowed(r, nil); got != tc.want {
+			t.Errorf("Host %q Origin %q: %v, want %v", tc.host, tc.origin, got, tc.want)
+		}
+	}
+	if got := splitOrigins(" a, ,b "); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("splitOrigins = %q", got)
+	}
+}
+
+func TestTapKeepsSeededSample(t *testing.T) {
+	sample := func(tapped bool) []int {
+		seedCollectors(42)
+		defer func() { collectorSeeds.rng = nil }()
+		c := NewCollector(10, "")
+		if tapped {
+			// with room for every line
+			_, _, cancel := c.Tap(10000, 0.5)
+			defer cancel()
+			emitter, err := newRateEmitter("100/s", io.Discard)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.SetEmitter(emitter)
+			defer c.CloseEmitter()
+		}
+		for i := 0; i < 5000; i++ {
+			c.AddLine(fmt.Sprint(i))
+		}
+		_, nos := c.LinesAndNumbers()
+		return nos
+	}
+	plain, tapped := sample(false), sample(true)
+	if fmt.Sprint(plain) != fmt.Sprint(tapped) {
+		t.Errorf("-seed sample %v with a tap and -emit-rate is %v", plain, tapped)
+	}
+}